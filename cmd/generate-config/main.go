@@ -0,0 +1,52 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/genconfig"
+)
+
+func main() {
+	var (
+		cfg         genconfig.Config
+		resources   string
+		interactive = flag.Bool("interactive", false, "prompt for the settings, the flags giving the defaults")
+	)
+	flag.StringVar(&cfg.Image, "image", "", "image of the provider container")
+	flag.StringVar(&cfg.KeyARN, "key", "", "KMS key or alias ARN")
+	flag.StringVar(&cfg.Region, "region", "", "AWS Region (default the region of -key)")
+	flag.StringVar(&cfg.Socket, "listen", genconfig.DefaultSocket, "unix socket of the provider, abstract if starting with @")
+	flag.IntVar(&cfg.HealthPort, "health-port", genconfig.DefaultHealthPort, "port of /healthz, /livez and /readyz")
+	flag.StringVar(&cfg.APIVersion, "kms-api-version", genconfig.DefaultAPIVersion, "KMS plugin API version used by the kube-apiserver, v1 or v2")
+	flag.StringVar(&cfg.ProviderName, "provider-name", genconfig.DefaultProviderName, "name of the kms provider in the EncryptionConfiguration")
+	flag.StringVar(&resources, "resources", "secrets", "comma separated list of the encrypted resources")
+	flag.DurationVar(&cfg.Timeout, "timeout", genconfig.DefaultTimeout, "timeout of the kube-apiserver calls to the provider")
+	flag.Parse()
+	cfg.Resources = strings.Split(resources, ",")
+
+	if *interactive {
+		if err := genconfig.Prompt(os.Stdin, os.Stderr, &cfg); err != nil {
+			log.Fatalf("Failed to read the settings: %v", err)
+		}
+	}
+	cfg.SetDefaults()
+	if err := genconfig.Generate(os.Stdout, cfg); err != nil {
+		log.Fatalf("Invalid settings: %v", err)
+	}
+}
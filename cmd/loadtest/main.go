@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/loadtest"
+)
+
+func main() {
+	c := loadtest.DefaultConfig("/tmp/awsencryptionprovider.sock")
+	flag.StringVar(&c.Addr, "listen", c.Addr, "GRPC listen address of the provider")
+	flag.DurationVar(&c.Duration, "duration", c.Duration, "duration of the run")
+	flag.IntVar(&c.Concurrency, "concurrency", c.Concurrency, "number of concurrent clients")
+	flag.Float64Var(&c.EncryptRatio, "encrypt-ratio", c.EncryptRatio, "fraction of the requests that are encryptions, the others decrypt recent ciphertexts")
+	flag.IntVar(&c.PayloadSize, "payload-size", c.PayloadSize, "plaintext size of the encryptions in bytes")
+	flag.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "timeout of each request")
+	flag.StringVar(&c.MetricsURL, "metrics-url", c.MetricsURL, "/metrics URL of the provider, to check its goroutines and memory do not grow (e.g. http://localhost:8080/metrics)")
+	flag.DurationVar(&c.SampleInterval, "sample-interval", c.SampleInterval, "interval between two samples of the provider metrics")
+	flag.Float64Var(&c.MaxGoroutineGrowth, "max-goroutine-growth", c.MaxGoroutineGrowth, "highest accepted ratio of the provider goroutines at the end of the run to the start")
+	flag.Float64Var(&c.MaxMemoryGrowth, "max-memory-growth", c.MaxMemoryGrowth, "highest accepted ratio of the provider Go heap at the end of the run to the start")
+	flag.Parse()
+
+	l, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+	zap.ReplaceGlobals(l)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r, err := loadtest.Run(ctx, c)
+	if err != nil {
+		log.Fatalf("Failed to run load test: %v", err)
+	}
+	fmt.Print(r)
+	if err := r.Check(c); err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+}
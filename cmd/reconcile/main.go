@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/reconcile"
+)
+
+func main() {
+	var (
+		startStr  = flag.String("start", "", "RFC3339 start of the window (default one hour before -end)")
+		endStr    = flag.String("end", "", "RFC3339 end of the window (default now)")
+		principal = flag.String("principal-arn", "", "ARN of the IAM role or user of the provider")
+		auditLogs = flag.String("audit-log", "", "comma separated list of the --kms-audit-log files of the providers")
+		region    = flag.String("region", "", "AWS Region of the CloudTrail events")
+		asJSON    = flag.Bool("json", false, "print the report as JSON")
+	)
+	flag.Parse()
+	if *principal == "" || *auditLogs == "" {
+		log.Fatal("-principal-arn and -audit-log must be set")
+	}
+
+	end := time.Now()
+	if *endStr != "" {
+		var err error
+		if end, err = time.Parse(time.RFC3339, *endStr); err != nil {
+			log.Fatalf("Failed to parse -end: %v", err)
+		}
+	}
+	start := end.Add(-time.Hour)
+	if *startStr != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, *startStr); err != nil {
+			log.Fatalf("Failed to parse -start: %v", err)
+		}
+	}
+
+	var records []cloud.AuditRecord
+	for _, path := range strings.Split(*auditLogs, ",") {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		r, err := reconcile.ReadAuditLog(f)
+		f.Close() //nolint:errcheck
+		if err != nil {
+			log.Fatalf("Failed to read audit log %s: %v", path, err)
+		}
+		records = append(records, r...)
+	}
+
+	ctx := context.Background()
+	optFns := []func(*config.LoadOptions) error{}
+	if *region != "" {
+		optFns = append(optFns, config.WithRegion(*region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		log.Fatalf("Failed to create AWS config: %v", err)
+	}
+	events, err := reconcile.LookupKMSEvents(ctx, cloudtrail.NewFromConfig(cfg), *principal, start, end)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := reconcile.Reconcile(start, end, events, records)
+	if *asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Print(report)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// listenPlaceholder matches the placeholders of the --listen addresses, e.g. {keyAlias}
+var listenPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// expandListenAddrs expands the placeholders of the listen addresses for their key:
+// {keyAlias} is the alias name of an alias key, {keyID} the ID of a key ARN or ID,
+// {index} the position of the key and {cluster} the cluster name.
+// A single templated address is expanded for every key, so one --listen serves them all.
+func expandListenAddrs(addrs, keys []string, cluster string) ([]string, error) {
+	if len(addrs) == 1 && len(keys) > 1 && listenPlaceholder.MatchString(addrs[0]) {
+		templated := make([]string, len(keys))
+		for i := range keys {
+			templated[i] = addrs[0]
+		}
+		addrs = templated
+	}
+	if len(addrs) != len(keys) {
+		return addrs, nil
+	}
+
+	expanded := make([]string, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for i, addr := range addrs {
+		var err error
+		expanded[i] = listenPlaceholder.ReplaceAllStringFunc(addr, func(placeholder string) string {
+			value, e := listenPlaceholderValue(placeholder, keys[i], i, cluster)
+			if e != nil && err == nil {
+				err = fmt.Errorf("%q: %w", addr, e)
+			}
+			return value
+		})
+		if err != nil {
+			return nil, err
+		}
+		if seen[expanded[i]] {
+			return nil, fmt.Errorf("%q expands to %q for several keys", addr, expanded[i])
+		}
+		seen[expanded[i]] = true
+	}
+	return expanded, nil
+}
+
+func listenPlaceholderValue(placeholder, key string, index int, cluster string) (string, error) {
+	switch placeholder {
+	case "{keyAlias}":
+		_, alias, ok := strings.Cut(key, "alias/")
+		if !ok || alias == "" {
+			return "", fmt.Errorf("{keyAlias} requires an alias key, got %q", key)
+		}
+		return strings.ReplaceAll(alias, "/", "_"), nil
+	case "{keyID}":
+		if strings.Contains(key, "alias/") || key == "" {
+			return "", fmt.Errorf("{keyID} requires a key ARN or ID, got %q", key)
+		}
+		return key[strings.LastIndex(key, "/")+1:], nil
+	case "{index}":
+		return strconv.Itoa(index), nil
+	case "{cluster}":
+		if cluster == "" {
+			return "", fmt.Errorf("{cluster} requires --cluster-name")
+		}
+		return cluster, nil
+	default:
+		return "", fmt.Errorf("unknown placeholder %s, use {keyAlias}, {keyID}, {index} or {cluster}", placeholder)
+	}
+}
@@ -14,56 +14,354 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	flag "github.com/spf13/pflag"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"sigs.k8s.io/aws-encryption-provider/pkg/admin"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/consistency"
+	"sigs.k8s.io/aws-encryption-provider/pkg/crash"
+	"sigs.k8s.io/aws-encryption-provider/pkg/guard"
 	"sigs.k8s.io/aws-encryption-provider/pkg/healthz"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
 	"sigs.k8s.io/aws-encryption-provider/pkg/livez"
 	"sigs.k8s.io/aws-encryption-provider/pkg/logging"
+	"sigs.k8s.io/aws-encryption-provider/pkg/membudget"
+	"sigs.k8s.io/aws-encryption-provider/pkg/metrics"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/readyz"
 	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	"sigs.k8s.io/aws-encryption-provider/pkg/slo"
+	"sigs.k8s.io/aws-encryption-provider/pkg/tracing"
 )
 
+const (
+	livezPolicyKMS     = "kms"
+	livezPolicyProcess = "process"
+)
+
+// transports of --health-transports
+const (
+	healthTransportHTTP = "http"
+	healthTransportGRPC = "grpc"
+	healthTransportFile = "file"
+)
+
+// exitCodeStartupDeadline is the exit code of a provider failing the startup preflight
+// within --startup-deadline, telling it apart from crashes
+const exitCodeStartupDeadline = 3
+
 func main() {
 	var (
-		healthPort         = flag.String("health-port", ":8080", "port to serve /healthz and /livez")
+		healthPorts        = flag.StringSlice("health-port", []string{":8080"}, "comma separated list of addresses to serve /healthz and /livez on, e.g. 127.0.0.1:8080,[::1]:8080")
+		metricsPorts       = flag.StringSlice("metrics-port", []string{}, "comma separated list of addresses to serve /metrics on (defaults to the health addresses)")
 		healthzPath        = flag.String("healthz-path", "/healthz", "deep health check path")
 		livezPath          = flag.String("livez-path", "/livez", "liveness/connectivity check path")
-		addrs              = flag.StringSlice("listen", []string{"/var/run/kmsplugin/socket.sock"}, "comma separated list of GRPC listen address")
+		livezPolicy        = flag.String("livez-policy", livezPolicyKMS, "what the liveness check reflects. Valid options: kms (KMS availability errors fail it), process (only gRPC serving and health check routine, never KMS)")
+		readyzPath         = flag.String("readyz-path", "/readyz", "readiness check path, also failing while the AWS credentials are expired or within --credentials-expiry-margin of their expiry")
+		credsExpiryMargin  = flag.Duration("credentials-expiry-margin", 5*time.Minute, "refresh the AWS credentials this long before they expire, failing readiness if the refresh fails")
+		adminPath          = flag.String("admin-path", "", "path prefix to serve the admin API on the --admin-address addresses, e.g. /admin (disabled if empty)")
+		adminAddrs         = flag.StringSlice("admin-address", []string{"127.0.0.1:8081"}, "comma separated list of addresses to serve the --admin-path API on, never the --health-port ones as the API is not authenticated")
+		grpcAdmin          = flag.Bool("grpc-admin", false, "serve the admin gRPC service (e.g. WarmDecrypt for restore tooling) on the gRPC listen addresses")
+		addrs              = flag.StringSlice("listen", []string{"/var/run/kmsplugin/socket.sock"}, "comma separated list of GRPC listen address, expanding the {keyAlias}, {keyID}, {index} and {cluster} placeholders for their --key (a single templated address is expanded for every key), e.g. /var/run/kmsplugin/{keyAlias}.sock")
+		clusterName        = flag.String("cluster-name", "", "name of the cluster, expanded in the {cluster} placeholder of --listen")
+		tlsAddrs           = flag.StringSlice("tls-listen", []string{}, "comma separated list of TCP addresses to also serve gRPC on with mutual TLS, one per --listen address, e.g. for control planes on another host (disabled if empty)")
+		tlsCertFile        = flag.String("tls-cert-file", "", "PEM encoded server certificate of --tls-listen")
+		tlsKeyFile         = flag.String("tls-key-file", "", "PEM encoded server key of --tls-listen")
+		tlsClientCAFile    = flag.String("tls-client-ca-file", "", "PEM encoded CA bundle the client certificates of --tls-listen must be signed by")
+		tlsAllowedSANs     = flag.StringSlice("tls-allowed-sans", []string{}, "comma separated list of DNS names, IPs, URIs or emails a --tls-listen client certificate must have one of as SAN (any certificate of the CA if empty)")
 		keys               = flag.StringSlice("key", []string{""}, "comma separated list of AWS KMS Keys")
 		healthKms          = flag.String("health-kms-version", "v1", "kms version to use for health checks. Valid options: v1, v2")
 		region             = flag.String("region", "", "AWS Region")
-		kmsEndpoint        = flag.String("kms-endpoint", "", "use this KMS endpoint instead of the one generated by AWS sdk")
+		roleARN            = flag.String("role-arn", "", "IAM role to assume with STS AssumeRoleWithWebIdentity and --web-identity-token-file, e.g. the role of the service account with IRSA (default credential chain if empty)")
+		webIdentityToken   = flag.String("web-identity-token-file", "", "web identity token to assume --role-arn with, e.g. /var/run/secrets/eks.amazonaws.com/serviceaccount/token with IRSA")
+		assumeRoleARN      = flag.String("assume-role-arn", "", "IAM role to assume with STS AssumeRole from the default credentials, e.g. to use a KMS key of another account (disabled if empty)")
+		assumeRoleExtID    = flag.String("assume-role-external-id", "", "external ID required by the trust policy of --assume-role-arn, if any")
+		assumeRoleSession  = flag.String("assume-role-session-name", cloud.DefaultAssumeRoleSessionName, "session name of the role assumed with --assume-role-arn, recorded by CloudTrail")
+		fips               = flag.Bool("fips", false, "use the FIPS endpoints of KMS and STS, failing at startup if the region has none")
+		kmsEndpoint        = flag.String("kms-endpoint", "", "use this KMS endpoint instead of the one generated by AWS sdk, e.g. a VPC interface endpoint, must be https:// unless --kms-endpoint-insecure")
+		kmsEndpointInsec   = flag.Bool("kms-endpoint-insecure", false, "also accept http:// KMS endpoints, for local testing only, e.g. with LocalStack")
 		qpsLimit           = flag.Int("qps-limit", 0, "(deprecated) number of requests per second to allow for KMS API calls (0 to not rate limit), use --retry-token-capacity instead")
 		burstLimit         = flag.Int("burst-limit", 0, "(deprecated) number of tokens that can be consumed in a single call, use --retry-token-capacity instead")
+		accountIDEPMode    = flag.String("account-id-endpoint-mode", "", "account ID based endpoint routing of the AWS SDK. Valid options: preferred, required, disabled (SDK default if empty)")
+		endpointDiscovery  = flag.String("endpoint-discovery", "", "endpoint discovery of the AWS SDK. Valid options: auto, enabled, disabled (SDK default if empty)")
 		retryTokenCapacity = flag.Int("retry-token-capacity", 0, "number of tokens for client-side AWS rate-limiting on retries")
+		kmsRetryMode       = flag.String("kms-retry-mode", "", "retry mode of the KMS client: standard, or adaptive to also rate limit the first attempts of the calls while KMS throttles (SDK default if empty)")
+		kmsMaxAttempts     = flag.Int("kms-max-attempts", 0, "maximum number of attempts of a KMS call, including the first one (SDK default if 0)")
+		kmsMaxBackoff      = flag.Duration("kms-max-backoff", 0, "maximum delay between two attempts of a KMS call (SDK default if 0)")
+		kmsRetryAmbiguous  = flag.Bool("kms-retry-ambiguous", true, "retry the KMS calls which failed after they were sent, e.g. on a connection reset while waiting for the response: KMS may have served them, so the retry counts twice against the request quota (see kms_ambiguous_retries_total)")
+		kmsMaxInFlight     = flag.Int("kms-max-in-flight", 0, "maximum concurrent KMS calls of the v1 and v2 plugins, shared between the versions by --kms-version-weights so a burst of one can't starve the other, also --max-inflight-kms-requests (0 for unlimited)")
+		kmsVersionWeights  = flag.StringToInt("kms-version-weights", map[string]int{"v1": 1, "v2": 1}, "weights of the plugin API versions in --kms-max-in-flight while both wait for calls, e.g. v1=1,v2=3")
+		grantTokens        = flag.StringSlice("grant-tokens", []string{}, "comma separated list of KMS grant tokens added to every Encrypt, Decrypt and GenerateDataKey call, so freshly created grants (e.g. of a key of another account) apply before they propagated")
+		kmsRequestIDHeader = flag.Bool("kms-request-id-header", false, "send the provider request ID (the UID of the apiserver request if any, else a random one) with every KMS call, in the X-Aws-Encryption-Provider-Request-Id header and the User-Agent recorded by CloudTrail, and log it with the KMS failures and in --kms-audit-log")
+		kmsAuditLog        = flag.String("kms-audit-log", "", "file to append a JSON line to for every KMS request attempt, with its request ID, to reconcile with CloudTrail (disabled if empty)")
+		otlpEndpoint       = flag.String("otlp-endpoint", "", "host:port of an OTLP gRPC collector to export traces of the Encrypt and Decrypt requests and their KMS calls to (disabled if empty)")
+		otlpInsecure       = flag.Bool("otlp-insecure", false, "connect to --otlp-endpoint without TLS")
+		traceSampleRatio   = flag.Float64("trace-sample-ratio", 1, "fraction of the requests to trace when the apiserver did not sample them, from 0 to 1")
 		encryptionCtxsArr  = flag.StringArray("encryption-context", []string{}, "AWS KMS Encryption Context (e.g. 'a=b,c=d')")
+		keyHierarchy       = flag.Bool("key-hierarchy", false, "for KMSv2, encrypt with data keys derived from a locally cached KMS data key, only calling KMS when it rotates")
+		v1KeyHierarchy     = flag.Bool("v1-key-hierarchy", false, "for KMS v1, encrypt with a locally cached KMS data key like --key-hierarchy, only calling KMS when it rotates (older providers cannot decrypt the ciphertexts)")
+		kekRotationPeriod  = flag.Duration("key-hierarchy-rotation-period", plugin.DefaultKEKRotationPeriod, "how long a locally cached KMS data key is used in key hierarchy mode")
+		clusterFeatures    = flag.StringSlice("cluster-features", nil, "comma separated list of the ciphertext features every provider of the cluster can decrypt (envelope, context, transformers): record the features in a ciphertext header and only write those (unset to write every configured feature without header)")
+		consistencyEPs     = flag.StringSlice("consistency-check-endpoints", []string{}, "comma separated list of KMS endpoints (e.g. VPC endpoints of each availability zone) to check that ciphertexts encrypted via one decrypt via the others (disabled if empty)")
+		consistencyPeriod  = flag.Duration("consistency-check-period", consistency.DefaultCheckPeriod, "period between two KMS endpoints consistency checks")
+		deletionGuard      = flag.Bool("key-deletion-guard", false, "periodically check with DescribeKey that the keys are not scheduled for deletion, alerting if they are")
+		deletionGuardCncl  = flag.Bool("key-deletion-guard-cancel", false, "with --key-deletion-guard, cancel scheduled deletions of the keys (requires kms:CancelKeyDeletion)")
+		deletionGuardTTL   = flag.Duration("key-deletion-guard-period", guard.DefaultCheckPeriod, "period between two key deletion guard checks")
+		grpcCompression    = flag.StringSlice("grpc-compression", []string{server.CompressionGzip}, "comma separated list of compressions accepted by the gRPC servers, gzip or zstd (gzip is always accepted, as grpc registers it)")
+		grpcWriteTimeout   = flag.Duration("grpc-write-timeout", 0, "close gRPC connections when a write to them blocks longer, e.g. because the apiserver stopped reading (disabled if 0)")
+		grpcMaxConnAge     = flag.Duration("grpc-max-connection-age", 0, "close gRPC connections once they are this old, the apiserver reconnects (disabled if 0)")
+		grpcMaxConnGrace   = flag.Duration("grpc-max-connection-age-grace", 30*time.Second, "time the requests in flight on a connection closed by --grpc-max-connection-age may still take")
+		sloTarget          = flag.Float64("slo-target", 0, "targeted fraction of successful encrypt/decrypt requests within --slo-latency-threshold, e.g. 0.999, to export SLO burn rates (0 to disable)")
+		sloLatency         = flag.Duration("slo-latency-threshold", 500*time.Millisecond, "latency above which an encrypt/decrypt request counts against the SLO")
+		verifyWritesUntil  = flag.String("verify-writes-until", "", "for KMSv2, RFC3339 end of the key rotation window during which newly written ciphertexts are sampled and decrypted back (disabled if empty)")
+		verifyWritesRate   = flag.Float64("verify-writes-sample-rate", 0.01, "fraction of KMSv2 encryptions verified during the rotation window, between 0 and 1")
+		canaryKey          = flag.String("canary-key", "", "for KMSv2, KMS key (e.g. the key a switch is planned to) a sample of the encryptions is mirrored to in the background, encrypting and decrypting back without storing anything (disabled if empty)")
+		canaryRate         = flag.Float64("canary-sample-rate", 0.01, "fraction of KMSv2 encryptions mirrored to --canary-key, between 0 and 1")
+		encCBFailures      = flag.Int("encrypt-circuit-breaker-failures", 0, "for KMSv2, number of KMS availability failures of Encrypt within --encrypt-circuit-breaker-window opening its circuit breaker, failing encryptions fast (0 to disable)")
+		encCBFailureRate   = flag.Float64("encrypt-circuit-breaker-failure-rate", 0, "for KMSv2, ratio of KMS availability failures to Encrypt requests within --encrypt-circuit-breaker-window opening its circuit breaker once there were --encrypt-circuit-breaker-min-requests, e.g. 0.5 (0 to disable)")
+		encCBMinRequests   = flag.Int("encrypt-circuit-breaker-min-requests", 20, "Encrypt requests within --encrypt-circuit-breaker-window below which --encrypt-circuit-breaker-failure-rate is not evaluated")
+		encCBWindow        = flag.Duration("encrypt-circuit-breaker-window", time.Minute, "period Encrypt failures are counted over")
+		encCBCooldown      = flag.Duration("encrypt-circuit-breaker-cooldown", 30*time.Second, "how long the Encrypt circuit breaker stays open before a trial request")
+		decCBFailures      = flag.Int("decrypt-circuit-breaker-failures", 0, "for KMSv2, number of KMS availability failures of Decrypt within --decrypt-circuit-breaker-window opening its circuit breaker, failing decryptions fast (0 to disable)")
+		decCBFailureRate   = flag.Float64("decrypt-circuit-breaker-failure-rate", 0, "for KMSv2, ratio of KMS availability failures to Decrypt requests within --decrypt-circuit-breaker-window opening its circuit breaker once there were --decrypt-circuit-breaker-min-requests, e.g. 0.5 (0 to disable)")
+		decCBMinRequests   = flag.Int("decrypt-circuit-breaker-min-requests", 20, "Decrypt requests within --decrypt-circuit-breaker-window below which --decrypt-circuit-breaker-failure-rate is not evaluated")
+		decCBWindow        = flag.Duration("decrypt-circuit-breaker-window", time.Minute, "period Decrypt failures are counted over")
+		decCBCooldown      = flag.Duration("decrypt-circuit-breaker-cooldown", 30*time.Second, "how long the Decrypt circuit breaker stays open before a trial request")
+		identityAssertion  = flag.Bool("identity-assertion", false, "for KMSv2, sign the key ARN and ciphertext format version into the encrypt annotations and verify them on decrypt before calling KMS")
+		identityKeys       = flag.StringSlice("identity-assertion-accepted-keys", []string{}, "comma separated list of keys besides --key that annotated ciphertexts may have been written with, e.g. after changing the key in place")
+		decryptOnlyKeys    = flag.StringSlice("decrypt-only-keys", []string{}, "for KMSv2, comma separated list of keys besides --key whose ciphertexts are decrypted, e.g. the previous key after a key change; decryptions are pinned to the key the ciphertext was written with and those of other keys rejected (disabled if empty)")
+		deprecatedCTs      = flag.StringSlice("deprecated-ciphertexts", []string{}, "for KMSv2, comma separated list of deprecated ciphertext formats, prefix=<prefix> of the stored ciphertexts or key=<key ID> they were written with, whose decryptions are counted and warned about to drive storage migrations to completion (disabled if empty)")
+		compatPrefixesArr  = flag.StringSlice("compat-prefixes", []string{}, "comma separated list of <prefix>=<behavior> mapping the storage prefixes of the ciphertexts of other providers (e.g. forks) to how they are decrypted, kms (a KMS ciphertext blob follows the prefix) or kms-base64 (a base64 encoded one), the prefix hex encoded if starting with 0x, so their data can be read after switching providers (disabled if empty)")
+		requestUIDCtxKey   = flag.String("request-uid-encryption-context-key", "", "for KMSv2, add the UID of each encrypt request to the KMS encryption context under this key, to correlate CloudTrail records with the apiserver logs (disabled if empty)")
+		storageV3          = flag.Bool("storage-version-v3", false, "for KMSv2, write the ciphertexts encrypted directly with KMS with a structured header recording their key ARN, algorithm and provider version, decrypted with the key they were encrypted with (only enable once every provider of the cluster can decrypt them)")
+		kmsAPIVersions     = flag.StringSlice("kms-api-versions", []string{plugin.GRPC_V1, plugin.GRPC_V2}, "comma separated list of the KMS API versions served (v1 for v1beta1, v2): the requests of the other version are refused with FailedPrecondition, e.g. to make sure no apiserver still uses KMS v1")
+		v1Shim             = flag.Bool("v1-shim", false, "serve KMS v1beta1 requests with the KMSv2 implementation (sharing its caches, options and ciphertext format) instead of the separate v1 plugin")
+		ciphertextAge      = flag.Bool("ciphertext-age", false, "for KMSv2, annotate ciphertexts with their encryption time and export the age of the decrypted ones")
+		ciphertextMaxAge   = flag.Duration("ciphertext-max-age", 0, "with --ciphertext-age, count and log the decryptions of ciphertexts older than this age, e.g. not re-encrypted since a key rotation (0 to disable)")
+		aliasResolution    = flag.Duration("key-alias-resolution-period", 0, "for KMSv2 with an alias --key, period to resolve the alias with DescribeKey and report the key it points to as key ID, so re-pointing the alias triggers the automatic re-encryption of the apiserver (0 to disable)")
+		statusCacheTTL     = flag.Duration("status-cache-interval", 0, "for KMSv2, interval to refresh the Status response in the background instead of evaluating it on every call (0 to disable)")
+		drainFilePath      = flag.String("drain-file", "", "while this file exists, advertise NotReady via the KMSv2 Status, /healthz and /readyz but keep serving Encrypt and Decrypt, e.g. to shift the apiservers to a replacement before exiting")
+		maintenanceMax     = flag.Duration("maintenance-max-duration", time.Hour, "longest maintenance mode started with <admin-path>/maintenance, during which /healthz and /readyz fail but Encrypt and Decrypt keep being served")
+		memoryBudget       = flag.Int64("memory-budget", 0, "bytes shared by the caches and in-flight KMSv2 requests, least recently used cache entries are evicted and requests rejected beyond it (0 for unlimited)")
+		recoveryProbe      = flag.Duration("recovery-probe-period", plugin.DefaultRecoveryProbePeriod, "while the latest KMS error is user-induced (e.g. disabled key, missing grant), period of the health check probes clearing it once they succeed (0 to disable)")
+		probeErrorTypesMap = flag.StringToString("health-probe-error-types", map[string]string{}, "comma separated list of <KMS error code>=<error type> classifying the KMS errors of the health probes only, e.g. AccessDeniedException=user-induced when the probes are denied while the requests work (error types: user-induced, throttled, corruption, other, partition-mismatch, policy-propagation)")
+		healthTransports   = flag.StringSlice("health-transports", []string{healthTransportHTTP, healthTransportGRPC}, "comma separated list of the transports reporting the health checks. Valid options: http (--healthz-path, --readyz-path and --livez-path on --health-port), grpc (standard gRPC health service on the --listen addresses), file (JSON status file --health-status-file, e.g. for node-problem-detector)")
+		healthStatusFile   = flag.String("health-status-file", "", "file the file health transport writes the health, readiness and liveness checks to every 10s, see --health-transports")
+		probeDryRun        = flag.Bool("health-probe-dry-run", false, "call KMS Encrypt with DryRun in the health probes, checking the permissions and state of the key without encrypting (the KMSv2 probes then skip Decrypt)")
+		probeDescribeKey   = flag.Bool("health-probe-describe-key", false, "call KMS DescribeKey instead of Encrypt and Decrypt in the health probes, only checking the credentials and that the key is enabled, without using the request quota of the cryptographic operations (requires kms:DescribeKey)")
+		probePayloadMax    = flag.Int("health-probe-payload-max", 0, "encrypt a payload the size of the 95th percentile of the latest plaintexts, of at most this many bytes, in the health probes, so they reflect the latency and failures of the requests (0 for a 3 bytes payload)")
+		throttleTolerance  = flag.Duration("health-throttle-tolerance", 0, "only fail health checks on KMS throttling once it lasted longer than this window, e.g. for AWS managed keys sharing their request quota (0 to fail on any throttling)")
+		healthCheckPeriod  = flag.Duration("health-check-period", plugin.DefaultHealthCheckPeriod, "period the result of a KMS health check is reused for, so the provider reports unhealthy at most this long after KMS fails and calls KMS for health checks at most once per period")
+		healthCheckErrBuf  = flag.Int("health-check-error-buffer", plugin.DefaultErrcBufSize, "number of KMS request errors queued for the health check routine, the errors of requests failing beyond it are dropped")
+		failureThreshold   = flag.Int("health-failure-threshold", 1, "number of failures among the latest --health-failure-results KMS results making a healthy provider report unhealthy, so a transient error does not churn the kubelet probes (1 to report the first error)")
+		failureResults     = flag.Int("health-failure-results", 0, "number of latest KMS results --health-failure-threshold counts the failures of (0 for the threshold, i.e. consecutive failures)")
+		failureWindow      = flag.Duration("health-failure-window", 0, "only count the KMS results of this window towards --health-failure-threshold (0 to count them all)")
+		trafficAware       = flag.Bool("traffic-aware-health-checks", false, "skip the health checks of a key whose KMS requests succeeded within the health check period, so the health checks only call KMS while the key serves no requests")
+		idleAfter          = flag.Duration("idle-after", 0, "once no Encrypt or Decrypt request was served for this long and KMS is healthy, run the health checks every --idle-health-check-period only, e.g. to cut the KMS cost of idle clusters (0 to disable)")
+		idleCheckPeriod    = flag.Duration("idle-health-check-period", plugin.DefaultIdleHealthCheckPeriod, "health check period of an idle provider, see --idle-after")
+		startupDeadline    = flag.Duration("startup-deadline", 0, "before binding the --listen sockets, check the credentials resolve, KMS DescribeKey finds each key enabled and an Encrypt and Decrypt roundtrip works, retrying for at most this long and exiting with code 3 if they still fail (0 to disable)")
+		bootstrapGrace     = flag.Duration("bootstrap-grace-period", 0, "after startup, treat KMS access denied errors as a key policy or grant still propagating: retry them with a short backoff and don't fail /livez (0 to disable)")
+		errorRulesFile     = flag.String("error-rules-file", "", "JSON file replacing the built-in rules classifying KMS errors by message")
+		retryPoliciesFile  = flag.String("retry-policies-file", "", "JSON file of the policies deciding which KMS errors are retried, by error type and code (AWS SDK default if empty)")
+		legacyMetricNames  = flag.Bool("legacy-metric-names", false, "also export the latency histograms under their previous *_latency_ms names, while dashboards migrate to the *_duration_seconds ones")
+		debugAWSHTTP       = flag.Duration("debug-aws-http", 0, "log the KMS HTTP requests and responses (headers, status and timings, bodies and credentials redacted) for this long after startup (0 to disable)")
+		debugAWSHTTPMax    = flag.Int("debug-aws-http-max-requests", 100, "stop the --debug-aws-http logging after this many requests")
+		crashStateFile     = flag.String("crash-state-file", "", "file to write a sanitized snapshot of the last state (configuration hash, recent errors, health state, goroutine stacks) to on fatal errors and panics, for node-problem-detector or support tooling (disabled if empty)")
+		logRedactPatterns  = flag.StringArray("log-redact-pattern", []string{}, "regular expression whose matches are replaced by REDACTED in the log messages and fields, e.g. organization-specific secret formats (can be repeated)")
 		debug              = flag.Bool("debug", false, "Print debug level logs")
 	)
+	flag.CommandLine.SetNormalizeFunc(normalizeFlagAliases)
 	flag.Parse()
 
+	v := &flagValidator{}
 	encryptionCtxs := []map[string]string{}
 	for _, encryptionCtxStr := range *encryptionCtxsArr {
 		encryptionCtx, err := stringToStringConv(encryptionCtxStr)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse encryption-context: %v", err)
-			os.Exit(1)
+		v.check(err == nil, []string{"encryption-context"}, fmt.Sprintf("failed to parse %q: %v", encryptionCtxStr, err), "use the key=value,key2=value2 format")
+		if err == nil {
+			encryptionCtxs = append(encryptionCtxs, encryptionCtx.(map[string]string))
 		}
-		encryptionCtxs = append(encryptionCtxs, encryptionCtx.(map[string]string))
 	}
-
-	if len(*keys) != len(*addrs) {
-		fmt.Fprintf(os.Stderr, "key and listen lists must have the same number of elements")
+	expandedAddrs, err := expandListenAddrs(*addrs, *keys, *clusterName)
+	v.check(err == nil, []string{"listen"}, fmt.Sprintf("%v", err), "use the {keyAlias}, {keyID}, {index} and {cluster} placeholders so every key expands to its own address")
+	if err == nil {
+		*addrs = expandedAddrs
+	}
+	v.check(len(*keys) == len(*addrs), []string{"key", "listen"},
+		fmt.Sprintf("key and listen lists must have the same number of elements, got %d and %d", len(*keys), len(*addrs)),
+		"list one --listen address per --key")
+	v.check(*livezPolicy == livezPolicyKMS || *livezPolicy == livezPolicyProcess, []string{"livez-policy"},
+		fmt.Sprintf("unknown policy %q", *livezPolicy), fmt.Sprintf("use %s or %s", livezPolicyKMS, livezPolicyProcess))
+	v.check(len(*healthPorts) > 0, []string{"health-port"}, "health-port list must not be empty", "set at least one address, e.g. :8080")
+	if *adminPath != "" {
+		v.check(len(*adminAddrs) > 0, []string{"admin-address", "admin-path"}, "admin-address list must not be empty", "set at least one address, e.g. 127.0.0.1:8081")
+		for _, addr := range *adminAddrs {
+			v.check(!slices.Contains(*healthPorts, addr) && !slices.Contains(*metricsPorts, addr), []string{"admin-address", "health-port", "metrics-port"},
+				fmt.Sprintf("the admin API must not share the probe and metrics listener %s", addr), "use a local address only reachable by the operators, e.g. 127.0.0.1:8081")
+		}
+	}
+	v.check(*healthKms == "v1" || *healthKms == "v2", []string{"health-kms-version"},
+		fmt.Sprintf("unknown version %q", *healthKms), "use v1 or v2")
+	for _, t := range *healthTransports {
+		v.check(t == healthTransportHTTP || t == healthTransportGRPC || t == healthTransportFile, []string{"health-transports"},
+			fmt.Sprintf("unknown transport %q", t), fmt.Sprintf("use %s, %s or %s", healthTransportHTTP, healthTransportGRPC, healthTransportFile))
+		v.check(t != healthTransportFile || *healthStatusFile != "", []string{"health-transports", "health-status-file"},
+			"the file transport requires a status file", "set --health-status-file")
+	}
+	v.check(!*probeDryRun || !*probeDescribeKey, []string{"health-probe-dry-run", "health-probe-describe-key"},
+		"the health probes call either Encrypt with DryRun or DescribeKey", "set only one of them")
+	v.check(*credsExpiryMargin >= 0, []string{"credentials-expiry-margin"}, "must not be negative", "use 0 to only fail readiness on expired credentials")
+	v.check(!*deletionGuardCncl || *deletionGuard, []string{"key-deletion-guard-cancel", "key-deletion-guard"},
+		"cancelling key deletions requires the key deletion guard", "also set --key-deletion-guard")
+	v.check(*sloTarget >= 0 && *sloTarget < 1, []string{"slo-target"},
+		fmt.Sprintf("expected in [0, 1), got %v", *sloTarget), "use e.g. 0.999, or 0 to disable")
+	if *verifyWritesUntil != "" {
+		_, err := time.Parse(time.RFC3339, *verifyWritesUntil)
+		v.check(err == nil, []string{"verify-writes-until"}, fmt.Sprintf("failed to parse %q: %v", *verifyWritesUntil, err), "use an RFC3339 time, e.g. 2024-06-01T00:00:00Z")
+		v.check(*verifyWritesRate > 0 && *verifyWritesRate <= 1, []string{"verify-writes-sample-rate"},
+			fmt.Sprintf("expected in (0, 1], got %v", *verifyWritesRate), "use e.g. 0.01 to verify 1% of the writes")
+	}
+	if *canaryKey != "" {
+		v.check(*canaryRate > 0 && *canaryRate <= 1, []string{"canary-sample-rate"},
+			fmt.Sprintf("expected in (0, 1], got %v", *canaryRate), "use e.g. 0.01 to mirror 1% of the encryptions")
+		v.check(!slices.Contains(*keys, *canaryKey), []string{"canary-key", "key"},
+			"the canary key is already used by a plugin", "set --canary-key to the key a switch is planned to")
+	}
+	v.check(*encCBFailureRate >= 0 && *encCBFailureRate <= 1, []string{"encrypt-circuit-breaker-failure-rate"}, "must be between 0 and 1", "use e.g. 0.5, or 0 to disable")
+	v.check(*decCBFailureRate >= 0 && *decCBFailureRate <= 1, []string{"decrypt-circuit-breaker-failure-rate"}, "must be between 0 and 1", "use e.g. 0.5, or 0 to disable")
+	v.check((*encCBFailures <= 0 && *encCBFailureRate <= 0) || (*encCBWindow > 0 && *encCBCooldown > 0), []string{"encrypt-circuit-breaker-window", "encrypt-circuit-breaker-cooldown"},
+		"the Encrypt circuit breaker requires a positive window and cooldown", "set both, e.g. 1m and 30s")
+	v.check((*decCBFailures <= 0 && *decCBFailureRate <= 0) || (*decCBWindow > 0 && *decCBCooldown > 0), []string{"decrypt-circuit-breaker-window", "decrypt-circuit-breaker-cooldown"},
+		"the Decrypt circuit breaker requires a positive window and cooldown", "set both, e.g. 1m and 30s")
+	v.check(len(*identityKeys) == 0 || *identityAssertion, []string{"identity-assertion-accepted-keys", "identity-assertion"},
+		"accepted keys are only checked with the identity assertion", "also set --identity-assertion, or drop the accepted keys")
+	v.check(*ciphertextMaxAge == 0 || *ciphertextAge, []string{"ciphertext-max-age", "ciphertext-age"},
+		"the ciphertext age is only tracked with --ciphertext-age", "also set --ciphertext-age")
+	if err := plugin.ParseAPIVersions(*kmsAPIVersions); err != nil {
+		v.check(false, []string{"kms-api-versions"}, err.Error(), "use v1, v2 or v1,v2")
+	} else {
+		v.check(slices.Contains(*kmsAPIVersions, *healthKms), []string{"health-kms-version", "kms-api-versions"},
+			fmt.Sprintf("the health checks use KMS API %s, which is not served", *healthKms), "set --health-kms-version to a served version")
+		v1Flags := []string{}
+		for _, f := range []string{"v1-shim", "v1-key-hierarchy"} {
+			if flag.CommandLine.Changed(f) {
+				v1Flags = append(v1Flags, f)
+			}
+		}
+		v.check(slices.Contains(*kmsAPIVersions, plugin.GRPC_V1) || len(v1Flags) == 0, append([]string{"kms-api-versions"}, v1Flags...),
+			"configures KMS API v1, which is not served", "add v1 to --kms-api-versions or remove the v1 flags")
+	}
+	v.check(!*v1KeyHierarchy || !*v1Shim, []string{"v1-key-hierarchy", "v1-shim"},
+		"the v1 requests are served by the KMSv2 plugin with the v1 shim", "use --key-hierarchy instead of --v1-key-hierarchy")
+	v.check((*roleARN == "") == (*webIdentityToken == ""), []string{"role-arn", "web-identity-token-file"},
+		"the web identity role and token are used together", "set both, or neither to use the default credential chain")
+	v.check(*roleARN == "" || strings.HasPrefix(*roleARN, "arn:"), []string{"role-arn"},
+		fmt.Sprintf("expected a role ARN, got %q", *roleARN), "use e.g. arn:aws:iam::123456789012:role/kms")
+	if *webIdentityToken != "" {
+		_, err := os.Stat(*webIdentityToken)
+		v.check(err == nil, []string{"web-identity-token-file"}, fmt.Sprintf("cannot read the token: %v", err),
+			"with IRSA, annotate the service account of the pod with eks.amazonaws.com/role-arn so the token is mounted")
+	}
+	v.check(*assumeRoleExtID == "" || *assumeRoleARN != "", []string{"assume-role-external-id", "assume-role-arn"},
+		"the external ID is only sent when assuming a role", "also set --assume-role-arn")
+	v.check(*assumeRoleARN == "" || strings.HasPrefix(*assumeRoleARN, "arn:"), []string{"assume-role-arn"},
+		fmt.Sprintf("expected a role ARN, got %q", *assumeRoleARN), "use e.g. arn:aws:iam::123456789012:role/kms")
+	v.check(*debugAWSHTTP <= 0 || *debugAWSHTTPMax > 0, []string{"debug-aws-http-max-requests"},
+		fmt.Sprintf("expected a positive number of requests, got %d", *debugAWSHTTPMax), "use e.g. 100")
+	v.check(*grpcWriteTimeout >= 0 && *grpcMaxConnAge >= 0 && *grpcMaxConnGrace >= 0, []string{"grpc-write-timeout", "grpc-max-connection-age", "grpc-max-connection-age-grace"},
+		"must not be negative", "use 0 to disable the limit")
+	insecureRemediation := "use an https:// endpoint, or set --kms-endpoint-insecure for local testing"
+	if *kmsEndpoint != "" {
+		problem := endpointProblem(*kmsEndpoint, *kmsEndpointInsec)
+		v.check(problem == "", []string{"kms-endpoint"}, problem, insecureRemediation)
+	}
+	for _, ep := range *consistencyEPs {
+		problem := endpointProblem(ep, *kmsEndpointInsec)
+		v.check(problem == "", []string{"consistency-check-endpoints"}, problem, insecureRemediation)
+	}
+	parsedClusterFeatures, err := plugin.ParseFeatures(*clusterFeatures)
+	v.check(err == nil, []string{"cluster-features"}, fmt.Sprintf("%v", err), "list features among envelope, context and transformers")
+	v.check(*healthCheckPeriod > 0, []string{"health-check-period"},
+		fmt.Sprintf("must be positive, got %s", *healthCheckPeriod), "use e.g. 30s")
+	v.check(*healthCheckErrBuf >= 0, []string{"health-check-error-buffer"},
+		fmt.Sprintf("must not be negative, got %d", *healthCheckErrBuf), "use e.g. 100")
+	v.check(*probePayloadMax >= 0 && *probePayloadMax <= plugin.MaxProbePayloadSize, []string{"health-probe-payload-max"},
+		fmt.Sprintf("expected at most the %d bytes KMS encrypts, got %d", plugin.MaxProbePayloadSize, *probePayloadMax), "use e.g. 4096, or 0 to disable")
+	v.check(*failureThreshold >= 1 && (*failureResults == 0 || *failureResults >= *failureThreshold), []string{"health-failure-threshold", "health-failure-results"},
+		fmt.Sprintf("expected at least 1 failure out of at least as many results, got %d out of %d", *failureThreshold, *failureResults), "use e.g. --health-failure-threshold=3 --health-failure-results=5")
+	v.check(*startupDeadline >= 0, []string{"startup-deadline"}, "must not be negative", "use 0 to disable the startup preflight")
+	v.check(*failureWindow >= 0, []string{"health-failure-window"}, "must not be negative", "use 0 to count all the results")
+	v.check(*idleAfter <= 0 || *idleCheckPeriod > *healthCheckPeriod, []string{"idle-after", "idle-health-check-period", "health-check-period"},
+		fmt.Sprintf("the idle period must be longer than the health check period of %s, got %s", *healthCheckPeriod, *idleCheckPeriod), "use e.g. 5m")
+	v.check(*traceSampleRatio >= 0 && *traceSampleRatio <= 1, []string{"trace-sample-ratio"},
+		fmt.Sprintf("expected a ratio from 0 to 1, got %v", *traceSampleRatio), "use e.g. 0.1 to trace 10% of the requests")
+	deprecated := make([]plugin.DeprecatedCiphertext, 0, len(*deprecatedCTs))
+	for _, entry := range *deprecatedCTs {
+		dc, err := plugin.ParseDeprecatedCiphertext(entry)
+		v.check(err == nil, []string{"deprecated-ciphertexts"}, fmt.Sprintf("%v", err), "use e.g. prefix=1 or key=arn:aws:kms:us-west-2:123456789012:key/old")
+		deprecated = append(deprecated, dc)
+	}
+	compatPrefixes := make([]plugin.CompatPrefix, 0, len(*compatPrefixesArr))
+	for _, entry := range *compatPrefixesArr {
+		cp, err := plugin.ParseCompatPrefix(entry)
+		v.check(err == nil, []string{"compat-prefixes"}, fmt.Sprintf("%v", err), "use e.g. fork:v1:=kms or 0x0a01=kms-base64")
+		compatPrefixes = append(compatPrefixes, cp)
+	}
+	if len(*tlsAddrs) > 0 {
+		v.check(len(*tlsAddrs) == len(*addrs), []string{"tls-listen", "listen"},
+			fmt.Sprintf("tls-listen and listen lists must have the same number of elements, got %d and %d", len(*tlsAddrs), len(*addrs)),
+			"set one TCP address per unix socket")
+		v.check(*tlsCertFile != "" && *tlsKeyFile != "" && *tlsClientCAFile != "", []string{"tls-cert-file", "tls-key-file", "tls-client-ca-file"},
+			"required by --tls-listen", "set the server certificate and key, and the CA of the client certificates")
+	}
+	v.check(len(*grantTokens) <= cloud.MaxGrantTokens, []string{"grant-tokens"},
+		fmt.Sprintf("KMS accepts at most %d grant tokens per request, got %d", cloud.MaxGrantTokens, len(*grantTokens)), "only keep the tokens of the grants still propagating")
+	var retryMode aws.RetryMode
+	if *kmsRetryMode != "" {
+		retryMode, err = aws.ParseRetryMode(*kmsRetryMode)
+		v.check(err == nil, []string{"kms-retry-mode"}, fmt.Sprintf("%v", err), "use standard or adaptive")
+	}
+	v.check(*kmsMaxAttempts >= 0, []string{"kms-max-attempts"}, "must not be negative", "use 0 for the SDK default")
+	v.check(*kmsMaxBackoff >= 0, []string{"kms-max-backoff"}, "must not be negative", "use 0 for the SDK default")
+	v.check(*kmsMaxInFlight >= 0, []string{"kms-max-in-flight"}, "must not be negative", "use 0 for unlimited")
+	for version, weight := range *kmsVersionWeights {
+		v.check((version == plugin.GRPC_V1 || version == plugin.GRPC_V2) && weight > 0, []string{"kms-version-weights"},
+			fmt.Sprintf("expected a positive weight of v1 or v2, got %s=%d", version, weight), "use e.g. v1=1,v2=3")
+	}
+	probeErrorTypes := map[string]kmsplugin.KMSErrorType{}
+	for code, name := range *probeErrorTypesMap {
+		errorType, err := kmsplugin.ParseKMSErrorType(name)
+		v.check(err == nil, []string{"health-probe-error-types"}, fmt.Sprintf("%v", err), "use e.g. AccessDeniedException=user-induced")
+		probeErrorTypes[code] = errorType
+	}
+	v.check(*memoryBudget >= 0, []string{"memory-budget"}, "must not be negative", "use 0 for unlimited")
+	redactors := make([]logging.Redactor, 0, len(*logRedactPatterns))
+	for _, pattern := range *logRedactPatterns {
+		re, err := regexp.Compile(pattern)
+		v.check(err == nil, []string{"log-redact-pattern"}, fmt.Sprintf("%v", err), "use a Go regular expression, e.g. 'token-[0-9a-f]{32}'")
+		if err == nil {
+			redactors = append(redactors, logging.RedactPattern(re))
+		}
+	}
+	if err := v.err(); err != nil {
+		fmt.Fprint(os.Stderr, err)
 		os.Exit(1)
 	}
 
@@ -78,25 +376,244 @@ func main() {
 		os.Exit(1)
 	}
 
-	zap.ReplaceGlobals(l)
+	var crashHandler *crash.Handler
+	if *crashStateFile != "" {
+		flags := map[string]string{}
+		flag.CommandLine.VisitAll(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+		crashHandler = crash.New(*crashStateFile, crash.ConfigHash(flags))
+		if l, err = crashHandler.Install(l); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to install the crash handler: %v", err)
+			os.Exit(1)
+		}
+		defer crashHandler.Recover()
+	}
+
+	// last, so the crash state is redacted too
+	zap.ReplaceGlobals(logging.WithRedactors(l, redactors...))
+
+	if *errorRulesFile != "" {
+		if err := kmsplugin.LoadMessageRules(*errorRulesFile); err != nil {
+			zap.L().Fatal("Failed to load error rules", zap.Error(err))
+		}
+	}
+	if *retryPoliciesFile != "" {
+		if err := kmsplugin.LoadRetryPolicies(*retryPoliciesFile); err != nil {
+			zap.L().Fatal("Failed to load retry policies", zap.Error(err))
+		}
+	}
+	if *bootstrapGrace > 0 {
+		kmsplugin.SetBootstrapGracePeriod(*bootstrapGrace)
+	}
+	metrics.SetLegacyNames(*legacyMetricNames)
+	for _, r := range kmsplugin.MessageRules() {
+		zap.L().Info("error-rule", zap.String("code", r.Code), zap.String("message-contains", r.MessageContains), zap.Stringer("error-type", r.ErrorType))
+	}
 
 	zap.L().Info("creating kms server",
-		zap.String("health-port", *healthPort),
+		zap.Strings("health-port", *healthPorts),
+		zap.Strings("metrics-port", *metricsPorts),
 		zap.String("healthz-path", *healthzPath),
 		zap.String("health-kms-version", *healthKms),
 		zap.String("livez-path", *livezPath),
+		zap.String("livez-policy", *livezPolicy),
+		zap.String("readyz-path", *readyzPath),
+		zap.Duration("credentials-expiry-margin", *credsExpiryMargin),
+		zap.String("admin-path", *adminPath),
+		zap.Strings("admin-address", *adminAddrs),
+		zap.Bool("legacy-metric-names", *legacyMetricNames),
+		zap.Bool("grpc-admin", *grpcAdmin),
 		zap.String("region", *region),
 		zap.Strings("listen-address", *addrs),
+		zap.String("cluster-name", *clusterName),
+		zap.Strings("tls-listen", *tlsAddrs),
+		zap.String("tls-cert-file", *tlsCertFile),
+		zap.String("tls-key-file", *tlsKeyFile),
+		zap.String("tls-client-ca-file", *tlsClientCAFile),
+		zap.Strings("tls-allowed-sans", *tlsAllowedSANs),
 		zap.String("kms-endpoint", *kmsEndpoint),
+		zap.Bool("kms-endpoint-insecure", *kmsEndpointInsec),
+		zap.Bool("fips", *fips),
+		zap.String("role-arn", *roleARN),
+		zap.String("web-identity-token-file", *webIdentityToken),
+		zap.String("assume-role-arn", *assumeRoleARN),
+		zap.Bool("assume-role-external-id-set", *assumeRoleExtID != ""),
+		zap.String("assume-role-session-name", *assumeRoleSession),
 		zap.Int("qps-limit", *qpsLimit),
 		zap.Int("burst-limit", *burstLimit),
 		zap.Int("retry-token-capacity", *retryTokenCapacity),
+		zap.Int("grant-tokens", len(*grantTokens)),
+		zap.String("kms-retry-mode", *kmsRetryMode),
+		zap.Int("kms-max-attempts", *kmsMaxAttempts),
+		zap.Duration("kms-max-backoff", *kmsMaxBackoff),
+		zap.Bool("kms-retry-ambiguous", *kmsRetryAmbiguous),
+		zap.Int("kms-max-in-flight", *kmsMaxInFlight),
+		zap.Any("kms-version-weights", *kmsVersionWeights),
+		zap.String("account-id-endpoint-mode", *accountIDEPMode),
+		zap.String("endpoint-discovery", *endpointDiscovery),
+		zap.Bool("kms-request-id-header", *kmsRequestIDHeader),
+		zap.String("kms-audit-log", *kmsAuditLog),
+		zap.String("otlp-endpoint", *otlpEndpoint),
+		zap.Bool("otlp-insecure", *otlpInsecure),
+		zap.Float64("trace-sample-ratio", *traceSampleRatio),
+		zap.Duration("debug-aws-http", *debugAWSHTTP),
+		zap.Int("debug-aws-http-max-requests", *debugAWSHTTPMax),
+		zap.Duration("startup-deadline", *startupDeadline),
+		zap.Duration("bootstrap-grace-period", *bootstrapGrace),
+		zap.String("retry-policies-file", *retryPoliciesFile),
+		zap.Duration("recovery-probe-period", *recoveryProbe),
+		zap.Any("health-probe-error-types", *probeErrorTypesMap),
+		zap.Strings("health-transports", *healthTransports),
+		zap.String("health-status-file", *healthStatusFile),
+		zap.Bool("health-probe-dry-run", *probeDryRun),
+		zap.Bool("health-probe-describe-key", *probeDescribeKey),
+		zap.Duration("health-throttle-tolerance", *throttleTolerance),
+		zap.Duration("health-check-period", *healthCheckPeriod),
+		zap.Int("health-check-error-buffer", *healthCheckErrBuf),
+		zap.Int("health-probe-payload-max", *probePayloadMax),
+		zap.Int("health-failure-threshold", *failureThreshold),
+		zap.Int("health-failure-results", *failureResults),
+		zap.Duration("health-failure-window", *failureWindow),
+		zap.Bool("traffic-aware-health-checks", *trafficAware),
+		zap.Duration("idle-after", *idleAfter),
+		zap.Duration("idle-health-check-period", *idleCheckPeriod),
+		zap.Strings("consistency-check-endpoints", *consistencyEPs),
+		zap.Bool("key-deletion-guard", *deletionGuard),
+		zap.Bool("key-deletion-guard-cancel", *deletionGuardCncl),
+		zap.Strings("grpc-compression", *grpcCompression),
+		zap.Duration("grpc-write-timeout", *grpcWriteTimeout),
+		zap.Duration("grpc-max-connection-age", *grpcMaxConnAge),
+		zap.Duration("grpc-max-connection-age-grace", *grpcMaxConnGrace),
+		zap.Float64("slo-target", *sloTarget),
+		zap.Duration("slo-latency-threshold", *sloLatency),
+		zap.String("verify-writes-until", *verifyWritesUntil),
+		zap.Float64("verify-writes-sample-rate", *verifyWritesRate),
+		zap.String("canary-key", *canaryKey),
+		zap.Float64("canary-sample-rate", *canaryRate),
+		zap.Int("encrypt-circuit-breaker-failures", *encCBFailures),
+		zap.Float64("encrypt-circuit-breaker-failure-rate", *encCBFailureRate),
+		zap.Int("encrypt-circuit-breaker-min-requests", *encCBMinRequests),
+		zap.Duration("encrypt-circuit-breaker-window", *encCBWindow),
+		zap.Duration("encrypt-circuit-breaker-cooldown", *encCBCooldown),
+		zap.Int("decrypt-circuit-breaker-failures", *decCBFailures),
+		zap.Float64("decrypt-circuit-breaker-failure-rate", *decCBFailureRate),
+		zap.Int("decrypt-circuit-breaker-min-requests", *decCBMinRequests),
+		zap.Duration("decrypt-circuit-breaker-window", *decCBWindow),
+		zap.Duration("decrypt-circuit-breaker-cooldown", *decCBCooldown),
+		zap.Bool("identity-assertion", *identityAssertion),
+		zap.Strings("identity-assertion-accepted-keys", *identityKeys),
+		zap.Strings("decrypt-only-keys", *decryptOnlyKeys),
+		zap.Strings("deprecated-ciphertexts", *deprecatedCTs),
+		zap.Strings("compat-prefixes", *compatPrefixesArr),
+		zap.String("request-uid-encryption-context-key", *requestUIDCtxKey),
+		zap.Bool("storage-version-v3", *storageV3),
+		zap.Strings("kms-api-versions", *kmsAPIVersions),
+		zap.Bool("v1-shim", *v1Shim),
+		zap.Bool("ciphertext-age", *ciphertextAge),
+		zap.Duration("ciphertext-max-age", *ciphertextMaxAge),
+		zap.Duration("key-alias-resolution-period", *aliasResolution),
+		zap.Duration("status-cache-interval", *statusCacheTTL),
+		zap.String("drain-file", *drainFilePath),
+		zap.Duration("maintenance-max-duration", *maintenanceMax),
+		zap.String("crash-state-file", *crashStateFile),
+		// the patterns may disclose the format of the secrets
+		zap.Int("log-redact-patterns", len(*logRedactPatterns)),
+		zap.Bool("key-hierarchy", *keyHierarchy),
+		zap.Strings("cluster-features", *clusterFeatures),
+		zap.Bool("v1-key-hierarchy", *v1KeyHierarchy),
+		zap.Duration("key-hierarchy-rotation-period", *kekRotationPeriod),
 	)
-	c, err := cloud.New(*region, *kmsEndpoint, *qpsLimit, *burstLimit, *retryTokenCapacity)
+	endpointOpts := []cloud.Option{
+		cloud.WithAccountIDEndpointMode(*accountIDEPMode),
+		cloud.WithEndpointDiscovery(*endpointDiscovery),
+		cloud.WithRetryConfig(cloud.RetryConfig{Mode: retryMode, MaxAttempts: *kmsMaxAttempts, MaxBackoff: *kmsMaxBackoff, NoAmbiguousRetries: !*kmsRetryAmbiguous}),
+	}
+	if *fips {
+		endpointOpts = append(endpointOpts, cloud.WithFIPS())
+	}
+	if len(*grantTokens) > 0 {
+		endpointOpts = append(endpointOpts, cloud.WithGrantTokens(*grantTokens...))
+	}
+	if *kmsRequestIDHeader {
+		endpointOpts = append(endpointOpts, cloud.WithCorrelationIDs())
+	}
+	if *roleARN != "" {
+		endpointOpts = append(endpointOpts, cloud.WithWebIdentity(*roleARN, *webIdentityToken))
+	}
+	if *assumeRoleARN != "" {
+		endpointOpts = append(endpointOpts, cloud.WithAssumeRole(*assumeRoleARN, *assumeRoleExtID, *assumeRoleSession))
+	}
+	credsWatcher := cloud.NewCredentialsWatcher(*credsExpiryMargin)
+	cloudOpts := append([]cloud.Option{cloud.WithCredentialsWatcher(credsWatcher)}, endpointOpts...)
+	if *kmsAuditLog != "" {
+		f, err := os.OpenFile(*kmsAuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			zap.L().Fatal("Failed to open KMS audit log", zap.Error(err))
+		}
+		defer f.Close() //nolint:errcheck
+		cloudOpts = append(cloudOpts, cloud.WithAuditLog(f))
+	}
+	// flushes the spans on exit, main exits without running its deferred calls
+	shutdownTracing := func(context.Context) error { return nil }
+	if *otlpEndpoint != "" {
+		var err error
+		shutdownTracing, err = tracing.Setup(context.Background(), tracing.Config{
+			Endpoint:    *otlpEndpoint,
+			Insecure:    *otlpInsecure,
+			SampleRatio: *traceSampleRatio,
+		})
+		if err != nil {
+			zap.L().Fatal("Failed to set up tracing", zap.Error(err))
+		}
+		cloudOpts = append(cloudOpts, cloud.WithTracing())
+	}
+	if *debugAWSHTTP > 0 {
+		zap.L().Warn("logging the KMS HTTP requests", zap.Duration("debug-aws-http", *debugAWSHTTP), zap.Int("debug-aws-http-max-requests", *debugAWSHTTPMax))
+		cloudOpts = append(cloudOpts, cloud.WithHTTPDebugLog(*debugAWSHTTP, *debugAWSHTTPMax))
+	}
+	var rateLimiter *cloud.RateLimiter
+	if *adminPath != "" {
+		rateLimiter = cloud.NewRateLimiter()
+		cloudOpts = append(cloudOpts, cloud.WithRateLimiter(rateLimiter))
+	}
+	c, err := cloud.New(*region, *kmsEndpoint, *qpsLimit, *burstLimit, *retryTokenCapacity, cloudOpts...)
 	if err != nil {
 		zap.L().Fatal("Failed to create new KMS service", zap.Error(err))
 	}
 
+	for _, key := range *keys {
+		if err := kmsplugin.CheckKeyPartition(key, cloud.Region(c)); err != nil {
+			zap.L().Error("KMS key can not be used", zap.String("key", key), zap.Error(err))
+		}
+	}
+
+	if *deletionGuard {
+		stopc := make(chan struct{})
+		defer close(stopc)
+		for _, key := range *keys {
+			go guard.NewKeyDeletionGuard(key, c, *deletionGuardCncl).Run(*deletionGuardTTL, stopc)
+		}
+	}
+
+	if len(*consistencyEPs) > 0 {
+		endpoints := []consistency.Endpoint{}
+		for _, ep := range *consistencyEPs {
+			epc, err := cloud.New(*region, ep, *qpsLimit, *burstLimit, *retryTokenCapacity, endpointOpts...)
+			if err != nil {
+				zap.L().Fatal("Failed to create KMS service for consistency check", zap.String("kms-endpoint", ep), zap.Error(err))
+			}
+			endpoints = append(endpoints, consistency.Endpoint{Name: ep, KMS: epc})
+		}
+		stopc := make(chan struct{})
+		defer close(stopc)
+		for i, key := range *keys {
+			checker, err := consistency.NewChecker(key, getOrDefault(encryptionCtxs, i, map[string]string{}), endpoints)
+			if err != nil {
+				zap.L().Fatal("Failed to create consistency check", zap.Error(err))
+			}
+			go checker.Run(*consistencyPeriod, stopc)
+		}
+	}
+
 	for i, encryptionCtx := range encryptionCtxs {
 		for k, v := range encryptionCtx {
 			zap.L().Info("encryption-context", zap.Int("index", i), zap.String("key", k), zap.String(
@@ -104,41 +621,261 @@ func main() {
 		}
 	}
 
-	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
-	go sharedHealthCheck.Start()
-	defer sharedHealthCheck.Stop()
+	sharedHealthCheck := plugin.NewSharedHealthCheck(*healthCheckPeriod, *healthCheckErrBuf)
+	crashHandler.SetHealthState(func() string { return sharedHealthCheck.HealthState().String() })
+
+	withClusterFeatures := flag.CommandLine.Changed("cluster-features")
+	v1Opts := []plugin.V1Option{}
+	if *v1KeyHierarchy {
+		if withClusterFeatures && parsedClusterFeatures&plugin.FeatureEnvelope == 0 {
+			// v1 ciphertexts have no features header
+			zap.L().Warn("not writing v1 key hierarchy ciphertexts until envelope is enabled cluster-wide")
+		} else {
+			v1Opts = append(v1Opts, plugin.WithV1KeyHierarchy(*kekRotationPeriod))
+		}
+	}
+	v2Opts := []plugin.V2Option{}
+	if withClusterFeatures {
+		v2Opts = append(v2Opts, plugin.WithClusterFeatures(parsedClusterFeatures))
+	}
+	if *keyHierarchy {
+		v2Opts = append(v2Opts, plugin.WithKeyHierarchy(*kekRotationPeriod))
+	}
+	if *storageV3 {
+		v2Opts = append(v2Opts, plugin.WithStorageVersionV3())
+	}
+	if *verifyWritesUntil != "" {
+		// validated with the flags
+		until, _ := time.Parse(time.RFC3339, *verifyWritesUntil)
+		v2Opts = append(v2Opts, plugin.WithWriteVerification(until, *verifyWritesRate))
+	}
+	if *encCBFailures > 0 || *decCBFailures > 0 || *encCBFailureRate > 0 || *decCBFailureRate > 0 {
+		v2Opts = append(v2Opts, plugin.WithCircuitBreakers(
+			plugin.CircuitBreakerConfig{FailureThreshold: *encCBFailures, FailureRate: *encCBFailureRate, MinRequests: *encCBMinRequests, Window: *encCBWindow, Cooldown: *encCBCooldown},
+			plugin.CircuitBreakerConfig{FailureThreshold: *decCBFailures, FailureRate: *decCBFailureRate, MinRequests: *decCBMinRequests, Window: *decCBWindow, Cooldown: *decCBCooldown},
+		))
+	}
+	if *identityAssertion {
+		v2Opts = append(v2Opts, plugin.WithIdentityAssertion(*identityKeys...))
+	}
+	if len(*decryptOnlyKeys) > 0 {
+		v2Opts = append(v2Opts, plugin.WithDecryptOnlyKeys(*decryptOnlyKeys...))
+	}
+	if len(compatPrefixes) > 0 {
+		v1Opts = append(v1Opts, plugin.WithV1CompatPrefixes(compatPrefixes...))
+		v2Opts = append(v2Opts, plugin.WithCompatPrefixes(compatPrefixes...))
+	}
+	var deprecations *plugin.Deprecations
+	if len(deprecated) > 0 {
+		deprecations = plugin.NewDeprecations(deprecated...)
+		v2Opts = append(v2Opts, plugin.WithDeprecations(deprecations))
+	}
+	if *requestUIDCtxKey != "" {
+		v2Opts = append(v2Opts, plugin.WithRequestUIDEncryptionContext(*requestUIDCtxKey))
+	}
+	if *ciphertextAge {
+		v2Opts = append(v2Opts, plugin.WithCiphertextAge(*ciphertextMaxAge))
+	}
+	if *aliasResolution > 0 {
+		v2Opts = append(v2Opts, plugin.WithAliasResolution(*aliasResolution))
+	}
+	if *statusCacheTTL > 0 {
+		v2Opts = append(v2Opts, plugin.WithStatusCache(*statusCacheTTL))
+	}
+	drainFile := plugin.NewDrainFile(*drainFilePath)
+	if drainFile != nil {
+		v2Opts = append(v2Opts, plugin.WithDrainFile(drainFile))
+	}
+	if *memoryBudget > 0 {
+		zap.L().Info("limiting memory used by caches and requests", zap.Int64("memory-budget", *memoryBudget))
+		v2Opts = append(v2Opts, plugin.WithMemoryBudget(membudget.New(*memoryBudget)))
+	}
+
+	if err := server.RegisterCompressors(*grpcCompression); err != nil {
+		zap.L().Fatal("Failed to configure gRPC compression", zap.Error(err))
+	}
+	// first, so the refused requests are not accounted as served
+	serverOpts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(plugin.APIVersionsInterceptor(*kmsAPIVersions))}
+	if *otlpEndpoint != "" {
+		serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor()))
+	}
+	serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(plugin.RequestMetricsInterceptor()))
+	if *sloTarget > 0 {
+		tracker, err := slo.NewTracker(slo.Objective{Target: *sloTarget, LatencyThreshold: *sloLatency})
+		if err != nil {
+			zap.L().Fatal("Failed to create SLO tracker", zap.Error(err))
+		}
+		prometheus.MustRegister(tracker)
+		serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(tracker.UnaryServerInterceptor()))
+	}
+	// last, so the time spent in the other interceptors counts as queueing
+	serverOpts = append(serverOpts,
+		grpc.StatsHandler(plugin.RequestPhasesStatsHandler()),
+		grpc.ChainUnaryInterceptor(plugin.RequestPhasesInterceptor()),
+	)
 
+	connLimits := server.ConnectionLimits{
+		WriteTimeout:          *grpcWriteTimeout,
+		MaxConnectionAge:      *grpcMaxConnAge,
+		MaxConnectionAgeGrace: *grpcMaxConnGrace,
+	}
 	servers := []*server.Server{}
 	p1s := []*plugin.V1Plugin{}
 	p2s := []*plugin.V2Plugin{}
+	// the plugins of every key, checked by the startup preflight
+	allP2s := []*plugin.V2Plugin{}
+	recoveryProbes := []func() error{}
+
+	// the KMS clients of the v1 and v2 plugins
+	v1c, v2c := c, c
+	if *kmsMaxInFlight > 0 {
+		fairShare := plugin.NewKMSFairShare(*kmsMaxInFlight, *kmsVersionWeights)
+		v1c, v2c = fairShare.Client(c, plugin.GRPC_V1), fairShare.Client(c, plugin.GRPC_V2)
+	}
 
 	for i, key := range *keys {
-		s := server.New()
+		s := server.NewWithLimits(connLimits, serverOpts...)
 		servers = append(servers, s)
 		encryptionCtx := getOrDefault(encryptionCtxs, i, map[string]string{})
 
-		p := plugin.New(key, c, encryptionCtx, sharedHealthCheck)
-		p.Register(s.Server)
-		p2 := plugin.NewV2(key, c, encryptionCtx, sharedHealthCheck)
+		p := plugin.New(key, v1c, encryptionCtx, sharedHealthCheck, v1Opts...)
+		p2Opts := v2Opts
+		if *canaryKey != "" {
+			// the canary health check is never started, so canary errors do not fail the health checks
+			canaryOpts := []plugin.V2Option{}
+			if *keyHierarchy {
+				canaryOpts = append(canaryOpts, plugin.WithKeyHierarchy(*kekRotationPeriod))
+			}
+			canary := plugin.NewV2(*canaryKey, c, encryptionCtx, plugin.NewSharedHealthCheck(*healthCheckPeriod, *healthCheckErrBuf), canaryOpts...)
+			p2Opts = append(slices.Clone(v2Opts), plugin.WithKeyCanary(canary, *canaryRate))
+		}
+		p2 := plugin.NewV2(key, v2c, encryptionCtx, sharedHealthCheck, p2Opts...)
+		allP2s = append(allP2s, p2)
+		if *v1Shim {
+			plugin.NewV1Shim(p2).Register(s.Server)
+		} else {
+			p.Register(s.Server)
+		}
 		p2.Register(s.Server)
+		if *grpcAdmin {
+			admin.RegisterWarmService(s.Server, p2)
+		}
 		if *healthKms == "v1" {
 			p1s = append(p1s, p)
+			recoveryProbes = append(recoveryProbes, p.Probe)
 		}
 		if *healthKms == "v2" {
 			p2s = append(p2s, p2)
+			recoveryProbes = append(recoveryProbes, p2.Probe)
+		}
+	}
+	sharedHealthCheck.SetRecoveryProbes(*recoveryProbe, recoveryProbes...)
+	sharedHealthCheck.SetThrottleTolerance(*throttleTolerance)
+	sharedHealthCheck.SetFailureThreshold(*failureThreshold, *failureResults, *failureWindow)
+	sharedHealthCheck.SetProbeErrorTypes(probeErrorTypes)
+	sharedHealthCheck.SetDryRunProbes(*probeDryRun)
+	sharedHealthCheck.SetDescribeKeyProbes(*probeDescribeKey)
+	sharedHealthCheck.SetMirroredProbePayload(*probePayloadMax)
+	sharedHealthCheck.SetIdleHealthChecks(*idleAfter, *idleCheckPeriod)
+	sharedHealthCheck.SetTrafficAwareChecks(*trafficAware)
+	stopHealthCheck := sharedHealthCheck.Start()
+	defer stopHealthCheck()
+	if *statusCacheTTL > 0 {
+		stopc := make(chan struct{})
+		defer close(stopc)
+		for _, p2 := range allP2s {
+			go p2.RunStatusCache(stopc)
+		}
+	}
+
+	healthMux := http.NewServeMux()
+	healthEvaluators := []healthz.Evaluator{}
+	if drainFile != nil {
+		healthEvaluators = append(healthEvaluators, drainFile)
+	}
+	var maintenance *plugin.Maintenance
+	if *adminPath != "" {
+		maintenance = plugin.NewMaintenance(*maintenanceMax)
+		healthEvaluators = append(healthEvaluators, maintenance)
+	}
+	readyEvaluators := append(healthEvaluators, healthz.NamedEvaluator("credentials", healthz.EvaluatorFuncs{HealthFunc: credsWatcher.Ready}))
+	// not ready until the startup preflight passed and the sockets are served
+	startup := &readyz.Startup{}
+	// every health transport reports the same checks, so they all agree
+	healthChecks := healthz.Checks{
+		Health: healthz.HealthChecks(p1s, p2s, healthEvaluators...),
+		Ready:  readyz.Checks(startup, servers, p1s, p2s, readyEvaluators...),
+	}
+	switch *livezPolicy {
+	case livezPolicyKMS:
+		healthChecks.Live = healthz.LiveChecks(p1s, p2s)
+	case livezPolicyProcess:
+		healthChecks.Live = livez.ProcessChecks(servers, sharedHealthCheck)
+	}
+	var transports []healthz.Transport
+	for _, t := range *healthTransports {
+		switch t {
+		case healthTransportHTTP:
+			transports = append(transports, healthz.NewHTTPTransport(healthMux, healthz.HTTPPaths{Health: *healthzPath, Ready: *readyzPath, Live: *livezPath}, *healthCheckPeriod))
+		case healthTransportGRPC:
+			transports = append(transports, healthz.TransportFunc(func(checks healthz.Checks) func() {
+				for _, s := range servers {
+					s.RegisterHealthService(server.HealthChecks{
+						Ready: func() error { return healthz.Run(checks.Ready) },
+						Live:  func() error { return healthz.Run(checks.Live) },
+					})
+				}
+				// the service stops with the servers
+				return func() {}
+			}))
+		case healthTransportFile:
+			transports = append(transports, healthz.NewFileTransport(*healthStatusFile, healthz.DefaultFileTransportPeriod))
+		}
+	}
+	stopHealthTransports := healthz.StartTransports(healthChecks, transports...)
+	defer stopHealthTransports()
+	if *adminPath != "" {
+		// the admin API is not authenticated, so it never shares the listeners of the kubelet probes
+		adminMux := http.NewServeMux()
+		adminMux.Handle(strings.TrimSuffix(*adminPath, "/")+"/ratelimit", admin.NewRateLimitHandler(rateLimiter))
+		adminMux.Handle(strings.TrimSuffix(*adminPath, "/")+"/error-rules", admin.NewErrorRulesHandler())
+		adminMux.Handle(strings.TrimSuffix(*adminPath, "/")+"/maintenance", admin.NewMaintenanceHandler(maintenance))
+		if deprecations != nil {
+			adminMux.Handle(strings.TrimSuffix(*adminPath, "/")+"/deprecations", admin.NewDeprecationsHandler(deprecations))
 		}
+		listenAndServeHTTP("admin", *adminAddrs, adminMux)
 	}
+	if len(*metricsPorts) == 0 {
+		healthMux.Handle("/metrics", promhttp.Handler())
+	} else {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", promhttp.Handler())
+		listenAndServeHTTP("metrics", *metricsPorts, metricsMux)
+	}
+	listenAndServeHTTP("healthcheck", *healthPorts, healthMux)
 
-	go func() {
-		http.Handle(*healthzPath, healthz.NewHandler(p1s, p2s))
-		http.Handle(*livezPath, livez.NewHandler(p1s, p2s))
-		http.Handle("/metrics", promhttp.Handler())
-		if err := http.ListenAndServe(*healthPort, nil); err != nil {
-			zap.L().Fatal("Failed to start healthcheck server", zap.Error(err))
+	if *startupDeadline > 0 {
+		// the health listeners are served meanwhile, so the kubelet doesn't restart a slow startup
+		ctx, cancel := context.WithTimeout(context.Background(), *startupDeadline)
+		err := plugin.Preflight(ctx, credsWatcher.Ready, allP2s...)
+		cancel()
+		if err != nil {
+			zap.L().Error("Failed the startup preflight, exiting", zap.Duration("startup-deadline", *startupDeadline), zap.Int("exit-code", exitCodeStartupDeadline), zap.Error(err))
+			if crashHandler != nil {
+				if err := crashHandler.Write("startup deadline exceeded"); err != nil {
+					zap.L().Error("Failed to write the crash state", zap.Error(err))
+				}
+			}
+			os.Exit(exitCodeStartupDeadline)
 		}
-	}()
-	zap.L().Info("Healthchecks server started", zap.String("port", *healthPort))
+	}
 
+	tlsConfig := server.TLSConfig{
+		CertFile:     *tlsCertFile,
+		KeyFile:      *tlsKeyFile,
+		ClientCAFile: *tlsClientCAFile,
+		AllowedSANs:  *tlsAllowedSANs,
+	}
 	for i, addr := range *addrs {
 		s := servers[i]
 
@@ -149,8 +886,20 @@ func main() {
 		}()
 
 		zap.L().Info("Plugin server started", zap.String("port", addr))
+
+		if len(*tlsAddrs) > 0 {
+			tlsAddr := (*tlsAddrs)[i]
+			go func() {
+				if err := s.ListenAndServeTLS(tlsAddr, tlsConfig); err != nil {
+					zap.L().Fatal("Failed to start TLS server", zap.Error(err))
+				}
+			}()
+			zap.L().Info("Plugin TLS server started", zap.String("port", tlsAddr))
+		}
 	}
 
+	startup.Complete()
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
@@ -161,10 +910,28 @@ func main() {
 	for _, s := range servers {
 		s.GracefulStop()
 	}
+	// the spans of the requests served last are still batched
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(ctx); err != nil {
+		zap.L().Error("Failed to flush the traces", zap.Error(err))
+	}
+	cancel()
 	zap.L().Info("Exiting...")
 	os.Exit(0)
 }
 
+// serves the handler on every address, exiting if any of them fails
+func listenAndServeHTTP(name string, addrs []string, handler http.Handler) {
+	for _, addr := range addrs {
+		go func() {
+			if err := http.ListenAndServe(addr, handler); err != nil {
+				zap.L().Fatal("Failed to start "+name+" server", zap.String("port", addr), zap.Error(err))
+			}
+		}()
+		zap.L().Info(name+" server started", zap.String("port", addr))
+	}
+}
+
 // get index in array or return default value if out of index
 func getOrDefault[T any](arr []T, index int, defaultVal T) T {
 	if index >= len(arr) || index < 0 {
@@ -196,3 +963,17 @@ func stringToStringConv(val string) (interface{}, error) {
 	}
 	return out, nil
 }
+
+// flagAliases are the alternative names of flags
+var flagAliases = map[string]string{
+	"max-inflight-kms-requests": "kms-max-in-flight",
+	"startup-timeout":           "startup-deadline",
+}
+
+// normalizeFlagAliases maps the flag aliases to their flag
+func normalizeFlagAliases(f *flag.FlagSet, name string) flag.NormalizedName {
+	if alias, ok := flagAliases[name]; ok {
+		name = alias
+	}
+	return flag.NormalizedName(name)
+}
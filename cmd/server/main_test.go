@@ -15,7 +15,9 @@ package main
 
 import (
 	"testing"
+	"time"
 
+	flag "github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -106,3 +108,103 @@ func TestStringToStringConv(t *testing.T) {
 		})
 	}
 }
+
+func TestFlagValidator(t *testing.T) {
+	v := &flagValidator{}
+	assert.NoError(t, v.err())
+
+	v.check(true, []string{"health-port"}, "not reported", "")
+	v.check(false, []string{"key", "listen"}, "key and listen lists must have the same number of elements", "list one --listen address per --key")
+	v.check(false, []string{"slo-target"}, "expected in [0, 1)", "")
+	err := v.err()
+	assert.Error(t, err)
+	assert.Equal(t, "found 2 problem(s) with the flags:\n"+
+		"  --key, --listen: key and listen lists must have the same number of elements\n"+
+		"    fix: list one --listen address per --key\n"+
+		"  --slo-target: expected in [0, 1)\n", err.Error())
+}
+
+func TestFlagAliases(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetNormalizeFunc(normalizeFlagAliases)
+	deadline := fs.Duration("startup-deadline", 0, "")
+	assert.NoError(t, fs.Parse([]string{"--startup-timeout=2m"}))
+	assert.Equal(t, 2*time.Minute, *deadline)
+}
+
+func TestEndpointProblem(t *testing.T) {
+	tests := []struct {
+		endpoint      string
+		allowInsecure bool
+		ok            bool
+	}{
+		{endpoint: "https://vpce-1234.kms.us-west-2.vpce.amazonaws.com", ok: true},
+		{endpoint: "https://kms.us-iso-east-1.c2s.ic.gov", allowInsecure: true, ok: true},
+		{endpoint: "http://localhost:4566", ok: false},
+		{endpoint: "http://localhost:4566", allowInsecure: true, ok: true},
+		{endpoint: "ftp://localhost:4566", allowInsecure: true, ok: false},
+		{endpoint: "kms.us-west-2.amazonaws.com", ok: false},
+	}
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			assert.Equal(t, test.ok, endpointProblem(test.endpoint, test.allowInsecure) == "")
+		})
+	}
+}
+
+func TestExpandListenAddrs(t *testing.T) {
+	keys := []string{
+		"arn:aws:kms:us-west-2:111122223333:alias/team/secrets",
+		"alias/backup",
+	}
+	tests := []struct {
+		name     string
+		addrs    []string
+		keys     []string
+		cluster  string
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "untemplated",
+			addrs:    []string{"/var/run/kmsplugin/socket.sock"},
+			keys:     []string{""},
+			expected: []string{"/var/run/kmsplugin/socket.sock"},
+		},
+		{
+			name:     "single template for every key",
+			addrs:    []string{"/var/run/kmsplugin/{cluster}-{keyAlias}.sock"},
+			keys:     keys,
+			cluster:  "prod",
+			expected: []string{"/var/run/kmsplugin/prod-team_secrets.sock", "/var/run/kmsplugin/prod-backup.sock"},
+		},
+		{
+			name:     "template per key",
+			addrs:    []string{"/var/run/kmsplugin/{keyID}.sock", "/var/run/kmsplugin/{index}.sock"},
+			keys:     []string{"arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab", "alias/backup"},
+			expected: []string{"/var/run/kmsplugin/1234abcd-12ab-34cd-56ef-1234567890ab.sock", "/var/run/kmsplugin/1.sock"},
+		},
+		{
+			name:     "mismatched lists are reported by the caller",
+			addrs:    []string{"/a.sock", "/b.sock"},
+			keys:     []string{""},
+			expected: []string{"/a.sock", "/b.sock"},
+		},
+		{name: "unknown placeholder", addrs: []string{"/{key}.sock"}, keys: keys, err: true},
+		{name: "alias of a key ARN", addrs: []string{"/{keyAlias}.sock"}, keys: []string{"1234abcd-12ab-34cd-56ef-1234567890ab"}, err: true},
+		{name: "ID of an alias", addrs: []string{"/{keyID}.sock"}, keys: []string{"alias/backup"}, err: true},
+		{name: "missing cluster name", addrs: []string{"/{cluster}.sock"}, keys: keys, err: true},
+		{name: "duplicate address", addrs: []string{"/{cluster}.sock"}, keys: keys, cluster: "prod", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addrs, err := expandListenAddrs(test.addrs, test.keys, test.cluster)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, addrs)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// flagProblem is an invalid flag value or combination of flags
+type flagProblem struct {
+	flags       []string
+	problem     string
+	remediation string
+}
+
+// flagValidator collects the problems of the flags, so all of them are reported at once
+// before the initialization starts
+type flagValidator struct {
+	problems []flagProblem
+}
+
+// check records a problem with the flags unless ok
+func (v *flagValidator) check(ok bool, flags []string, problem, remediation string) {
+	if ok {
+		return
+	}
+	v.problems = append(v.problems, flagProblem{flags: flags, problem: problem, remediation: remediation})
+}
+
+// err returns the problems found with their remediation, nil if there is none
+func (v *flagValidator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "found %d problem(s) with the flags:\n", len(v.problems))
+	for _, p := range v.problems {
+		fmt.Fprintf(&b, "  --%s: %s\n", strings.Join(p.flags, ", --"), p.problem)
+		if p.remediation != "" {
+			fmt.Fprintf(&b, "    fix: %s\n", p.remediation)
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
+
+// endpointProblem returns why endpoint cannot be used as a KMS endpoint, "" if it can.
+// Plain HTTP is only accepted if allowInsecure, e.g. for LocalStack.
+func endpointProblem(endpoint string, allowInsecure bool) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Sprintf("expected an endpoint URL, got %q", endpoint)
+	}
+	switch {
+	case u.Scheme == "https":
+		return ""
+	case u.Scheme == "http" && allowInsecure:
+		return ""
+	default:
+		return fmt.Sprintf("expected an https:// endpoint, got %q", endpoint)
+	}
+}
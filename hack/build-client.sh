@@ -6,3 +6,6 @@ source hack/setup-go.sh
 go version
 go build -ldflags "-w -s" -o bin/grpcclient cmd/client/main.go
 go build -ldflags "-w -s" -o bin/grpcclientv2 cmd/clientv2/main.go
+go build -ldflags "-w -s" -o bin/loadtest cmd/loadtest/main.go
+go build -ldflags "-w -s" -o bin/reconcile cmd/reconcile/main.go
+go build -ldflags "-w -s" -o bin/generate-config cmd/generate-config/main.go
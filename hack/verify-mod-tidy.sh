@@ -6,4 +6,5 @@ source hack/setup-go.sh
 go version
 
 go mod tidy
+(cd test/localkms && go mod tidy)
 git diff --no-patch --exit-code
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+// NewDeprecationsHandler returns a new handler listing the deprecated ciphertext formats
+// and how many of their ciphertexts were decrypted, see plugin.Deprecations.
+func NewDeprecationsHandler(d *plugin.Deprecations) http.Handler {
+	return &deprecationsHandler{d: d}
+}
+
+type deprecationsHandler struct {
+	d *plugin.Deprecations
+}
+
+func (hd *deprecationsHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if e := json.NewEncoder(rw).Encode(hd.d.Status()); e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+func TestDeprecationsHandler(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	d := plugin.NewDeprecations(plugin.DeprecatedCiphertext{Prefix: "1"})
+	hd := NewDeprecationsHandler(d)
+
+	rw := httptest.NewRecorder()
+	hd.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/deprecations", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var status []plugin.DeprecationStatus
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&status))
+	assert.Equal(t, d.Status(), status)
+
+	rw = httptest.NewRecorder()
+	hd.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/deprecations", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// NewErrorRulesHandler returns a new handler listing the active kmsplugin.MessageRules.
+func NewErrorRulesHandler() http.Handler {
+	return &errorRulesHandler{}
+}
+
+type errorRulesHandler struct{}
+
+func (hd *errorRulesHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if e := json.NewEncoder(rw).Encode(kmsplugin.MessageRules()); e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+}
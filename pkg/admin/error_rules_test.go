@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestErrorRulesHandler(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	hd := NewErrorRulesHandler()
+
+	rw := httptest.NewRecorder()
+	hd.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/error-rules", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	var rules []kmsplugin.MessageRule
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&rules))
+	assert.Equal(t, kmsplugin.MessageRules(), rules)
+
+	rw = httptest.NewRecorder()
+	hd.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/error-rules", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
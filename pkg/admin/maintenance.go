@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+// maintenanceBody is the JSON request body starting a plugin.Maintenance
+type maintenanceBody struct {
+	Duration string `json:"duration"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// NewMaintenanceHandler returns a new handler to start and end the maintenance mode.
+//
+// GET returns the current plugin.MaintenanceStatus, PUT starts or extends the maintenance, e.g.
+//
+//	{"duration": "30m", "reason": "node upgrade"}
+//
+// and DELETE ends it. All of them return the resulting status.
+func NewMaintenanceHandler(m *plugin.Maintenance) http.Handler {
+	return &maintenanceHandler{m: m}
+}
+
+type maintenanceHandler struct {
+	m *plugin.Maintenance
+}
+
+func (hd *maintenanceHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var status plugin.MaintenanceStatus
+	switch req.Method {
+	case http.MethodGet:
+		status = hd.m.Status()
+	case http.MethodPut:
+		var body maintenanceBody
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %w", err))
+			return
+		}
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("duration expected a duration, got %q", body.Duration))
+			return
+		}
+		if status, err = hd.m.Start(d, body.Reason); err != nil {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+	case http.MethodDelete:
+		status = hd.m.End()
+	default:
+		rw.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if e := json.NewEncoder(rw).Encode(status); e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+}
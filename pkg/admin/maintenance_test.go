@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+func TestMaintenanceHandler(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	m := plugin.NewMaintenance(time.Hour)
+	hd := NewMaintenanceHandler(m)
+
+	serve := func(method, body string) (*httptest.ResponseRecorder, plugin.MaintenanceStatus) {
+		rw := httptest.NewRecorder()
+		hd.ServeHTTP(rw, httptest.NewRequest(method, "/admin/maintenance", strings.NewReader(body)))
+		var status plugin.MaintenanceStatus
+		if rw.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(rw.Body).Decode(&status))
+		}
+		return rw, status
+	}
+
+	rw, status := serve(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.False(t, status.Active)
+	assert.NoError(t, m.Health())
+
+	rw, status = serve(http.MethodPut, `{"duration": "30m", "reason": "node upgrade"}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, status.Active)
+	assert.Equal(t, "node upgrade", status.Reason)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), status.Until, time.Minute)
+	assert.True(t, errors.Is(m.Health(), plugin.ErrMaintenance))
+	assert.NoError(t, m.Live())
+
+	for _, body := range []string{`{"duration": "2h"}`, `{"duration": "0s"}`, `{"duration": "soon"}`, `{`} {
+		rw, _ = serve(http.MethodPut, body)
+		assert.Equal(t, http.StatusBadRequest, rw.Code, body)
+	}
+
+	rw, status = serve(http.MethodDelete, "")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.False(t, status.Active)
+	assert.NoError(t, m.Health())
+
+	rw, _ = serve(http.MethodPost, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
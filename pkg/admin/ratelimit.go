@@ -0,0 +1,86 @@
+// Package admin implements admin handlers.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+// rateLimitBody is the JSON representation of cloud.RateLimitConfig
+type rateLimitBody struct {
+	RetryTokenCapacity *uint   `json:"retryTokenCapacity,omitempty"`
+	MaxBackoff         *string `json:"maxBackoff,omitempty"`
+}
+
+// NewRateLimitHandler returns a new handler to tune the KMS client rate limiter at runtime.
+//
+// GET returns the current parameters, PUT updates the parameters set in the request body, e.g.
+//
+//	{"retryTokenCapacity": 1000, "maxBackoff": "10s"}
+//
+// A retryTokenCapacity of 0 disables client-side rate limiting.
+func NewRateLimitHandler(rl *cloud.RateLimiter) http.Handler {
+	return &rateLimitHandler{rl: rl}
+}
+
+type rateLimitHandler struct {
+	rl *cloud.RateLimiter
+}
+
+func (hd *rateLimitHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body rateLimitBody
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %w", err))
+			return
+		}
+		cfg := hd.rl.Config()
+		if body.RetryTokenCapacity != nil {
+			cfg.RetryTokenCapacity = *body.RetryTokenCapacity
+		}
+		if body.MaxBackoff != nil {
+			d, err := time.ParseDuration(*body.MaxBackoff)
+			if err != nil || d <= 0 {
+				writeError(rw, http.StatusBadRequest, fmt.Errorf("maxBackoff expected positive duration, got %q", *body.MaxBackoff))
+				return
+			}
+			cfg.MaxBackoff = d
+		}
+		hd.rl.Update(cfg)
+		zap.L().Info("updated rate limits",
+			zap.Uint("retry-token-capacity", cfg.RetryTokenCapacity),
+			zap.Duration("max-backoff", cfg.MaxBackoff),
+		)
+	default:
+		rw.Header().Set("Allow", "GET, PUT")
+		writeError(rw, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	cfg := hd.rl.Config()
+	maxBackoff := cfg.MaxBackoff.String()
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if e := json.NewEncoder(rw).Encode(rateLimitBody{
+		RetryTokenCapacity: &cfg.RetryTokenCapacity,
+		MaxBackoff:         &maxBackoff,
+	}); e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+}
+
+func writeError(rw http.ResponseWriter, code int, err error) {
+	rw.WriteHeader(code)
+	_, e := fmt.Fprint(rw, err)
+	if e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+	zap.L().Warn("admin request failed", zap.Error(err))
+}
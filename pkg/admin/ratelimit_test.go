@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestRateLimitHandler(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	tt := []struct {
+		name       string
+		method     string
+		body       string
+		code       int
+		capacity   uint
+		maxBackoff time.Duration
+	}{
+		{
+			name:       "get defaults",
+			method:     http.MethodGet,
+			code:       http.StatusOK,
+			capacity:   500,
+			maxBackoff: 20 * time.Second,
+		},
+		{
+			name:       "update capacity",
+			method:     http.MethodPut,
+			body:       `{"retryTokenCapacity": 1000}`,
+			code:       http.StatusOK,
+			capacity:   1000,
+			maxBackoff: 20 * time.Second,
+		},
+		{
+			name:       "update max backoff",
+			method:     http.MethodPut,
+			body:       `{"maxBackoff": "5s"}`,
+			code:       http.StatusOK,
+			capacity:   1000,
+			maxBackoff: 5 * time.Second,
+		},
+		{
+			name:       "invalid max backoff",
+			method:     http.MethodPut,
+			body:       `{"maxBackoff": "-1s"}`,
+			code:       http.StatusBadRequest,
+			capacity:   1000,
+			maxBackoff: 5 * time.Second,
+		},
+		{
+			name:       "invalid body",
+			method:     http.MethodPut,
+			body:       `{`,
+			code:       http.StatusBadRequest,
+			capacity:   1000,
+			maxBackoff: 5 * time.Second,
+		},
+		{
+			name:       "method not allowed",
+			method:     http.MethodDelete,
+			code:       http.StatusMethodNotAllowed,
+			capacity:   1000,
+			maxBackoff: 5 * time.Second,
+		},
+	}
+
+	rl := cloud.NewRateLimiter()
+	hd := NewRateLimitHandler(rl)
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			hd.ServeHTTP(rw, httptest.NewRequest(entry.method, "/admin/ratelimit", strings.NewReader(entry.body)))
+			assert.Equal(t, entry.code, rw.Code)
+			assert.Equal(t, entry.capacity, rl.Config().RetryTokenCapacity)
+			assert.Equal(t, entry.maxBackoff, rl.Config().MaxBackoff)
+
+			if entry.code == http.StatusOK {
+				var body rateLimitBody
+				assert.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+				assert.Equal(t, entry.capacity, *body.RetryTokenCapacity)
+				assert.Equal(t, entry.maxBackoff.String(), *body.MaxBackoff)
+			}
+		})
+	}
+}
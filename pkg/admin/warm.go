@@ -0,0 +1,111 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+const warmDecryptMethod = "/awsencryptionprovider.admin.v1.Admin/WarmDecrypt"
+
+// WarmResult is the result of a WarmDecrypt call
+type WarmResult struct {
+	Warmed int
+	Failed int
+}
+
+// warmer is the handler type of the admin gRPC service, see admin.proto
+type warmer interface {
+	warmDecrypt(stream grpc.ServerStream) error
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: "awsencryptionprovider.admin.v1.Admin",
+	HandlerType: (*warmer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "WarmDecrypt",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(warmer).warmDecrypt(stream)
+			},
+			ClientStreams: true,
+		},
+	},
+	Metadata: "admin.proto",
+}
+
+// RegisterWarmService registers the admin gRPC service warming the plugin with the server
+func RegisterWarmService(s *grpc.Server, p *plugin.V2Plugin) {
+	zap.L().Info("registering the admin service with grpc server")
+	s.RegisterService(&adminServiceDesc, &warmService{p: p})
+}
+
+type warmService struct {
+	p *plugin.V2Plugin
+}
+
+func (ws *warmService) warmDecrypt(stream grpc.ServerStream) error {
+	var result WarmResult
+	for {
+		ciphertext := &wrapperspb.BytesValue{}
+		err := stream.RecvMsg(ciphertext)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := ws.p.Warm(stream.Context(), ciphertext.Value); err != nil {
+			zap.L().Warn("failed to warm ciphertext", zap.Int("index", result.Warmed+result.Failed), zap.Error(err))
+			result.Failed++
+			continue
+		}
+		result.Warmed++
+	}
+	zap.L().Info("warmed ciphertexts", zap.Int("warmed", result.Warmed), zap.Int("failed", result.Failed))
+	resp, err := structpb.NewStruct(map[string]interface{}{
+		"warmed": result.Warmed,
+		"failed": result.Failed,
+	})
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(resp)
+}
+
+// WarmDecrypt calls the admin gRPC service to warm the plugin served on conn with the ciphertexts
+func WarmDecrypt(ctx context.Context, conn *grpc.ClientConn, ciphertexts [][]byte) (*WarmResult, error) {
+	stream, err := conn.NewStream(ctx, &adminServiceDesc.Streams[0], warmDecryptMethod)
+	if err != nil {
+		return nil, err
+	}
+	for _, ciphertext := range ciphertexts {
+		if err := stream.SendMsg(wrapperspb.Bytes(ciphertext)); err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	resp := &structpb.Struct{}
+	if err := stream.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	fields := resp.GetFields()
+	warmed, failed := fields["warmed"], fields["failed"]
+	if warmed == nil || failed == nil {
+		return nil, fmt.Errorf("unexpected WarmDecrypt response %v", resp)
+	}
+	return &WarmResult{
+		Warmed: int(warmed.GetNumberValue()),
+		Failed: int(failed.GetNumberValue()),
+	}, nil
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+)
+
+type countingKMSMock struct {
+	*cloud.KMSMock
+	decryptCalls atomic.Int32
+}
+
+func (m *countingKMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	m.decryptCalls.Add(1)
+	return m.KMSMock.Decrypt(ctx, params, optFns...)
+}
+
+func TestWarmDecrypt(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	kek := strings.Repeat("k", 32)
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetDefaultGenerateDataKeyResp(kek, "encrypted-kek", nil)
+	c.SetDecryptResp(kek, nil)
+
+	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	writer := plugin.NewV2("test-key", c, nil, sharedHealthCheck, plugin.WithKeyHierarchy(plugin.DefaultKEKRotationPeriod))
+	var ciphertexts [][]byte
+	for i := 0; i < 3; i++ {
+		eRes, err := writer.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte("secret")})
+		if err != nil {
+			t.Fatalf("#%d: unexpected error from Encrypt %v", i, err)
+		}
+		ciphertexts = append(ciphertexts, eRes.Ciphertext)
+	}
+	ciphertexts = append(ciphertexts, []byte{}, []byte("9unknown-version"))
+
+	// a restarted plugin, warmed through the admin service
+	p := plugin.NewV2("test-key", c, nil, sharedHealthCheck, plugin.WithKeyHierarchy(plugin.DefaultKEKRotationPeriod))
+	s := server.New()
+	RegisterWarmService(s.Server, p)
+	p.Register(s.Server)
+	addr := filepath.Join(t.TempDir(), "test.sock")
+	go func() {
+		_ = s.ListenAndServe(addr)
+	}()
+	defer s.Stop()
+
+	conn, err := connection.New(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var res *WarmResult
+	for {
+		if res, err = WarmDecrypt(ctx, conn, ciphertexts); err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error from WarmDecrypt %v", err)
+	}
+	if res.Warmed != 3 || res.Failed != 2 {
+		t.Fatalf("expected 3 warmed and 2 failed ciphertexts, got %+v", res)
+	}
+	if n := c.decryptCalls.Load(); n != 1 {
+		t.Fatalf("expected 1 Decrypt call to warm the KEK, got %d", n)
+	}
+
+	dRes, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: ciphertexts[0]})
+	if err != nil {
+		t.Fatalf("unexpected error from Decrypt %v", err)
+	}
+	if string(dRes.Plaintext) != "secret" {
+		t.Fatalf("expected secret, got %s", string(dRes.Plaintext))
+	}
+	if n := c.decryptCalls.Load(); n != 1 {
+		t.Fatalf("expected the warmed KEK to be used, got %d Decrypt calls", n)
+	}
+}
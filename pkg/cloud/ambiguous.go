@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	smithy "github.com/aws/smithy-go"
+)
+
+var _ aws.RetryerV2 = &ambiguousRetryer{}
+
+// ambiguousRetryer wraps the configured retryer, counting the retries of the calls which failed
+// after they were sent, so KMS may have served them and the retry may count twice against the
+// request quota. KMS has no idempotency token to deduplicate them, so with noRetry they fail
+// instead, leaving the retry to the apiserver.
+type ambiguousRetryer struct {
+	aws.Retryer
+	noRetry bool
+}
+
+func newAmbiguousRetryer(r aws.Retryer, noRetry bool) aws.Retryer {
+	return &ambiguousRetryer{Retryer: r, noRetry: noRetry}
+}
+
+func (r *ambiguousRetryer) IsErrorRetryable(err error) bool {
+	if r.noRetry && isAmbiguousError(err) {
+		return false
+	}
+	return r.Retryer.IsErrorRetryable(err)
+}
+
+// GetRetryToken is called before every retry, with the error of the failed attempt
+func (r *ambiguousRetryer) GetRetryToken(ctx context.Context, opErr error) (func(error) error, error) {
+	release, err := r.Retryer.GetRetryToken(ctx, opErr)
+	if err == nil && isAmbiguousError(opErr) {
+		kmsAmbiguousRetryCounter.WithLabelValues(awsmiddleware.GetOperationName(ctx)).Inc()
+	}
+	return release, err
+}
+
+func (r *ambiguousRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if r2, ok := r.Retryer.(aws.RetryerV2); ok {
+		return r2.GetAttemptToken(ctx)
+	}
+	return r.GetInitialToken(), nil
+}
+
+// isAmbiguousError returns true if err is a failure after the request was sent, e.g. the
+// connection reset while waiting for the response, so KMS may have served the request.
+// KMS errors and connection failures are not ambiguous.
+func isAmbiguousError(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return false
+	}
+	var rte *awshttp.ResponseTimeoutError
+	if errors.As(err, &rte) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "read"
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// DefaultAssumeRoleSessionName is the session name of the assumed role if none is set
+const DefaultAssumeRoleSessionName = "aws-encryption-provider"
+
+type assumeRole struct {
+	roleARN     string
+	externalID  string
+	sessionName string
+}
+
+// WithAssumeRole makes the KMS client use the credentials of roleARN, assumed with STS
+// AssumeRole from the default credentials, e.g. to use a KMS key of another account.
+// externalID is the external ID the trust policy of the role requires, if any. The credentials
+// are cached and refreshed before they expire, failures to assume the role fail the KMS
+// requests, and so the health checks.
+func WithAssumeRole(roleARN, externalID, sessionName string) Option {
+	return func(o *options) {
+		if sessionName == "" {
+			sessionName = DefaultAssumeRoleSessionName
+		}
+		o.assumeRole = &assumeRole{roleARN: roleARN, externalID: externalID, sessionName: sessionName}
+	}
+}
+
+// credentials returns the cached credentials of the assumed role, assumed with cfg
+func (a *assumeRole) credentials(cfg aws.Config, optFns ...func(*aws.CredentialsCacheOptions)) aws.CredentialsProvider {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), a.roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = a.sessionName
+		if a.externalID != "" {
+			o.ExternalID = aws.String(a.externalID)
+		}
+	})
+	return aws.NewCredentialsCache(provider, optFns...)
+}
+
+type webIdentity struct {
+	roleARN   string
+	tokenFile string
+}
+
+// WithWebIdentity makes the KMS client use the credentials of roleARN, assumed with STS
+// AssumeRoleWithWebIdentity and the token of tokenFile, e.g. the IAM role of the service account
+// with IRSA, instead of relying on the default credential chain picking up the AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE environment variables. New fails if the token file cannot be read.
+// The token file is read again on every refresh, so its rotation is picked up. WithAssumeRole
+// chains from these credentials.
+func WithWebIdentity(roleARN, tokenFile string) Option {
+	return func(o *options) {
+		o.webIdentity = &webIdentity{roleARN: roleARN, tokenFile: tokenFile}
+	}
+}
+
+// check returns an error if the token file cannot be read or is empty
+func (w *webIdentity) check() error {
+	token, err := os.ReadFile(w.tokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read web identity token file %w", err)
+	}
+	if len(token) == 0 {
+		return fmt.Errorf("web identity token file %s is empty", w.tokenFile)
+	}
+	return nil
+}
+
+// credentials returns the cached credentials of the role, assumed with cfg
+func (w *webIdentity) credentials(cfg aws.Config, optFns ...func(*aws.CredentialsCacheOptions)) aws.CredentialsProvider {
+	provider := stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), w.roleARN, stscreds.IdentityTokenFile(w.tokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+		o.RoleSessionName = DefaultAssumeRoleSessionName
+	})
+	return aws.NewCredentialsCache(provider, optFns...)
+}
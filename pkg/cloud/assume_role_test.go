@@ -0,0 +1,111 @@
+package cloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+func TestAssumeRole(t *testing.T) {
+	var form map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		form = req.PostForm
+		rw.Header().Set("Content-Type", "text/xml")
+		rw.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>` + //nolint:errcheck
+			`<Credentials><AccessKeyId>ASSUMED</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey><SessionToken>TOKEN</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration></Credentials>` +
+			`<AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/kms/provider</Arn><AssumedRoleId>id:provider</AssumedRoleId></AssumedRoleUser>` +
+			`</AssumeRoleResult><ResponseMetadata><RequestId>request-1</RequestId></ResponseMetadata></AssumeRoleResponse>`))
+	}))
+	defer ts.Close()
+
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "BASE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	t.Setenv("AWS_ENDPOINT_URL_STS", ts.URL)
+
+	c, err := New("us-west-2", "", 0, 0, 0, WithAssumeRole("arn:aws:iam::123456789012:role/kms", "external-1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := c.(*kms.Client).Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASSUMED" {
+		t.Fatalf("expected the credentials of the assumed role, got %q", creds.AccessKeyID)
+	}
+	for k, v := range map[string]string{
+		"RoleArn":         "arn:aws:iam::123456789012:role/kms",
+		"ExternalId":      "external-1",
+		"RoleSessionName": DefaultAssumeRoleSessionName,
+	} {
+		if got := form[k]; len(got) != 1 || got[0] != v {
+			t.Errorf("expected AssumeRole %s %q, got %v", k, v, got)
+		}
+	}
+}
+
+func TestWebIdentity(t *testing.T) {
+	var form map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		form = req.PostForm
+		rw.Header().Set("Content-Type", "text/xml")
+		rw.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleWithWebIdentityResult>` + //nolint:errcheck
+			`<Credentials><AccessKeyId>WEBIDENTITY</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey><SessionToken>TOKEN</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration></Credentials>` +
+			`<AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/kms/provider</Arn><AssumedRoleId>id:provider</AssumedRoleId></AssumedRoleUser>` +
+			`</AssumeRoleWithWebIdentityResult><ResponseMetadata><RequestId>request-1</RequestId></ResponseMetadata></AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer ts.Close()
+
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ENDPOINT_URL_STS", ts.URL)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if _, err := New("us-west-2", "", 0, 0, 0, WithWebIdentity("arn:aws:iam::123456789012:role/kms", tokenFile)); err == nil {
+		t.Fatal("expected an error with a missing token file")
+	}
+	if err := os.WriteFile(tokenFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New("us-west-2", "", 0, 0, 0, WithWebIdentity("arn:aws:iam::123456789012:role/kms", tokenFile)); err == nil {
+		t.Fatal("expected an error with an empty token file")
+	}
+	if err := os.WriteFile(tokenFile, []byte("jwt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New("us-west-2", "", 0, 0, 0, WithWebIdentity("arn:aws:iam::123456789012:role/kms", tokenFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := c.(*kms.Client).Options().Credentials.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "WEBIDENTITY" {
+		t.Fatalf("expected the credentials of the web identity role, got %q", creds.AccessKeyID)
+	}
+	for k, v := range map[string]string{
+		"Action":           "AssumeRoleWithWebIdentity",
+		"RoleArn":          "arn:aws:iam::123456789012:role/kms",
+		"WebIdentityToken": "jwt",
+		"RoleSessionName":  DefaultAssumeRoleSessionName,
+	} {
+		if got := form[k]; len(got) != 1 || got[0] != v {
+			t.Errorf("expected AssumeRoleWithWebIdentity %s %q, got %v", k, v, got)
+		}
+	}
+}
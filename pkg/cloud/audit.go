@@ -0,0 +1,81 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.uber.org/zap"
+)
+
+// requestIDHeader carries the KMS request ID, also recorded by CloudTrail
+const requestIDHeader = "X-Amzn-Requestid"
+
+// AuditRecord is a KMS request attempt answered by KMS, see WithAuditLog
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	RequestID  string    `json:"requestID"`
+	StatusCode int       `json:"statusCode"`
+	// CorrelationID is the provider request ID of the call, see WithCorrelationIDs
+	CorrelationID string `json:"correlationID,omitempty"`
+}
+
+// WithAuditLog writes an AuditRecord as a JSON line to w for every KMS request attempt
+// answered by KMS, including retries, so the KMS events of CloudTrail can be reconciled
+// with the requests of the provider by request ID.
+func WithAuditLog(w io.Writer) Option {
+	return func(o *options) {
+		o.auditLog = &auditLog{enc: json.NewEncoder(w)}
+	}
+}
+
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (a *auditLog) record(r AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(r); err != nil {
+		zap.L().Warn("failed to write KMS audit record", zap.String("request-id", r.RequestID), zap.Error(err))
+	}
+}
+
+// addMiddleware records the attempts after the transport, before their response is deserialized
+func (a *auditLog) addMiddleware(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("KMSAuditLog", func(
+		ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler,
+	) (middleware.DeserializeOutput, middleware.Metadata, error) {
+		out, md, err := next.HandleDeserialize(ctx, in)
+		if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+			a.record(AuditRecord{
+				Time:          time.Now().UTC(),
+				Operation:     awsmiddleware.GetOperationName(ctx),
+				RequestID:     resp.Header.Get(requestIDHeader),
+				StatusCode:    resp.StatusCode,
+				CorrelationID: CorrelationID(ctx),
+			})
+		}
+		return out, md, err
+	}), middleware.After)
+}
@@ -0,0 +1,45 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestAuditLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("x-amzn-RequestId", "request-1")
+		rw.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		rw.Write([]byte(`{"CiphertextBlob":"Zm9v","KeyId":"key"}`)) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	// not created with New, so the requests are not counted by the transport metrics
+	var log bytes.Buffer
+	o := &options{}
+	WithAuditLog(&log)(o)
+	c := kms.New(kms.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String(ts.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		APIOptions:   []func(*middleware.Stack) error{o.auditLog.addMiddleware},
+	})
+	if _, err := c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var r AuditRecord
+	if err := json.Unmarshal(log.Bytes(), &r); err != nil {
+		t.Fatalf("failed to decode audit record %q: %v", log.String(), err)
+	}
+	if r.Operation != "Encrypt" || r.RequestID != "request-1" || r.StatusCode != http.StatusOK || r.Time.IsZero() {
+		t.Fatalf("unexpected audit record %+v", r)
+	}
+}
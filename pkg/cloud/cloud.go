@@ -15,10 +15,11 @@ package cloud
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
@@ -29,39 +30,152 @@ import (
 type AWSKMSv2 interface {
 	Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error)
 	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
 }
 
-func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int) (AWSKMSv2, error) {
-	var optFns []func(*config.LoadOptions) error
+// Option configures optional behavior of the KMS client
+type Option func(*options)
+
+type options struct {
+	rateLimiter           *RateLimiter
+	accountIDEndpointMode string
+	endpointDiscovery     string
+	auditLog              *auditLog
+	credentialsWatcher    *CredentialsWatcher
+	assumeRole            *assumeRole
+	webIdentity           *webIdentity
+	httpDebugLog          *httpDebugLog
+	fips                  bool
+	tracing               bool
+	httpClient            aws.HTTPClient
+	grantTokens           []string
+	retry                 RetryConfig
+	correlationIDs        bool
+}
+
+// WithRateLimiter makes the KMS client use the given rate limiter,
+// so its parameters can be tuned at runtime. New configures it from
+// the qps, burst and retryTokenCapacity arguments when those are set.
+func WithRateLimiter(rl *RateLimiter) Option {
+	return func(o *options) {
+		o.rateLimiter = rl
+	}
+}
+
+// WithHTTPClient makes the KMS client, and the STS client of WithAssumeRole or
+// WithWebIdentity, send their requests with the given HTTP client, e.g. an
+// *http.Client with a custom transport for mTLS egress or a proxy.
+func WithHTTPClient(c aws.HTTPClient) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// RetryConfig holds the retry parameters of the KMS client, zero values keeping the AWS SDK defaults
+type RetryConfig struct {
+	// Mode is aws.RetryModeStandard or aws.RetryModeAdaptive, the latter also rate
+	// limiting the first attempts of the calls while KMS throttles
+	Mode aws.RetryMode
+	// MaxAttempts is the maximum number of attempts of a call, including the first one
+	MaxAttempts int
+	// MaxBackoff is the maximum delay between two attempts, the initial
+	// RateLimitConfig.MaxBackoff of the rate limiter of WithRateLimiter if any
+	MaxBackoff time.Duration
+	// NoAmbiguousRetries does not retry the calls which failed after they were sent,
+	// e.g. on a connection reset, as KMS may have served them
+	NoAmbiguousRetries bool
+}
+
+func (c RetryConfig) isZero() bool {
+	return c == RetryConfig{}
+}
+
+// WithRetryConfig makes the KMS client retry with the given parameters
+func WithRetryConfig(c RetryConfig) Option {
+	return func(o *options) {
+		o.retry = c
+	}
+}
+
+func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int, opts ...Option) (AWSKMSv2, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	optFns := []func(*config.LoadOptions) error{}
 	if region != "" {
 		optFns = append(optFns, config.WithRegion(region))
 	}
 
+	if o.accountIDEndpointMode != "" {
+		mode, err := parseAccountIDEndpointMode(o.accountIDEndpointMode)
+		if err != nil {
+			return nil, err
+		}
+		optFns = append(optFns, config.WithAccountIDEndpointMode(mode))
+	}
+	if o.endpointDiscovery != "" {
+		state, err := parseEndpointDiscovery(o.endpointDiscovery)
+		if err != nil {
+			return nil, err
+		}
+		optFns = append(optFns, config.WithEndpointDiscovery(state))
+	}
+
+	if o.httpClient != nil {
+		optFns = append(optFns, config.WithHTTPClient(o.httpClient))
+	}
+
+	if o.fips {
+		optFns = append(optFns, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+
+	if o.credentialsWatcher != nil {
+		optFns = append(optFns, config.WithCredentialsCacheOptions(func(co *aws.CredentialsCacheOptions) {
+			co.ExpiryWindow = o.credentialsWatcher.margin
+		}))
+	}
+
+	rl := o.rateLimiter
+	flatRetryCost := false
 	switch {
 	// Use --retry-token-capacity's value if set, --qps-limit and --burst-limit are deprecated.
 	// https://docs.aws.amazon.com/sdk-for-go/v2/developer-guide/configure-retries-timeouts.html (Client-side rate limiting)
 	case retryTokenCapacity > 0:
-		optFns = append(optFns, config.WithRetryer(func() aws.Retryer {
-			return retry.NewStandard(func(o *retry.StandardOptions) {
-				o.RateLimiter = ratelimit.NewTokenRateLimit(uint(retryTokenCapacity))
-			})
-		}))
+		if rl == nil {
+			rl = NewRateLimiter()
+		}
+		rl.Update(RateLimitConfig{
+			RetryTokenCapacity: uint(retryTokenCapacity),
+			MaxBackoff:         rl.Config().MaxBackoff,
+		})
 	case qps > 0:
 		zap.L().Info("--qps-limit and --burst-limit are deprecated, use --retry-token-capacity instead")
 		if burst <= 0 {
 			return nil, fmt.Errorf("burst expected >0, got %d", burst)
 		}
-		optFns = append(optFns, config.WithRetryer(func() aws.Retryer {
-			return retry.NewStandard(func(o *retry.StandardOptions) {
-				// Attempt to set a "reasonable" value from the previous intent of --qps-limit and --burst-limit.
-				// In aws-sdk-go-v2, client-side rate limits only apply on retries, with varying token cost depending
-				// on the type of retry. However, --qps-limit and --burst-limit used to apply to all requests, so set
-				// all retry costs to a flat value of 1 until these flags are fully deprecated
-				o.RateLimiter = ratelimit.NewTokenRateLimit(uint(qps) + uint(burst))
-				o.RetryCost = 1
-				o.RetryTimeoutCost = 1
-			})
-		}))
+		if rl == nil {
+			rl = NewRateLimiter()
+		}
+		// Attempt to set a "reasonable" value from the previous intent of --qps-limit and --burst-limit.
+		// In aws-sdk-go-v2, client-side rate limits only apply on retries, with varying token cost depending
+		// on the type of retry. However, --qps-limit and --burst-limit used to apply to all requests, so set
+		// all retry costs to a flat value of 1 until these flags are fully deprecated
+		rl.Update(RateLimitConfig{
+			RetryTokenCapacity: uint(qps) + uint(burst),
+			MaxBackoff:         rl.Config().MaxBackoff,
+		})
+		flatRetryCost = true
+	}
+	if rl != nil && o.retry.MaxBackoff > 0 {
+		rl.Update(RateLimitConfig{
+			RetryTokenCapacity: rl.Config().RetryTokenCapacity,
+			MaxBackoff:         o.retry.MaxBackoff,
+		})
+	}
+	if rl != nil || !o.retry.isZero() {
+		optFns = append(optFns, config.WithRetryer(newRetryer(rl, flatRetryCost, o.retry)))
 	}
 
 	cfg, err := config.LoadDefaultConfig(context.Background(), optFns...)
@@ -78,7 +192,128 @@ func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int) (AWSKMS
 		cfg.Region = region.Region
 	}
 
-	var kmsOptFns []func(*kms.Options)
+	if o.fips {
+		if err := checkFIPSEndpoints(context.Background(), cfg.Region, kmsEndpoint, o.assumeRole != nil || o.webIdentity != nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return newClient(cfg, kmsEndpoint, o)
+}
+
+// NewFromConfig returns a KMS client built from cfg rather than from the default AWS
+// configuration chain, for embedders providing their own credentials, region or HTTP
+// client. The options acting on the loading of the configuration (WithAccountIDEndpointMode,
+// WithEndpointDiscovery and WithFIPS) are rejected, set them on cfg instead.
+func NewFromConfig(cfg aws.Config, kmsEndpoint string, opts ...Option) (AWSKMSv2, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.accountIDEndpointMode != "" || o.endpointDiscovery != "" || o.fips {
+		return nil, errors.New("account ID endpoint mode, endpoint discovery and FIPS options must be set on the AWS config")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("the AWS config must have a region")
+	}
+
+	if o.httpClient != nil {
+		cfg.HTTPClient = o.httpClient
+	}
+	if o.rateLimiter != nil && o.retry.MaxBackoff > 0 {
+		o.rateLimiter.Update(RateLimitConfig{
+			RetryTokenCapacity: o.rateLimiter.Config().RetryTokenCapacity,
+			MaxBackoff:         o.retry.MaxBackoff,
+		})
+	}
+	if o.rateLimiter != nil || !o.retry.isZero() {
+		cfg.Retryer = newRetryer(o.rateLimiter, false, o.retry)
+	}
+	return newClient(cfg, kmsEndpoint, o)
+}
+
+// newRetryer returns the retryer of the retry config, taking its tokens and backoff from
+// rl if not nil
+func newRetryer(rl *RateLimiter, flatRetryCost bool, rc RetryConfig) func() aws.Retryer {
+	standardOpts := func(o *retry.StandardOptions) {
+		if rc.MaxAttempts > 0 {
+			o.MaxAttempts = rc.MaxAttempts
+		}
+		if rc.MaxBackoff > 0 {
+			o.MaxBackoff = rc.MaxBackoff
+		}
+		if rl != nil {
+			o.RateLimiter = rl
+			o.Backoff = rl
+		}
+		if flatRetryCost {
+			o.RetryCost = 1
+			o.RetryTimeoutCost = 1
+		}
+	}
+	return func() aws.Retryer {
+		if rc.Mode == aws.RetryModeAdaptive {
+			return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+				o.StandardOptions = append(o.StandardOptions, standardOpts)
+			})
+		}
+		return retry.NewStandard(standardOpts)
+	}
+}
+
+// newClient returns the KMS client of cfg, with the credentials and middlewares of o
+func newClient(cfg aws.Config, kmsEndpoint string, o *options) (AWSKMSv2, error) {
+	cacheOptFns := []func(*aws.CredentialsCacheOptions){}
+	if o.credentialsWatcher != nil {
+		cacheOptFns = append(cacheOptFns, func(co *aws.CredentialsCacheOptions) {
+			co.ExpiryWindow = o.credentialsWatcher.margin
+		})
+	}
+	if o.webIdentity != nil {
+		if err := o.webIdentity.check(); err != nil {
+			return nil, err
+		}
+		cfg.Credentials = o.webIdentity.credentials(cfg, cacheOptFns...)
+	}
+	if o.assumeRole != nil {
+		cfg.Credentials = o.assumeRole.credentials(cfg, cacheOptFns...)
+	}
+	if o.credentialsWatcher != nil && cfg.Credentials != nil {
+		o.credentialsWatcher.provider = cfg.Credentials
+		cfg.Credentials = o.credentialsWatcher
+	}
+
+	kmsOptFns := []func(*kms.Options){
+		func(ko *kms.Options) {
+			ko.HTTPClient = newInstrumentedHTTPClient(ko.HTTPClient)
+			ko.Retryer = newAmbiguousRetryer(newRetryPolicyRetryer(newPolicyPropagationRetryer(newRetryAfterRetryer(ko.Retryer))), o.retry.NoAmbiguousRetries)
+		},
+	}
+	if o.httpDebugLog != nil {
+		kmsOptFns = append(kmsOptFns, func(ko *kms.Options) {
+			ko.HTTPClient = &httpDebugClient{next: ko.HTTPClient, log: o.httpDebugLog}
+		})
+	}
+	if o.auditLog != nil {
+		kmsOptFns = append(kmsOptFns, func(ko *kms.Options) {
+			ko.APIOptions = append(ko.APIOptions, o.auditLog.addMiddleware)
+		})
+	}
+	if len(o.grantTokens) > 0 {
+		kmsOptFns = append(kmsOptFns, func(ko *kms.Options) {
+			ko.APIOptions = append(ko.APIOptions, grantTokensMiddleware(o.grantTokens))
+		})
+	}
+	if o.correlationIDs {
+		kmsOptFns = append(kmsOptFns, func(ko *kms.Options) {
+			ko.APIOptions = append(ko.APIOptions, addCorrelationIDMiddleware)
+		})
+	}
+	if o.tracing {
+		kmsOptFns = append(kmsOptFns, func(ko *kms.Options) {
+			ko.APIOptions = append(ko.APIOptions, addTracingMiddleware)
+		})
+	}
 	if kmsEndpoint != "" {
 		kmsOptFns = append(kmsOptFns, func(o *kms.Options) {
 			o.BaseEndpoint = aws.String(kmsEndpoint)
@@ -88,3 +323,19 @@ func New(region, kmsEndpoint string, qps, burst, retryTokenCapacity int) (AWSKMS
 	client := kms.NewFromConfig(cfg, kmsOptFns...)
 	return client, nil
 }
+
+// Regioner is implemented by the clients wrapping a KMS client, so Region sees through them
+type Regioner interface {
+	Region() string
+}
+
+// Region returns the region the KMS client was configured with, or "" if unknown
+func Region(c AWSKMSv2) string {
+	switch kc := c.(type) {
+	case *kms.Client:
+		return kc.Options().Region
+	case Regioner:
+		return kc.Region()
+	}
+	return ""
+}
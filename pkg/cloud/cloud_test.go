@@ -1,9 +1,19 @@
 package cloud
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -104,3 +114,173 @@ func TestNewConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestNewEndpointOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		expectErr bool
+	}{
+		{
+			name: "account ID endpoint mode required",
+			opts: []Option{WithAccountIDEndpointMode("required")},
+		},
+		{
+			name:      "invalid account ID endpoint mode",
+			opts:      []Option{WithAccountIDEndpointMode("always")},
+			expectErr: true,
+		},
+		{
+			name: "endpoint discovery enabled",
+			opts: []Option{WithEndpointDiscovery("enabled")},
+		},
+		{
+			name:      "invalid endpoint discovery",
+			opts:      []Option{WithEndpointDiscovery("on")},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New("us-west-2", "", 0, 0, 0, test.opts...)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// countingTransport answers the Encrypt requests itself, without connection,
+// so the transport metrics are left untouched
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	rec.WriteString(`{"CiphertextBlob":"Zm9v","KeyId":"key"}`) //nolint:errcheck
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestNewFromConfig(t *testing.T) {
+	cfg := aws.Config{Region: "us-west-2", Credentials: aws.AnonymousCredentials{}}
+	cfgTransport, optTransport := &countingTransport{}, &countingTransport{}
+	cfg.HTTPClient = &http.Client{Transport: cfgTransport}
+	for _, opts := range [][]Option{nil, {WithHTTPClient(&http.Client{Transport: optTransport})}} {
+		c, err := NewFromConfig(cfg, "https://kms.example.com", opts...)
+		assert.NoError(t, err)
+		_, err = c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), cfgTransport.requests.Load(), "expected the HTTP client of the config to send the first request")
+	assert.Equal(t, int32(1), optTransport.requests.Load(), "expected the HTTP client of WithHTTPClient to send the second request")
+	assert.Equal(t, "us-west-2", Region(mustNewFromConfig(t, cfg)))
+
+	_, err := NewFromConfig(aws.Config{}, "")
+	assert.Error(t, err, "expected an error without region")
+	_, err = NewFromConfig(cfg, "", WithFIPS())
+	assert.Error(t, err, "expected an error with a configuration loading option")
+}
+
+func mustNewFromConfig(t *testing.T, cfg aws.Config) AWSKMSv2 {
+	t.Helper()
+	c, err := NewFromConfig(cfg, "")
+	assert.NoError(t, err)
+	return c
+}
+
+// throttlingTransport counts the requests and throttles them all
+type throttlingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *throttlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	rec.WriteHeader(http.StatusBadRequest)
+	rec.WriteString(`{"__type":"ThrottlingException","message":"Rate exceeded"}`) //nolint:errcheck
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestWithRetryConfig(t *testing.T) {
+	for _, mode := range []aws.RetryMode{aws.RetryModeStandard, aws.RetryModeAdaptive} {
+		t.Run(string(mode), func(t *testing.T) {
+			transport := &throttlingTransport{}
+			cfg := aws.Config{Region: "us-west-2", Credentials: aws.AnonymousCredentials{}, HTTPClient: &http.Client{Transport: transport}}
+			c, err := NewFromConfig(cfg, "https://kms.example.com",
+				WithRetryConfig(RetryConfig{Mode: mode, MaxAttempts: 2, MaxBackoff: time.Millisecond}))
+			assert.NoError(t, err)
+			_, err = c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")})
+			assert.Error(t, err)
+			assert.Equal(t, int32(2), transport.requests.Load(), "expected --kms-max-attempts attempts")
+		})
+	}
+}
+
+// resettingTransport fails the first request as if the connection was reset while
+// waiting for the response, then encrypts
+type resettingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *resettingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.requests.Add(1) == 1 {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	rec.WriteString(`{"CiphertextBlob":"Zm9v","KeyId":"key"}`) //nolint:errcheck
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestAmbiguousRetries(t *testing.T) {
+	for _, noRetry := range []bool{false, true} {
+		transport := &resettingTransport{}
+		cfg := aws.Config{Region: "us-west-2", Credentials: aws.AnonymousCredentials{}, HTTPClient: &http.Client{Transport: transport}}
+		c, err := NewFromConfig(cfg, "https://kms.example.com",
+			WithRetryConfig(RetryConfig{MaxBackoff: time.Millisecond, NoAmbiguousRetries: noRetry}))
+		assert.NoError(t, err)
+		before := counterValue(t, "aws_encryption_provider_kms_ambiguous_retries_total")
+		_, err = c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")})
+		retries := counterValue(t, "aws_encryption_provider_kms_ambiguous_retries_total") - before
+		if noRetry {
+			assert.Error(t, err)
+			assert.Equal(t, int32(1), transport.requests.Load(), "expected the ambiguous failure not to be retried")
+			assert.Equal(t, 0.0, retries)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, int32(2), transport.requests.Load(), "expected the ambiguous failure to be retried")
+			assert.Equal(t, 1.0, retries)
+		}
+	}
+}
+
+// counterValue returns the sum of the values of the registered counter
+func counterValue(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v float64
+	for _, f := range families {
+		if f.GetName() == name {
+			for _, m := range f.GetMetric() {
+				v += m.GetCounter().GetValue()
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// CorrelationIDHeader carries the provider request ID of a KMS call, see WithCorrelationIDs
+const CorrelationIDHeader = "X-Aws-Encryption-Provider-Request-Id"
+
+// correlationUserAgentKey prefixes the provider request ID in the User-Agent of a KMS call,
+// as CloudTrail records the user agent of the calls but not their other headers
+const correlationUserAgentKey = "aws-encryption-provider-request"
+
+type correlationIDKey struct{}
+
+// ContextWithCorrelationID returns ctx carrying the provider request ID id, sent with the
+// KMS calls made with ctx if WithCorrelationIDs is set
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the provider request ID carried by ctx, "" if none
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// NewCorrelationID returns a random provider request ID, for requests the apiserver sent without UID
+func NewCorrelationID() string {
+	b := make([]byte, 16)
+	// never fails, see rand.Read
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationIDs sends the provider request ID of the context of every KMS call, see
+// ContextWithCorrelationID, in the CorrelationIDHeader and in the User-Agent recorded by
+// CloudTrail, so the CloudTrail events, the provider logs and the apiserver audit entries
+// of a request can be joined. Calls without request ID are sent unchanged.
+func WithCorrelationIDs() Option {
+	return func(o *options) {
+		o.correlationIDs = true
+	}
+}
+
+// addCorrelationIDMiddleware sets the provider request ID headers once the User-Agent is built,
+// before the request is signed
+func addCorrelationIDMiddleware(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("KMSCorrelationID", func(
+		ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+	) (middleware.BuildOutput, middleware.Metadata, error) {
+		if id := CorrelationID(ctx); id != "" {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set(CorrelationIDHeader, id)
+				ua := req.Header.Get("User-Agent")
+				req.Header.Set("User-Agent", strings.TrimSpace(ua+" "+correlationUserAgentKey+"/"+id))
+			}
+		}
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+}
@@ -0,0 +1,54 @@
+package cloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+)
+
+// headerTransport records the headers of the last request
+type headerTransport struct {
+	header http.Header
+}
+
+func (h *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.header = req.Header.Clone()
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	rec.WriteString(`{"CiphertextBlob":"Zm9v","KeyId":"key"}`) //nolint:errcheck
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestWithCorrelationIDs(t *testing.T) {
+	transport := &headerTransport{}
+	cfg := aws.Config{Region: "us-west-2", Credentials: aws.AnonymousCredentials{}, HTTPClient: &http.Client{Transport: transport}}
+	c, err := NewFromConfig(cfg, "https://kms.example.com", WithCorrelationIDs())
+	assert.NoError(t, err)
+
+	ctx := ContextWithCorrelationID(context.Background(), "6f1c8a0e-uid")
+	_, err = c.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")})
+	assert.NoError(t, err)
+	assert.Equal(t, "6f1c8a0e-uid", transport.header.Get(CorrelationIDHeader))
+	assert.True(t, strings.HasSuffix(transport.header.Get("User-Agent"), " aws-encryption-provider-request/6f1c8a0e-uid"),
+		"expected the request ID in the User-Agent, got %q", transport.header.Get("User-Agent"))
+
+	// calls without request ID are unchanged
+	_, err = c.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")})
+	assert.NoError(t, err)
+	assert.Empty(t, transport.header.Get(CorrelationIDHeader))
+	assert.NotContains(t, transport.header.Get("User-Agent"), correlationUserAgentKey)
+}
+
+func TestNewCorrelationID(t *testing.T) {
+	a, b := NewCorrelationID(), NewCorrelationID()
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+}
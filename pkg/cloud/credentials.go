@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+const (
+	// period between two refresh attempts of credentials still valid after a failed one
+	credentialsRetryPeriod  = 10 * time.Second
+	credentialsReadyTimeout = 5 * time.Second
+)
+
+// CredentialsWatcher tracks the validity of the credentials of the KMS client,
+// see WithCredentialsWatcher.
+type CredentialsWatcher struct {
+	margin      time.Duration
+	retryPeriod time.Duration
+
+	provider aws.CredentialsProvider
+
+	mu sync.Mutex
+	// latest credentials retrieved, their Expires is margin before the actual expiry
+	creds aws.Credentials
+	// error of the latest refresh attempt, nil once one succeeds
+	refreshErr   error
+	refreshErrTs time.Time
+}
+
+// NewCredentialsWatcher returns a new *CredentialsWatcher refreshing the credentials
+// margin before they expire
+func NewCredentialsWatcher(margin time.Duration) *CredentialsWatcher {
+	return &CredentialsWatcher{margin: margin, retryPeriod: credentialsRetryPeriod}
+}
+
+// WithCredentialsWatcher makes the KMS client refresh its credentials the margin of w
+// before they expire, and keep using them while the refresh fails until they actually
+// expire, so w.Ready can report the failure before the requests fail.
+func WithCredentialsWatcher(w *CredentialsWatcher) Option {
+	return func(o *options) {
+		o.credentialsWatcher = w
+	}
+}
+
+// Ready returns an error if the credentials are expired or within the margin of their
+// expiry and no refresh succeeded, e.g. to hold restarts of the apiservers until they are
+// healthy. It refreshes the credentials if they are due, but never calls KMS.
+func (w *CredentialsWatcher) Ready() error {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialsReadyTimeout)
+	defer cancel()
+	creds, err := w.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve credentials %w", err)
+	}
+	if !creds.CanExpire || time.Now().Before(creds.Expires) {
+		return nil
+	}
+	w.mu.Lock()
+	refreshErr := w.refreshErr
+	w.mu.Unlock()
+	if refreshErr == nil {
+		return fmt.Errorf("credentials expire at %s, within %s", creds.Expires.Add(w.margin).Format(time.RFC3339), w.margin)
+	}
+	return fmt.Errorf("credentials expire at %s, within %s, and failed to refresh %w", creds.Expires.Add(w.margin).Format(time.RFC3339), w.margin, refreshErr)
+}
+
+// Retrieve implements aws.CredentialsProvider, falling back to the latest credentials
+// while they are valid if the refresh fails
+func (w *CredentialsWatcher) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if w.provider == nil {
+		return aws.Credentials{}, errors.New("no credentials configured")
+	}
+	w.mu.Lock()
+	last, refreshErr, refreshErrTs := w.creds, w.refreshErr, w.refreshErrTs
+	w.mu.Unlock()
+	if refreshErr != nil && w.valid(last) && time.Since(refreshErrTs) < w.retryPeriod {
+		return last, nil
+	}
+
+	creds, err := w.provider.Retrieve(ctx)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		w.refreshErr, w.refreshErrTs = err, time.Now()
+		if w.valid(w.creds) {
+			zap.L().Warn("failed to refresh credentials, using the current ones until they expire",
+				zap.Time("expires", w.creds.Expires.Add(w.margin)), zap.Error(err))
+			return w.creds, nil
+		}
+		return aws.Credentials{}, err
+	}
+	w.creds, w.refreshErr = creds, nil
+	return creds, nil
+}
+
+// valid returns true if creds are not actually expired
+func (w *CredentialsWatcher) valid(creds aws.Credentials) bool {
+	return creds.HasKeys() && (!creds.CanExpire || time.Now().Before(creds.Expires.Add(w.margin)))
+}
@@ -0,0 +1,90 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type fakeCredentialsProvider struct {
+	mu      sync.Mutex
+	expires time.Time
+	err     error
+}
+
+func (p *fakeCredentialsProvider) set(expires time.Time, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expires, p.err = expires, err
+}
+
+func (p *fakeCredentialsProvider) Retrieve(context.Context) (aws.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return aws.Credentials{}, p.err
+	}
+	return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", CanExpire: true, Expires: p.expires}, nil
+}
+
+func TestCredentialsWatcher(t *testing.T) {
+	fake := &fakeCredentialsProvider{}
+	w := NewCredentialsWatcher(time.Hour)
+	w.retryPeriod = 0
+	w.provider = aws.NewCredentialsCache(fake, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = w.margin
+	})
+
+	fake.set(time.Now().Add(2*time.Hour), nil)
+	if err := w.Ready(); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+
+	// refreshed within the margin, but the new credentials are still within it
+	fake.set(time.Now().Add(30*time.Minute), nil)
+	w.provider.(*aws.CredentialsCache).Invalidate()
+	if err := w.Ready(); err == nil || !strings.Contains(err.Error(), "within 1h0m0s") {
+		t.Fatalf("expected credentials within the margin to fail, got %v", err)
+	}
+
+	// failed refresh, the requests keep using the valid credentials
+	refreshErr := errors.New("sts unavailable")
+	fake.set(time.Time{}, refreshErr)
+	creds, err := w.Retrieve(context.Background())
+	if err != nil || !creds.HasKeys() {
+		t.Fatalf("expected the current credentials while valid, got %v", err)
+	}
+	if err := w.Ready(); !errors.Is(err, refreshErr) {
+		t.Fatalf("expected the refresh error, got %v", err)
+	}
+
+	// refresh succeeds again
+	fake.set(time.Now().Add(2*time.Hour), nil)
+	if err := w.Ready(); err != nil {
+		t.Fatalf("expected ready after a successful refresh, got %v", err)
+	}
+}
+
+func TestCredentialsWatcherExpired(t *testing.T) {
+	fake := &fakeCredentialsProvider{}
+	w := NewCredentialsWatcher(0)
+	w.provider = fake
+
+	fake.set(time.Now().Add(-time.Minute), nil)
+	if err := w.Ready(); err == nil {
+		t.Fatal("expected expired credentials to fail")
+	}
+
+	fake.set(time.Time{}, errors.New("sts unavailable"))
+	if _, err := w.Retrieve(context.Background()); err == nil {
+		t.Fatal("expected no fallback to expired credentials")
+	}
+	if err := w.Ready(); err == nil {
+		t.Fatal("expected a failed refresh without valid credentials to fail")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+const redacted = "REDACTED"
+
+// headers whose value is replaced with redacted in the debug logs
+var redactedHeaders = map[string]struct{}{
+	"X-Amz-Security-Token": {},
+	"Cookie":               {},
+	"Set-Cookie":           {},
+}
+
+// WithHTTPDebugLog logs the KMS HTTP requests and responses (method, URL, headers, status
+// and duration) for the given duration or until maxRequests requests were logged, whichever
+// comes first, e.g. to debug signature or endpoint issues in the field. Bodies are never
+// logged, only their length, and the credentials are redacted: the session token, the access
+// key ID and signature of the Authorization header, whose credential scope and signed
+// headers are kept.
+func WithHTTPDebugLog(duration time.Duration, maxRequests int) Option {
+	return func(o *options) {
+		d := &httpDebugLog{until: time.Now().Add(duration)}
+		d.remaining.Store(int64(maxRequests))
+		o.httpDebugLog = d
+	}
+}
+
+type httpDebugLog struct {
+	until     time.Time
+	remaining atomic.Int64
+}
+
+// enabled returns true if the next request is logged, consuming one of the remaining requests
+func (d *httpDebugLog) enabled() bool {
+	if time.Now().After(d.until) {
+		return false
+	}
+	return d.remaining.Add(-1) >= 0
+}
+
+// httpDebugClient logs the requests of the wrapped client, see WithHTTPDebugLog
+type httpDebugClient struct {
+	next aws.HTTPClient
+	log  *httpDebugLog
+}
+
+func (c *httpDebugClient) Do(req *http.Request) (*http.Response, error) {
+	if !c.log.enabled() {
+		return c.next.Do(req)
+	}
+	start := time.Now()
+	resp, err := c.next.Do(req)
+	fields := []zap.Field{
+		zap.String("method", req.Method),
+		zap.String("url", req.URL.Redacted()),
+		zap.Any("request-headers", sanitizeHeaders(req.Header)),
+		zap.Int64("request-body-length", req.ContentLength),
+		zap.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	} else {
+		fields = append(fields,
+			zap.Int("status", resp.StatusCode),
+			zap.Any("response-headers", sanitizeHeaders(resp.Header)),
+			zap.Int64("response-body-length", resp.ContentLength),
+		)
+	}
+	zap.L().Info("KMS HTTP request", fields...)
+	return resp, err
+}
+
+// sanitizeHeaders returns the headers with the credentials redacted
+func sanitizeHeaders(h http.Header) map[string]string {
+	sanitized := make(map[string]string, len(h))
+	for k := range h {
+		v := h.Get(k)
+		switch _, ok := redactedHeaders[k]; {
+		case ok:
+			v = redacted
+		case k == "Authorization":
+			v = sanitizeAuthorization(v)
+		}
+		sanitized[k] = v
+	}
+	return sanitized
+}
+
+// sanitizeAuthorization redacts the access key ID and signature of a SigV4 Authorization header,
+// e.g. "AWS4-HMAC-SHA256 Credential=AKID/20240101/us-west-2/kms/aws4_request, SignedHeaders=host, Signature=abc"
+func sanitizeAuthorization(v string) string {
+	algorithm, params, ok := strings.Cut(v, " ")
+	if !ok {
+		return redacted
+	}
+	parts := strings.Split(params, ",")
+	for i, part := range parts {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "Credential":
+			if _, scope, ok := strings.Cut(value, "/"); ok {
+				value = redacted + "/" + scope
+			} else {
+				value = redacted
+			}
+		case "SignedHeaders":
+		default:
+			value = redacted
+		}
+		parts[i] = name + "=" + value
+	}
+	return algorithm + " " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,72 @@
+package cloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestHTTPDebugLog(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		rw.Write([]byte(`{"CiphertextBlob":"Zm9v","KeyId":"key"}`)) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	o := &options{}
+	WithHTTPDebugLog(time.Hour, 1)(o)
+	client := kms.New(kms.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String(ts.URL),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKIDSECRETID", SecretAccessKey: "SECRET", SessionToken: "SESSIONTOKEN"}, nil
+		}),
+		HTTPClient: &httpDebugClient{next: http.DefaultClient, log: o.httpDebugLog},
+	})
+	for range 2 {
+		if _, err := client.Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("plaintext")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := logs.FilterMessage("KMS HTTP request").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 logged request, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["status"] != int64(http.StatusOK) {
+		t.Fatalf("expected status 200, got %v", fields["status"])
+	}
+	headers := fields["request-headers"].(map[string]string)
+	authorization := headers["Authorization"]
+	if strings.Contains(authorization, "AKIDSECRETID") || !strings.Contains(authorization, "Credential=REDACTED/") ||
+		!strings.Contains(authorization, "/us-west-2/kms/aws4_request") || !strings.Contains(authorization, "Signature=REDACTED") {
+		t.Fatalf("expected a sanitized Authorization header, got %q", authorization)
+	}
+	if headers["X-Amz-Security-Token"] != redacted {
+		t.Fatalf("expected the session token to be redacted, got %q", headers["X-Amz-Security-Token"])
+	}
+	if headers["X-Amz-Target"] != "TrentService.Encrypt" {
+		t.Fatalf("expected the operation header, got %q", headers["X-Amz-Target"])
+	}
+}
+
+func TestHTTPDebugLogExpired(t *testing.T) {
+	o := &options{}
+	WithHTTPDebugLog(-time.Second, 10)(o)
+	if o.httpDebugLog.enabled() {
+		t.Fatal("expected no logging after the duration")
+	}
+}
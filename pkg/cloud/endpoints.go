@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// WithAccountIDEndpointMode sets the account ID based endpoint routing mode of the SDK,
+// one of "preferred", "required" or "disabled". An empty mode keeps the SDK default,
+// which also reads AWS_ACCOUNT_ID_ENDPOINT_MODE and the shared config.
+func WithAccountIDEndpointMode(mode string) Option {
+	return func(o *options) {
+		o.accountIDEndpointMode = mode
+	}
+}
+
+// WithEndpointDiscovery sets the endpoint discovery state of the SDK,
+// one of "auto", "enabled" or "disabled". An empty state keeps the SDK default,
+// which also reads AWS_ENABLE_ENDPOINT_DISCOVERY and the shared config.
+func WithEndpointDiscovery(state string) Option {
+	return func(o *options) {
+		o.endpointDiscovery = state
+	}
+}
+
+func parseAccountIDEndpointMode(mode string) (aws.AccountIDEndpointMode, error) {
+	switch m := aws.AccountIDEndpointMode(mode); m {
+	case aws.AccountIDEndpointModePreferred, aws.AccountIDEndpointModeRequired, aws.AccountIDEndpointModeDisabled:
+		return m, nil
+	default:
+		return "", fmt.Errorf("account ID endpoint mode expected preferred, required or disabled, got %q", mode)
+	}
+}
+
+func parseEndpointDiscovery(state string) (aws.EndpointDiscoveryEnableState, error) {
+	switch state {
+	case "auto":
+		return aws.EndpointDiscoveryAuto, nil
+	case "enabled":
+		return aws.EndpointDiscoveryEnabled, nil
+	case "disabled":
+		return aws.EndpointDiscoveryDisabled, nil
+	default:
+		return aws.EndpointDiscoveryUnset, fmt.Errorf("endpoint discovery expected auto, enabled or disabled, got %q", state)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// fipsLookupTimeout bounds the DNS lookups of the FIPS endpoints at startup
+const fipsLookupTimeout = 5 * time.Second
+
+// lookupHost resolves the FIPS endpoints, replaced by the tests
+var lookupHost = net.DefaultResolver.LookupHost
+
+// WithFIPS makes the KMS client, and the STS client assuming roles, use the FIPS endpoints
+// of the region, e.g. for FedRAMP. New fails if the region has none.
+func WithFIPS() Option {
+	return func(o *options) {
+		o.fips = true
+	}
+}
+
+// checkFIPSEndpoints returns an error if the region has no FIPS endpoint for KMS, unless
+// kmsEndpoint overrides it, or for STS if withSTS
+func checkFIPSEndpoints(ctx context.Context, region, kmsEndpoint string, withSTS bool) error {
+	if kmsEndpoint == "" {
+		ep, err := kms.NewDefaultEndpointResolverV2().ResolveEndpoint(ctx, kms.EndpointParameters{
+			Region:  aws.String(region),
+			UseFIPS: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("region %s has no KMS FIPS endpoint %w", region, err)
+		}
+		if err := checkHost(ctx, ep.URI.Hostname()); err != nil {
+			return fmt.Errorf("region %s has no KMS FIPS endpoint %w", region, err)
+		}
+	}
+	if withSTS {
+		ep, err := sts.NewDefaultEndpointResolverV2().ResolveEndpoint(ctx, sts.EndpointParameters{
+			Region:  aws.String(region),
+			UseFIPS: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("region %s has no STS FIPS endpoint %w", region, err)
+		}
+		if err := checkHost(ctx, ep.URI.Hostname()); err != nil {
+			return fmt.Errorf("region %s has no STS FIPS endpoint %w", region, err)
+		}
+	}
+	return nil
+}
+
+// checkHost returns an error if host does not resolve
+func checkHost(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, fipsLookupTimeout)
+	defer cancel()
+	if _, err := lookupHost(ctx, host); err != nil {
+		return fmt.Errorf("failed to resolve %s %w", host, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+func TestFIPS(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "KEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+
+	var lookups []string
+	defer func(f func(context.Context, string) ([]string, error)) { lookupHost = f }(lookupHost)
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		lookups = append(lookups, host)
+		if host == "kms-fips.us-west-2.amazonaws.com" || host == "sts-fips.us-west-2.amazonaws.com" {
+			return []string{"192.0.2.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	c, err := New("us-west-2", "", 0, 0, 0, WithFIPS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.(*kms.Client).Options().EndpointOptions.UseFIPSEndpoint; got != aws.FIPSEndpointStateEnabled {
+		t.Fatalf("expected the FIPS endpoint to be enabled, got %v", got)
+	}
+	if strings.Join(lookups, ",") != "kms-fips.us-west-2.amazonaws.com" {
+		t.Fatalf("expected only the KMS FIPS endpoint to be checked, got %v", lookups)
+	}
+
+	lookups = nil
+	if _, err := New("us-west-2", "", 0, 0, 0, WithFIPS(), WithAssumeRole("arn:aws:iam::123456789012:role/kms", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lookups, ",") != "kms-fips.us-west-2.amazonaws.com,sts-fips.us-west-2.amazonaws.com" {
+		t.Fatalf("expected the KMS and STS FIPS endpoints to be checked, got %v", lookups)
+	}
+
+	if _, err := New("eu-south-9", "", 0, 0, 0, WithFIPS()); err == nil || !strings.Contains(err.Error(), "region eu-south-9 has no KMS FIPS endpoint") {
+		t.Fatalf("expected an error for a region without FIPS endpoint, got %v", err)
+	}
+
+	lookups = nil
+	if _, err := New("eu-south-9", "https://kms.example.com", 0, 0, 0, WithFIPS()); err != nil {
+		t.Fatal(err)
+	}
+	if len(lookups) != 0 {
+		t.Fatalf("expected no check with a KMS endpoint override, got %v", lookups)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// MaxGrantTokens is the maximum number of grant tokens of a KMS request
+const MaxGrantTokens = 10
+
+// WithGrantTokens adds the grant tokens to every Encrypt, Decrypt and GenerateDataKey call,
+// so the permissions of freshly created grants (e.g. of a key of another account) apply
+// before the grants propagated, instead of failing with InvalidGrantTokenException or
+// AccessDeniedException. See https://docs.aws.amazon.com/kms/latest/developerguide/grant-manage.html#using-grant-token
+func WithGrantTokens(tokens ...string) Option {
+	return func(o *options) {
+		o.grantTokens = tokens
+	}
+}
+
+// grantTokensMiddleware returns the middleware setting the grant tokens on the inputs of the calls
+func grantTokensMiddleware(tokens []string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("KMSGrantTokens", func(
+			ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+		) (middleware.InitializeOutput, middleware.Metadata, error) {
+			// set on copies, the inputs belong to the callers
+			switch params := in.Parameters.(type) {
+			case *kms.EncryptInput:
+				input := *params
+				input.GrantTokens = withGrantTokens(params.GrantTokens, tokens)
+				in.Parameters = &input
+			case *kms.DecryptInput:
+				input := *params
+				input.GrantTokens = withGrantTokens(params.GrantTokens, tokens)
+				in.Parameters = &input
+			case *kms.GenerateDataKeyInput:
+				input := *params
+				input.GrantTokens = withGrantTokens(params.GrantTokens, tokens)
+				in.Parameters = &input
+			}
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+	}
+}
+
+// withGrantTokens returns the tokens of the call followed by the configured ones it lacks
+func withGrantTokens(call, tokens []string) []string {
+	merged := slices.Clone(call)
+	for _, t := range tokens {
+		if !slices.Contains(merged, t) {
+			merged = append(merged, t)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,61 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestGrantTokens(t *testing.T) {
+	var got [][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var body struct{ GrantTokens []string }
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		got = append(got, body.GrantTokens)
+		rw.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		rw.Write([]byte(`{"CiphertextBlob":"Zm9v","Plaintext":"Zm9v","KeyId":"key"}`)) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	// not created with New, so the requests are not counted by the transport metrics
+	o := &options{}
+	WithGrantTokens("token-1", "token-2")(o)
+	c := kms.New(kms.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String(ts.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		APIOptions:   []func(*middleware.Stack) error{grantTokensMiddleware(o.grantTokens)},
+	})
+	ctx := context.Background()
+	if _, err := c.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	decryptInput := &kms.DecryptInput{CiphertextBlob: []byte("foo"), GrantTokens: []string{"token-2", "token-3"}}
+	if _, err := c.Decrypt(ctx, decryptInput); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{KeyId: aws.String("key")}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"token-1", "token-2"},
+		{"token-2", "token-3", "token-1"},
+		{"token-1", "token-2"},
+	}
+	if !slices.EqualFunc(got, expected, slices.Equal[[]string]) {
+		t.Fatalf("expected grant tokens %v, got %v", expected, got)
+	}
+	if len(decryptInput.GrantTokens) != 2 {
+		t.Fatalf("expected the input of the call to be left unchanged, got %v", decryptInput.GrantTokens)
+	}
+}
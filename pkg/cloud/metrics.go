@@ -0,0 +1,68 @@
+package cloud
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/aws-encryption-provider/pkg/metrics"
+)
+
+func init() {
+	registerPrometheusMetrics()
+}
+
+func registerPrometheusMetrics() {
+	prometheus.MustRegister(transportOpenConnections)
+	prometheus.MustRegister(transportConnectionCounter)
+	prometheus.MustRegister(transportDNSLatencyMetric)
+	prometheus.MustRegister(transportTLSLatencyMetric)
+	prometheus.MustRegister(kmsAmbiguousRetryCounter)
+}
+
+var (
+	transportOpenConnections = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_transport_open_connections",
+			Help: "Number of currently open connections from the kms client",
+		},
+	)
+
+	// the connection reuse ratio can be derived from the "reused" label
+	transportConnectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_transport_connections_total",
+			Help: "total connections obtained by the kms client for a request",
+		},
+		[]string{
+			"reused",
+		},
+	)
+
+	transportDNSLatencyMetric = metrics.NewDurationHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_kms_transport_dns_lookup_duration_seconds",
+			Help:    "DNS lookup latency for the kms client",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		},
+		"aws_encryption_provider_kms_transport_dns_lookup_latency_ms",
+		nil,
+	)
+
+	transportTLSLatencyMetric = metrics.NewDurationHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_kms_transport_tls_handshake_duration_seconds",
+			Help:    "TLS handshake latency for the kms client",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		},
+		"aws_encryption_provider_kms_transport_tls_handshake_latency_ms",
+		nil,
+	)
+
+	kmsAmbiguousRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_ambiguous_retries_total",
+			Help: "Number of retries of KMS calls which failed after they were sent, so KMS may have served them twice",
+		},
+		[]string{
+			"operation",
+		},
+	)
+)
@@ -45,6 +45,8 @@ type KMSMock struct {
 	defaultEncErr error
 	defaultDecOut *kms.DecryptOutput
 	defaultDecErr error
+	defaultGenOut *kms.GenerateDataKeyOutput
+	defaultGenErr error
 
 	// Conditional rules (evaluated in order)
 	encryptRules []EncryptRule
@@ -69,6 +71,15 @@ func (m *KMSMock) SetDefaultDecryptResp(dec string, decErr error) *KMSMock {
 	return m
 }
 
+// SetDefaultGenerateDataKeyResp sets the default generate data key response
+func (m *KMSMock) SetDefaultGenerateDataKeyResp(plain, enc string, genErr error) *KMSMock {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.defaultGenOut = &kms.GenerateDataKeyOutput{Plaintext: []byte(plain), CiphertextBlob: []byte(enc)}
+	m.defaultGenErr = genErr
+	return m
+}
+
 // Legacy methods for backward compatibility
 func (m *KMSMock) SetEncryptResp(enc string, encErr error) *KMSMock {
 	return m.SetDefaultEncryptResp(enc, encErr)
@@ -142,3 +153,9 @@ func (m *KMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns
 	// Fall back to default response
 	return m.defaultDecOut, m.defaultDecErr
 }
+
+func (m *KMSMock) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.defaultGenOut, m.defaultGenErr
+}
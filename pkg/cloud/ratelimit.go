@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+var (
+	_ retry.RateLimiter    = &RateLimiter{}
+	_ retry.BackoffDelayer = &RateLimiter{}
+)
+
+// RateLimitConfig holds the parameters of the client-side retry rate limiter.
+type RateLimitConfig struct {
+	// RetryTokenCapacity is the size of the retry token bucket, 0 disables rate limiting.
+	// The deprecated --qps-limit and --burst-limit map to a capacity of qps+burst.
+	RetryTokenCapacity uint
+	// MaxBackoff is the maximum delay between two retry attempts
+	MaxBackoff time.Duration
+}
+
+// RateLimiter is the client-side retry rate limiter and backoff of the KMS client,
+// whose parameters can be changed at runtime with Update.
+type RateLimiter struct {
+	mu      sync.RWMutex
+	config  RateLimitConfig
+	limiter retry.RateLimiter
+	backoff retry.BackoffDelayer
+}
+
+// NewRateLimiter returns a new *RateLimiter with the AWS SDK defaults
+func NewRateLimiter() *RateLimiter {
+	r := &RateLimiter{}
+	r.Update(RateLimitConfig{
+		RetryTokenCapacity: retry.DefaultRetryRateTokens,
+		MaxBackoff:         retry.DefaultMaxBackoff,
+	})
+	return r
+}
+
+// Update replaces the rate limiter parameters.
+// The retry token bucket is recreated at full capacity.
+func (r *RateLimiter) Update(cfg RateLimitConfig) {
+	var limiter retry.RateLimiter = ratelimit.None
+	if cfg.RetryTokenCapacity > 0 {
+		limiter = ratelimit.NewTokenRateLimit(cfg.RetryTokenCapacity)
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = retry.DefaultMaxBackoff
+	}
+	backoff := retry.NewExponentialJitterBackoff(cfg.MaxBackoff)
+
+	r.mu.Lock()
+	r.config, r.limiter, r.backoff = cfg, limiter, backoff
+	r.mu.Unlock()
+}
+
+// Config returns the current rate limiter parameters
+func (r *RateLimiter) Config() RateLimitConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+func (r *RateLimiter) GetToken(ctx context.Context, cost uint) (func() error, error) {
+	r.mu.RLock()
+	limiter := r.limiter
+	r.mu.RUnlock()
+	return limiter.GetToken(ctx, cost)
+}
+
+func (r *RateLimiter) AddTokens(v uint) error {
+	r.mu.RLock()
+	limiter := r.limiter
+	r.mu.RUnlock()
+	return limiter.AddTokens(v)
+}
+
+func (r *RateLimiter) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	r.mu.RLock()
+	backoff := r.backoff
+	r.mu.RUnlock()
+	return backoff.BackoffDelay(attempt, err)
+}
@@ -0,0 +1,44 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterUpdate(t *testing.T) {
+	rl := NewRateLimiter()
+	assert.Equal(t, RateLimitConfig{RetryTokenCapacity: retry.DefaultRetryRateTokens, MaxBackoff: retry.DefaultMaxBackoff}, rl.Config())
+
+	rl.Update(RateLimitConfig{RetryTokenCapacity: 5, MaxBackoff: time.Millisecond})
+	_, err := rl.GetToken(context.Background(), 5)
+	assert.NoError(t, err)
+	_, err = rl.GetToken(context.Background(), 1)
+	assert.Error(t, err, "expected the retry token bucket to be exhausted")
+	for attempt := 1; attempt < 10; attempt++ {
+		d, err := rl.BackoffDelay(attempt, nil)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, d, time.Millisecond)
+	}
+
+	// a capacity of 0 disables rate limiting
+	rl.Update(RateLimitConfig{RetryTokenCapacity: 0, MaxBackoff: time.Second})
+	for i := 0; i < 10; i++ {
+		_, err = rl.GetToken(context.Background(), 100)
+		assert.NoError(t, err)
+	}
+}
+
+func TestNewWithRateLimiter(t *testing.T) {
+	rl := NewRateLimiter()
+	_, err := New("us-west-2", "", 0, 0, 42, WithRateLimiter(rl))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(42), rl.Config().RetryTokenCapacity)
+
+	_, err = New("us-west-2", "", 10, 5, 0, WithRateLimiter(rl))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(15), rl.Config().RetryTokenCapacity)
+}
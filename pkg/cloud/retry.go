@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// MaxRetryAfter caps the delay honored from a "Retry-After" header
+const MaxRetryAfter = retry.DefaultMaxBackoff
+
+// PolicyPropagationMaxBackoff caps the delay between retries of requests
+// denied while the key policy or grants propagate
+const PolicyPropagationMaxBackoff = 5 * time.Second
+
+var (
+	_ aws.RetryerV2 = &retryAfterRetryer{}
+	_ aws.RetryerV2 = &policyPropagationRetryer{}
+	_ aws.RetryerV2 = &retryPolicyRetryer{}
+)
+
+// retryAfterRetryer wraps the configured retryer, waiting for the delay
+// requested by KMS in throttled responses instead of the retryer's own backoff.
+type retryAfterRetryer struct {
+	aws.Retryer
+}
+
+func newRetryAfterRetryer(r aws.Retryer) aws.Retryer {
+	if r == nil {
+		r = retry.NewStandard()
+	}
+	return &retryAfterRetryer{Retryer: r}
+}
+
+func (r *retryAfterRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	if d, ok := kmsplugin.RetryAfter(err); ok {
+		if d > MaxRetryAfter {
+			d = MaxRetryAfter
+		}
+		zap.L().Debug("honoring retry-after from KMS", zap.Int("attempt", attempt), zap.Duration("delay", d))
+		return d, nil
+	}
+	return r.Retryer.RetryDelay(attempt, err)
+}
+
+func (r *retryAfterRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if r2, ok := r.Retryer.(aws.RetryerV2); ok {
+		return r2.GetAttemptToken(ctx)
+	}
+	return r.GetInitialToken(), nil
+}
+
+// policyPropagationRetryer wraps the configured retryer, retrying access denied
+// errors during the bootstrap grace period (see kmsplugin.SetBootstrapGracePeriod)
+// with a shorter backoff, as the key policy or grants are likely still propagating.
+type policyPropagationRetryer struct {
+	aws.Retryer
+	backoff retry.BackoffDelayer
+}
+
+func newPolicyPropagationRetryer(r aws.Retryer) aws.Retryer {
+	return &policyPropagationRetryer{
+		Retryer: r,
+		backoff: retry.NewExponentialJitterBackoff(PolicyPropagationMaxBackoff),
+	}
+}
+
+func (r *policyPropagationRetryer) IsErrorRetryable(err error) bool {
+	if kmsplugin.ParseError(err) == kmsplugin.KMSErrorTypePolicyPropagation {
+		return true
+	}
+	return r.Retryer.IsErrorRetryable(err)
+}
+
+func (r *policyPropagationRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	if kmsplugin.ParseError(err) == kmsplugin.KMSErrorTypePolicyPropagation {
+		zap.L().Info("retrying request denied during bootstrap grace period, the key policy or grants may still be propagating",
+			zap.Int("attempt", attempt), zap.Error(err))
+		return r.backoff.BackoffDelay(attempt, err)
+	}
+	return r.Retryer.RetryDelay(attempt, err)
+}
+
+func (r *policyPropagationRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if r2, ok := r.Retryer.(aws.RetryerV2); ok {
+		return r2.GetAttemptToken(ctx)
+	}
+	return r.GetInitialToken(), nil
+}
+
+// retryPolicyRetryer wraps the configured retryer, applying the active retry policies
+// (see kmsplugin.SetRetryPolicies) to the errors they match.
+type retryPolicyRetryer struct {
+	aws.Retryer
+}
+
+func newRetryPolicyRetryer(r aws.Retryer) aws.Retryer {
+	return &retryPolicyRetryer{Retryer: r}
+}
+
+func (r *retryPolicyRetryer) IsErrorRetryable(err error) bool {
+	if p, ok := kmsplugin.MatchRetryPolicy(err); ok {
+		return p.Retry
+	}
+	return r.Retryer.IsErrorRetryable(err)
+}
+
+// MaxAttempts also allows the attempts of the policies with more attempts than the
+// configured retryer, RetryDelay stops the other errors at the configured retryer's maximum
+func (r *retryPolicyRetryer) MaxAttempts() int {
+	maxAttempts := r.Retryer.MaxAttempts()
+	if maxAttempts == 0 {
+		// unlimited
+		return 0
+	}
+	return max(maxAttempts, kmsplugin.MaxRetryPolicyAttempts())
+}
+
+func (r *retryPolicyRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	maxAttempts := r.Retryer.MaxAttempts()
+	if p, ok := kmsplugin.MatchRetryPolicy(err); ok && p.MaxAttempts > 0 {
+		maxAttempts = p.MaxAttempts
+	}
+	if maxAttempts > 0 && attempt >= maxAttempts {
+		return 0, &retry.MaxAttemptsError{Attempt: attempt, Err: err}
+	}
+	return r.Retryer.RetryDelay(attempt, err)
+}
+
+func (r *retryPolicyRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	if r2, ok := r.Retryer.(aws.RetryerV2); ok {
+		return r2.GetAttemptToken(ctx)
+	}
+	return r.GetInitialToken(), nil
+}
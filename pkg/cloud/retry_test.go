@@ -0,0 +1,144 @@
+package cloud
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	smithy "github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestRetryAfterRetryer(t *testing.T) {
+	r := newRetryAfterRetryer(retry.NewStandard(func(o *retry.StandardOptions) {
+		o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) {
+			return time.Millisecond, nil
+		})
+	}))
+
+	throttled := func(retryAfter string) error {
+		return &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": []string{retryAfter}},
+			}},
+			Err: errors.New("throttled"),
+		}
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		delay time.Duration
+	}{
+		{name: "no hint", err: errors.New("fail"), delay: time.Millisecond},
+		{name: "hint", err: throttled("2"), delay: 2 * time.Second},
+		{name: "hint capped", err: throttled("3600"), delay: MaxRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := r.RetryDelay(1, tt.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != tt.delay {
+				t.Fatalf("expected delay %v, got %v", tt.delay, d)
+			}
+		})
+	}
+}
+
+func TestPolicyPropagationRetryer(t *testing.T) {
+	r := newPolicyPropagationRetryer(retry.NewStandard())
+	denied := &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}
+
+	if r.IsErrorRetryable(denied) {
+		t.Fatal("expected access denied error not to be retried outside of the bootstrap grace period")
+	}
+
+	kmsplugin.SetBootstrapGracePeriod(time.Minute)
+	defer kmsplugin.SetBootstrapGracePeriod(0)
+	if !r.IsErrorRetryable(denied) {
+		t.Fatal("expected access denied error to be retried during the bootstrap grace period")
+	}
+	for attempt := 1; attempt < 10; attempt++ {
+		d, err := r.RetryDelay(attempt, denied)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d > PolicyPropagationMaxBackoff {
+			t.Fatalf("#%d: expected delay up to %v, got %v", attempt, PolicyPropagationMaxBackoff, d)
+		}
+	}
+}
+
+func TestRetryPolicyRetryer(t *testing.T) {
+	r := newRetryPolicyRetryer(retry.NewStandard(func(o *retry.StandardOptions) {
+		o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) {
+			return time.Millisecond, nil
+		})
+	}))
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "slow down"}
+	internal := &smithy.GenericAPIError{Code: "KMSInternalException", Message: "internal"}
+	timeout := &smithy.GenericAPIError{Code: "RequestTimeoutException", Message: "timeout"}
+
+	if !r.IsErrorRetryable(throttled) || r.IsErrorRetryable(internal) || r.MaxAttempts() != retry.DefaultMaxAttempts {
+		t.Fatal("expected the AWS SDK defaults without policies")
+	}
+
+	if err := kmsplugin.SetRetryPolicies([]kmsplugin.RetryPolicy{
+		{ErrorType: kmsplugin.KMSErrorTypeThrottled, Retry: true, MaxAttempts: 5},
+		{ErrorType: kmsplugin.KMSErrorTypeOther, Code: "KMSInternalException", Retry: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer kmsplugin.SetRetryPolicies(nil) //nolint:errcheck
+
+	if r.MaxAttempts() != 5 {
+		t.Fatalf("expected the attempts of the throttled policy, got %d", r.MaxAttempts())
+	}
+	if !r.IsErrorRetryable(internal) {
+		t.Fatal("expected the internal error to be retried")
+	}
+	if !r.IsErrorRetryable(timeout) {
+		t.Fatal("expected the errors without policy to be retried as by default")
+	}
+	if _, err := r.RetryDelay(4, throttled); err != nil {
+		t.Fatalf("expected the throttled error to be retried until its 5th attempt, got %v", err)
+	}
+	var maxAttemptsErr *retry.MaxAttemptsError
+	if _, err := r.RetryDelay(5, throttled); !errors.As(err, &maxAttemptsErr) {
+		t.Fatalf("expected the throttled error to stop at its 5th attempt, got %v", err)
+	}
+	if _, err := r.RetryDelay(retry.DefaultMaxAttempts, timeout); !errors.As(err, &maxAttemptsErr) {
+		t.Fatalf("expected the errors without policy to stop at the default attempts, got %v", err)
+	}
+}
+
+func TestIsAmbiguousError(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		ambiguous bool
+	}{
+		{name: "connection reset", err: &smithyhttp.RequestSendError{Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, ambiguous: true},
+		{name: "unexpected EOF", err: &smithyhttp.RequestSendError{Err: io.ErrUnexpectedEOF}, ambiguous: true},
+		{name: "response timeout", err: &awshttp.ResponseTimeoutError{TimeoutDur: time.Second}, ambiguous: true},
+		{name: "dial", err: &smithyhttp.RequestSendError{Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}},
+		{name: "KMS error", err: &smithy.GenericAPIError{Code: "ThrottlingException"}},
+		{name: "other", err: errors.New("other")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isAmbiguousError(tc.err); got != tc.ambiguous {
+				t.Fatalf("expected %v, got %v", tc.ambiguous, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the KMS spans
+const tracerName = "sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+
+// span attributes of the KMS calls
+const (
+	attrRequestID  = attribute.Key("aws.request_id")
+	attrRetryCount = attribute.Key("aws.retry_count")
+)
+
+// WithTracing records an OpenTelemetry client span for every KMS call, child of the span of
+// the context of the call, with its KMS request ID and the number of retries as attributes.
+// The spans go to the global tracer provider, see tracing.Setup.
+func WithTracing() Option {
+	return func(o *options) {
+		o.tracing = true
+	}
+}
+
+type attemptsKey struct{}
+
+// addTracingMiddleware starts the span once per call and counts the attempts after the retries
+func addTracingMiddleware(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("KMSTracing", func(
+		ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+	) (middleware.InitializeOutput, middleware.Metadata, error) {
+		operation := awsmiddleware.GetOperationName(ctx)
+		ctx, span := otel.Tracer(tracerName).Start(ctx, "KMS."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("rpc.system", "aws-api"),
+				attribute.String("rpc.service", "KMS"),
+				attribute.String("rpc.method", operation),
+			),
+		)
+		defer span.End()
+		attempts := new(atomic.Int32)
+		ctx = middleware.WithStackValue(ctx, attemptsKey{}, attempts)
+
+		out, md, err := next.HandleInitialize(ctx, in)
+		if n := attempts.Load(); n > 0 {
+			span.SetAttributes(attrRetryCount.Int(int(n - 1)))
+		}
+		if requestID, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+			span.SetAttributes(attrRequestID.String(requestID))
+		}
+		if err != nil {
+			var re interface{ ServiceRequestID() string }
+			if errors.As(err, &re) && re.ServiceRequestID() != "" {
+				span.SetAttributes(attrRequestID.String(re.ServiceRequestID()))
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return out, md, err
+	}), middleware.After); err != nil {
+		return err
+	}
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("KMSTracingAttempts", func(
+		ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler,
+	) (middleware.FinalizeOutput, middleware.Metadata, error) {
+		if attempts, ok := middleware.GetStackValue(ctx, attemptsKey{}).(*atomic.Int32); ok {
+			attempts.Add(1)
+		}
+		return next.HandleFinalize(ctx, in)
+	}), "Retry", middleware.After)
+}
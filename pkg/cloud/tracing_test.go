@@ -0,0 +1,97 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(prev)
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		n := calls.Add(1)
+		rw.Header().Set("x-amzn-RequestId", fmt.Sprintf("request-%d", n))
+		rw.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch n {
+		case 1:
+			// retried
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"__type":"KMSInternalException","message":"test"}`)) //nolint:errcheck
+		case 2:
+			rw.Write([]byte(`{"CiphertextBlob":"Zm9v","KeyId":"key"}`)) //nolint:errcheck
+		default:
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"__type":"DisabledException","message":"test"}`)) //nolint:errcheck
+		}
+	}))
+	defer ts.Close()
+
+	c := kms.New(kms.Options{
+		Region:       "us-west-2",
+		BaseEndpoint: aws.String(ts.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		APIOptions:   []func(*middleware.Stack) error{addTracingMiddleware},
+		Retryer: retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+		}),
+	})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	if _, err := c.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Encrypt(ctx, &kms.EncryptInput{KeyId: aws.String("key"), Plaintext: []byte("foo")}); err == nil {
+		t.Fatal("expected the disabled key to fail")
+	}
+	parent.End()
+
+	spans := sr.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 2 KMS spans and the parent, got %d", len(spans))
+	}
+	for i, expected := range []struct {
+		requestID  string
+		retryCount int64
+		status     codes.Code
+	}{
+		{requestID: "request-2", retryCount: 1, status: codes.Unset},
+		{requestID: "request-3", retryCount: 0, status: codes.Error},
+	} {
+		span := spans[i]
+		if span.Name() != "KMS.Encrypt" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("#%d: expected a KMS.Encrypt child span, got %q", i, span.Name())
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		if got := attrs[attrRequestID].AsString(); got != expected.requestID {
+			t.Errorf("#%d: expected request ID %s, got %s", i, expected.requestID, got)
+		}
+		if got := attrs[attrRetryCount].AsInt64(); got != expected.retryCount {
+			t.Errorf("#%d: expected %d retries, got %d", i, expected.retryCount, got)
+		}
+		if span.Status().Code != expected.status {
+			t.Errorf("#%d: expected status %v, got %v", i, expected.status, span.Status().Code)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloud
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// instrumentedHTTPClient wraps the HTTP client used by the KMS client and
+// records transport-level metrics (connection pool usage, DNS and TLS latency),
+// which makes it possible to tell network-layer degradation apart from
+// KMS-side latency.
+type instrumentedHTTPClient struct {
+	next aws.HTTPClient
+}
+
+// newInstrumentedHTTPClient wraps the given client. Open connections can only
+// be counted when the client exposes its transport (i.e. the SDK default).
+func newInstrumentedHTTPClient(next aws.HTTPClient) *instrumentedHTTPClient {
+	if next == nil {
+		next = awshttp.NewBuildableClient()
+	}
+	if b, ok := next.(*awshttp.BuildableClient); ok {
+		next = b.WithTransportOptions(func(tr *http.Transport) {
+			tr.DialContext = countingDialContext(tr.DialContext)
+		})
+	}
+	return &instrumentedHTTPClient{next: next}
+}
+
+func (c *instrumentedHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	t := &requestTrace{}
+	ctx := httptrace.WithClientTrace(req.Context(), t.clientTrace())
+	return c.next.Do(req.WithContext(ctx))
+}
+
+// requestTrace keeps the start timestamps of a single request.
+// The httptrace hooks may be called from the dialing goroutine,
+// so access is guarded by a mutex.
+type requestTrace struct {
+	mu       sync.Mutex
+	dnsStart time.Time
+	tlsStart time.Time
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			start := t.dnsStart
+			t.mu.Unlock()
+			if !start.IsZero() {
+				transportDNSLatencyMetric.WithLabelValues().ObserveSince(start)
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			start := t.tlsStart
+			t.mu.Unlock()
+			if !start.IsZero() {
+				transportTLSLatencyMetric.WithLabelValues().ObserveSince(start)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			transportConnectionCounter.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// countingDialContext tracks the number of open connections created by dial.
+func countingDialContext(dial dialContextFunc) dialContextFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return conn, err
+		}
+		transportOpenConnections.Inc()
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(transportOpenConnections.Dec)
+	return c.Conn.Close()
+}
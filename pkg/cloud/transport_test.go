@@ -0,0 +1,45 @@
+package cloud
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	c := newInstrumentedHTTPClient(nil)
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		assert.NoError(t, err)
+		resp, err := c.Do(req)
+		assert.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close() //nolint:errcheck
+	}
+
+	metrics := httptest.NewServer(promhttp.Handler())
+	defer metrics.Close()
+	resp, err := http.Get(metrics.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck
+	d, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	for _, expects := range []string{
+		`aws_encryption_provider_kms_transport_connections_total{reused="false"} 1`,
+		`aws_encryption_provider_kms_transport_connections_total{reused="true"} 1`,
+		`aws_encryption_provider_kms_transport_open_connections 1`,
+	} {
+		assert.True(t, strings.Contains(string(d), expects), "expected %q, got\n\n%s", expects, string(d))
+	}
+}
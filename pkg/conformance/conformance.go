@@ -0,0 +1,306 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance checks that a Kubernetes KMS provider, of any vendor, serving the
+// v1beta1 or v2 API on a unix socket behaves as the apiserver expects: round trips, the
+// limits and formats the apiserver validates, and failing cleanly on bad ciphertexts.
+//
+// Providers are tested from their own tests, e.g.
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.RunV2(t, conformance.Config{Addr: "/var/run/kmsplugin/socket.sock"})
+//	}
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pbv1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+)
+
+// DefaultTimeout is the timeout of each request if Config.Timeout is not set
+const DefaultTimeout = 10 * time.Second
+
+// limits enforced by the apiserver on the KMSv2 responses
+const (
+	maxKeyIDSize           = 1024
+	maxCiphertextSize      = 1024
+	maxAnnotationsSize     = 32 * 1024
+	dekSeedSize            = 32
+	apiserverStoragePrefix = "k8s:enc:"
+)
+
+var (
+	// RFC 1123 subdomain, the apiserver requires annotation keys to be fully qualified domain names
+	fqdnRegexp    = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?\.)+[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	v2APIVersions = []string{"v2", "v2beta1"}
+)
+
+// Config is the provider under test
+type Config struct {
+	// Addr is the unix socket path of the provider, or its abstract socket address starting with "@"
+	Addr string
+	// Timeout of each request, DefaultTimeout if 0
+	Timeout time.Duration
+}
+
+func (c Config) context() (context.Context, context.CancelFunc) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// RunV1 runs the KMS v1beta1 conformance tests against the provider as subtests of t
+//
+//nolint:staticcheck
+func RunV1(t *testing.T, cfg Config) {
+	conn, err := connection.New(cfg.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	client := pbv1.NewKeyManagementServiceClient(conn)
+
+	encrypt := func(t *testing.T, plain []byte) []byte {
+		t.Helper()
+		ctx, cancel := cfg.context()
+		defer cancel()
+		resp, err := client.Encrypt(ctx, &pbv1.EncryptRequest{Version: "v1beta1", Plain: plain})
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		if len(resp.Cipher) == 0 {
+			t.Fatal("Encrypt returned an empty ciphertext")
+		}
+		return resp.Cipher
+	}
+	decrypt := func(cipher []byte) ([]byte, error) {
+		ctx, cancel := cfg.context()
+		defer cancel()
+		resp, err := client.Decrypt(ctx, &pbv1.DecryptRequest{Version: "v1beta1", Cipher: cipher})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Plain, nil
+	}
+
+	t.Run("version", func(t *testing.T) {
+		ctx, cancel := cfg.context()
+		defer cancel()
+		resp, err := client.Version(ctx, &pbv1.VersionRequest{Version: "v1beta1"})
+		if err != nil {
+			t.Fatalf("Version failed: %v", err)
+		}
+		if resp.Version != "v1beta1" {
+			t.Errorf("expected version v1beta1, got %q", resp.Version)
+		}
+		if resp.RuntimeName == "" || resp.RuntimeVersion == "" {
+			t.Errorf("expected a runtime name and version, got %q %q", resp.RuntimeName, resp.RuntimeVersion)
+		}
+	})
+
+	t.Run("roundtrip", func(t *testing.T) {
+		for _, plain := range [][]byte{randomBytes(t, 1), randomBytes(t, dekSeedSize), randomBytes(t, 64*1024)} {
+			cipher := encrypt(t, plain)
+			checkCiphertext(t, plain, cipher)
+			got, err := decrypt(cipher)
+			if err != nil {
+				t.Fatalf("Decrypt of a %d bytes plaintext failed: %v", len(plain), err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("Decrypt of a %d bytes plaintext returned a different plaintext", len(plain))
+			}
+		}
+	})
+
+	t.Run("nondeterministic", func(t *testing.T) {
+		plain := randomBytes(t, dekSeedSize)
+		if bytes.Equal(encrypt(t, plain), encrypt(t, plain)) {
+			t.Error("expected encryptions of the same plaintext to differ")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := decrypt(nil)
+		checkDecryptError(t, "empty ciphertext", err)
+		_, err = decrypt(tamper(encrypt(t, randomBytes(t, dekSeedSize))))
+		checkDecryptError(t, "tampered ciphertext", err)
+	})
+}
+
+// RunV2 runs the KMSv2 conformance tests against the provider as subtests of t
+func RunV2(t *testing.T, cfg Config) {
+	conn, err := connection.New(cfg.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	client := pb.NewKeyManagementServiceClient(conn)
+
+	encrypt := func(t *testing.T, plaintext []byte) *pb.EncryptResponse {
+		t.Helper()
+		ctx, cancel := cfg.context()
+		defer cancel()
+		resp, err := client.Encrypt(ctx, &pb.EncryptRequest{Plaintext: plaintext, Uid: "conformance-encrypt"})
+		if err != nil {
+			t.Fatalf("Encrypt failed: %v", err)
+		}
+		return resp
+	}
+	decrypt := func(resp *pb.EncryptResponse) ([]byte, error) {
+		ctx, cancel := cfg.context()
+		defer cancel()
+		dResp, err := client.Decrypt(ctx, &pb.DecryptRequest{
+			Ciphertext:  resp.Ciphertext,
+			KeyId:       resp.KeyId,
+			Annotations: resp.Annotations,
+			Uid:         "conformance-decrypt",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return dResp.Plaintext, nil
+	}
+
+	var statusKeyID string
+	t.Run("status", func(t *testing.T) {
+		ctx, cancel := cfg.context()
+		defer cancel()
+		resp, err := client.Status(ctx, &pb.StatusRequest{})
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if !slices.Contains(v2APIVersions, resp.Version) {
+			t.Errorf("expected version in %v, got %q", v2APIVersions, resp.Version)
+		}
+		if resp.Healthz != "ok" {
+			t.Errorf("expected healthz ok, got %q", resp.Healthz)
+		}
+		checkKeyID(t, resp.KeyId)
+		statusKeyID = resp.KeyId
+	})
+
+	t.Run("roundtrip", func(t *testing.T) {
+		// the apiserver only encrypts DEK seeds
+		plaintext := randomBytes(t, dekSeedSize)
+		resp := encrypt(t, plaintext)
+		checkKeyID(t, resp.KeyId)
+		if statusKeyID != "" && resp.KeyId != statusKeyID {
+			t.Errorf("expected the key ID of Status %q, got %q", statusKeyID, resp.KeyId)
+		}
+		checkCiphertext(t, plaintext, resp.Ciphertext)
+		if len(resp.Ciphertext) > maxCiphertextSize {
+			t.Errorf("expected a ciphertext of at most %d bytes, got %d", maxCiphertextSize, len(resp.Ciphertext))
+		}
+		checkAnnotations(t, resp.Annotations)
+		got, err := decrypt(resp)
+		if err != nil {
+			t.Fatalf("Decrypt failed: %v", err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatal("Decrypt returned a different plaintext")
+		}
+	})
+
+	t.Run("nondeterministic", func(t *testing.T) {
+		plaintext := randomBytes(t, dekSeedSize)
+		if bytes.Equal(encrypt(t, plaintext).Ciphertext, encrypt(t, plaintext).Ciphertext) {
+			t.Error("expected encryptions of the same plaintext to differ")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		resp := encrypt(t, randomBytes(t, dekSeedSize))
+		_, err := decrypt(&pb.EncryptResponse{KeyId: resp.KeyId, Annotations: resp.Annotations})
+		checkDecryptError(t, "empty ciphertext", err)
+		_, err = decrypt(&pb.EncryptResponse{Ciphertext: tamper(resp.Ciphertext), KeyId: resp.KeyId, Annotations: resp.Annotations})
+		checkDecryptError(t, "tampered ciphertext", err)
+	})
+}
+
+// checkCiphertext checks that the ciphertext does not leak the plaintext nor carry the
+// prefix the apiserver adds to the stored data, and strips before calling Decrypt
+func checkCiphertext(t *testing.T, plaintext, ciphertext []byte) {
+	t.Helper()
+	if len(ciphertext) == 0 {
+		t.Fatal("expected a ciphertext, got none")
+	}
+	if len(plaintext) >= 8 && bytes.Contains(ciphertext, plaintext) {
+		t.Error("expected the ciphertext not to contain the plaintext")
+	}
+	if bytes.HasPrefix(ciphertext, []byte(apiserverStoragePrefix)) {
+		t.Errorf("expected the ciphertext not to start with the apiserver prefix %q", apiserverStoragePrefix)
+	}
+}
+
+func checkKeyID(t *testing.T, keyID string) {
+	t.Helper()
+	if keyID == "" || len(keyID) > maxKeyIDSize {
+		t.Errorf("expected a key ID of 1 to %d bytes, got %d", maxKeyIDSize, len(keyID))
+	}
+}
+
+func checkAnnotations(t *testing.T, annotations map[string][]byte) {
+	t.Helper()
+	size := 0
+	for k, v := range annotations {
+		if len(k) > 253 || !fqdnRegexp.MatchString(k) {
+			t.Errorf("expected annotation key %q to be a fully qualified domain name", k)
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxAnnotationsSize {
+		t.Errorf("expected annotations of at most %d bytes, got %d", maxAnnotationsSize, size)
+	}
+}
+
+// checkDecryptError checks that a decryption failed, answered by the provider
+func checkDecryptError(t *testing.T, what string, err error) {
+	t.Helper()
+	switch {
+	case err == nil:
+		t.Errorf("expected Decrypt of an %s to fail", what)
+	case status.Code(err) == codes.DeadlineExceeded || status.Code(err) == codes.Unavailable:
+		t.Errorf("expected Decrypt of an %s to fail cleanly, got %v", what, err)
+	}
+}
+
+// tamper returns a copy of the ciphertext with its last byte flipped
+func tamper(ciphertext []byte) []byte {
+	tampered := bytes.Clone(ciphertext)
+	if len(tampered) > 0 {
+		tampered[len(tampered)-1] ^= 0xff
+	}
+	return tampered
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
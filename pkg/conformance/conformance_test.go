@@ -0,0 +1,58 @@
+package conformance
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
+)
+
+// TestProvider runs the conformance tests against this provider, the KMS mock
+// only round trips with the key hierarchy modes
+func TestProvider(t *testing.T) {
+	addr := ptesting.TempSocketPath(t, "conformance")
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("health-check", nil).SetDecryptResp("health-check", nil)
+	c.SetDefaultGenerateDataKeyResp(strings.Repeat("k", 32), "encrypted-kek", nil)
+	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	s := server.New()
+	plugin.New("test-key", c, nil, sharedHealthCheck, plugin.WithV1KeyHierarchy(time.Hour)).Register(s.Server)
+	plugin.NewV2("test-key", c, nil, sharedHealthCheck, plugin.WithKeyHierarchy(time.Hour)).Register(s.Server)
+	errc := make(chan error)
+	go func() {
+		errc <- s.ListenAndServe(addr)
+	}()
+	defer func() {
+		s.Stop()
+		if err := <-errc; err != nil {
+			t.Fatalf("unexpected gRPC server stop error %v", err)
+		}
+	}()
+
+	t.Run("v1", func(t *testing.T) { RunV1(t, Config{Addr: addr}) })
+	t.Run("v2", func(t *testing.T) { RunV2(t, Config{Addr: addr}) })
+}
+
+// TestExternalProvider runs the conformance tests against the providers listening on
+// $KMS_CONFORMANCE_V1_ADDR and $KMS_CONFORMANCE_V2_ADDR, if set
+func TestExternalProvider(t *testing.T) {
+	v1Addr, v2Addr := os.Getenv("KMS_CONFORMANCE_V1_ADDR"), os.Getenv("KMS_CONFORMANCE_V2_ADDR")
+	if v1Addr == "" && v2Addr == "" {
+		t.Skip("KMS_CONFORMANCE_V1_ADDR and KMS_CONFORMANCE_V2_ADDR not set")
+	}
+	if v1Addr != "" {
+		t.Run("v1", func(t *testing.T) { RunV1(t, Config{Addr: v1Addr}) })
+	}
+	if v2Addr != "" {
+		t.Run("v2", func(t *testing.T) { RunV2(t, Config{Addr: v2Addr}) })
+	}
+}
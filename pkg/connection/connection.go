@@ -2,15 +2,21 @@ package connection
 
 import (
 	"fmt"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-// New returns a grpc client connection for a given unix socket file path
+// New returns a grpc client connection for a given unix socket file path,
+// or Linux abstract socket address starting with "@"
 func New(addr string) (*grpc.ClientConn, error) {
+	target := "unix://" + addr
+	if strings.HasPrefix(addr, "@") {
+		target = "unix-abstract:" + strings.TrimPrefix(addr, "@")
+	}
 	conn, err := grpc.NewClient(
-		"unix://"+addr,
+		target,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	)
 	if err != nil {
@@ -0,0 +1,132 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package consistency implements a probe checking that ciphertexts
+// encrypted through one KMS endpoint decrypt through the others.
+package consistency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// DefaultCheckPeriod is the default period between two consistency checks
+const DefaultCheckPeriod = 5 * time.Minute
+
+const probeSize = 32
+
+// Endpoint is a KMS client sending requests to a given endpoint
+type Endpoint struct {
+	// Name identifies the endpoint in logs and metrics, e.g. its URL
+	Name string
+	KMS  cloud.AWSKMSv2
+}
+
+// Checker encrypts a random probe through every endpoint and
+// decrypts the result through the next one, so every endpoint
+// is checked as both source and target.
+type Checker struct {
+	keyID         string
+	encryptionCtx map[string]string
+	endpoints     []Endpoint
+}
+
+// NewChecker returns a new *Checker of the key across the endpoints.
+// At least two endpoints are required.
+func NewChecker(keyID string, encryptionCtx map[string]string, endpoints []Endpoint) (*Checker, error) {
+	if len(endpoints) < 2 {
+		return nil, fmt.Errorf("consistency check expected at least 2 endpoints, got %d", len(endpoints))
+	}
+	return &Checker{
+		keyID:         keyID,
+		encryptionCtx: encryptionCtx,
+		endpoints:     endpoints,
+	}, nil
+}
+
+// Run checks the endpoints every period until stopc is closed
+func (c *Checker) Run(period time.Duration, stopc <-chan struct{}) {
+	zap.L().Info("starting consistency check routine", zap.String("key", c.keyID), zap.Duration("period", period))
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), period)
+		if err := c.Check(ctx); err != nil {
+			zap.L().Error("KMS endpoints are inconsistent", zap.String("key", c.keyID), zap.Error(err))
+		}
+		cancel()
+		select {
+		case <-stopc:
+			zap.L().Warn("exiting consistency check routine")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs one consistency check, returning the errors of all failed endpoint pairs
+func (c *Checker) Check(ctx context.Context) error {
+	var errs []error
+	for i, source := range c.endpoints {
+		target := c.endpoints[(i+1)%len(c.endpoints)]
+		err := c.checkPair(ctx, source, target)
+		status, consistent := kmsplugin.StatusSuccess, 1.0
+		if err != nil {
+			status, consistent = kmsplugin.GetStatusLabel(err, kmsplugin.ParseError(err).String()), 0
+			errs = append(errs, fmt.Errorf("encrypt via %s, decrypt via %s: %w", source.Name, target.Name, err))
+		}
+		consistencyCheckCounter.WithLabelValues(c.keyID, source.Name, target.Name, status).Inc()
+		consistencyCheckGauge.WithLabelValues(c.keyID, source.Name, target.Name).Set(consistent)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Checker) checkPair(ctx context.Context, source, target Endpoint) error {
+	plaintext := make([]byte, probeSize)
+	if _, err := rand.Read(plaintext); err != nil {
+		return err
+	}
+
+	encInput := &kms.EncryptInput{
+		Plaintext: plaintext,
+		KeyId:     aws.String(c.keyID),
+	}
+	decInput := &kms.DecryptInput{}
+	if len(c.encryptionCtx) > 0 {
+		encInput.EncryptionContext = c.encryptionCtx
+		decInput.EncryptionContext = c.encryptionCtx
+	}
+	encResult, err := source.KMS.Encrypt(ctx, encInput)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %w", err)
+	}
+	decInput.CiphertextBlob = encResult.CiphertextBlob
+	decResult, err := target.KMS.Decrypt(ctx, decInput)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %w", err)
+	}
+	if !bytes.Equal(decResult.Plaintext, plaintext) {
+		return errors.New("decrypted plaintext does not match")
+	}
+	return nil
+}
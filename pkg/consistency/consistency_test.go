@@ -0,0 +1,69 @@
+package consistency
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+// fakeKMS "encrypts" by prefixing the plaintext with its key material,
+// so only endpoints sharing the same material can decrypt each other's ciphertexts.
+type fakeKMS struct {
+	*cloud.KMSMock
+	material string
+}
+
+func (f *fakeKMS) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: append([]byte(f.material), params.Plaintext...)}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if !strings.HasPrefix(string(params.CiphertextBlob), f.material) {
+		return nil, errors.New("InvalidCiphertextException")
+	}
+	return &kms.DecryptOutput{Plaintext: params.CiphertextBlob[len(f.material):]}, nil
+}
+
+func TestChecker(t *testing.T) {
+	if _, err := NewChecker("key", nil, []Endpoint{{Name: "a", KMS: &fakeKMS{}}}); err == nil {
+		t.Fatal("expected error with a single endpoint")
+	}
+
+	tests := []struct {
+		name      string
+		materials []string
+		failures  int
+	}{
+		{name: "consistent", materials: []string{"k", "k", "k"}},
+		{name: "one endpoint routed elsewhere", materials: []string{"k", "k", "x"}, failures: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints := []Endpoint{}
+			for i, m := range tt.materials {
+				endpoints = append(endpoints, Endpoint{Name: string(rune('a' + i)), KMS: &fakeKMS{material: m}})
+			}
+			c, err := NewChecker("key", map[string]string{"a": "b"}, endpoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Check(context.Background())
+			if tt.failures == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != tt.failures {
+				t.Fatalf("expected %d failed pairs, got %d: %v", tt.failures, n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package consistency
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerPrometheusMetrics()
+}
+
+func registerPrometheusMetrics() {
+	prometheus.MustRegister(consistencyCheckCounter)
+	prometheus.MustRegister(consistencyCheckGauge)
+}
+
+var (
+	consistencyCheckCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_consistency_checks_total",
+			Help: "total checks of ciphertexts encrypted via the source KMS endpoint decrypted via the target one",
+		},
+		[]string{
+			"key_arn",
+			"source",
+			"target",
+			"status",
+		},
+	)
+
+	consistencyCheckGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_consistency_check_consistent",
+			Help: "1 if the latest ciphertext encrypted via the source KMS endpoint decrypted via the target one, 0 otherwise",
+		},
+		[]string{
+			"key_arn",
+			"source",
+			"target",
+		},
+	)
+)
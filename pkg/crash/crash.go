@@ -0,0 +1,208 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crash writes a sanitized snapshot of the last state of the provider when it
+// crashes, for post-mortem analysis by node-problem-detector or support tooling.
+package crash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
+)
+
+const (
+	// maxRecentErrors is the number of recent errors kept in the snapshot
+	maxRecentErrors = 20
+	// maxStackSize bounds the goroutine dump of the snapshot
+	maxStackSize = 1 << 20
+)
+
+// exit is os.Exit, replaced by tests
+var exit = os.Exit
+
+// Event is a warning or error logged by the provider. Only the message is kept, not the
+// fields, which may hold request data.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// State is the snapshot written on a crash
+type State struct {
+	Time         time.Time `json:"time"`
+	Reason       string    `json:"reason"`
+	Version      string    `json:"version"`
+	Commit       string    `json:"commit"`
+	ConfigHash   string    `json:"configHash"`
+	HealthState  string    `json:"healthState,omitempty"`
+	RecentErrors []Event   `json:"recentErrors"`
+	Stack        string    `json:"stack"`
+}
+
+// Handler keeps the recent errors of the provider and writes its State to a file when
+// it crashes. A nil *Handler does nothing.
+type Handler struct {
+	path       string
+	configHash string
+
+	mu           sync.Mutex
+	recentErrors []Event
+	healthState  func() string
+}
+
+// New returns a *Handler writing the State to path, see Install
+func New(path, configHash string) *Handler {
+	return &Handler{path: path, configHash: configHash}
+}
+
+// ConfigHash returns a hash of the flag values, identifying the configuration of the
+// provider without revealing it
+func ConfigHash(flags map[string]string) string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, flags[name]) //nolint:errcheck
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Install makes the runtime append the output of unhandled panics and fatal errors,
+// e.g. in the gRPC handlers, to the file path.runtime, and returns l recording its
+// warnings and errors and writing the State before exiting on Fatal. Call Recover
+// deferred in main to also write the State on panics of the main goroutine.
+func (h *Handler) Install(l *zap.Logger) (*zap.Logger, error) {
+	f, err := os.OpenFile(h.path+".runtime", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the runtime crash output: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to set the runtime crash output: %w", err)
+	}
+	return l.WithOptions(zap.Hooks(h.record), zap.WithFatalHook(h)), nil
+}
+
+// SetHealthState sets the function returning the KMS health state of the State
+func (h *Handler) SetHealthState(fn func() string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.healthState = fn
+}
+
+// record keeps the warnings and errors logged
+func (h *Handler) record(e zapcore.Entry) error {
+	if e.Level < zapcore.WarnLevel {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.recentErrors) == maxRecentErrors {
+		h.recentErrors = append(h.recentErrors[:0], h.recentErrors[1:]...)
+	}
+	h.recentErrors = append(h.recentErrors, Event{Time: e.Time, Level: e.Level.String(), Message: e.Message})
+	return nil
+}
+
+// OnWrite implements zapcore.CheckWriteHook, writing the State after a Fatal entry and exiting
+func (h *Handler) OnWrite(ce *zapcore.CheckedEntry, _ []zapcore.Field) {
+	if err := h.Write("fatal: " + ce.Message); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the crash state: %v\n", err) //nolint:errcheck
+	}
+	exit(1)
+}
+
+// Recover writes the State on a panic of the goroutine, then panics again. It must be
+// called deferred. Only the message of runtime errors is recorded, other panic values
+// may hold request data.
+func (h *Handler) Recover() {
+	if h == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	reason := fmt.Sprintf("panic: %T", r)
+	if re, ok := r.(runtime.Error); ok {
+		reason = "panic: " + re.Error()
+	}
+	if err := h.Write(reason); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the crash state: %v\n", err) //nolint:errcheck
+	}
+	panic(r)
+}
+
+// Write writes the State with the stacks of all the goroutines, replacing the file atomically
+func (h *Handler) Write(reason string) error {
+	stack := make([]byte, maxStackSize)
+	stack = stack[:runtime.Stack(stack, true)]
+
+	h.mu.Lock()
+	state := State{
+		Time:         time.Now(),
+		Reason:       reason,
+		Version:      version.Version,
+		Commit:       version.Commit,
+		ConfigHash:   h.configHash,
+		RecentErrors: append([]Event{}, h.recentErrors...),
+		Stack:        string(stack),
+	}
+	healthState := h.healthState
+	h.mu.Unlock()
+	if healthState != nil {
+		state.HealthState = healthState()
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.path)
+}
@@ -0,0 +1,112 @@
+package crash
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func readState(t *testing.T, path string) State {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s State
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("invalid state %q: %v", b, err)
+	}
+	return s
+}
+
+func newLogger() *zap.Logger {
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(zapcore.NewCore(enc, zapcore.AddSync(io.Discard), zapcore.DebugLevel))
+}
+
+func TestConfigHash(t *testing.T) {
+	a := ConfigHash(map[string]string{"key": "arn", "region": "us-west-2"})
+	if a != ConfigHash(map[string]string{"region": "us-west-2", "key": "arn"}) {
+		t.Fatal("expected the hash not to depend on the order of the flags")
+	}
+	if a == ConfigHash(map[string]string{"key": "arn", "region": "eu-west-1"}) {
+		t.Fatal("expected the hash to depend on the values of the flags")
+	}
+}
+
+func TestFatal(t *testing.T) {
+	var exitCode int
+	exit = func(code int) { exitCode = code }
+	defer func() { exit = os.Exit }()
+
+	path := filepath.Join(t.TempDir(), "last-state.json")
+	h := New(path, "hash")
+	h.SetHealthState(func() string { return "failed-infra" })
+	l, err := h.Install(newLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path + ".runtime") //nolint:errcheck
+	for i := 0; i < maxRecentErrors+5; i++ {
+		l.Info("ignored")
+		l.Error("request to encrypt failed", zap.String("secret", "plaintext"))
+	}
+	l.Fatal("Failed to start server")
+
+	if exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+	s := readState(t, path)
+	if s.Reason != "fatal: Failed to start server" || s.ConfigHash != "hash" || s.HealthState != "failed-infra" {
+		t.Fatalf("unexpected state %+v", s)
+	}
+	// the fatal entry itself is the most recent error
+	if len(s.RecentErrors) != maxRecentErrors || s.RecentErrors[0].Message != "request to encrypt failed" || s.RecentErrors[maxRecentErrors-1].Level != "fatal" {
+		t.Fatalf("unexpected recent errors %+v", s.RecentErrors)
+	}
+	if !strings.Contains(s.Stack, "crash.TestFatal") {
+		t.Fatalf("expected the stack of the goroutines, got %q", s.Stack)
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "plaintext") {
+		t.Fatal("expected the fields of the log entries to be left out")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last-state.json")
+	h := New(path, "hash")
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("expected Recover to panic again")
+			}
+		}()
+		defer h.Recover()
+		var m map[string]int
+		m["key"]++
+	}()
+	if s := readState(t, path); s.Reason != "panic: assignment to entry in nil map" {
+		t.Fatalf("unexpected reason %q", s.Reason)
+	}
+
+	func() {
+		defer func() { recover() }() //nolint:errcheck
+		defer h.Recover()
+		panic("plaintext")
+	}()
+	if s := readState(t, path); s.Reason != "panic: string" {
+		t.Fatalf("expected the panic value to be left out, got %q", s.Reason)
+	}
+}
+
+func TestNilHandler(t *testing.T) {
+	var h *Handler
+	h.SetHealthState(func() string { return "" })
+	defer h.Recover()
+}
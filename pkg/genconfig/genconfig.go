@@ -0,0 +1,275 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package genconfig generates the static pod manifest of the provider and the matching
+// kube-apiserver EncryptionConfiguration, so the socket, key and KMS API version of the
+// two configuration surfaces can't drift apart.
+package genconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Defaults of Config
+const (
+	DefaultSocket       = "/var/run/kmsplugin/socket.sock"
+	DefaultHealthPort   = 8080
+	DefaultProviderName = "aws-encryption-provider"
+	DefaultAPIVersion   = "v2"
+	DefaultTimeout      = 3 * time.Second
+	DefaultCacheSize    = 1000
+)
+
+// Config is the input of Generate
+type Config struct {
+	// Image of the provider container
+	Image string
+	// KeyARN is the KMS key, or alias, ARN
+	KeyARN string
+	// Region of the key, defaults to the region of KeyARN
+	Region string
+	// Socket is the unix socket the provider listens on, abstract if it starts with "@"
+	Socket string
+	// HealthPort serves /healthz and /livez
+	HealthPort int
+	// APIVersion of the KMS plugin API, "v1" or "v2"
+	APIVersion string
+	// ProviderName is the name of the kms provider of the EncryptionConfiguration
+	ProviderName string
+	// Resources encrypted by the provider, e.g. "secrets"
+	Resources []string
+	// Timeout of the apiserver calls to the provider
+	Timeout time.Duration
+}
+
+// keyARNPattern matches KMS key and alias ARNs, capturing the region
+var keyARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:kms:([a-z0-9-]+):[0-9]{12}:(key|alias)/[A-Za-z0-9/_-]+$`)
+
+// resourcePattern matches the resources of an EncryptionConfiguration, e.g. "secrets" or "*.apps"
+var resourcePattern = regexp.MustCompile(`^(\*|[a-z0-9.-]+)(\.[a-z0-9*.-]+)?$`)
+
+// SetDefaults sets the unset fields to their default
+func (c *Config) SetDefaults() {
+	if c.Region == "" {
+		if m := keyARNPattern.FindStringSubmatch(c.KeyARN); m != nil {
+			c.Region = m[1]
+		}
+	}
+	if c.Socket == "" {
+		c.Socket = DefaultSocket
+	}
+	if c.HealthPort == 0 {
+		c.HealthPort = DefaultHealthPort
+	}
+	if c.APIVersion == "" {
+		c.APIVersion = DefaultAPIVersion
+	}
+	if c.ProviderName == "" {
+		c.ProviderName = DefaultProviderName
+	}
+	if len(c.Resources) == 0 {
+		c.Resources = []string{"secrets"}
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+}
+
+// Validate returns all the problems of c, joined
+func (c Config) Validate() error {
+	var errs []error
+	if c.Image == "" {
+		errs = append(errs, errors.New("image must be set"))
+	}
+	m := keyARNPattern.FindStringSubmatch(c.KeyARN)
+	switch {
+	case m == nil:
+		errs = append(errs, fmt.Errorf("expected a KMS key or alias ARN, got %q", c.KeyARN))
+	case c.Region != m[1]:
+		errs = append(errs, fmt.Errorf("region %q doesn't match the region %q of the key", c.Region, m[1]))
+	}
+	if !strings.HasPrefix(c.Socket, "@") && !path.IsAbs(c.Socket) {
+		errs = append(errs, fmt.Errorf("expected an absolute socket path or an abstract socket starting with @, got %q", c.Socket))
+	}
+	if c.HealthPort <= 0 || c.HealthPort > 65535 {
+		errs = append(errs, fmt.Errorf("expected a health port from 1 to 65535, got %d", c.HealthPort))
+	}
+	if c.APIVersion != "v1" && c.APIVersion != "v2" {
+		errs = append(errs, fmt.Errorf("expected KMS API version v1 or v2, got %q", c.APIVersion))
+	}
+	if c.ProviderName == "" || strings.ContainsAny(c.ProviderName, ": \t\n") {
+		errs = append(errs, fmt.Errorf("expected a provider name without spaces or colons, got %q", c.ProviderName))
+	}
+	for _, r := range c.Resources {
+		if !resourcePattern.MatchString(r) {
+			errs = append(errs, fmt.Errorf("expected a resource such as secrets or *.apps, got %q", r))
+		}
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("expected a positive timeout, got %s", c.Timeout))
+	}
+	return errors.Join(errs...)
+}
+
+// Prompt asks for the fields of c on out, reading the answers from in. An empty answer
+// keeps the current value, shown between brackets.
+func Prompt(in io.Reader, out io.Writer, c *Config) error {
+	scanner := bufio.NewScanner(in)
+	ask := func(question string, value *string) error {
+		if *value != "" {
+			fmt.Fprintf(out, "%s [%s]: ", question, *value) //nolint:errcheck
+		} else {
+			fmt.Fprintf(out, "%s: ", question) //nolint:errcheck
+		}
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if answer := strings.TrimSpace(scanner.Text()); answer != "" {
+			*value = answer
+		}
+		return nil
+	}
+
+	if err := ask("Provider image", &c.Image); err != nil {
+		return err
+	}
+	if err := ask("KMS key ARN", &c.KeyARN); err != nil {
+		return err
+	}
+	c.SetDefaults()
+	resources := strings.Join(c.Resources, ",")
+	for _, q := range []struct {
+		question string
+		value    *string
+	}{
+		{"Region", &c.Region},
+		{"Socket", &c.Socket},
+		{"KMS API version (v1 or v2)", &c.APIVersion},
+		{"Provider name", &c.ProviderName},
+		{"Encrypted resources", &resources},
+	} {
+		if err := ask(q.question, q.value); err != nil {
+			return err
+		}
+	}
+	c.Resources = strings.Split(resources, ",")
+	return nil
+}
+
+// quote returns s as a YAML double quoted scalar if it isn't safe as a plain one
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, ":#{}[],&*!|>'\"%@` \t\n") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+var manifests = template.Must(template.New("manifests").Funcs(template.FuncMap{"quote": quote}).Parse(`# static pod manifest of the provider, e.g. /etc/kubernetes/manifests/aws-encryption-provider.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: aws-encryption-provider
+  namespace: kube-system
+spec:
+  containers:
+  - image: {{ quote .Image }}
+    name: aws-encryption-provider
+    command:
+    - /aws-encryption-provider
+    - {{ quote (printf "--key=%s" .KeyARN) }}
+    - {{ quote (printf "--region=%s" .Region) }}
+    - {{ quote (printf "--listen=%s" .Socket) }}
+    - {{ quote (printf "--health-port=:%d" .HealthPort) }}
+    ports:
+    - containerPort: {{ .HealthPort }}
+      protocol: TCP
+    livenessProbe:
+      httpGet:
+        path: /livez
+        port: {{ .HealthPort }}
+    readinessProbe:
+      httpGet:
+        path: /readyz
+        port: {{ .HealthPort }}
+{{- if not .Abstract }}
+    volumeMounts:
+    - mountPath: {{ quote .SocketDir }}
+      name: var-run-kmsplugin
+  volumes:
+  - name: var-run-kmsplugin
+    hostPath:
+      path: {{ quote .SocketDir }}
+      type: DirectoryOrCreate
+{{- else }}
+  hostNetwork: true
+{{- end }}
+---
+# kube-apiserver --encryption-provider-config{{ if not .Abstract }}, the kube-apiserver must also mount {{ .SocketDir }}{{ end }}
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources:
+{{- range .Resources }}
+    - {{ quote . }}
+{{- end }}
+    providers:
+    - kms:
+{{- if eq .APIVersion "v2" }}
+        apiVersion: v2
+{{- end }}
+        name: {{ quote .ProviderName }}
+        endpoint: {{ quote (printf "unix://%s" .Endpoint) }}
+{{- if eq .APIVersion "v1" }}
+        cachesize: {{ .CacheSize }}
+{{- end }}
+        timeout: {{ .Timeout }}
+    - identity: {}
+`))
+
+// Generate validates c and writes the static pod manifest of the provider and the matching
+// EncryptionConfiguration to w, as two YAML documents.
+func Generate(w io.Writer, c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	abstract := strings.HasPrefix(c.Socket, "@")
+	endpoint := c.Socket
+	if abstract {
+		endpoint = "/" + c.Socket
+	}
+	return manifests.Execute(w, struct {
+		Config
+		Abstract  bool
+		SocketDir string
+		Endpoint  string
+		CacheSize int
+	}{
+		Config:    c,
+		Abstract:  abstract,
+		SocketDir: path.Dir(c.Socket),
+		Endpoint:  endpoint,
+		CacheSize: DefaultCacheSize,
+	})
+}
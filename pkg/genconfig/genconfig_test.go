@@ -0,0 +1,150 @@
+package genconfig
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const keyARN = "arn:aws:kms:us-west-2:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+func validConfig() Config {
+	c := Config{Image: "registry.k8s.io/aws-encryption-provider:v1", KeyARN: keyARN}
+	c.SetDefaults()
+	return c
+}
+
+func documents(t *testing.T, out []byte) []map[string]interface{} {
+	t.Helper()
+	var docs []map[string]interface{}
+	dec := yaml.NewDecoder(bytes.NewReader(out))
+	for {
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return docs
+			}
+			t.Fatalf("invalid YAML: %v\n%s", err, out)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func kms(t *testing.T, doc map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	resources := doc["resources"].([]interface{})[0].(map[string]interface{})
+	return resources["providers"].([]interface{})[0].(map[string]interface{})["kms"].(map[string]interface{})
+}
+
+func TestSetDefaults(t *testing.T) {
+	c := Config{KeyARN: keyARN}
+	c.SetDefaults()
+	if c.Region != "us-west-2" || c.Socket != DefaultSocket || c.APIVersion != "v2" || c.HealthPort != DefaultHealthPort {
+		t.Fatalf("unexpected defaults %+v", c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		name   string
+		modify func(*Config)
+		err    string
+	}{
+		{name: "valid", modify: func(*Config) {}},
+		{name: "alias", modify: func(c *Config) { c.KeyARN = "arn:aws:kms:us-west-2:123456789012:alias/etcd" }},
+		{name: "abstract socket", modify: func(c *Config) { c.Socket = "@kmsplugin" }},
+		{name: "key id", modify: func(c *Config) { c.KeyARN = "1234abcd" }, err: "expected a KMS key or alias ARN"},
+		{name: "region mismatch", modify: func(c *Config) { c.Region = "eu-west-1" }, err: "doesn't match the region"},
+		{name: "relative socket", modify: func(c *Config) { c.Socket = "socket.sock" }, err: "expected an absolute socket path"},
+		{name: "version", modify: func(c *Config) { c.APIVersion = "v3" }, err: "expected KMS API version"},
+		{name: "resource", modify: func(c *Config) { c.Resources = []string{"secrets: x"} }, err: "expected a resource"},
+		{name: "image", modify: func(c *Config) { c.Image = "" }, err: "image must be set"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := validConfig()
+			tc.modify(&c)
+			err := c.Validate()
+			if tc.err == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Fatalf("expected error containing %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf, validConfig()); err != nil {
+		t.Fatal(err)
+	}
+	docs := documents(t, buf.Bytes())
+	if len(docs) != 2 || docs[0]["kind"] != "Pod" || docs[1]["kind"] != "EncryptionConfiguration" {
+		t.Fatalf("expected a Pod and an EncryptionConfiguration, got\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "--key="+keyARN) || !strings.Contains(buf.String(), "--listen="+DefaultSocket) {
+		t.Fatalf("expected the key and socket flags, got\n%s", buf.String())
+	}
+	k := kms(t, docs[1])
+	if k["apiVersion"] != "v2" || k["endpoint"] != "unix://"+DefaultSocket || k["timeout"] != "3s" {
+		t.Fatalf("unexpected kms provider %v", k)
+	}
+	if _, ok := k["cachesize"]; ok {
+		t.Fatalf("unexpected cachesize for KMSv2 %v", k)
+	}
+}
+
+func TestGenerateV1AbstractSocket(t *testing.T) {
+	c := validConfig()
+	c.APIVersion = "v1"
+	c.Socket = "@kmsplugin"
+	var buf bytes.Buffer
+	if err := Generate(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	docs := documents(t, buf.Bytes())
+	if spec := docs[0]["spec"].(map[string]interface{}); spec["hostNetwork"] != true || spec["volumes"] != nil {
+		t.Fatalf("expected host network and no volume for an abstract socket, got %v", spec)
+	}
+	k := kms(t, docs[1])
+	if k["endpoint"] != "unix:///@kmsplugin" || k["cachesize"] != DefaultCacheSize {
+		t.Fatalf("unexpected kms provider %v", k)
+	}
+	if _, ok := k["apiVersion"]; ok {
+		t.Fatalf("unexpected apiVersion for KMSv1 %v", k)
+	}
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf, Config{}); err == nil || buf.Len() != 0 {
+		t.Fatalf("expected an error and no output, got %v and %q", err, buf.String())
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	in := strings.NewReader("registry.k8s.io/aws-encryption-provider:v1\n" + keyARN + "\n\n@kmsplugin\nv1\n\nsecrets,configmaps\n")
+	var out bytes.Buffer
+	var c Config
+	if err := Prompt(in, &out, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Region != "us-west-2" || c.Socket != "@kmsplugin" || c.APIVersion != "v1" || c.ProviderName != DefaultProviderName {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if len(c.Resources) != 2 || c.Resources[1] != "configmaps" {
+		t.Fatalf("unexpected resources %v", c.Resources)
+	}
+	if !strings.Contains(out.String(), "Region [us-west-2]: ") {
+		t.Fatalf("expected the default region in the prompt, got %q", out.String())
+	}
+
+	if err := Prompt(strings.NewReader("image\n"), &out, &Config{}); err == nil {
+		t.Fatal("expected an error on a truncated input")
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package guard implements a guard against the deletion of the KMS keys in use.
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// DefaultCheckPeriod is the default period between two key deletion checks
+const DefaultCheckPeriod = time.Hour
+
+// keyDeletionAPI is implemented by the KMS clients of cloud.New, its calls are not part of cloud.AWSKMSv2
+type keyDeletionAPI interface {
+	DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
+	CancelKeyDeletion(ctx context.Context, params *kms.CancelKeyDeletionInput, optFns ...func(*kms.Options)) (*kms.CancelKeyDeletionOutput, error)
+}
+
+// KeyDeletionGuard detects when a KMS key in use is scheduled for deletion,
+// which would make every secret encrypted with it unreadable once deleted,
+// and optionally cancels the deletion.
+type KeyDeletionGuard struct {
+	keyID  string
+	svc    cloud.AWSKMSv2
+	cancel bool
+}
+
+// NewKeyDeletionGuard returns a new *KeyDeletionGuard of the key.
+// If cancel is true, scheduled deletions are canceled with "CancelKeyDeletion",
+// which requires the kms:CancelKeyDeletion permission. Canceling leaves the key disabled.
+func NewKeyDeletionGuard(keyID string, svc cloud.AWSKMSv2, cancel bool) *KeyDeletionGuard {
+	return &KeyDeletionGuard{
+		keyID:  keyID,
+		svc:    svc,
+		cancel: cancel,
+	}
+}
+
+// Run checks the key every period until stopc is closed
+func (g *KeyDeletionGuard) Run(period time.Duration, stopc <-chan struct{}) {
+	zap.L().Info("starting key deletion guard routine", zap.String("key", g.keyID), zap.Duration("period", period), zap.Bool("cancel", g.cancel))
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), period)
+		if _, err := g.Check(ctx); err != nil {
+			zap.L().Error("key deletion guard check failed", zap.String("key", g.keyID), zap.Error(err))
+		}
+		cancel()
+		select {
+		case <-stopc:
+			zap.L().Warn("exiting key deletion guard routine")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check returns true if the key is pending deletion after the check,
+// i.e. the deletion was not canceled.
+func (g *KeyDeletionGuard) Check(ctx context.Context) (bool, error) {
+	svc, ok := g.svc.(keyDeletionAPI)
+	if !ok {
+		return false, errors.New("the KMS client does not support DescribeKey and CancelKeyDeletion")
+	}
+	out, err := svc.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(g.keyID)})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe key %w", err)
+	}
+	if out.KeyMetadata == nil || out.KeyMetadata.KeyState != kmstypes.KeyStatePendingDeletion {
+		keyPendingDeletionMetric.WithLabelValues(g.keyID).Set(0)
+		return false, nil
+	}
+
+	keyPendingDeletionMetric.WithLabelValues(g.keyID).Set(1)
+	fields := []zap.Field{zap.String("key", g.keyID)}
+	if out.KeyMetadata.DeletionDate != nil {
+		fields = append(fields, zap.Time("deletion-date", *out.KeyMetadata.DeletionDate))
+	}
+	zap.L().Error("KMS KEY IS SCHEDULED FOR DELETION, ALL DATA ENCRYPTED WITH IT WILL BE LOST ONCE DELETED", fields...)
+	if !g.cancel {
+		return true, nil
+	}
+
+	if _, err := svc.CancelKeyDeletion(ctx, &kms.CancelKeyDeletionInput{KeyId: aws.String(g.keyID)}); err != nil {
+		keyDeletionCancelCounter.WithLabelValues(g.keyID, kmsplugin.StatusFailure).Inc()
+		return true, fmt.Errorf("failed to cancel key deletion %w", err)
+	}
+	keyDeletionCancelCounter.WithLabelValues(g.keyID, kmsplugin.StatusSuccess).Inc()
+	keyPendingDeletionMetric.WithLabelValues(g.keyID).Set(0)
+	zap.L().Warn("canceled KMS key deletion, the key is left disabled and must be enabled again", zap.String("key", g.keyID))
+	return false, nil
+}
@@ -0,0 +1,86 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+type keyStateMock struct {
+	*cloud.KMSMock
+	state       kmstypes.KeyState
+	describeErr error
+	cancelErr   error
+	cancelCalls int
+}
+
+func (m *keyStateMock) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kmstypes.KeyMetadata{KeyId: params.KeyId, KeyState: m.state}}, nil
+}
+
+func (m *keyStateMock) CancelKeyDeletion(ctx context.Context, params *kms.CancelKeyDeletionInput, optFns ...func(*kms.Options)) (*kms.CancelKeyDeletionOutput, error) {
+	m.cancelCalls++
+	if m.cancelErr != nil {
+		return nil, m.cancelErr
+	}
+	m.state = kmstypes.KeyStateDisabled
+	return &kms.CancelKeyDeletionOutput{KeyId: params.KeyId}, nil
+}
+
+func TestKeyDeletionGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       kmstypes.KeyState
+		describeErr error
+		cancelErr   error
+		cancel      bool
+		pending     bool
+		cancelCalls int
+		expectErr   bool
+	}{
+		{name: "enabled", state: kmstypes.KeyStateEnabled},
+		{name: "describe failure", describeErr: errors.New("fail"), expectErr: true},
+		{name: "pending deletion", state: kmstypes.KeyStatePendingDeletion, pending: true},
+		{name: "pending deletion canceled", state: kmstypes.KeyStatePendingDeletion, cancel: true, cancelCalls: 1},
+		{
+			name:        "pending deletion cancel failure",
+			state:       kmstypes.KeyStatePendingDeletion,
+			cancelErr:   errors.New("AccessDeniedException"),
+			cancel:      true,
+			pending:     true,
+			cancelCalls: 1,
+			expectErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &keyStateMock{KMSMock: &cloud.KMSMock{}, state: tt.state, describeErr: tt.describeErr, cancelErr: tt.cancelErr}
+			g := NewKeyDeletionGuard("key", m, tt.cancel)
+			pending, err := g.Check(context.Background())
+			if tt.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if pending != tt.pending {
+				t.Fatalf("expected pending %v, got %v", tt.pending, pending)
+			}
+			if m.cancelCalls != tt.cancelCalls {
+				t.Fatalf("expected %d CancelKeyDeletion calls, got %d", tt.cancelCalls, m.cancelCalls)
+			}
+		})
+	}
+}
+
+func TestKeyDeletionGuardUnsupportedClient(t *testing.T) {
+	// the implementations of cloud.AWSKMSv2 are not required to implement the guard calls
+	g := NewKeyDeletionGuard("key", &cloud.KMSMock{}, true)
+	if _, err := g.Check(context.Background()); err == nil {
+		t.Fatal("expected an error for a client without DescribeKey")
+	}
+}
@@ -0,0 +1,37 @@
+package guard
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerPrometheusMetrics()
+}
+
+func registerPrometheusMetrics() {
+	prometheus.MustRegister(keyPendingDeletionMetric)
+	prometheus.MustRegister(keyDeletionCancelCounter)
+}
+
+var (
+	keyPendingDeletionMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_key_pending_deletion",
+			Help: "1 if the kms key is scheduled for deletion, 0 otherwise",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	keyDeletionCancelCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_key_deletion_cancellations_total",
+			Help: "total attempts to cancel the deletion of the kms key",
+		},
+		[]string{
+			"key_arn",
+			"status",
+		},
+	)
+)
@@ -0,0 +1,39 @@
+package healthz
+
+import "sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+
+var (
+	_ Evaluator = &plugin.V1Plugin{}
+	_ Evaluator = &plugin.V2Plugin{}
+	_ Evaluator = &plugin.DrainFile{}
+	_ Evaluator = &plugin.Maintenance{}
+)
+
+// Evaluator is a health check evaluated by the healthz and livez handlers after the plugins,
+// e.g. so embedders can combine the KMS health with checks of their own dependencies.
+type Evaluator interface {
+	// Health returns an error if the check fails, failing /healthz
+	Health() error
+	// Live returns an error if the process should be restarted, failing /livez
+	Live() error
+}
+
+// EvaluatorFuncs adapts functions to an Evaluator, a nil function always succeeds
+type EvaluatorFuncs struct {
+	HealthFunc func() error
+	LiveFunc   func() error
+}
+
+func (e EvaluatorFuncs) Health() error {
+	if e.HealthFunc == nil {
+		return nil
+	}
+	return e.HealthFunc()
+}
+
+func (e EvaluatorFuncs) Live() error {
+	if e.LiveFunc == nil {
+		return nil
+	}
+	return e.LiveFunc()
+}
@@ -0,0 +1,37 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+func TestHandlerEvaluators(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	var dependencyErr error
+	hd := NewChecksHandler("health", HealthChecks([]*plugin.V1Plugin{}, []*plugin.V2Plugin{},
+		EvaluatorFuncs{LiveFunc: func() error { return errors.New("not evaluated by healthz") }},
+		EvaluatorFuncs{HealthFunc: func() error { return dependencyErr }},
+	), plugin.DefaultHealthCheckPeriod)
+
+	rw := httptest.NewRecorder()
+	hd.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rw.Code)
+	}
+
+	dependencyErr = errors.New("dependency unavailable")
+	rw = httptest.NewRecorder()
+	hd.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, got %d", http.StatusInternalServerError, rw.Code)
+	}
+	if got := rw.Body.String(); got != dependencyErr.Error() {
+		t.Fatalf("expected body %q, got %q", dependencyErr.Error(), got)
+	}
+}
@@ -0,0 +1,58 @@
+package healthz
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// ReasonHeader is the response header holding the machine-readable reason of a failed check,
+// the kmsplugin.KMSErrorType of the error, e.g. "throttled"
+const ReasonHeader = "X-Health-Check-Reason"
+
+// WriteFailure writes a failed check response for the error, with its reason in the
+// ReasonHeader. Throttled errors also get a "Retry-After" header: the delay requested
+// by KMS if any, else retryAfter, e.g. the health check period during which the result is reused.
+func WriteFailure(rw http.ResponseWriter, err error, retryAfter time.Duration) {
+	writeFailureHeaders(rw, err, retryAfter)
+	rw.WriteHeader(http.StatusInternalServerError)
+	_, e := fmt.Fprint(rw, err)
+	if e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+}
+
+// writeFailureHeaders sets the ReasonHeader and "Retry-After" headers of a failed check, see WriteFailure
+func writeFailureHeaders(rw http.ResponseWriter, err error, retryAfter time.Duration) {
+	errType := kmsplugin.ParseError(err)
+	rw.Header().Set(ReasonHeader, reason(err))
+	if errType == kmsplugin.KMSErrorTypeThrottled {
+		d, ok := kmsplugin.RetryAfter(err)
+		if !ok {
+			d = retryAfter
+		}
+		rw.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(d)))
+	}
+}
+
+// reason returns the reason of a failed check, the kmsplugin.KMSErrorType of err
+func reason(err error) string {
+	if r := kmsplugin.ParseError(err).String(); r != "" {
+		return r
+	}
+	return kmsplugin.KMSErrorTypeOther.String()
+}
+
+// retryAfterSeconds rounds the delay up to whole seconds, at least 1
+func retryAfterSeconds(d time.Duration) int {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
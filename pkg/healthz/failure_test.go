@@ -0,0 +1,69 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.uber.org/zap"
+)
+
+func TestWriteFailure(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	tt := []struct {
+		name       string
+		err        error
+		reason     string
+		retryAfter string
+	}{
+		{
+			name:   "other",
+			err:    errors.New("fail"),
+			reason: "other",
+		},
+		{
+			name:   "user-induced",
+			err:    &kmstypes.KMSInvalidStateException{Message: aws.String("test")},
+			reason: "user-induced",
+		},
+		{
+			name:       "throttled without hint",
+			err:        &kmstypes.LimitExceededException{Message: aws.String("test")},
+			reason:     "throttled",
+			retryAfter: "30",
+		},
+		{
+			name: "throttled with hint",
+			err: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Header:     http.Header{"Retry-After": []string{"7"}},
+				}},
+				Err: &kmstypes.LimitExceededException{Message: aws.String("test")},
+			},
+			reason:     "throttled",
+			retryAfter: "7",
+		},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			WriteFailure(rw, entry.err, 30*time.Second)
+			if rw.Code != http.StatusInternalServerError {
+				t.Fatalf("expected %d, got %d", http.StatusInternalServerError, rw.Code)
+			}
+			if got := rw.Header().Get(ReasonHeader); got != entry.reason {
+				t.Fatalf("expected reason %q, got %q", entry.reason, got)
+			}
+			if got := rw.Header().Get("Retry-After"); got != entry.retryAfter {
+				t.Fatalf("expected Retry-After %q, got %q", entry.retryAfter, got)
+			}
+		})
+	}
+}
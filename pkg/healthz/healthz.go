@@ -2,52 +2,100 @@
 package healthz
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
+	"time"
 
 	"go.uber.org/zap"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
 )
 
-// NewHandler returns a new healthz handler.
-func NewHandler(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin) http.Handler {
-	return &handler{p1s: p1s, p2s: p2s}
+// NewChecksHandler returns a handler failing with the error of the first failing check, named
+// e.g. "live" in the logs. Like the Kubernetes apiserver health endpoints, the "verbose" query
+// parameter lists the result of every check, and each "exclude" one skips the check it names.
+// retryAfter is the "Retry-After" of throttled failures without a delay requested by KMS, see
+// WriteFailure.
+func NewChecksHandler(name string, checks []NamedCheck, retryAfter time.Duration) http.Handler {
+	return &handler{name: name, checks: checks, retryAfter: retryAfter}
 }
 
 type handler struct {
-	p1s []*plugin.V1Plugin
-	p2s []*plugin.V2Plugin
+	name       string
+	checks     []NamedCheck
+	retryAfter time.Duration
 }
 
 func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	for _, p := range hd.p1s {
-		err := p.Health()
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, e := fmt.Fprint(rw, err)
-			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
+	start := time.Now()
+	query := req.URL.Query()
+	excluded := make(map[string]bool)
+	for _, name := range query["exclude"] {
+		excluded[name] = true
+	}
+	var (
+		err     error
+		verbose bytes.Buffer
+	)
+	for _, c := range hd.checks {
+		if excluded[c.Name] {
+			delete(excluded, c.Name)
+			fmt.Fprintf(&verbose, "[+]%s excluded: ok\n", c.Name)
+			continue
+		}
+		if e := c.Check(); e != nil {
+			if err == nil {
+				err = e
 			}
-			zap.L().Error("health check failed", zap.Error(err))
-			return
+			fmt.Fprintf(&verbose, "[-]%s failed: %v\n", c.Name, e)
+			continue
 		}
+		fmt.Fprintf(&verbose, "[+]%s ok\n", c.Name)
 	}
-	for _, p := range hd.p2s {
-		err := p.Health()
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, e := fmt.Fprint(rw, err)
-			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
-			}
-			zap.L().Error("health check failed", zap.Error(err))
+	for _, name := range query["exclude"] {
+		if !excluded[name] {
+			continue
+		}
+		delete(excluded, name)
+		fmt.Fprintf(&verbose, "warn: some health checks cannot be excluded: no matches for %q\n", name)
+	}
+
+	if WantsProbe(req) {
+		WriteProbe(rw, err, time.Since(start))
+		zap.L().Debug(hd.name+" check probe", zap.Error(err))
+		return
+	}
+	_, isVerbose := query["verbose"]
+	if err != nil {
+		zap.L().Error(hd.name+" check failed", zap.Error(err))
+		if !isVerbose {
+			WriteFailure(rw, err, hd.retryAfter)
 			return
 		}
+		writeFailureHeaders(rw, err, hd.retryAfter)
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(&verbose, "%s check failed\n", hd.name)
+		writeBody(rw, verbose.Bytes())
+		return
 	}
 	rw.WriteHeader(http.StatusOK)
-	_, e := fmt.Fprint(rw, http.StatusText(http.StatusOK))
-	if e != nil {
+	if isVerbose {
+		fmt.Fprintf(&verbose, "%s check passed\n", hd.name)
+		writeBody(rw, verbose.Bytes())
+	} else {
+		writeBody(rw, []byte(http.StatusText(http.StatusOK)))
+	}
+	zap.L().Debug(hd.name + " check success")
+}
+
+func writeBody(rw http.ResponseWriter, b []byte) {
+	if _, e := rw.Write(b); e != nil {
 		zap.L().Error("error writing response", zap.Error(e))
 	}
-	zap.L().Debug("health check success")
+}
+
+// Check returns the first failing health check of the plugins, then the evaluators,
+// e.g. for the gRPC health service to agree with the handler.
+func Check(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators ...Evaluator) error {
+	return Run(HealthChecks(p1s, p2s, evaluators...))
 }
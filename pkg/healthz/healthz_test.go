@@ -2,13 +2,9 @@ package healthz
 
 import (
 	"errors"
-	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -18,6 +14,7 @@ import (
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
 	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
 )
 
 // TestHealthz tests healthz handlers.
@@ -64,13 +61,13 @@ func TestHealthz(t *testing.T) {
 	}
 	for i, entry := range tt {
 		t.Run(entry.path, func(t *testing.T) {
-			addr := filepath.Join(os.TempDir(), fmt.Sprintf("healthz%x", rand.Int63()))
-			defer os.RemoveAll(addr) //nolint:errcheck
+			ptesting.VerifyNoGoroutineLeaks(t)
+			addr := ptesting.TempSocketPath(t, "healthz")
 
 			c := &cloud.KMSMock{}
 			c.SetEncryptResp("test", entry.kmsEncryptErr)
 			sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
-			go sharedHealthCheck.Start()
+			sharedHealthCheck.Start()
 			defer sharedHealthCheck.Stop()
 			p := plugin.New("test-key", c, nil, sharedHealthCheck)
 
@@ -96,7 +93,7 @@ func TestHealthz(t *testing.T) {
 				t.Fatal("took too long to start gRPC server")
 			}
 
-			hd := NewHandler([]*plugin.V1Plugin{p}, []*plugin.V2Plugin{})
+			hd := NewChecksHandler("health", HealthChecks([]*plugin.V1Plugin{p}, []*plugin.V2Plugin{}), plugin.DefaultHealthCheckPeriod)
 
 			mux := http.NewServeMux()
 			mux.Handle(entry.path, hd)
@@ -0,0 +1,81 @@
+package healthz
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+)
+
+// NamedCheck is a check reported by name with the "verbose" query parameter of the handlers,
+// and skipped with the "exclude" one, see NewChecksHandler
+type NamedCheck struct {
+	Name  string
+	Check func() error
+}
+
+// Named is implemented by the evaluators naming their check, the others are named
+// "evaluator-<index>"
+type Named interface {
+	Name() string
+}
+
+// NamedEvaluator returns e named name, e.g. for the "exclude" query parameter of the handlers
+func NamedEvaluator(name string, e Evaluator) Evaluator {
+	return &namedEvaluator{Evaluator: e, name: name}
+}
+
+type namedEvaluator struct {
+	Evaluator
+	name string
+}
+
+func (e *namedEvaluator) Name() string {
+	return e.name
+}
+
+// Run returns the error of the first failing check, nil if they all pass
+func Run(checks []NamedCheck) error {
+	for _, c := range checks {
+		if err := c.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HealthChecks returns the health checks of the plugins, named "kms-v1-<index>" and
+// "kms-v2-<index>", then of the evaluators
+func HealthChecks(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators ...Evaluator) []NamedCheck {
+	return namedChecks(p1s, p2s, evaluators, Evaluator.Health)
+}
+
+// LiveChecks returns the live checks of the plugins, named as in HealthChecks, then of the evaluators
+func LiveChecks(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators ...Evaluator) []NamedCheck {
+	return namedChecks(p1s, p2s, evaluators, Evaluator.Live)
+}
+
+// EvaluatorLiveChecks returns the live checks of the evaluators
+func EvaluatorLiveChecks(evaluators ...Evaluator) []NamedCheck {
+	return namedChecks(nil, nil, evaluators, Evaluator.Live)
+}
+
+func namedChecks(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators []Evaluator, check func(Evaluator) error) []NamedCheck {
+	checks := make([]NamedCheck, 0, len(p1s)+len(p2s)+len(evaluators))
+	add := func(name string, e Evaluator) {
+		checks = append(checks, NamedCheck{Name: name, Check: func() error { return check(e) }})
+	}
+	for i, p := range p1s {
+		add(fmt.Sprintf("kms-v1-%d", i), p)
+	}
+	for i, p := range p2s {
+		add(fmt.Sprintf("kms-v2-%d", i), p)
+	}
+	for i, e := range evaluators {
+		name := fmt.Sprintf("evaluator-%d", i)
+		if n, ok := e.(Named); ok {
+			name = n.Name()
+		}
+		add(name, e)
+	}
+	return checks
+}
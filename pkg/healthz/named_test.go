@@ -0,0 +1,94 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestChecksHandler(t *testing.T) {
+	throttled := &kmstypes.LimitExceededException{Message: aws.String("test")}
+	handler := NewChecksHandler("health", []NamedCheck{
+		{Name: "kms-v2-0", Check: func() error { return nil }},
+		{Name: "kms-v2-1", Check: func() error { return throttled }},
+		{Name: "drain-file", Check: func() error { return errors.New("draining") }},
+	}, 7*time.Second)
+
+	tt := []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{
+			target: "/healthz",
+			code:   http.StatusInternalServerError,
+			body:   throttled.Error(),
+		},
+		{
+			target: "/healthz?verbose",
+			code:   http.StatusInternalServerError,
+			body: "[+]kms-v2-0 ok\n" +
+				"[-]kms-v2-1 failed: " + throttled.Error() + "\n" +
+				"[-]drain-file failed: draining\n" +
+				"health check failed\n",
+		},
+		{
+			target: "/healthz?exclude=kms-v2-1",
+			code:   http.StatusInternalServerError,
+			body:   "draining",
+		},
+		{
+			target: "/healthz?exclude=kms-v2-1&exclude=drain-file",
+			code:   http.StatusOK,
+			body:   "OK",
+		},
+		{
+			target: "/healthz?verbose&exclude=kms-v2-1&exclude=drain-file&exclude=unknown",
+			code:   http.StatusOK,
+			body: "[+]kms-v2-0 ok\n" +
+				"[+]kms-v2-1 excluded: ok\n" +
+				"[+]drain-file excluded: ok\n" +
+				"warn: some health checks cannot be excluded: no matches for \"unknown\"\n" +
+				"health check passed\n",
+		},
+	}
+	for _, entry := range tt {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, entry.target, nil))
+		if rw.Code != entry.code {
+			t.Fatalf("%s: expected %d, got %d", entry.target, entry.code, rw.Code)
+		}
+		if body := rw.Body.String(); body != entry.body {
+			t.Fatalf("%s: expected body %q, got %q", entry.target, entry.body, body)
+		}
+	}
+
+	// the failure headers are kept in verbose mode
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/healthz?verbose&exclude=drain-file", nil))
+	if reason := rw.Header().Get(ReasonHeader); reason != "throttled" {
+		t.Fatalf("expected the throttled reason, got %q", reason)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "7" {
+		t.Fatalf("expected the Retry-After of the handler, got %q", got)
+	}
+}
+
+func TestNamedChecks(t *testing.T) {
+	credentials := NamedEvaluator("credentials", EvaluatorFuncs{HealthFunc: func() error { return errors.New("no credentials") }})
+	checks := HealthChecks(nil, nil, EvaluatorFuncs{}, credentials)
+	if len(checks) != 2 || checks[0].Name != "evaluator-0" || checks[1].Name != "credentials" {
+		t.Fatalf("unexpected checks %+v", checks)
+	}
+	if err := Run(checks); err == nil || err.Error() != "no credentials" {
+		t.Fatalf("expected the credentials failure, got %v", err)
+	}
+	if err := Run(LiveChecks(nil, nil, credentials)); err != nil {
+		t.Fatalf("unexpected live failure %v", err)
+	}
+}
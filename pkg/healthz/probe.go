@@ -0,0 +1,54 @@
+package healthz
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// probeContentType is the Prometheus text exposition format of probe responses
+const probeContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WantsProbe returns true if the request asks for a probe response, with a
+// "format=prometheus" query parameter or an Accept header listing the Prometheus
+// text format (as sent by Prometheus scrapes).
+func WantsProbe(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "prometheus" {
+		return true
+	}
+	for _, accept := range req.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			mediaType = strings.ReplaceAll(mediaType, " ", "")
+			if strings.HasPrefix(mediaType, "text/plain;version=0.0.4") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WriteProbe writes the result of a check taking d in the blackbox_exporter conventions:
+// always a 200 response with the probe_success and probe_duration_seconds gauges, labelled
+// with the reason of the failure if any, so the health of a fleet can be scraped directly.
+func WriteProbe(rw http.ResponseWriter, err error, d time.Duration) {
+	failure, success := "", 1
+	if err != nil {
+		failure, success = reason(err), 0
+		rw.Header().Set(ReasonHeader, failure)
+	}
+	rw.Header().Set("Content-Type", probeContentType)
+	rw.WriteHeader(http.StatusOK)
+	_, e := fmt.Fprintf(rw, `# HELP probe_success Displays whether or not the probe was a success
+# TYPE probe_success gauge
+probe_success{reason=%q} %d
+# HELP probe_duration_seconds Returns how long the probe took to complete in seconds
+# TYPE probe_duration_seconds gauge
+probe_duration_seconds %g
+`, failure, success, d.Seconds())
+	if e != nil {
+		zap.L().Error("error writing response", zap.Error(e))
+	}
+}
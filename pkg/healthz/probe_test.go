@@ -0,0 +1,82 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestWantsProbe(t *testing.T) {
+	tt := []struct {
+		name   string
+		target string
+		accept string
+		want   bool
+	}{
+		{name: "default", target: "/healthz", want: false},
+		{name: "query", target: "/healthz?format=prometheus", want: true},
+		{name: "other query", target: "/healthz?format=json", want: false},
+		{name: "prometheus scrape", target: "/healthz", accept: "application/openmetrics-text;version=1.0.0;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", want: true},
+		{name: "spaced", target: "/healthz", accept: "text/plain; version=0.0.4", want: true},
+		{name: "plain text", target: "/healthz", accept: "text/plain", want: false},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, entry.target, nil)
+			if entry.accept != "" {
+				req.Header.Set("Accept", entry.accept)
+			}
+			if got := WantsProbe(req); got != entry.want {
+				t.Fatalf("expected %v, got %v", entry.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteProbe(t *testing.T) {
+	tt := []struct {
+		name    string
+		err     error
+		success string
+	}{
+		{
+			name:    "success",
+			success: `probe_success{reason=""} 1`,
+		},
+		{
+			name:    "other",
+			err:     errors.New("fail"),
+			success: `probe_success{reason="other"} 0`,
+		},
+		{
+			name:    "throttled",
+			err:     &kmstypes.LimitExceededException{Message: aws.String("test")},
+			success: `probe_success{reason="throttled"} 0`,
+		},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			WriteProbe(rw, entry.err, 1500*time.Millisecond)
+			if rw.Code != http.StatusOK {
+				t.Fatalf("expected %d, got %d", http.StatusOK, rw.Code)
+			}
+			if got := rw.Header().Get("Content-Type"); got != probeContentType {
+				t.Fatalf("expected content type %q, got %q", probeContentType, got)
+			}
+			body := rw.Body.String()
+			if !strings.Contains(body, entry.success+"\n") {
+				t.Fatalf("expected %q in body %q", entry.success, body)
+			}
+			if !strings.Contains(body, "probe_duration_seconds 1.5\n") {
+				t.Fatalf("expected duration in body %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package healthz
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultFileTransportPeriod is the period the status file of a FileTransport is written at
+const DefaultFileTransportPeriod = 10 * time.Second
+
+// Checks are the health checks of the provider, evaluated by every Transport so they all agree.
+// No checks always succeed.
+type Checks struct {
+	// Health fails the deep health check, e.g. /healthz
+	Health []NamedCheck
+	// Ready fails the readiness check, e.g. /readyz and the gRPC readiness service
+	Ready []NamedCheck
+	// Live fails the liveness check, e.g. /livez and the gRPC liveness service
+	Live []NamedCheck
+}
+
+// Transport reports the health checks, e.g. over HTTP, the standard gRPC health service or
+// a status file for node-problem-detector, so several of them can be enabled at once.
+type Transport interface {
+	// Start reports the checks until stop is called
+	Start(checks Checks) (stop func())
+}
+
+// TransportFunc adapts a function to a Transport
+type TransportFunc func(checks Checks) (stop func())
+
+func (f TransportFunc) Start(checks Checks) func() {
+	return f(checks)
+}
+
+// StartTransports starts every transport with the same checks, returning a func stopping them
+func StartTransports(checks Checks, transports ...Transport) (stop func()) {
+	stops := make([]func(), 0, len(transports))
+	for _, t := range transports {
+		stops = append(stops, t.Start(checks))
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// HTTPPaths are the paths of the checks of an HTTP transport, empty to not serve a check
+type HTTPPaths struct {
+	Health string
+	Ready  string
+	Live   string
+}
+
+// NewHTTPTransport returns a transport serving the checks on the paths of mux, see NewChecksHandler
+// for retryAfter
+func NewHTTPTransport(mux *http.ServeMux, paths HTTPPaths, retryAfter time.Duration) Transport {
+	return &httpTransport{mux: mux, paths: paths, retryAfter: retryAfter}
+}
+
+type httpTransport struct {
+	mux        *http.ServeMux
+	paths      HTTPPaths
+	retryAfter time.Duration
+}
+
+func (t *httpTransport) Start(checks Checks) func() {
+	for _, c := range []struct {
+		name, path string
+		checks     []NamedCheck
+	}{
+		{name: "health", path: t.paths.Health, checks: checks.Health},
+		{name: "ready", path: t.paths.Ready, checks: checks.Ready},
+		{name: "live", path: t.paths.Live, checks: checks.Live},
+	} {
+		if c.path != "" {
+			t.mux.Handle(c.path, NewChecksHandler(c.name, c.checks, t.retryAfter))
+		}
+	}
+	// the handlers cannot be unregistered, the server serving mux stops them
+	return func() {}
+}
+
+// FileStatus is the content of the status file of a FileTransport
+type FileStatus struct {
+	// Time the checks were evaluated at, so stale files can be told apart
+	Time   time.Time   `json:"time"`
+	Health CheckStatus `json:"health"`
+	Ready  CheckStatus `json:"ready"`
+	Live   CheckStatus `json:"live"`
+}
+
+// CheckStatus is the result of a check
+type CheckStatus struct {
+	OK bool `json:"ok"`
+	// Reason of the failure, as in the ReasonHeader
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newCheckStatus(err error) CheckStatus {
+	if err == nil {
+		return CheckStatus{OK: true}
+	}
+	return CheckStatus{Reason: reason(err), Error: err.Error()}
+}
+
+// NewFileTransport returns a transport writing the checks as a JSON FileStatus to path every
+// period, e.g. for a node-problem-detector custom plugin. The file is replaced atomically.
+func NewFileTransport(path string, period time.Duration) Transport {
+	return &fileTransport{path: path, period: period}
+}
+
+type fileTransport struct {
+	path   string
+	period time.Duration
+}
+
+func (t *fileTransport) Start(checks Checks) func() {
+	t.write(checks)
+	stopc, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(t.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopc:
+				return
+			case <-ticker.C:
+				t.write(checks)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopc)
+			<-done
+		})
+	}
+}
+
+func (t *fileTransport) write(checks Checks) {
+	status := FileStatus{
+		Time:   time.Now().UTC(),
+		Health: newCheckStatus(Run(checks.Health)),
+		Ready:  newCheckStatus(Run(checks.Ready)),
+		Live:   newCheckStatus(Run(checks.Live)),
+	}
+	if err := writeFileAtomic(t.path, status); err != nil {
+		zap.L().Error("failed to write the health status file", zap.String("path", t.path), zap.Error(err))
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file renamed to path, so readers never see
+// a partial file
+func writeFileAtomic(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
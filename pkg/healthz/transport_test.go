@@ -0,0 +1,81 @@
+package healthz
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+func TestTransports(t *testing.T) {
+	disabled := &kmstypes.DisabledException{Message: aws.String("test")}
+	var ready atomic.Bool
+	checks := Checks{
+		Ready: []NamedCheck{{Name: "ready", Check: func() error {
+			if ready.Load() {
+				return nil
+			}
+			return disabled
+		}}},
+		Live: []NamedCheck{{Name: "live", Check: func() error { return errors.New("not live") }}},
+	}
+	mux := http.NewServeMux()
+	path := filepath.Join(t.TempDir(), "health.json")
+	stop := StartTransports(checks,
+		NewHTTPTransport(mux, HTTPPaths{Health: "/healthz", Ready: "/readyz"}, time.Second),
+		NewFileTransport(path, time.Millisecond),
+	)
+	defer stop()
+
+	for target, code := range map[string]int{
+		"/healthz": http.StatusOK,
+		"/readyz":  http.StatusInternalServerError,
+		"/livez":   http.StatusNotFound,
+	} {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, target, nil))
+		if rw.Code != code {
+			t.Fatalf("%s: expected %d, got %d", target, code, rw.Code)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading the status file %v", err)
+	}
+	var status FileStatus
+	if err := json.Unmarshal(b, &status); err != nil {
+		t.Fatalf("unexpected error decoding the status file %v", err)
+	}
+	if !status.Health.OK || status.Ready.OK || status.Live.OK {
+		t.Fatalf("expected only the health check to pass, got %+v", status)
+	}
+	if status.Ready.Reason != "user-induced" || status.Live.Reason != "other" {
+		t.Fatalf("expected the reasons of the failures, got %+v", status)
+	}
+
+	// the file is refreshed every period
+	ready.Store(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for !status.Ready.OK {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the status file to be refreshed")
+		}
+		time.Sleep(time.Millisecond)
+		if b, err = os.ReadFile(path); err == nil {
+			err = json.Unmarshal(b, &status)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error reading the status file %v", err)
+		}
+	}
+	stop()
+}
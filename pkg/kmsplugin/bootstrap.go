@@ -0,0 +1,34 @@
+package kmsplugin
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// accessDeniedCode is the KMS error code of requests not (yet) allowed by the key policy,
+// grants or IAM policies
+const accessDeniedCode = "AccessDeniedException"
+
+// stsAccessDeniedCode is the code of the STS AssumeRole access denied errors
+const stsAccessDeniedCode = "AccessDenied"
+
+// bootstrapGraceUntil is the unix nano time the bootstrap grace period ends, 0 if disabled
+var bootstrapGraceUntil atomic.Int64
+
+// SetBootstrapGracePeriod starts a grace period of the given duration, during which
+// access denied KMS errors are assumed to be caused by a key policy or grant created
+// shortly before and not yet propagated, see KMSErrorTypePolicyPropagation.
+// A non-positive duration ends the grace period.
+func SetBootstrapGracePeriod(d time.Duration) {
+	if d <= 0 {
+		bootstrapGraceUntil.Store(0)
+		return
+	}
+	bootstrapGraceUntil.Store(time.Now().Add(d).UnixNano())
+}
+
+// InBootstrapGracePeriod returns true until the grace period set by SetBootstrapGracePeriod ends
+func InBootstrapGracePeriod() bool {
+	until := bootstrapGraceUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
@@ -0,0 +1,100 @@
+package kmsplugin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MessageRule classifies KMS errors whose code alone is ambiguous,
+// by matching a substring of the error message.
+type MessageRule struct {
+	// Code is the KMS error code, e.g. "AccessDeniedException"
+	Code string `json:"code"`
+	// MessageContains is the substring the error message must contain
+	MessageContains string `json:"messageContains"`
+	// ErrorType is the classification of matching errors
+	ErrorType KMSErrorType `json:"errorType"`
+	// Description documents why the rule exists
+	Description string `json:"description,omitempty"`
+}
+
+//go:embed error_rules.json
+var defaultMessageRulesJSON []byte
+
+var (
+	messageRulesMu sync.RWMutex
+	messageRules   = mustParseMessageRules(defaultMessageRulesJSON)
+)
+
+// DefaultMessageRules returns the built-in message rules
+func DefaultMessageRules() []MessageRule {
+	return mustParseMessageRules(defaultMessageRulesJSON)
+}
+
+// MessageRules returns the active message rules, in evaluation order
+func MessageRules() []MessageRule {
+	messageRulesMu.RLock()
+	defer messageRulesMu.RUnlock()
+	return append([]MessageRule(nil), messageRules...)
+}
+
+// SetMessageRules replaces the active message rules
+func SetMessageRules(rules []MessageRule) error {
+	for i, r := range rules {
+		if r.Code == "" || r.MessageContains == "" {
+			return fmt.Errorf("rule #%d: code and messageContains must not be empty", i)
+		}
+		if r.ErrorType == KMSErrorTypeNil {
+			return fmt.Errorf("rule #%d: errorType must be set", i)
+		}
+	}
+	messageRulesMu.Lock()
+	messageRules = append([]MessageRule(nil), rules...)
+	messageRulesMu.Unlock()
+	return nil
+}
+
+// LoadMessageRules replaces the active message rules with the JSON list of MessageRule in the file,
+// so new AWS wording can be handled without a code change.
+func LoadMessageRules(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read message rules: %w", err)
+	}
+	rules, err := parseMessageRules(b)
+	if err != nil {
+		return err
+	}
+	return SetMessageRules(rules)
+}
+
+func parseMessageRules(b []byte) ([]MessageRule, error) {
+	var rules []MessageRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse message rules: %w", err)
+	}
+	return rules, nil
+}
+
+func mustParseMessageRules(b []byte) []MessageRule {
+	rules, err := parseMessageRules(b)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+func matchMessageRules(code, message string) (KMSErrorType, bool) {
+	messageRulesMu.RLock()
+	defer messageRulesMu.RUnlock()
+	for _, r := range messageRules {
+		if r.Code == code && strings.Contains(message, r.MessageContains) {
+			return r.ErrorType, true
+		}
+	}
+	return KMSErrorTypeNil, false
+}
@@ -0,0 +1,83 @@
+package kmsplugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDefaultMessageRules makes sure every built-in rule classifies the errors it is meant for.
+func TestDefaultMessageRules(t *testing.T) {
+	rules := DefaultMessageRules()
+	assert.NotEmpty(t, rules)
+	assert.Equal(t, rules, MessageRules())
+
+	for _, r := range rules {
+		t.Run(r.Code+"/"+r.MessageContains, func(t *testing.T) {
+			err := &mockAPIError{code: r.Code, message: "prefix " + r.MessageContains + " suffix"}
+			assert.Equal(t, r.ErrorType, ParseError(err))
+
+			err = &mockAPIError{code: r.Code, message: "some unrelated message"}
+			assert.Equal(t, KMSErrorTypeOther, ParseError(err))
+		})
+	}
+}
+
+func TestLoadMessageRules(t *testing.T) {
+	t.Cleanup(func() {
+		assert.NoError(t, SetMessageRules(DefaultMessageRules()))
+	})
+
+	tests := []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{
+			name:      "invalid json",
+			content:   `[{`,
+			expectErr: true,
+		},
+		{
+			name:      "unknown error type",
+			content:   `[{"code": "AccessDeniedException", "messageContains": "x", "errorType": "unknown"}]`,
+			expectErr: true,
+		},
+		{
+			name:      "missing message",
+			content:   `[{"code": "AccessDeniedException", "errorType": "user-induced"}]`,
+			expectErr: true,
+		},
+		{
+			name:      "missing error type",
+			content:   `[{"code": "AccessDeniedException", "messageContains": "x"}]`,
+			expectErr: true,
+		},
+		{
+			name:    "override",
+			content: `[{"code": "AccessDeniedException", "messageContains": "has been scheduled for removal", "errorType": "user-induced"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+			before := MessageRules()
+			err := LoadMessageRules(path)
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, before, MessageRules(), "failed load must keep the active rules")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	assert.Len(t, MessageRules(), 1)
+	assert.Equal(t, KMSErrorTypeUserInduced, ParseError(&mockAPIError{code: "AccessDeniedException", message: "key has been scheduled for removal"}))
+	assert.Equal(t, KMSErrorTypeOther, ParseError(&mockAPIError{code: "AccessDeniedException", message: "key does not exist"}))
+	assert.Error(t, LoadMessageRules(filepath.Join(t.TempDir(), "missing.json")))
+}
@@ -0,0 +1,17 @@
+package kmsplugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// fingerprintSize is the number of bytes of the SHA-256 kept in fingerprints,
+// enough to tell apart the objects of any etcd
+const fingerprintSize = 16
+
+// CiphertextFingerprint returns a stable, non-reversible identifier of a ciphertext,
+// so failures can be attributed to distinct stored objects without logging them
+func CiphertextFingerprint(ciphertext []byte) string {
+	sum := sha256.Sum256(ciphertext)
+	return hex.EncodeToString(sum[:fingerprintSize])
+}
@@ -0,0 +1,103 @@
+package kmsplugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxHeaderSize bounds the size of the fields of a Header, far above any valid key ARN
+const maxHeaderSize = 4096
+
+// ErrMalformedHeader is returned when decoding an invalid Header
+var ErrMalformedHeader = errors.New("malformed storage version header")
+
+// Header is the structured header of the KMSStorageVersionV3 ciphertexts, so they can be
+// routed to the key they were encrypted with and migrated without guessing their format.
+//
+// It is encoded after the version byte as the uvarint length of its fields, then each field
+// as its uvarint length and bytes, in order KeyARN, Algorithm, ProviderVersion and
+// PlaintextLength in decimal. Decoding ignores the fields following the known ones, which
+// later versions can append, and leaves PlaintextLength unknown in the headers written
+// before it was added.
+type Header struct {
+	// KeyARN of the KMS key the payload was encrypted with
+	KeyARN string
+	// Algorithm the payload was encrypted with, e.g. "SYMMETRIC_DEFAULT"
+	Algorithm string
+	// ProviderVersion of the provider which encrypted the payload
+	ProviderVersion string
+	// PlaintextLength is the length of the encrypted plaintext, so truncated decryptions are
+	// detected, 0 if unknown
+	PlaintextLength int
+}
+
+func (h Header) fields() []string {
+	plaintextLength := ""
+	if h.PlaintextLength > 0 {
+		plaintextLength = strconv.Itoa(h.PlaintextLength)
+	}
+	return []string{h.KeyARN, h.Algorithm, h.ProviderVersion, plaintextLength}
+}
+
+// EncodeHeader returns the KMSStorageVersionV3 ciphertext of the header and payload
+func EncodeHeader(h Header, payload []byte) []byte {
+	var fields []byte
+	for _, f := range h.fields() {
+		fields = binary.AppendUvarint(fields, uint64(len(f)))
+		fields = append(fields, f...)
+	}
+	ciphertext := make([]byte, 0, 1+binary.MaxVarintLen64+len(fields)+len(payload))
+	ciphertext = append(ciphertext, KMSStorageVersionV3...)
+	ciphertext = binary.AppendUvarint(ciphertext, uint64(len(fields)))
+	ciphertext = append(ciphertext, fields...)
+	return append(ciphertext, payload...)
+}
+
+// DecodeHeader returns the header and payload of a KMSStorageVersionV3 ciphertext
+func DecodeHeader(ciphertext []byte) (Header, []byte, error) {
+	if len(ciphertext) == 0 || KMSStorageVersion(ciphertext[:1]) != KMSStorageVersionV3 {
+		return Header{}, nil, ErrMalformedHeader
+	}
+	fields, payload, err := readField(ciphertext[1:], maxHeaderSize)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	if len(payload) == 0 {
+		return Header{}, nil, fmt.Errorf("%w: no payload", ErrMalformedHeader)
+	}
+	var values [4]string
+	for i := range values {
+		// the fields following ProviderVersion are optional
+		if i > 2 && len(fields) == 0 {
+			break
+		}
+		var v []byte
+		if v, fields, err = readField(fields, len(fields)); err != nil {
+			return Header{}, nil, err
+		}
+		values[i] = string(v)
+	}
+	h := Header{KeyARN: values[0], Algorithm: values[1], ProviderVersion: values[2]}
+	if h.KeyARN == "" {
+		return Header{}, nil, fmt.Errorf("%w: no key ARN", ErrMalformedHeader)
+	}
+	if values[3] != "" {
+		if h.PlaintextLength, err = strconv.Atoi(values[3]); err != nil || h.PlaintextLength <= 0 {
+			return Header{}, nil, fmt.Errorf("%w: invalid plaintext length %q", ErrMalformedHeader, values[3])
+		}
+	}
+	return h, payload, nil
+}
+
+// readField returns the uvarint length-prefixed field at the start of b, of at most max
+// bytes, and the bytes following it
+func readField(b []byte, max int) ([]byte, []byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 || n > uint64(max) || n > uint64(len(b)-size) {
+		return nil, nil, ErrMalformedHeader
+	}
+	end := size + int(n)
+	return b[size:end], b[end:], nil
+}
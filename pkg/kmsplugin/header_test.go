@@ -0,0 +1,82 @@
+package kmsplugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	h := Header{
+		KeyARN:          "arn:aws:kms:us-west-2:123456789012:key/test",
+		Algorithm:       "SYMMETRIC_DEFAULT",
+		ProviderVersion: "v1.2.3",
+		PlaintextLength: 32,
+	}
+	ciphertext := EncodeHeader(h, []byte("payload"))
+	if KMSStorageVersion(ciphertext[:1]) != KMSStorageVersionV3 {
+		t.Fatalf("expected version %q, got %q", KMSStorageVersionV3, ciphertext[:1])
+	}
+	got, payload, err := DecodeHeader(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != h || string(payload) != "payload" {
+		t.Fatalf("expected %+v and payload, got %+v and %q", h, got, payload)
+	}
+}
+
+func TestHeaderUnknownFields(t *testing.T) {
+	h, payload, err := DecodeHeader(encodeFields("arn:aws:kms:us-west-2:123456789012:key/test", "SYMMETRIC_DEFAULT", "v9", "32", "a later field"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ProviderVersion != "v9" || h.PlaintextLength != 32 || string(payload) != "payload" {
+		t.Fatalf("expected the known fields and payload, got %+v and %q", h, payload)
+	}
+}
+
+// encodeFields returns the KMSStorageVersionV3 ciphertext of the raw header fields and "payload"
+func encodeFields(values ...string) []byte {
+	var fields []byte
+	for _, f := range values {
+		fields = binary.AppendUvarint(fields, uint64(len(f)))
+		fields = append(fields, f...)
+	}
+	ciphertext := binary.AppendUvarint([]byte(KMSStorageVersionV3), uint64(len(fields)))
+	return append(append(ciphertext, fields...), "payload"...)
+}
+
+func TestHeaderWithoutPlaintextLength(t *testing.T) {
+	h, payload, err := DecodeHeader(encodeFields("arn:aws:kms:us-west-2:123456789012:key/test", "SYMMETRIC_DEFAULT", "v1.2.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ProviderVersion != "v1.2.3" || h.PlaintextLength != 0 || string(payload) != "payload" {
+		t.Fatalf("expected an unknown plaintext length, got %+v and %q", h, payload)
+	}
+}
+
+func TestDecodeHeaderMalformed(t *testing.T) {
+	valid := EncodeHeader(Header{KeyARN: "arn", Algorithm: "SYMMETRIC_DEFAULT"}, []byte("payload"))
+	tt := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{name: "empty", ciphertext: nil},
+		{name: "other version", ciphertext: append([]byte(KMSStorageVersionV2), valid[1:]...)},
+		{name: "no length", ciphertext: []byte(KMSStorageVersionV3)},
+		{name: "truncated", ciphertext: valid[:5]},
+		{name: "no payload", ciphertext: valid[:len(valid)-len("payload")]},
+		{name: "too large", ciphertext: binary.AppendUvarint([]byte(KMSStorageVersionV3), maxHeaderSize+1)},
+		{name: "no key", ciphertext: EncodeHeader(Header{Algorithm: "SYMMETRIC_DEFAULT"}, []byte("payload"))},
+		{name: "invalid plaintext length", ciphertext: encodeFields("arn", "SYMMETRIC_DEFAULT", "v1.2.3", "-1")},
+	}
+	for _, entry := range tt {
+		t.Run(entry.name, func(t *testing.T) {
+			if _, _, err := DecodeHeader(entry.ciphertext); !errors.Is(err, ErrMalformedHeader) {
+				t.Fatalf("expected %v, got %v", ErrMalformedHeader, err)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ package kmsplugin
 
 import (
 	"errors"
-	"strings"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -20,6 +20,10 @@ const (
 	KMSErrorTypeThrottled
 	KMSErrorTypeCorruption
 	KMSErrorTypeOther
+	KMSErrorTypePartitionMismatch
+	// KMSErrorTypePolicyPropagation is an access denied error during the bootstrap
+	// grace period, see SetBootstrapGracePeriod
+	KMSErrorTypePolicyPropagation
 )
 
 func (t KMSErrorType) String() string {
@@ -34,11 +38,60 @@ func (t KMSErrorType) String() string {
 		return "other"
 	case KMSErrorTypeCorruption:
 		return "corruption"
+	case KMSErrorTypePartitionMismatch:
+		return "partition-mismatch"
+	case KMSErrorTypePolicyPropagation:
+		return "policy-propagation"
 	default:
 		return ""
 	}
 }
 
+// ParseKMSErrorType parses the string representation of a non-nil KMSErrorType
+func ParseKMSErrorType(s string) (KMSErrorType, error) {
+	for _, t := range []KMSErrorType{
+		KMSErrorTypeUserInduced,
+		KMSErrorTypeThrottled,
+		KMSErrorTypeCorruption,
+		KMSErrorTypeOther,
+		KMSErrorTypePartitionMismatch,
+		KMSErrorTypePolicyPropagation,
+	} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return KMSErrorTypeNil, fmt.Errorf("unknown error type %q", s)
+}
+
+func (t KMSErrorType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *KMSErrorType) UnmarshalText(text []byte) error {
+	parsed, err := ParseKMSErrorType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// ClassifiedError overrides the KMSErrorType ParseError returns for Err, e.g. to classify
+// the failures of the health probes differently from the failures of the requests
+type ClassifiedError struct {
+	Type KMSErrorType
+	Err  error
+}
+
+func (e *ClassifiedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClassifiedError) Unwrap() error {
+	return e.Err
+}
+
 // ParseError parses error codes from KMS
 // ref. https://docs.aws.amazon.com/kms/latest/developerguide/key-state.html
 // ref. https://docs.aws.amazon.com/sdk-for-go/api/service/kms/
@@ -47,6 +100,16 @@ func ParseError(err error) (errorType KMSErrorType) {
 		return KMSErrorTypeNil
 	}
 
+	var ce *ClassifiedError
+	if errors.As(err, &ce) {
+		return ce.Type
+	}
+
+	var pe *PartitionMismatchError
+	if errors.As(err, &pe) {
+		return KMSErrorTypePartitionMismatch
+	}
+
 	uerr := errors.Unwrap(err)
 	if uerr == nil {
 		// in case the error was not wrapped,
@@ -56,7 +119,12 @@ func ParseError(err error) (errorType KMSErrorType) {
 
 	var ae smithy.APIError
 	if !errors.As(uerr, &ae) {
-		return KMSErrorTypeOther
+		// embedders may still pass errors of aws-sdk-go v1
+		le, ok := asLegacyAPIError(uerr)
+		if !ok {
+			return KMSErrorTypeOther
+		}
+		ae, uerr = le, le
 	}
 
 	zap.L().Debug("parsed error", zap.String("code", ae.ErrorCode()), zap.String("message", ae.ErrorMessage()))
@@ -84,34 +152,37 @@ func ParseError(err error) (errorType KMSErrorType) {
 	case (&kmstypes.InvalidCiphertextException{}).ErrorCode():
 		return KMSErrorTypeCorruption
 
-	// AWS SDK Go for KMS does not "yet" define specific error code for a case where a customer specifies the deleted key
-	// "AccessDeniedException" error code may be returned when (1) CMK does not exist (not pending delete),
-	// or (2) corresponding IAM role is not allowed to access the key.
-	// Thus we only want to mark "AccessDeniedException" as user-induced for the case (1).
-	// e.g., "AccessDeniedException: The ciphertext refers to a customer master key that does not exist, does not exist in this region, or you are not allowed to access."
-	//       or "AccessDeniedException: User xxx is not authorized to perform: xxx on this resource because the resource does not exist in this Region, no resource-based policies allow access, or a resource-based policy explicitly denies access"
-	// KMS service may change the error message, so we do the string match.
-	case "AccessDeniedException":
-		if strings.Contains(ae.ErrorMessage(), "does not exist") {
-			return KMSErrorTypeUserInduced
-		}
-	// Sometimes this error message is returned as part of KMSInvalidStateException or KMSInternalException
-	case (&kmstypes.KMSInternalException{}).ErrorCode():
-		if strings.Contains(ae.ErrorMessage(), "AWS KMS rejected the request because the external key store proxy did not respond in time. Retry the request. If you see this error repeatedly, report it to your external key store proxy administrator") {
-			return KMSErrorTypeUserInduced
-		}
+	// STS refused to assume the role of the provider credentials, e.g. a wrong external ID
+	// or trust policy, see cloud.WithAssumeRole
+	case stsAccessDeniedCode:
+		return KMSErrorTypeUserInduced
+	}
+
+	// AWS SDK Go for KMS does not "yet" define specific error codes for some cases, e.g. a customer
+	// specifying a deleted key results in an "AccessDeniedException", as does a missing IAM permission.
+	// KMS service may change the error message, so we do the string match with the active MessageRules.
+	if errorType, ok := matchMessageRules(ae.ErrorCode(), ae.ErrorMessage()); ok {
+		return errorType
+	}
+
+	// Key policies and grants are eventually consistent: right after the key or grant was created,
+	// e.g. by cluster creation automation, requests can be denied for a while.
+	if ae.ErrorCode() == accessDeniedCode && InBootstrapGracePeriod() {
+		return KMSErrorTypePolicyPropagation
 	}
 
 	return KMSErrorTypeOther
 }
 
 const (
-	StatusSuccess           = "success"
-	StatusFailure           = "failure"
-	StatusFailureThrottle   = "failure-throttle"
-	StatusFailureCorruption = "failure-corruption"
-	OperationEncrypt        = "encrypt"
-	OperationDecrypt        = "decrypt"
+	StatusSuccess            = "success"
+	StatusFailure            = "failure"
+	StatusFailureThrottle    = "failure-throttle"
+	StatusFailureCorruption  = "failure-corruption"
+	OperationEncrypt         = "encrypt"
+	OperationDecrypt         = "decrypt"
+	OperationGenerateDataKey = "generate-data-key"
+	OperationDescribeKey     = "describe-key"
 )
 
 // StorageVersion is a prefix used for versioning encrypted content
@@ -121,6 +192,15 @@ type KMSStorageVersion string
 
 const (
 	KMSStorageVersionV2 KMSStorageVersion = "1"
+	// KMSStorageVersionV2KeyHierarchy marks ciphertexts sealed by a locally
+	// derived DEK, see plugin.WithKeyHierarchy
+	KMSStorageVersionV2KeyHierarchy KMSStorageVersion = "2"
+	// KMSStorageVersionV2Features prefixes a ciphertext of another version with
+	// the optional features it was written with, see plugin.WithClusterFeatures
+	KMSStorageVersionV2Features KMSStorageVersion = "3"
+	// KMSStorageVersionV3 prefixes a KMS ciphertext with a structured header recording
+	// the key, algorithm and provider version it was encrypted with, see Header
+	KMSStorageVersionV3 KMSStorageVersion = "4"
 )
 
 // TODO: make configurable
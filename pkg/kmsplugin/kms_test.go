@@ -2,7 +2,9 @@ package kmsplugin
 
 import (
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"github.com/aws/smithy-go"
@@ -102,6 +104,11 @@ func TestParseError(t *testing.T) {
 			err:      &mockAPIError{code: "AccessDeniedException", message: "access denied for some other reason"},
 			expected: KMSErrorTypeOther,
 		},
+		{
+			name:     "STS AccessDenied assuming the role",
+			err:      fmt.Errorf("failed to refresh cached credentials, %w", &mockAPIError{code: "AccessDenied", message: "User dummy is not authorized to perform: sts:AssumeRole"}),
+			expected: KMSErrorTypeUserInduced,
+		},
 		{
 			name:     "KMSInternalException with timeout message",
 			err:      &mockAPIError{code: (&types.KMSInternalException{}).ErrorCode(), message: "AWS KMS rejected the request because the external key store proxy did not respond in time. Retry the request. If you see this error repeatedly, report it to your external key store proxy administrator"},
@@ -126,3 +133,94 @@ func TestParseError(t *testing.T) {
 		})
 	}
 }
+
+func TestParseErrorBootstrapGracePeriod(t *testing.T) {
+	denied := &mockAPIError{code: "AccessDeniedException", message: "User dummy is not authorized to perform: kms:Encrypt on resource: dummy because no resource-based policy allows the kms:Encrypt action"}
+	notExist := &mockAPIError{code: "AccessDeniedException", message: "The ciphertext refers to a customer master key that does not exist"}
+
+	assert.Equal(t, KMSErrorTypeOther, ParseError(denied))
+
+	SetBootstrapGracePeriod(time.Minute)
+	defer SetBootstrapGracePeriod(0)
+	assert.True(t, InBootstrapGracePeriod())
+	assert.Equal(t, KMSErrorTypePolicyPropagation, ParseError(denied))
+	// message rules take precedence
+	assert.Equal(t, KMSErrorTypeUserInduced, ParseError(notExist))
+
+	SetBootstrapGracePeriod(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	assert.False(t, InBootstrapGracePeriod())
+	assert.Equal(t, KMSErrorTypeOther, ParseError(denied))
+}
+
+// mockLegacyAPIError implements the awserr.Error interface of aws-sdk-go v1 for testing
+type mockLegacyAPIError struct {
+	code    string
+	message string
+}
+
+func (e *mockLegacyAPIError) Error() string {
+	return e.code + ": " + e.message
+}
+
+func (e *mockLegacyAPIError) Code() string {
+	return e.code
+}
+
+func (e *mockLegacyAPIError) Message() string {
+	return e.message
+}
+
+func (e *mockLegacyAPIError) OrigErr() error {
+	return nil
+}
+
+func TestParseErrorLegacy(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected KMSErrorType
+	}{
+		{
+			name:     "DisabledException",
+			err:      &mockLegacyAPIError{code: (&types.DisabledException{}).ErrorCode()},
+			expected: KMSErrorTypeUserInduced,
+		},
+		{
+			name:     "ThrottlingException",
+			err:      &mockLegacyAPIError{code: "ThrottlingException"},
+			expected: KMSErrorTypeThrottled,
+		},
+		{
+			name:     "LimitExceededException",
+			err:      &mockLegacyAPIError{code: (&types.LimitExceededException{}).ErrorCode()},
+			expected: KMSErrorTypeThrottled,
+		},
+		{
+			name:     "InvalidCiphertextException",
+			err:      &mockLegacyAPIError{code: (&types.InvalidCiphertextException{}).ErrorCode()},
+			expected: KMSErrorTypeCorruption,
+		},
+		{
+			name:     "AccessDeniedException matching a message rule",
+			err:      &mockLegacyAPIError{code: "AccessDeniedException", message: "The ciphertext refers to a customer master key that does not exist"},
+			expected: KMSErrorTypeUserInduced,
+		},
+		{
+			name:     "wrapped",
+			err:      fmt.Errorf("failed to encrypt %w", &mockLegacyAPIError{code: (&types.KMSInvalidStateException{}).ErrorCode()}),
+			expected: KMSErrorTypeUserInduced,
+		},
+		{
+			name:     "unknown code",
+			err:      &mockLegacyAPIError{code: "SomethingElse"},
+			expected: KMSErrorTypeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ParseError(tt.err), "ParseError returned incorrect error type")
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package kmsplugin
+
+import (
+	"errors"
+
+	smithy "github.com/aws/smithy-go"
+)
+
+// legacyAPIError is the awserr.Error interface of aws-sdk-go v1, matched
+// structurally so embedders still using v1 don't need this module to depend on it.
+type legacyAPIError interface {
+	error
+	Code() string
+	Message() string
+	OrigErr() error
+}
+
+// asLegacyAPIError converts an aws-sdk-go v1 error in the chain of err
+// to the equivalent smithy.APIError of aws-sdk-go-v2
+func asLegacyAPIError(err error) (smithy.APIError, bool) {
+	var le legacyAPIError
+	if !errors.As(err, &le) {
+		return nil, false
+	}
+	return &smithy.GenericAPIError{Code: le.Code(), Message: le.Message()}, true
+}
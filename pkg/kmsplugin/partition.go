@@ -0,0 +1,87 @@
+package kmsplugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PartitionMismatchError is returned when the configured KMS key lives in a different
+// AWS partition (e.g. "aws" vs "aws-us-gov") than the region of the KMS client.
+// Such a key can never be used, whatever the KMS availability.
+type PartitionMismatchError struct {
+	KeyID           string
+	KeyPartition    string
+	Region          string
+	RegionPartition string
+	// Err is the KMS error the mismatch was detected for, if any
+	Err error
+}
+
+func (e *PartitionMismatchError) Error() string {
+	msg := fmt.Sprintf("key %s is in partition %q but the KMS client uses region %q of partition %q, "+
+		"configure a key of partition %q or set --region to a region of partition %q",
+		e.KeyID, e.KeyPartition, e.Region, e.RegionPartition, e.RegionPartition, e.KeyPartition)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *PartitionMismatchError) Unwrap() error {
+	return e.Err
+}
+
+// WithCause returns a copy of the error wrapping the given KMS error
+func (e PartitionMismatchError) WithCause(err error) error {
+	e.Err = err
+	return &e
+}
+
+// PartitionForRegion returns the AWS partition of the region, or "" if the region is unknown
+func PartitionForRegion(region string) string {
+	switch {
+	case region == "":
+		return ""
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	case strings.HasPrefix(region, "eu-isoe-"):
+		return "aws-iso-e"
+	case strings.HasPrefix(region, "us-isof-"):
+		return "aws-iso-f"
+	default:
+		return "aws"
+	}
+}
+
+// KeyPartition returns the partition of a key ARN, or "" for key IDs and aliases
+func KeyPartition(keyID string) string {
+	if !strings.HasPrefix(keyID, "arn:") {
+		return ""
+	}
+	parts := strings.SplitN(keyID, ":", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+// CheckKeyPartition returns a *PartitionMismatchError if the key ARN is in a
+// different partition than the region. Nothing is checked when either is unknown.
+func CheckKeyPartition(keyID, region string) *PartitionMismatchError {
+	keyPartition, regionPartition := KeyPartition(keyID), PartitionForRegion(region)
+	if keyPartition == "" || regionPartition == "" || keyPartition == regionPartition {
+		return nil
+	}
+	return &PartitionMismatchError{
+		KeyID:           keyID,
+		KeyPartition:    keyPartition,
+		Region:          region,
+		RegionPartition: regionPartition,
+	}
+}
@@ -0,0 +1,79 @@
+package kmsplugin
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckKeyPartition(t *testing.T) {
+	tests := []struct {
+		name     string
+		keyID    string
+		region   string
+		mismatch bool
+	}{
+		{
+			name:   "same partition",
+			keyID:  "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			region: "us-west-2",
+		},
+		{
+			name:   "same gov partition",
+			keyID:  "arn:aws-us-gov:kms:us-gov-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			region: "us-gov-east-1",
+		},
+		{
+			name:     "commercial key in gov region",
+			keyID:    "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			region:   "us-gov-west-1",
+			mismatch: true,
+		},
+		{
+			name:     "china key in commercial region",
+			keyID:    "arn:aws-cn:kms:cn-north-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+			region:   "eu-west-1",
+			mismatch: true,
+		},
+		{
+			name:   "key id",
+			keyID:  "1234abcd-12ab-34cd-56ef-1234567890ab",
+			region: "us-gov-west-1",
+		},
+		{
+			name:   "alias",
+			keyID:  "alias/my-key",
+			region: "cn-north-1",
+		},
+		{
+			name:  "unknown region",
+			keyID: "arn:aws-cn:kms:cn-north-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckKeyPartition(tt.keyID, tt.region)
+			if !tt.mismatch {
+				assert.Nil(t, err)
+				return
+			}
+			assert.NotNil(t, err)
+			assert.Equal(t, KeyPartition(tt.keyID), err.KeyPartition)
+			assert.Equal(t, PartitionForRegion(tt.region), err.RegionPartition)
+		})
+	}
+}
+
+func TestPartitionMismatchErrorType(t *testing.T) {
+	pe := CheckKeyPartition("arn:aws:kms:us-west-2:111122223333:key/abc", "us-gov-west-1")
+	cause := &mockAPIError{code: "NotFoundException", message: "key not found"}
+
+	err := fmt.Errorf("failed to decrypt %w", pe.WithCause(cause))
+	assert.Equal(t, KMSErrorTypePartitionMismatch, ParseError(err))
+	assert.True(t, errors.Is(err, cause))
+	assert.Contains(t, err.Error(), "set --region to a region of partition \"aws\"")
+	assert.Nil(t, pe.Err, "WithCause must not modify the original error")
+}
@@ -0,0 +1,47 @@
+package kmsplugin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// RetryAfter returns the delay requested by the "Retry-After" header of a
+// throttled KMS response, if the error carries one.
+// Both the delay-seconds and the HTTP-date forms are supported.
+func RetryAfter(err error) (time.Duration, bool) {
+	var re *smithyhttp.ResponseError
+	if !errors.As(err, &re) || re.Response == nil || re.Response.Response == nil {
+		return 0, false
+	}
+	if re.HTTPStatusCode() != http.StatusTooManyRequests && re.HTTPStatusCode() != http.StatusServiceUnavailable &&
+		ParseError(err) != KMSErrorTypeThrottled {
+		return 0, false
+	}
+	return parseRetryAfter(re.Response.Header.Get("Retry-After"), time.Now())
+}
+
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
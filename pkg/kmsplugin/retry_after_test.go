@@ -0,0 +1,62 @@
+package kmsplugin
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func newResponseError(status int, retryAfter string, err error) error {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &smithy.OperationError{
+		ServiceID:     "KMS",
+		OperationName: "Encrypt",
+		Err: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status, Header: header}},
+			Err:      err,
+		},
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	tests := []struct {
+		name  string
+		err   error
+		delay time.Duration
+		ok    bool
+	}{
+		{name: "nil", err: nil},
+		{name: "not a response error", err: errors.New("fail")},
+		{name: "throttled without header", err: newResponseError(400, "", throttled)},
+		{name: "throttled seconds", err: newResponseError(400, "3", throttled), delay: 3 * time.Second, ok: true},
+		{name: "too many requests", err: newResponseError(http.StatusTooManyRequests, "1", errors.New("fail")), delay: time.Second, ok: true},
+		{name: "service unavailable", err: newResponseError(http.StatusServiceUnavailable, "0", errors.New("fail")), ok: true},
+		{name: "not throttled", err: newResponseError(400, "3", &smithy.GenericAPIError{Code: "DisabledException"})},
+		{name: "invalid header", err: newResponseError(http.StatusTooManyRequests, "soon", throttled)},
+		{name: "negative header", err: newResponseError(http.StatusTooManyRequests, "-1", throttled)},
+		{name: "past date", err: newResponseError(http.StatusTooManyRequests, "Wed, 21 Oct 2015 07:28:00 GMT", throttled), ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, ok := RetryAfter(tt.err)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.delay, d)
+		})
+	}
+}
+
+func TestParseRetryAfterDate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	d, ok := parseRetryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+}
@@ -0,0 +1,106 @@
+package kmsplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	smithy "github.com/aws/smithy-go"
+)
+
+// RetryPolicy decides whether the KMS requests failing with errors of a KMSErrorType,
+// and optionally of a KMS error code, are retried, instead of the AWS SDK default.
+type RetryPolicy struct {
+	// ErrorType is the classification of the errors the policy applies to, see ParseError
+	ErrorType KMSErrorType `json:"errorType"`
+	// Code restricts the policy to the errors with this KMS error code, e.g. "KMSInternalException"
+	Code string `json:"code,omitempty"`
+	// Retry is false to never retry the errors
+	Retry bool `json:"retry"`
+	// MaxAttempts caps the attempts of requests failing with the errors, including the first one,
+	// the AWS SDK default if 0
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Description documents why the policy exists
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	retryPoliciesMu sync.RWMutex
+	retryPolicies   []RetryPolicy
+)
+
+// RetryPolicies returns the active retry policies, in evaluation order
+func RetryPolicies() []RetryPolicy {
+	retryPoliciesMu.RLock()
+	defer retryPoliciesMu.RUnlock()
+	return append([]RetryPolicy(nil), retryPolicies...)
+}
+
+// SetRetryPolicies replaces the active retry policies. None is active by default,
+// so the AWS SDK decides which errors are retried.
+func SetRetryPolicies(policies []RetryPolicy) error {
+	for i, p := range policies {
+		if p.ErrorType == KMSErrorTypeNil {
+			return fmt.Errorf("policy #%d: errorType must be set", i)
+		}
+		if p.MaxAttempts < 0 {
+			return fmt.Errorf("policy #%d: maxAttempts must not be negative", i)
+		}
+		if !p.Retry && p.MaxAttempts > 0 {
+			return fmt.Errorf("policy #%d: maxAttempts requires retry", i)
+		}
+	}
+	retryPoliciesMu.Lock()
+	retryPolicies = append([]RetryPolicy(nil), policies...)
+	retryPoliciesMu.Unlock()
+	return nil
+}
+
+// LoadRetryPolicies replaces the active retry policies with the JSON list of RetryPolicy in the file
+func LoadRetryPolicies(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read retry policies: %w", err)
+	}
+	var policies []RetryPolicy
+	if err := json.Unmarshal(b, &policies); err != nil {
+		return fmt.Errorf("failed to parse retry policies: %w", err)
+	}
+	return SetRetryPolicies(policies)
+}
+
+// MatchRetryPolicy returns the first active retry policy applying to err
+func MatchRetryPolicy(err error) (RetryPolicy, bool) {
+	retryPoliciesMu.RLock()
+	policies := retryPolicies
+	retryPoliciesMu.RUnlock()
+	if len(policies) == 0 || err == nil {
+		return RetryPolicy{}, false
+	}
+	errorType, code := ParseError(err), ""
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		code = ae.ErrorCode()
+	}
+	for _, p := range policies {
+		if p.ErrorType == errorType && (p.Code == "" || p.Code == code) {
+			return p, true
+		}
+	}
+	return RetryPolicy{}, false
+}
+
+// MaxRetryPolicyAttempts returns the largest MaxAttempts of the active retry policies
+func MaxRetryPolicyAttempts() int {
+	retryPoliciesMu.RLock()
+	defer retryPoliciesMu.RUnlock()
+	max := 0
+	for _, p := range retryPolicies {
+		if p.MaxAttempts > max {
+			max = p.MaxAttempts
+		}
+	}
+	return max
+}
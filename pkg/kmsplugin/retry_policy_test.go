@@ -0,0 +1,50 @@
+package kmsplugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry-policies.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[
+  {"errorType": "throttled", "retry": true, "maxAttempts": 10},
+  {"errorType": "other", "code": "KMSInternalException", "retry": true},
+  {"errorType": "other", "retry": false},
+  {"errorType": "user-induced", "retry": false}
+]`), 0o600))
+	assert.NoError(t, LoadRetryPolicies(path))
+	defer SetRetryPolicies(nil) //nolint:errcheck
+
+	assert.Len(t, RetryPolicies(), 4)
+	assert.Equal(t, 10, MaxRetryPolicyAttempts())
+
+	p, ok := MatchRetryPolicy(&mockAPIError{code: "ThrottlingException", message: "slow down"})
+	assert.True(t, ok)
+	assert.True(t, p.Retry)
+	assert.Equal(t, 10, p.MaxAttempts)
+
+	p, ok = MatchRetryPolicy(&mockAPIError{code: "KMSInternalException", message: "internal"})
+	assert.True(t, ok)
+	assert.True(t, p.Retry)
+
+	p, ok = MatchRetryPolicy(&mockAPIError{code: "DependencyTimeoutException", message: "timeout"})
+	assert.True(t, ok)
+	assert.False(t, p.Retry)
+
+	_, ok = MatchRetryPolicy(&mockAPIError{code: "InvalidCiphertextException", message: "corrupt"})
+	assert.False(t, ok, "expected no policy for corruption errors")
+
+	_, ok = MatchRetryPolicy(nil)
+	assert.False(t, ok)
+}
+
+func TestSetRetryPoliciesValidation(t *testing.T) {
+	assert.Error(t, SetRetryPolicies([]RetryPolicy{{Retry: true}}))
+	assert.Error(t, SetRetryPolicies([]RetryPolicy{{ErrorType: KMSErrorTypeThrottled, Retry: true, MaxAttempts: -1}}))
+	assert.Error(t, SetRetryPolicies([]RetryPolicy{{ErrorType: KMSErrorTypeUserInduced, MaxAttempts: 3}}))
+	assert.Empty(t, RetryPolicies())
+}
@@ -3,52 +3,41 @@ package livez
 
 import (
 	"fmt"
-	"net/http"
 
-	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/healthz"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
 )
 
-// NewHandler returns a new livez handler.
-func NewHandler(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin) http.Handler {
-	return &handler{p1s: p1s, p2s: p2s}
+// Check returns the first failing live check of the plugins, then the evaluators
+func Check(p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators ...healthz.Evaluator) error {
+	return healthz.Run(healthz.LiveChecks(p1s, p2s, evaluators...))
 }
 
-type handler struct {
-	p1s []*plugin.V1Plugin
-	p2s []*plugin.V2Plugin
+// CheckProcess only reflects the health of the process, and never the KMS reachability: it
+// returns an error if a gRPC server stopped serving, the shared health check routine is not
+// running or any of the evaluators fails
+func CheckProcess(servers []*server.Server, healthCheck *plugin.SharedHealthCheck, evaluators ...healthz.Evaluator) error {
+	return healthz.Run(ProcessChecks(servers, healthCheck, evaluators...))
 }
 
-func (hd *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	for _, p := range hd.p1s {
-		err := p.Live()
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, e := fmt.Fprint(rw, err)
-			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
+// ProcessChecks returns the checks of CheckProcess, named "grpc-server-<index>",
+// "health-check-routine" then as the evaluators
+func ProcessChecks(servers []*server.Server, healthCheck *plugin.SharedHealthCheck, evaluators ...healthz.Evaluator) []healthz.NamedCheck {
+	var checks []healthz.NamedCheck
+	for i, s := range servers {
+		checks = append(checks, healthz.NamedCheck{Name: fmt.Sprintf("grpc-server-%d", i), Check: func() error {
+			if !s.Serving() {
+				return fmt.Errorf("gRPC server #%d is not serving", i)
 			}
-			zap.L().Error("live check failed", zap.Error(err))
-			return
-		}
+			return nil
+		}})
 	}
-	for _, p := range hd.p2s {
-		err := p.Live()
-		if err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			_, e := fmt.Fprint(rw, err)
-			if e != nil {
-				zap.L().Error("error writing response", zap.Error(e))
-			}
-			zap.L().Error("live check failed", zap.Error(err))
-			return
+	checks = append(checks, healthz.NamedCheck{Name: "health-check-routine", Check: func() error {
+		if state := healthCheck.State(); state != plugin.SharedHealthCheckRunning {
+			return fmt.Errorf("health check routine is %s", state)
 		}
-	}
-
-	rw.WriteHeader(http.StatusOK)
-	_, e := fmt.Fprint(rw, http.StatusText(http.StatusOK))
-	if e != nil {
-		zap.L().Error("error writing response", zap.Error(e))
-	}
-	zap.L().Debug("live check success")
+		return nil
+	}})
+	return append(checks, healthz.EvaluatorLiveChecks(evaluators...)...)
 }
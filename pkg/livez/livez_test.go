@@ -2,13 +2,9 @@ package livez
 
 import (
 	"errors"
-	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -16,8 +12,10 @@ import (
 	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"go.uber.org/zap"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/healthz"
 	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
 	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
 )
 
 // TestLivez tests livez handlers.
@@ -64,13 +62,13 @@ func TestLivez(t *testing.T) {
 	}
 	for i, entry := range tt {
 		t.Run(entry.path, func(t *testing.T) {
-			addr := filepath.Join(os.TempDir(), fmt.Sprintf("livez%x", rand.Int63()))
-			defer os.RemoveAll(addr) //nolint:errcheck
+			ptesting.VerifyNoGoroutineLeaks(t)
+			addr := ptesting.TempSocketPath(t, "livez")
 
 			c := &cloud.KMSMock{}
 			c.SetEncryptResp("test", entry.kmsEncryptErr)
 			sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
-			go sharedHealthCheck.Start()
+			sharedHealthCheck.Start()
 			defer sharedHealthCheck.Stop()
 			p := plugin.New("test-key", c, nil, sharedHealthCheck)
 
@@ -96,7 +94,7 @@ func TestLivez(t *testing.T) {
 				t.Fatal("took too long to start gRPC server")
 			}
 
-			hd := NewHandler([]*plugin.V1Plugin{p}, []*plugin.V2Plugin{})
+			hd := healthz.NewChecksHandler("live", healthz.LiveChecks([]*plugin.V1Plugin{p}, []*plugin.V2Plugin{}), plugin.DefaultHealthCheckPeriod)
 
 			mux := http.NewServeMux()
 			mux.Handle(entry.path, hd)
@@ -125,3 +123,82 @@ func TestLivez(t *testing.T) {
 		})
 	}
 }
+
+// TestProcessLivez tests the process livez handler ignores KMS errors.
+func TestProcessLivez(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	ptesting.VerifyNoGoroutineLeaks(t)
+	addr := ptesting.TempSocketPath(t, "livez-process")
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("test", &kmstypes.KMSInternalException{Message: aws.String("test")})
+	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := plugin.New("test-key", c, nil, sharedHealthCheck)
+
+	s := server.New()
+	p.Register(s.Server)
+	errc := make(chan error)
+	go func() {
+		errc <- s.ListenAndServe(addr)
+	}()
+
+	ts := httptest.NewServer(healthz.NewChecksHandler("process live", ProcessChecks([]*server.Server{s}, sharedHealthCheck), plugin.DefaultHealthCheckPeriod))
+	defer ts.Close()
+	get := func() int {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close() //nolint:errcheck
+		return resp.StatusCode
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for get() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("expected 200 OK once the gRPC server serves, despite KMS errors")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Stop()
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected gRPC server stop error %v", err)
+	}
+	if code := get(); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 with a stopped gRPC server, got %d", code)
+	}
+}
+
+func TestLivezEvaluators(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	var dependencyErr error
+	evaluators := []healthz.Evaluator{
+		healthz.EvaluatorFuncs{HealthFunc: func() error { return errors.New("not evaluated by livez") }},
+		healthz.EvaluatorFuncs{LiveFunc: func() error { return dependencyErr }},
+	}
+	for name, hd := range map[string]http.Handler{
+		"kms":     healthz.NewChecksHandler("live", healthz.LiveChecks([]*plugin.V1Plugin{}, []*plugin.V2Plugin{}, evaluators...), plugin.DefaultHealthCheckPeriod),
+		"process": healthz.NewChecksHandler("process live", ProcessChecks([]*server.Server{}, sharedHealthCheck, evaluators...), plugin.DefaultHealthCheckPeriod),
+	} {
+		dependencyErr = nil
+		rw := httptest.NewRecorder()
+		hd.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: expected %d, got %d", name, http.StatusOK, rw.Code)
+		}
+
+		dependencyErr = errors.New("dependency unavailable")
+		rw = httptest.NewRecorder()
+		hd.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		if rw.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: expected %d, got %d", name, http.StatusInternalServerError, rw.Code)
+		}
+	}
+}
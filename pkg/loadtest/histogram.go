@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadtest
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// the first bucket holds latencies up to histogramMin, each next one up to histogramGrowth times more
+	histogramMin     = 50 * time.Microsecond
+	histogramGrowth  = 1.2
+	histogramBuckets = 80 // up to ~33min
+)
+
+// Histogram is a fixed memory latency distribution, so runs of hours do not grow.
+// Quantiles are approximated to the upper bound of their bucket, within 20%.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets [histogramBuckets]int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+// Observe records a latency
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	if d > histogramMin {
+		i = int(math.Ceil(math.Log(float64(d)/float64(histogramMin)) / math.Log(histogramGrowth)))
+		if i >= histogramBuckets {
+			i = histogramBuckets - 1
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Count returns the number of recorded latencies
+func (h *Histogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Mean returns the mean latency
+func (h *Histogram) Mean() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Max returns the highest latency
+func (h *Histogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Quantile returns the approximate latency below which the fraction q of the latencies are
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var n int64
+	for i, c := range h.buckets {
+		n += c
+		if n >= rank {
+			upper := time.Duration(float64(histogramMin) * math.Pow(histogramGrowth, float64(i)))
+			if upper > h.max {
+				return h.max
+			}
+			return upper
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loadtest drives a mixture of KMSv2 encrypt and decrypt traffic against a running
+// provider for a long time, e.g. to qualify a release, recording the latency distributions and
+// checking that the resource usage of the provider does not grow.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/connection"
+)
+
+const (
+	OperationEncrypt = "encrypt"
+	OperationDecrypt = "decrypt"
+
+	// number of recent ciphertexts decrypt requests are picked from
+	ciphertextPoolSize = 1024
+)
+
+// Config configures a load test run
+type Config struct {
+	// Addr is the unix socket of the provider
+	Addr string
+	// Duration of the run
+	Duration time.Duration
+	// Concurrency is the number of concurrent clients
+	Concurrency int
+	// EncryptRatio is the fraction of the requests that are encryptions, the others
+	// decrypt recently encrypted ciphertexts and verify the plaintext
+	EncryptRatio float64
+	// PayloadSize is the plaintext size of the encryptions in bytes
+	PayloadSize int
+	// RequestTimeout bounds each request
+	RequestTimeout time.Duration
+
+	// MetricsURL is the /metrics endpoint of the provider, sampled every SampleInterval
+	// to check its goroutines and memory do not grow (not checked if empty)
+	MetricsURL     string
+	SampleInterval time.Duration
+	// MaxGoroutineGrowth and MaxMemoryGrowth are the highest accepted ratios of the
+	// provider goroutines, respectively Go heap in use, at the end of the run to the start
+	MaxGoroutineGrowth float64
+	MaxMemoryGrowth    float64
+}
+
+// DefaultConfig returns a configuration of a one hour run against the provider socket
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:               addr,
+		Duration:           time.Hour,
+		Concurrency:        8,
+		EncryptRatio:       0.2,
+		PayloadSize:        1024,
+		RequestTimeout:     10 * time.Second,
+		SampleInterval:     30 * time.Second,
+		MaxGoroutineGrowth: 1.5,
+		MaxMemoryGrowth:    1.5,
+	}
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Addr == "":
+		return errors.New("the provider address must be set")
+	case c.Duration <= 0:
+		return fmt.Errorf("duration expected >0, got %v", c.Duration)
+	case c.Concurrency <= 0:
+		return fmt.Errorf("concurrency expected >0, got %d", c.Concurrency)
+	case c.EncryptRatio <= 0 || c.EncryptRatio > 1:
+		return fmt.Errorf("encrypt ratio expected in (0, 1], got %v", c.EncryptRatio)
+	case c.PayloadSize <= 0:
+		return fmt.Errorf("payload size expected >0, got %d", c.PayloadSize)
+	case c.MetricsURL != "" && c.SampleInterval <= 0:
+		return fmt.Errorf("sample interval expected >0, got %v", c.SampleInterval)
+	}
+	return nil
+}
+
+// OperationReport is the outcome of the requests of an operation
+type OperationReport struct {
+	// Latency of the successful requests
+	Latency Histogram
+	Errors  atomic.Int64
+	// Mismatches counts decryptions not returning the encrypted plaintext
+	Mismatches atomic.Int64
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Duration   time.Duration
+	Operations map[string]*OperationReport
+	// Samples of the provider resource usage, if Config.MetricsURL is set
+	Samples         []ResourceSample
+	GoroutineGrowth float64
+	MemoryGrowth    float64
+}
+
+// Check returns an error if any decryption mismatched or the provider resources grew beyond the configuration
+func (r *Report) Check(c Config) error {
+	var errs []error
+	if n := r.Operations[OperationDecrypt].Mismatches.Load(); n > 0 {
+		errs = append(errs, fmt.Errorf("%d decryptions did not return the encrypted plaintext", n))
+	}
+	if c.MaxGoroutineGrowth > 0 && r.GoroutineGrowth > c.MaxGoroutineGrowth {
+		errs = append(errs, fmt.Errorf("provider goroutines grew by %.2fx, more than %.2fx", r.GoroutineGrowth, c.MaxGoroutineGrowth))
+	}
+	if c.MaxMemoryGrowth > 0 && r.MemoryGrowth > c.MaxMemoryGrowth {
+		errs = append(errs, fmt.Errorf("provider heap grew by %.2fx, more than %.2fx", r.MemoryGrowth, c.MaxMemoryGrowth))
+	}
+	return errors.Join(errs...)
+}
+
+// String summarizes the report
+func (r *Report) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "duration: %v\n", r.Duration.Round(time.Second))
+	for _, op := range []string{OperationEncrypt, OperationDecrypt} {
+		o := r.Operations[op]
+		fmt.Fprintf(&b, "%s: count=%d errors=%d mismatches=%d mean=%v p50=%v p90=%v p99=%v p999=%v max=%v\n",
+			op, o.Latency.Count(), o.Errors.Load(), o.Mismatches.Load(), o.Latency.Mean(),
+			o.Latency.Quantile(0.5), o.Latency.Quantile(0.9), o.Latency.Quantile(0.99), o.Latency.Quantile(0.999), o.Latency.Max())
+	}
+	if len(r.Samples) > 0 {
+		fmt.Fprintf(&b, "samples: %d goroutine-growth=%.2fx memory-growth=%.2fx\n", len(r.Samples), r.GoroutineGrowth, r.MemoryGrowth)
+	}
+	return b.String()
+}
+
+type sealed struct {
+	plaintext []byte
+	resp      *pb.EncryptResponse
+}
+
+// ciphertextPool holds the latest encryptions, for decrypt requests
+type ciphertextPool struct {
+	mu    sync.Mutex
+	items []sealed
+	next  int
+}
+
+func (cp *ciphertextPool) add(s sealed) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if len(cp.items) < ciphertextPoolSize {
+		cp.items = append(cp.items, s)
+		return
+	}
+	cp.items[cp.next] = s
+	cp.next = (cp.next + 1) % ciphertextPoolSize
+}
+
+func (cp *ciphertextPool) pick() (sealed, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if len(cp.items) == 0 {
+		return sealed{}, false
+	}
+	return cp.items[mathrand.IntN(len(cp.items))], true
+}
+
+// Run drives the configured traffic until the duration elapses or ctx is done.
+// Failed requests are counted in the report, Run only fails if the run cannot start.
+func Run(ctx context.Context, c Config) (*Report, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	conn, err := connection.New(c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint:errcheck
+	client := pb.NewKeyManagementServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(ctx, c.Duration)
+	defer cancel()
+
+	r := &Report{Operations: map[string]*OperationReport{
+		OperationEncrypt: {},
+		OperationDecrypt: {},
+	}}
+	pool := &ciphertextPool{}
+	start := time.Now()
+	zap.L().Info("starting load test", zap.String("addr", c.Addr), zap.Duration("duration", c.Duration),
+		zap.Int("concurrency", c.Concurrency), zap.Float64("encrypt-ratio", c.EncryptRatio))
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if s, ok := pool.pick(); ok && mathrand.Float64() >= c.EncryptRatio {
+					r.decrypt(ctx, c, client, s)
+				} else {
+					r.encrypt(ctx, c, client, pool)
+				}
+			}
+		}()
+	}
+
+	if c.MetricsURL != "" {
+		httpClient := &http.Client{Timeout: c.SampleInterval}
+		ticker := time.NewTicker(c.SampleInterval)
+		r.Samples = append(r.Samples, sampleResources(ctx, httpClient, c.MetricsURL))
+	sampling:
+		for {
+			select {
+			case <-ctx.Done():
+				break sampling
+			case <-ticker.C:
+				s := sampleResources(ctx, httpClient, c.MetricsURL)
+				if s.SampleErr != nil && ctx.Err() != nil {
+					break sampling
+				}
+				if s.SampleErr != nil {
+					zap.L().Warn("failed to sample provider resources", zap.Error(s.SampleErr))
+				}
+				r.Samples = append(r.Samples, s)
+			}
+		}
+		ticker.Stop()
+	}
+	wg.Wait()
+	r.Duration = time.Since(start)
+
+	var samples []ResourceSample
+	for _, s := range r.Samples {
+		if s.SampleErr == nil {
+			samples = append(samples, s)
+		}
+	}
+	r.GoroutineGrowth = growth(samples, func(s ResourceSample) float64 { return s.Goroutines })
+	r.MemoryGrowth = growth(samples, func(s ResourceSample) float64 { return s.HeapInuseBytes })
+	return r, nil
+}
+
+func (r *Report) encrypt(ctx context.Context, c Config, client pb.KeyManagementServiceClient, pool *ciphertextPool) {
+	plaintext := make([]byte, c.PayloadSize)
+	_, _ = rand.Read(plaintext)
+	reqCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+	o := r.Operations[OperationEncrypt]
+	start := time.Now()
+	resp, err := client.Encrypt(reqCtx, &pb.EncryptRequest{Plaintext: plaintext})
+	if err != nil {
+		if ctx.Err() == nil {
+			o.Errors.Add(1)
+			zap.L().Debug("encrypt failed", zap.Error(err))
+		}
+		return
+	}
+	o.Latency.Observe(time.Since(start))
+	pool.add(sealed{plaintext: plaintext, resp: resp})
+}
+
+func (r *Report) decrypt(ctx context.Context, c Config, client pb.KeyManagementServiceClient, s sealed) {
+	reqCtx, cancel := context.WithTimeout(ctx, c.RequestTimeout)
+	defer cancel()
+	o := r.Operations[OperationDecrypt]
+	start := time.Now()
+	resp, err := client.Decrypt(reqCtx, &pb.DecryptRequest{
+		Ciphertext:  s.resp.Ciphertext,
+		KeyId:       s.resp.KeyId,
+		Annotations: s.resp.Annotations,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			o.Errors.Add(1)
+			zap.L().Debug("decrypt failed", zap.Error(err))
+		}
+		return
+	}
+	o.Latency.Observe(time.Since(start))
+	if !bytes.Equal(resp.Plaintext, s.plaintext) {
+		o.Mismatches.Add(1)
+		zap.L().Error("decryption did not return the encrypted plaintext", zap.String("key", s.resp.KeyId))
+	}
+}
@@ -0,0 +1,117 @@
+package loadtest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
+)
+
+// echoKMSMock returns the plaintext as ciphertext, so decryptions can be verified
+type echoKMSMock struct {
+	*cloud.KMSMock
+}
+
+func (m *echoKMSMock) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: params.Plaintext}, nil
+}
+
+func (m *echoKMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: params.CiphertextBlob}, nil
+}
+
+func TestRun(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewNop())
+	ptesting.VerifyNoGoroutineLeaks(t)
+	addr := ptesting.TempSocketPath(t, "loadtest")
+
+	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
+	p := plugin.NewV2("test-key", &echoKMSMock{KMSMock: &cloud.KMSMock{}}, nil, sharedHealthCheck, plugin.WithIdentityAssertion())
+	s := server.New()
+	p.Register(s.Server)
+	errc := make(chan error)
+	go func() {
+		errc <- s.ListenAndServe(addr)
+	}()
+	defer func() {
+		s.Stop()
+		if err := <-errc; err != nil {
+			t.Fatalf("unexpected gRPC server stop error %v", err)
+		}
+	}()
+	ts := httptest.NewServer(promhttp.Handler())
+	defer ts.Close()
+
+	c := DefaultConfig(addr)
+	c.Duration = time.Second
+	c.Concurrency = 4
+	c.EncryptRatio = 0.5
+	c.PayloadSize = 64
+	c.MetricsURL = ts.URL
+	c.SampleInterval = 50 * time.Millisecond
+	c.MaxGoroutineGrowth = 10
+	c.MaxMemoryGrowth = 10
+
+	r, err := Run(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(r)
+	for _, op := range []string{OperationEncrypt, OperationDecrypt} {
+		o := r.Operations[op]
+		if o.Latency.Count() == 0 {
+			t.Fatalf("expected %s requests", op)
+		}
+		if n := o.Errors.Load(); n > 0 {
+			t.Fatalf("expected no %s errors, got %d", op, n)
+		}
+	}
+	if len(r.Samples) < 4 {
+		t.Fatalf("expected resource samples, got %d", len(r.Samples))
+	}
+	if r.GoroutineGrowth == 0 || r.MemoryGrowth == 0 {
+		t.Fatalf("expected resource growth to be computed, got %+v", r)
+	}
+	if err := r.Check(c); err != nil {
+		t.Fatal(err)
+	}
+
+	r.GoroutineGrowth = 20
+	if err := r.Check(c); err == nil {
+		t.Fatal("expected goroutine growth to fail the check")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if q := h.Quantile(0.5); q != 0 {
+		t.Fatalf("expected 0 for an empty histogram, got %v", q)
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	for _, tc := range []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{q: 0.5, expected: 500 * time.Millisecond},
+		{q: 0.99, expected: 990 * time.Millisecond},
+		{q: 1, expected: time.Second},
+	} {
+		got := h.Quantile(tc.q)
+		if got < tc.expected || float64(got) > float64(tc.expected)*histogramGrowth {
+			t.Fatalf("expected quantile %v within 20%% above %v, got %v", tc.q, tc.expected, got)
+		}
+	}
+	if h.Max() != time.Second {
+		t.Fatalf("expected max 1s, got %v", h.Max())
+	}
+}
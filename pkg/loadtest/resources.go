@@ -0,0 +1,113 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loadtest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metrics of the provider /metrics endpoint sampled during a run
+const (
+	goroutinesMetric  = "go_goroutines"
+	heapInuseMetric   = "go_memstats_heap_inuse_bytes"
+	residentMemMetric = "process_resident_memory_bytes"
+)
+
+// ResourceSample is the resource usage of the provider at a point in time
+type ResourceSample struct {
+	Time       time.Time
+	Goroutines float64
+	// HeapInuseBytes is the Go heap in use, less noisy than the resident memory
+	HeapInuseBytes   float64
+	ResidentMemBytes float64
+	// SampleErr is set if the metrics could not be scraped
+	SampleErr error
+}
+
+// sampleResources scrapes the provider metrics at url
+func sampleResources(ctx context.Context, client *http.Client, url string) ResourceSample {
+	s := ResourceSample{Time: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		s.SampleErr = err
+		return s
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		s.SampleErr = err
+		return s
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		s.SampleErr = fmt.Errorf("unexpected status %s", resp.Status)
+		return s
+	}
+
+	sampledGoroutines := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case goroutinesMetric:
+			s.Goroutines, sampledGoroutines = v, true
+		case heapInuseMetric:
+			s.HeapInuseBytes = v
+		case residentMemMetric:
+			s.ResidentMemBytes = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.SampleErr = err
+	} else if !sampledGoroutines {
+		s.SampleErr = fmt.Errorf("no %s metric at %s", goroutinesMetric, url)
+	}
+	return s
+}
+
+// growth returns the ratio of the value at the end of the run to the value at its start,
+// each the median of the first, respectively last, quarter of the samples to smooth out GC cycles.
+func growth(samples []ResourceSample, value func(ResourceSample) float64) float64 {
+	n := len(samples) / 4
+	if n == 0 {
+		return 0
+	}
+	first, last := median(samples[:n], value), median(samples[len(samples)-n:], value)
+	if first == 0 {
+		return 0
+	}
+	return last / first
+}
+
+func median(samples []ResourceSample, value func(ResourceSample) float64) float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = value(s)
+	}
+	sort.Float64s(values)
+	return values[len(values)/2]
+}
@@ -0,0 +1,136 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces the secrets matched by the RedactPattern redactors
+const Redacted = "REDACTED"
+
+// Redactor returns s with the secrets it contains replaced, e.g. by Redacted
+type Redactor func(s string) string
+
+// RedactPattern returns a Redactor replacing the matches of re by Redacted
+func RedactPattern(re *regexp.Regexp) Redactor {
+	return func(s string) string {
+		return re.ReplaceAllString(s, Redacted)
+	}
+}
+
+// WithRedactors returns a logger applying the redactors to the message and to every field of
+// the entries of l before they are emitted, including the error messages and the fields added
+// with With, so organization-specific secret patterns never reach the logs. Embedders can wrap
+// the logger passed to zap.ReplaceGlobals with it.
+func WithRedactors(l *zap.Logger, redactors ...Redactor) *zap.Logger {
+	if len(redactors) == 0 {
+		return l
+	}
+	return l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{Core: core, redactors: redactors}
+	}))
+}
+
+// redactingCore redacts the entries before writing them to the wrapped Core
+type redactingCore struct {
+	zapcore.Core
+	redactors []Redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.redactFields(fields)), redactors: c.redactors}
+}
+
+func (c *redactingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = c.redact(ent.Message)
+	return c.Core.Write(ent, c.redactFields(fields))
+}
+
+func (c *redactingCore) redact(s string) string {
+	for _, r := range c.redactors {
+		s = r(s)
+	}
+	return s
+}
+
+// redactFields returns the fields with their strings redacted. The fields which may hold strings
+// are encoded, e.g. errors and objects, and replaced by their redacted encoding.
+func (c *redactingCore) redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		switch f.Type {
+		case zapcore.StringType:
+			f.String = c.redact(f.String)
+		case zapcore.ByteStringType:
+			f = zap.String(f.Key, c.redact(string(f.Interface.([]byte))))
+		case zapcore.ErrorType, zapcore.StringerType, zapcore.ReflectType,
+			zapcore.ObjectMarshalerType, zapcore.InlineMarshalerType, zapcore.ArrayMarshalerType:
+			enc := zapcore.NewMapObjectEncoder()
+			f.AddTo(enc)
+			if f.Type == zapcore.InlineMarshalerType {
+				f = zap.Any(f.Key, c.redactValue(enc.Fields))
+				break
+			}
+			// errors also add their verbose form, e.g. "<key>Verbose"
+			for key, v := range enc.Fields {
+				if key == f.Key {
+					f = zap.Any(key, c.redactValue(v))
+				} else {
+					redacted = append(redacted, zap.Any(key, c.redactValue(v)))
+				}
+			}
+		}
+		redacted[i] = f
+	}
+	return redacted
+}
+
+// redactValue returns v, encoded by a zapcore.MapObjectEncoder, with its strings redacted
+func (c *redactingCore) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return c.redact(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = c.redactValue(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = c.redactValue(value)
+		}
+		return s
+	case fmt.Stringer:
+		return c.redact(v.String())
+	case error:
+		return c.redact(v.Error())
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr,
+		float32, float64, complex64, complex128, time.Time, time.Duration:
+		return v
+	default:
+		// reflected values, redacted through their JSON encoding
+		b, err := json.Marshal(v)
+		if err != nil {
+			return c.redact(fmt.Sprint(v))
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			return c.redact(string(b))
+		}
+		return c.redactValue(decoded)
+	}
+}
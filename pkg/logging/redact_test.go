@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type secretStringer string
+
+func (s secretStringer) String() string {
+	return string(s)
+}
+
+type secretObject struct {
+	Token string
+}
+
+func (o secretObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("token", o.Token)
+	return nil
+}
+
+func TestWithRedactors(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := WithRedactors(zap.New(core), RedactPattern(regexp.MustCompile(`secret-[0-9]+`)))
+
+	l.With(zap.String("context", "secret-1")).Info("found secret-2",
+		zap.String("string", "secret-3"),
+		zap.ByteString("bytes", []byte("secret-4")),
+		zap.Error(fmt.Errorf("wrapped: %w", errors.New("secret-5"))),
+		zap.Stringer("stringer", secretStringer("secret-6")),
+		zap.Object("object", secretObject{Token: "secret-7"}),
+		zap.Any("reflected", map[string][]string{"tokens": {"secret-8"}}),
+		zap.Int("int", 1),
+	)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	entry := fmt.Sprintf("%s %v", entries[0].Message, entries[0].ContextMap())
+	if strings.Contains(entry, "secret-") {
+		t.Fatalf("expected the secrets to be redacted, got %s", entry)
+	}
+	if strings.Count(entry, Redacted) != 8 || entries[0].ContextMap()["int"] != int64(1) {
+		t.Fatalf("expected the secrets to be replaced and the other fields kept, got %s", entry)
+	}
+}
+
+func TestWithRedactorsNone(t *testing.T) {
+	l := zap.NewNop()
+	if WithRedactors(l) != l {
+		t.Fatal("expected the logger to be returned as is without redactors")
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package membudget implements a memory budget shared by caches and request queues.
+package membudget
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// entryOverhead approximates the bookkeeping cost of a cache entry in bytes
+const entryOverhead = 64
+
+// ErrBudgetExceeded is returned by Reserve when the budget cannot fit the reservation
+var ErrBudgetExceeded = errors.New("memory budget exceeded")
+
+// Budget is a memory budget in bytes shared by caches and request queues.
+//
+// Cache entries are evicted in least-recently-used order across all caches
+// whenever the budget is exceeded. Reservations, e.g. for queued requests,
+// cannot be evicted and fail with ErrBudgetExceeded instead.
+// A limit <= 0 means an unlimited budget, which still tracks usage.
+type Budget struct {
+	limit int64
+
+	mu    sync.Mutex
+	used  int64
+	lru   *list.List
+	usage map[string]int64
+}
+
+type entry struct {
+	cache *Cache
+	key   string
+	value []byte
+	size  int64
+}
+
+// New returns a new *Budget of limit bytes
+func New(limit int64) *Budget {
+	b := &Budget{
+		limit: limit,
+		lru:   list.New(),
+		usage: make(map[string]int64),
+	}
+	if limit > 0 {
+		budgetLimitMetric.Set(float64(limit))
+		setBudget(b)
+	}
+	return b
+}
+
+// Limit returns the budget limit in bytes
+func (b *Budget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the bytes currently used by all consumers
+func (b *Budget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Reserve accounts size bytes to the consumer, evicting cache entries
+// if needed, until the returned release function is called.
+func (b *Budget) Reserve(consumer string, size int64) (release func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.makeRoom(size) {
+		budgetRejectedCounter.WithLabelValues(consumer).Inc()
+		return nil, ErrBudgetExceeded
+	}
+	b.account(consumer, size)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.account(consumer, -size)
+			b.mu.Unlock()
+		})
+	}, nil
+}
+
+// NewCache returns a new *Cache accounted to the budget under the given name
+func (b *Budget) NewCache(name string) *Cache {
+	return &Cache{
+		budget:  b,
+		name:    name,
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// makeRoom evicts least recently used cache entries until size bytes fit in the budget.
+// b.mu must be held.
+func (b *Budget) makeRoom(size int64) bool {
+	if b.limit <= 0 {
+		return true
+	}
+	if size > b.limit {
+		return false
+	}
+	for b.used+size > b.limit {
+		el := b.lru.Back()
+		if el == nil {
+			return false
+		}
+		b.evict(el)
+	}
+	return true
+}
+
+// b.mu must be held
+func (b *Budget) evict(el *list.Element) {
+	e := b.lru.Remove(el).(*entry)
+	delete(e.cache.entries, e.key)
+	b.account(e.cache.name, -e.size)
+	budgetEvictionsCounter.WithLabelValues(e.cache.name).Inc()
+}
+
+// b.mu must be held
+func (b *Budget) account(consumer string, size int64) {
+	b.used += size
+	b.usage[consumer] += size
+	budgetUsedMetric.WithLabelValues(consumer).Set(float64(b.usage[consumer]))
+}
+
+// Cache is a least-recently-used cache whose entries are accounted to a Budget.
+type Cache struct {
+	budget  *Budget
+	name    string
+	entries map[string]*list.Element
+}
+
+// Get returns the cached value of the key and marks it as recently used
+func (c *Cache) Get(key string) ([]byte, bool) {
+	b := c.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	b.lru.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Add caches the value of the key, evicting least recently used entries
+// of any cache sharing the budget if needed.
+// Values that cannot fit in the budget are not cached.
+func (c *Cache) Add(key string, value []byte) {
+	b := c.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		b.evict(el)
+	}
+	size := int64(len(key)+len(value)) + entryOverhead
+	if !b.makeRoom(size) {
+		return
+	}
+	c.entries[key] = b.lru.PushFront(&entry{cache: c, key: key, value: value, size: size})
+	b.account(c.name, size)
+}
+
+// Len returns the number of cached entries
+func (c *Cache) Len() int {
+	c.budget.mu.Lock()
+	defer c.budget.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,85 @@
+package membudget
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCacheEviction(t *testing.T) {
+	// room for two entries of 1 byte keys and 35 bytes values
+	b := New(2 * (1 + 35 + entryOverhead))
+	c1, c2 := b.NewCache("c1"), b.NewCache("c2")
+	value := make([]byte, 35)
+
+	c1.Add("a", value)
+	c2.Add("b", value)
+	if _, ok := c1.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	// "b" is the least recently used entry across both caches
+	c1.Add("c", value)
+	if _, ok := c2.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if _, ok := c1.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	if c1.Len() != 2 || c2.Len() != 0 {
+		t.Fatalf("unexpected cache sizes %d, %d", c1.Len(), c2.Len())
+	}
+	if used := b.Used(); used != b.Limit() {
+		t.Fatalf("expected %d bytes used, got %d", b.Limit(), used)
+	}
+
+	// replacing an entry does not count it twice
+	c1.Add("a", value)
+	if used := b.Used(); used != b.Limit() {
+		t.Fatalf("expected %d bytes used, got %d", b.Limit(), used)
+	}
+
+	// values larger than the budget are not cached
+	c1.Add("d", make([]byte, b.Limit()))
+	if _, ok := c1.Get("d"); ok {
+		t.Fatal("expected oversized value not to be cached")
+	}
+}
+
+func TestReserve(t *testing.T) {
+	b := New(1000)
+	c := b.NewCache("cache")
+	c.Add("a", make([]byte, 500))
+
+	release, err := b.Reserve("queue", 800)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if c.Len() != 0 {
+		t.Fatal("expected cache entries to be evicted for the reservation")
+	}
+	if _, err := b.Reserve("queue", 300); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected %v, got %v", ErrBudgetExceeded, err)
+	}
+	release()
+	release()
+	if used := b.Used(); used != 0 {
+		t.Fatalf("expected 0 bytes used, got %d", used)
+	}
+	if _, err := b.Reserve("queue", 1001); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected %v, got %v", ErrBudgetExceeded, err)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	b := New(0)
+	c := b.NewCache("cache")
+	for _, k := range []string{"a", "b", "c"} {
+		c.Add(k, make([]byte, 1<<20))
+	}
+	if c.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", c.Len())
+	}
+	if _, err := b.Reserve("queue", 1<<30); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package membudget
+
+import (
+	"os"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerPrometheusMetrics()
+}
+
+func registerPrometheusMetrics() {
+	prometheus.MustRegister(budgetLimitMetric)
+	prometheus.MustRegister(budgetUsedMetric)
+	prometheus.MustRegister(budgetEvictionsCounter)
+	prometheus.MustRegister(budgetRejectedCounter)
+	prometheus.MustRegister(rssPressureMetric)
+}
+
+// the budget reported by rssPressureMetric, the latest limited one created
+var currentBudget atomic.Pointer[Budget]
+
+func setBudget(b *Budget) {
+	currentBudget.Store(b)
+}
+
+var (
+	budgetLimitMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_memory_budget_limit_bytes",
+			Help: "Memory budget shared by caches and request queues in bytes, 0 if unlimited",
+		},
+	)
+
+	budgetUsedMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_memory_budget_used_bytes",
+			Help: "Memory budget used by each cache or request queue in bytes",
+		},
+		[]string{
+			"consumer",
+		},
+	)
+
+	budgetEvictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_memory_budget_evictions_total",
+			Help: "total cache entries evicted to stay within the memory budget",
+		},
+		[]string{
+			"consumer",
+		},
+	)
+
+	budgetRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_memory_budget_rejected_total",
+			Help: "total reservations rejected because the memory budget was exceeded",
+		},
+		[]string{
+			"consumer",
+		},
+	)
+
+	rssPressureMetric = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_memory_rss_pressure_ratio",
+			Help: "Resident set size of the process divided by the memory budget, 0 if the budget is unlimited",
+		},
+		rssPressure,
+	)
+)
+
+func rssPressure() float64 {
+	b := currentBudget.Load()
+	if b == nil || b.limit <= 0 {
+		return 0
+	}
+	return float64(residentBytes()) / float64(b.limit)
+}
+
+// residentBytes returns the resident set size of the process,
+// approximated by the Go runtime where /proc is not available.
+func residentBytes() uint64 {
+	if data, err := os.ReadFile("/proc/self/statm"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds the helpers shared by the Prometheus metrics of the provider packages.
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var legacyNames atomic.Bool
+
+// SetLegacyNames also exports the metrics renamed to follow the Prometheus naming conventions
+// under their previous name and unit, so existing dashboards and alerts keep working while
+// they are migrated. It is disabled by default.
+func SetLegacyNames(enabled bool) {
+	legacyNames.Store(enabled)
+}
+
+// DurationHistogramVec is a histogram of durations in seconds, also exported in milliseconds
+// under its legacy name if enabled with SetLegacyNames
+type DurationHistogramVec struct {
+	seconds *prometheus.HistogramVec
+	legacy  *prometheus.HistogramVec
+}
+
+var _ prometheus.Collector = &DurationHistogramVec{}
+
+// NewDurationHistogramVec returns a histogram of durations in seconds. The legacy histogram
+// is named legacyName and has the buckets of opts in milliseconds.
+func NewDurationHistogramVec(opts prometheus.HistogramOpts, legacyName string, labelNames []string) *DurationHistogramVec {
+	legacyBuckets := make([]float64, len(opts.Buckets))
+	for i, b := range opts.Buckets {
+		legacyBuckets[i] = b * 1000
+	}
+	return &DurationHistogramVec{
+		seconds: prometheus.NewHistogramVec(opts, labelNames),
+		legacy: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    legacyName,
+			Help:    opts.Help + " in milliseconds (deprecated, use " + opts.Name + ")",
+			Buckets: legacyBuckets,
+		}, labelNames),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (h *DurationHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	h.seconds.Describe(ch)
+	h.legacy.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (h *DurationHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	h.seconds.Collect(ch)
+	if legacyNames.Load() {
+		h.legacy.Collect(ch)
+	}
+}
+
+// WithLabelValues returns the observer of the durations with the label values
+func (h *DurationHistogramVec) WithLabelValues(lvs ...string) DurationObserver {
+	return DurationObserver{seconds: h.seconds.WithLabelValues(lvs...), legacy: h.legacy.WithLabelValues(lvs...)}
+}
+
+// DurationObserver observes durations into a DurationHistogramVec
+type DurationObserver struct {
+	seconds prometheus.Observer
+	legacy  prometheus.Observer
+}
+
+// Observe records a duration
+func (o DurationObserver) Observe(d time.Duration) {
+	o.seconds.Observe(d.Seconds())
+	if legacyNames.Load() {
+		o.legacy.Observe(float64(d) / float64(time.Millisecond))
+	}
+}
+
+// ObserveSince records the duration since start
+func (o DurationObserver) ObserveSince(start time.Time) {
+	o.Observe(time.Since(start))
+}
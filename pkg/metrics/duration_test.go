@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDurationHistogramVec(t *testing.T) {
+	h := NewDurationHistogramVec(prometheus.HistogramOpts{
+		Name:    "test_duration_seconds",
+		Help:    "test",
+		Buckets: []float64{0.001, 0.01},
+	}, "test_latency_ms", []string{"operation"})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(h)
+
+	gather := func() map[string]float64 {
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums := map[string]float64{}
+		for _, f := range families {
+			sums[f.GetName()] = f.GetMetric()[0].GetHistogram().GetSampleSum()
+		}
+		return sums
+	}
+
+	h.WithLabelValues("encrypt").Observe(5 * time.Millisecond)
+	sums := gather()
+	if sums["test_duration_seconds"] != 0.005 {
+		t.Fatalf("expected a sum of 0.005s, got %v", sums["test_duration_seconds"])
+	}
+	if _, ok := sums["test_latency_ms"]; ok {
+		t.Fatal("expected no legacy histogram by default")
+	}
+
+	SetLegacyNames(true)
+	defer SetLegacyNames(false)
+	h.WithLabelValues("encrypt").Observe(5 * time.Millisecond)
+	sums = gather()
+	if sums["test_latency_ms"] != 5 {
+		t.Fatalf("expected a legacy sum of 5ms, got %v", sums["test_latency_ms"])
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+)
+
+// describeAliasTimeout bounds the DescribeKey calls resolving the alias
+const describeAliasTimeout = 10 * time.Second
+
+// WithAliasResolution makes the plugin, when its key is an alias, report the ARN of the key the
+// alias points to as the key ID of the Status and Encrypt responses, resolved with DescribeKey at
+// most every period. When the alias is re-pointed to a new key, the key ID changes, which triggers
+// the KMSv2 automatic re-encryption of the apiserver. Ciphertexts of any key the alias pointed to
+// are decrypted with that key. It requires kms:DescribeKey and is a no-op for key ARNs.
+func WithAliasResolution(period time.Duration) V2Option {
+	return func(p *V2Plugin) {
+		if !strings.Contains(p.keyID, ":alias/") && !strings.HasPrefix(p.keyID, "alias/") {
+			zap.L().Info("not resolving the key, not an alias", zap.String("key", p.keyID))
+			return
+		}
+		p.aliasResolution = &aliasResolution{period: period, keyARNs: map[string]struct{}{}}
+	}
+}
+
+// aliasResolution is the key the alias of the plugin points to
+type aliasResolution struct {
+	period time.Duration
+
+	mu         sync.Mutex
+	keyARN     string
+	resolvedAt time.Time
+	resolving  bool
+	// every key the alias pointed to
+	keyARNs map[string]struct{}
+}
+
+// reportedKeyID returns the key ID of the responses, the ARN of the key the alias points to
+// once resolved, see WithAliasResolution
+func (p *V2Plugin) reportedKeyID() string {
+	if p.aliasResolution == nil {
+		return p.keyID
+	}
+	p.aliasResolution.mu.Lock()
+	defer p.aliasResolution.mu.Unlock()
+	if p.aliasResolution.keyARN == "" {
+		return p.keyID
+	}
+	return p.aliasResolution.keyARN
+}
+
+// resolvedKeyID returns reportedKeyID, resolving the alias once the latest resolution is older
+// than the period: synchronously until it first succeeds, then in the background
+func (p *V2Plugin) resolvedKeyID() string {
+	r := p.aliasResolution
+	if r == nil {
+		return p.keyID
+	}
+	r.mu.Lock()
+	stale := time.Since(r.resolvedAt) >= r.period && !r.resolving
+	resolved := r.keyARN != ""
+	if stale {
+		r.resolving = true
+	}
+	r.mu.Unlock()
+	switch {
+	case stale && !resolved:
+		p.resolveAlias()
+	case stale:
+		go p.resolveAlias()
+	}
+	return p.reportedKeyID()
+}
+
+// resolveAlias calls DescribeKey on the alias and records the key it points to
+func (p *V2Plugin) resolveAlias() {
+	r := p.aliasResolution
+	ctx, cancel := context.WithTimeout(context.Background(), describeAliasTimeout)
+	defer cancel()
+	out, err := describeKey(ctx, p.svc, p.keyID)
+	if err == nil && (out.KeyMetadata == nil || aws.ToString(out.KeyMetadata.Arn) == "") {
+		err = errors.New("no key ARN in the DescribeKey response")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolving = false
+	if err != nil {
+		// retried on the next Status call
+		zap.L().Warn("failed to resolve the key alias", zap.String("key", p.keyID), zap.Error(err))
+		return
+	}
+	keyARN := aws.ToString(out.KeyMetadata.Arn)
+	r.resolvedAt = time.Now()
+	if keyARN == r.keyARN {
+		return
+	}
+	if r.keyARN == "" {
+		zap.L().Info("resolved the key alias", zap.String("key", p.keyID), zap.String("key-arn", keyARN))
+	} else {
+		zap.L().Warn("key alias points to a new key, reporting its key ID", zap.String("key", p.keyID), zap.String("from", r.keyARN), zap.String("to", keyARN))
+		kmsAliasKeyChangeCounter.WithLabelValues(p.keyID).Inc()
+		// the re-encryptions reporting the new key must not be wrapped by the KEK of the old one
+		p.keyHierarchy.expireCurrent()
+	}
+	r.keyARN = keyARN
+	r.keyARNs[keyARN] = struct{}{}
+}
+
+// isAliasKey returns true if the alias of the plugin pointed to keyID
+func (p *V2Plugin) isAliasKey(keyID string) bool {
+	if p.aliasResolution == nil {
+		return false
+	}
+	p.aliasResolution.mu.Lock()
+	defer p.aliasResolution.mu.Unlock()
+	_, ok := p.aliasResolution.keyARNs[keyID]
+	return ok
+}
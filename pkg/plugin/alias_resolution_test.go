@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+const (
+	aliasARN = "arn:aws:kms:us-west-2:123456789012:alias/etcd"
+	keyARN1  = "arn:aws:kms:us-west-2:123456789012:key/11111111-1111-1111-1111-111111111111"
+	keyARN2  = "arn:aws:kms:us-west-2:123456789012:key/22222222-2222-2222-2222-222222222222"
+)
+
+// aliasKMSMock resolves the alias to target with DescribeKey
+type aliasKMSMock struct {
+	*cloud.KMSMock
+	mu          sync.Mutex
+	target      string
+	describeErr error
+}
+
+func (m *aliasKMSMock) setTarget(target string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.target, m.describeErr = target, err
+}
+
+func (m *aliasKMSMock) DescribeKey(_ context.Context, params *kms.DescribeKeyInput, _ ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kmstypes.KeyMetadata{KeyId: params.KeyId, Arn: aws.String(m.target)}}, nil
+}
+
+func newAliasKMSMock() *aliasKMSMock {
+	m := &aliasKMSMock{KMSMock: &cloud.KMSMock{}}
+	m.SetEncryptResp("foo", nil)
+	m.SetDecryptResp("foo", nil)
+	return m
+}
+
+func TestAliasResolution(t *testing.T) {
+	m := newAliasKMSMock()
+	m.setTarget("", errors.New("access denied"))
+	p := NewV2(aliasARN, m, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize),
+		WithAliasResolution(10*time.Millisecond), WithDecryptOnlyKeys("arn:aws:kms:us-west-2:123456789012:key/other"))
+	ctx := context.Background()
+
+	// the alias is reported until resolved
+	if resp, _ := p.Status(ctx, &pb.StatusRequest{}); resp.KeyId != aliasARN {
+		t.Fatalf("expected the alias before resolution, got %q", resp.KeyId)
+	}
+
+	m.setTarget(keyARN1, nil)
+	if resp, _ := p.Status(ctx, &pb.StatusRequest{}); resp.KeyId != keyARN1 {
+		t.Fatalf("expected the first key once resolved, got %q", resp.KeyId)
+	}
+	if resp, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte("foo")}); err != nil || resp.KeyId != keyARN1 {
+		t.Fatalf("expected the encrypt response to report the first key, got %v, %v", resp, err)
+	}
+
+	// re-pointed, the new key is reported once resolved in the background
+	m.setTarget(keyARN2, nil)
+	time.Sleep(20 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, _ := p.Status(ctx, &pb.StatusRequest{})
+		if resp.KeyId == keyARN2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second key after the alias was re-pointed, got %q", resp.KeyId)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(scrapeMetrics(t), `aws_encryption_provider_kms_alias_key_changes_total{key_arn="`+aliasARN+`"} 1`) {
+		t.Error("expected the key change to be counted")
+	}
+
+	// the ciphertexts of both keys are pinned to their key
+	for _, keyID := range []string{keyARN1, keyARN2} {
+		if pinned, err := p.decryptKeyID(keyID); err != nil || pinned != keyID {
+			t.Fatalf("expected decryptions of %s to be pinned to it, got %q, %v", keyID, pinned, err)
+		}
+	}
+}
+
+// aliasHierarchyKMSMock counts the GenerateDataKey calls of an aliasKMSMock
+type aliasHierarchyKMSMock struct {
+	*aliasKMSMock
+	generateCalls atomic.Int32
+}
+
+func (m *aliasHierarchyKMSMock) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	m.generateCalls.Add(1)
+	return m.KMSMock.GenerateDataKey(ctx, params, optFns...)
+}
+
+func TestAliasResolutionKeyHierarchy(t *testing.T) {
+	m := &aliasHierarchyKMSMock{aliasKMSMock: newAliasKMSMock()}
+	m.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	m.setTarget(keyARN1, nil)
+	p := NewV2(aliasARN, m, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize),
+		WithAliasResolution(10*time.Millisecond), WithKeyHierarchy(DefaultKEKRotationPeriod))
+	ctx := context.Background()
+	encrypt := func() *pb.EncryptResponse {
+		resp, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+		if err != nil {
+			t.Fatalf("unexpected error from Encrypt %v", err)
+		}
+		return resp
+	}
+
+	if resp, _ := p.Status(ctx, &pb.StatusRequest{}); resp.KeyId != keyARN1 {
+		t.Fatalf("expected the first key once resolved, got %q", resp.KeyId)
+	}
+	encrypt()
+	encrypt()
+	if n := m.generateCalls.Load(); n != 1 {
+		t.Fatalf("expected the KEK to be reused, got %d GenerateDataKey calls", n)
+	}
+
+	// re-pointed, the KEK of the old key is not used for the new key ID
+	m.setTarget(keyARN2, nil)
+	time.Sleep(20 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, _ := p.Status(ctx, &pb.StatusRequest{})
+		if resp.KeyId == keyARN2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second key after the alias was re-pointed, got %q", resp.KeyId)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if resp := encrypt(); resp.KeyId != keyARN2 {
+		t.Fatalf("expected the encrypt response to report the second key, got %q", resp.KeyId)
+	}
+	if n := m.generateCalls.Load(); n != 2 {
+		t.Fatalf("expected a new KEK for the new key, got %d GenerateDataKey calls", n)
+	}
+}
+
+func TestAliasResolutionKeyARN(t *testing.T) {
+	p := NewV2(keyARN1, newAliasKMSMock(), nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize), WithAliasResolution(time.Minute))
+	if p.aliasResolution != nil {
+		t.Fatal("expected no resolution of a key ARN")
+	}
+}
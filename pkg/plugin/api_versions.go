@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// services of the KMS API versions, by gRPC service name prefix of their full method names
+var apiVersionServices = map[string]string{
+	"/v1beta1.KeyManagementService/": GRPC_V1,
+	"/v2.KeyManagementService/":      GRPC_V2,
+}
+
+// APIVersionsInterceptor returns a gRPC interceptor refusing the requests of the KMS API versions
+// which are not served, e.g. of an apiserver still configured with "apiVersion: v1" after v1 was
+// disabled, with FailedPrecondition and the versions to configure instead, rather than serving a
+// version the operator believes is disabled. The other services, e.g. health, are not affected.
+func APIVersionsInterceptor(served []string) grpc.UnaryServerInterceptor {
+	servedVersions := make(map[string]bool, len(served))
+	for _, v := range served {
+		servedVersions[v] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		version, method := apiVersion(info.FullMethod)
+		if version == "" || servedVersions[version] {
+			return handler(ctx, req)
+		}
+		kmsAPIVersionRejectionCounter.WithLabelValues(version, method).Inc()
+		zap.L().Warn("refusing a request of a KMS API version which is not served",
+			zap.String("version", version), zap.String("method", method), zap.Strings("served", served))
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"KMS API %s is not served by this provider (serving %s): configure the apiserver EncryptionConfiguration with a served apiVersion, or add %s to --kms-api-versions",
+			version, strings.Join(served, ", "), version)
+	}
+}
+
+// apiVersion returns the KMS API version and method of a gRPC full method name, "" if it is
+// not a method of the KMS services
+func apiVersion(fullMethod string) (version, method string) {
+	for prefix, v := range apiVersionServices {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return v, strings.TrimPrefix(fullMethod, prefix)
+		}
+	}
+	return "", ""
+}
+
+// ParseAPIVersions checks the KMS API versions of the --kms-api-versions flag
+func ParseAPIVersions(versions []string) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("at least one KMS API version must be served")
+	}
+	for _, v := range versions {
+		if v != GRPC_V1 && v != GRPC_V2 {
+			return fmt.Errorf("unknown KMS API version %q", v)
+		}
+	}
+	return nil
+}
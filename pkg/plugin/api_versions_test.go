@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIVersionsInterceptor(t *testing.T) {
+	interceptor := APIVersionsInterceptor([]string{GRPC_V2})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "resp", nil
+	}
+
+	for _, method := range []string{
+		"/v2.KeyManagementService/Status",
+		"/v2.KeyManagementService/Encrypt",
+		"/grpc.health.v1.Health/Check",
+	} {
+		resp, err := interceptor(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if resp != "resp" || err != nil {
+			t.Fatalf("%s: expected the handler response, got %v, %v", method, resp, err)
+		}
+	}
+	for _, method := range []string{
+		"/v1beta1.KeyManagementService/Version",
+		"/v1beta1.KeyManagementService/Encrypt",
+	} {
+		_, err := interceptor(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "--kms-api-versions") {
+			t.Fatalf("%s: expected an actionable FailedPrecondition error, got %v", method, err)
+		}
+	}
+
+	metrics := scrapeMetrics(t)
+	for _, expected := range []string{
+		`aws_encryption_provider_kms_api_version_rejections_total{method="Version",version="v1"} 1`,
+		`aws_encryption_provider_kms_api_version_rejections_total{method="Encrypt",version="v1"} 1`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s", expected)
+		}
+	}
+}
+
+func TestParseAPIVersions(t *testing.T) {
+	for _, versions := range [][]string{{GRPC_V1}, {GRPC_V2}, {GRPC_V1, GRPC_V2}} {
+		if err := ParseAPIVersions(versions); err != nil {
+			t.Errorf("%v: unexpected error %v", versions, err)
+		}
+	}
+	for _, versions := range [][]string{{}, {"v1beta1"}, {GRPC_V2, "v3"}} {
+		if err := ParseAPIVersions(versions); err == nil {
+			t.Errorf("%v: expected an error", versions)
+		}
+	}
+}
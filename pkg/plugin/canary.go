@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+const (
+	// maximum number of canary round trips running concurrently, samples are skipped beyond it
+	maxConcurrentCanaries = 4
+	canaryTimeout         = 30 * time.Second
+)
+
+// WithKeyCanary mirrors a sample of the Encrypt requests to the canary plugin of another key,
+// e.g. the key a switch is planned to, encrypting the plaintext and decrypting it back in the
+// background to validate the key under the real traffic. The canary ciphertexts are discarded,
+// the canary does not delay nor fail Encrypt. sampleRate is the fraction of Encrypt calls
+// mirrored, between 0 and 1.
+//
+// The canary should have its own SharedHealthCheck, so its errors do not fail the health checks.
+func WithKeyCanary(canary *V2Plugin, sampleRate float64) V2Option {
+	return func(p *V2Plugin) {
+		p.keyCanary = &keyCanary{
+			p:          canary,
+			sampleRate: sampleRate,
+			sem:        make(chan struct{}, maxConcurrentCanaries),
+		}
+	}
+}
+
+type keyCanary struct {
+	p          *V2Plugin
+	sampleRate float64
+	sem        chan struct{}
+}
+
+// mirrorToCanary runs a canary round trip of a sample of the plaintexts in the background
+func (p *V2Plugin) mirrorToCanary(plaintext []byte) {
+	kc := p.keyCanary
+	if kc == nil || rand.Float64() >= kc.sampleRate {
+		return
+	}
+	select {
+	case kc.sem <- struct{}{}:
+	default:
+		kmsCanaryCounter.WithLabelValues(p.keyID, kc.p.keyID, verificationStatusSkipped).Inc()
+		return
+	}
+
+	plaintext = bytes.Clone(plaintext)
+	go func() {
+		defer func() { <-kc.sem }()
+		ctx, cancel := context.WithTimeout(context.Background(), canaryTimeout)
+		defer cancel()
+
+		status := kmsplugin.StatusSuccess
+		eRes, err := kc.p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: plaintext})
+		var dRes *pb.DecryptResponse
+		if err == nil {
+			dRes, err = kc.p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: eRes.Ciphertext, KeyId: eRes.KeyId, Annotations: eRes.Annotations})
+		}
+		switch {
+		case err != nil:
+			zap.L().Warn("canary round trip failed", zap.String("key", p.keyID), zap.String("canary-key", kc.p.keyID), zap.Error(err))
+			status = kmsplugin.StatusFailure
+		case !bytes.Equal(dRes.Plaintext, plaintext):
+			zap.L().Error("canary ciphertext decrypted to a different plaintext", zap.String("key", p.keyID), zap.String("canary-key", kc.p.keyID))
+			status = verificationStatusMismatch
+		}
+		kmsCanaryCounter.WithLabelValues(p.keyID, kc.p.keyID, status).Inc()
+	}()
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestKeyCanary(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	tests := []struct {
+		key        string
+		canaryErr  error
+		sampleRate float64
+		expects    string
+	}{
+		{
+			key:        "test-key-canary-ok",
+			sampleRate: 1,
+			expects:    `aws_encryption_provider_kms_canary_round_trips_total{canary_key_arn="test-canary-key",key_arn="test-key-canary-ok",status="success"} 1`,
+		},
+		{
+			key:        "test-key-canary-failure",
+			canaryErr:  errors.New("fail"),
+			sampleRate: 1,
+			expects:    `aws_encryption_provider_kms_canary_round_trips_total{canary_key_arn="test-canary-key",key_arn="test-key-canary-failure",status="failure"} 1`,
+		},
+		{
+			key:        "test-key-canary-unsampled",
+			sampleRate: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			c := (&cloud.KMSMock{}).SetEncryptResp("foo", nil).SetDecryptResp(plainMessage, nil)
+			c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+				return aws.ToString(params.KeyId) == "test-canary-key"
+			}, "bar", tt.canaryErr)
+			canary := NewV2("test-canary-key", c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize))
+			p := NewV2(tt.key, c, nil, sharedHealthCheck, WithKeyCanary(canary, tt.sampleRate))
+			resp, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+			if err != nil {
+				t.Fatalf("expected the canary not to fail Encrypt, got %v", err)
+			}
+			if resp.KeyId != tt.key {
+				t.Fatalf("expected key ID %q, got %q", tt.key, resp.KeyId)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for {
+				d := scrapeMetrics(t)
+				if tt.expects == "" {
+					if strings.Contains(d, `canary_key_arn="test-canary-key",key_arn="`+tt.key) {
+						t.Fatalf("expected no canary round trip, got\n\n%s\n\n", d)
+					}
+					return
+				}
+				if strings.Contains(d, tt.expects) {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %q, got\n\n%s\n\n", tt.expects, d)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+)
+
+// EncryptedAtAnnotation records the unix time in seconds a ciphertext was encrypted at, see WithCiphertextAge
+const EncryptedAtAnnotation = "encrypted-at.aws-encryption-provider.sigs.k8s.io"
+
+// WithCiphertextAge annotates the EncryptResponse with the time of the encryption, and exports
+// the age of the annotated ciphertexts on Decrypt, to track the progress of storage re-encryption
+// campaigns. The decryptions of ciphertexts older than maxAge, if not 0, are also counted and logged
+// to spot the objects never migrated.
+//
+// The apiserver encrypts a data key with KMS and reuses it for many writes, so the age is the one
+// of the data key, never younger than the age of the object.
+func WithCiphertextAge(maxAge time.Duration) V2Option {
+	return func(p *V2Plugin) {
+		p.ciphertextAge = &ciphertextAge{maxAge: maxAge}
+	}
+}
+
+type ciphertextAge struct {
+	maxAge time.Duration
+}
+
+// annotateEncryptedAt adds the encryption time to the response, if enabled
+func (p *V2Plugin) annotateEncryptedAt(resp *pb.EncryptResponse) {
+	if p.ciphertextAge == nil {
+		return
+	}
+	if resp.Annotations == nil {
+		resp.Annotations = make(map[string][]byte)
+	}
+	resp.Annotations[EncryptedAtAnnotation] = strconv.AppendInt(nil, time.Now().Unix(), 10)
+}
+
+// observeCiphertextAge exports the age of the ciphertext of the request, if enabled and annotated
+func (p *V2Plugin) observeCiphertextAge(request *pb.DecryptRequest) {
+	if p.ciphertextAge == nil {
+		return
+	}
+	annotation, ok := request.Annotations[EncryptedAtAnnotation]
+	if !ok {
+		return
+	}
+	unix, err := strconv.ParseInt(string(annotation), 10, 64)
+	if err != nil {
+		zap.L().Debug("invalid encrypted-at annotation", zap.ByteString("annotation", annotation), zap.Error(err))
+		return
+	}
+	age := time.Since(time.Unix(unix, 0))
+	kmsCiphertextAgeMetric.WithLabelValues(p.keyID).Observe(age.Seconds())
+	if p.ciphertextAge.maxAge > 0 && age > p.ciphertextAge.maxAge {
+		kmsCiphertextAgeExceededCounter.WithLabelValues(p.keyID).Inc()
+		zap.L().Warn("decrypted a ciphertext older than the maximum age", zap.String("key", p.keyID),
+			zap.Duration("age", age), zap.Duration("max-age", p.ciphertextAge.maxAge))
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestCiphertextAge(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp(plainMessage, nil)
+	p := NewV2(key, c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize), WithCiphertextAge(24*time.Hour))
+	ctx := context.Background()
+
+	eRes, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	encryptedAt, err := strconv.ParseInt(string(eRes.Annotations[EncryptedAtAnnotation]), 10, 64)
+	if err != nil || time.Since(time.Unix(encryptedAt, 0)) > time.Minute {
+		t.Fatalf("expected the encryption time annotation, got %q", eRes.Annotations[EncryptedAtAnnotation])
+	}
+
+	exceeded := func() int { return logs.FilterMessage("decrypted a ciphertext older than the maximum age").Len() }
+	for _, tc := range []struct {
+		name        string
+		annotations map[string][]byte
+		exceeded    int
+	}{
+		{name: "recent", annotations: eRes.Annotations, exceeded: 0},
+		{name: "not annotated", annotations: nil, exceeded: 0},
+		{name: "invalid", annotations: map[string][]byte{EncryptedAtAnnotation: []byte("yesterday")}, exceeded: 0},
+		{name: "old", annotations: map[string][]byte{
+			EncryptedAtAnnotation: strconv.AppendInt(nil, time.Now().Add(-48*time.Hour).Unix(), 10),
+		}, exceeded: 1},
+	} {
+		if _, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("1foo"), KeyId: key, Annotations: tc.annotations}); err != nil {
+			t.Fatalf("%s: unexpected error from Decrypt %v", tc.name, err)
+		}
+		if n := exceeded(); n != tc.exceeded {
+			t.Fatalf("%s: expected %d ciphertexts older than the maximum age, got %d", tc.name, tc.exceeded, n)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	smithy "github.com/aws/smithy-go"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// CircuitBreakerConfig configures the circuit breaker of an operation
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of KMS availability failures within Window
+	// opening the circuit, 0 to only open it on FailureRate
+	FailureThreshold int
+	// FailureRate is the ratio of KMS availability failures to requests within Window
+	// opening the circuit once there were at least MinRequests, 0 to only open it on
+	// FailureThreshold. The circuit breaker is disabled if both are 0.
+	FailureRate float64
+	// MinRequests is the number of requests within Window below which FailureRate is not evaluated
+	MinRequests int
+	// Window is the period failures are counted over
+	Window time.Duration
+	// Cooldown is how long the circuit stays open before a trial request is let through
+	Cooldown time.Duration
+}
+
+// WithCircuitBreakers fails Encrypt, respectively Decrypt, requests fast with an Unavailable
+// error while the circuit of the operation is open, instead of adding load to an unavailable KMS.
+// Each operation has its own circuit and configuration, e.g. to protect reads less aggressively.
+// Only KMS availability failures (throttling and errors not caused by the key or the ciphertext)
+// count towards the thresholds.
+func WithCircuitBreakers(encrypt, decrypt CircuitBreakerConfig) V2Option {
+	return func(p *V2Plugin) {
+		p.encryptBreaker = newCircuitBreaker(p.keyID, kmsplugin.OperationEncrypt, encrypt)
+		p.decryptBreaker = newCircuitBreaker(p.keyID, kmsplugin.OperationDecrypt, decrypt)
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed = circuitState(iota)
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreaker struct {
+	keyID     string
+	operation string
+	config    CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    circuitState
+	failures []time.Time
+	// nil unless FailureRate is set
+	outcomes *outcomeBuckets
+	openedAt time.Time
+	// a trial request is in flight in the half-open state
+	trial bool
+}
+
+// newCircuitBreaker returns nil if the configuration disables the circuit breaker
+func newCircuitBreaker(keyID, operation string, config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureThreshold <= 0 && config.FailureRate <= 0 {
+		return nil
+	}
+	kmsCircuitBreakerOpen.WithLabelValues(keyID, operation).Set(0)
+	cb := &circuitBreaker{keyID: keyID, operation: operation, config: config}
+	if config.FailureRate > 0 {
+		cb.outcomes = &outcomeBuckets{width: max(config.Window/outcomeBucketCount, time.Nanosecond)}
+	}
+	return cb
+}
+
+// allow returns an Unavailable error if the request must not be sent to KMS
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.config.Cooldown {
+			break
+		}
+		cb.state = circuitHalfOpen
+		fallthrough
+	case circuitHalfOpen:
+		if cb.trial {
+			break
+		}
+		cb.trial = true
+		return nil
+	default:
+		return nil
+	}
+	kmsCircuitBreakerRejectionCounter.WithLabelValues(cb.keyID, cb.operation).Inc()
+	return status.Errorf(codes.Unavailable, "circuit breaker for %s is open after KMS failures", cb.operation)
+}
+
+// record updates the circuit with the result of a request allowed by allow
+func (cb *circuitBreaker) record(err error) {
+	if cb == nil {
+		return
+	}
+	failed := isAvailabilityFailure(err)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	now := time.Now()
+	if cb.state == circuitHalfOpen && cb.trial {
+		cb.trial = false
+		switch {
+		case failed:
+			cb.open(now)
+		case err == nil:
+			cb.close()
+		}
+		// other errors tell nothing about KMS, the next request is the trial
+		return
+	}
+	if cb.state != circuitClosed {
+		return
+	}
+	cb.outcomes.add(now, failed)
+	if !failed {
+		return
+	}
+	cb.failures = append(cb.failures, now)
+	i := 0
+	for i < len(cb.failures) && now.Sub(cb.failures[i]) > cb.config.Window {
+		i++
+	}
+	cb.failures = cb.failures[i:]
+	if cb.config.FailureThreshold > 0 && len(cb.failures) >= cb.config.FailureThreshold {
+		cb.open(now)
+		return
+	}
+	if requests, failures := cb.outcomes.totals(now); cb.outcomes != nil && requests >= cb.config.MinRequests &&
+		float64(failures) >= cb.config.FailureRate*float64(requests) {
+		cb.open(now)
+	}
+}
+
+func (cb *circuitBreaker) open(now time.Time) {
+	zap.L().Warn("opening circuit breaker", zap.String("key", cb.keyID), zap.String("operation", cb.operation),
+		zap.Duration("cooldown", cb.config.Cooldown))
+	cb.state, cb.openedAt, cb.failures = circuitOpen, now, nil
+	cb.outcomes.reset()
+	kmsCircuitBreakerOpen.WithLabelValues(cb.keyID, cb.operation).Set(1)
+}
+
+func (cb *circuitBreaker) close() {
+	zap.L().Info("closing circuit breaker", zap.String("key", cb.keyID), zap.String("operation", cb.operation))
+	cb.state = circuitClosed
+	kmsCircuitBreakerOpen.WithLabelValues(cb.keyID, cb.operation).Set(0)
+}
+
+// outcomeBucketCount is the number of buckets the window of the failure rate is divided in
+const outcomeBucketCount = 10
+
+// outcomeBuckets counts the requests and failures of the latest window in buckets, so the
+// failure rate is evaluated in constant memory whatever the request rate
+type outcomeBuckets struct {
+	width   time.Duration
+	buckets [outcomeBucketCount]struct {
+		start              time.Time
+		requests, failures int
+	}
+}
+
+func (o *outcomeBuckets) add(now time.Time, failed bool) {
+	if o == nil {
+		return
+	}
+	start := now.Truncate(o.width)
+	b := &o.buckets[(start.UnixNano()/int64(o.width))%outcomeBucketCount]
+	if !b.start.Equal(start) {
+		b.start, b.requests, b.failures = start, 0, 0
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// totals returns the requests and failures of the buckets of the window ending at now
+func (o *outcomeBuckets) totals(now time.Time) (requests, failures int) {
+	if o == nil {
+		return 0, 0
+	}
+	for _, b := range o.buckets {
+		if now.Sub(b.start) < o.width*outcomeBucketCount {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+func (o *outcomeBuckets) reset() {
+	if o == nil {
+		return
+	}
+	*o = outcomeBuckets{width: o.width}
+}
+
+// isAvailabilityFailure returns true for errors of KMS API calls that do not
+// come from the key, the request or the ciphertext, see V2Plugin.Live
+func isAvailabilityFailure(err error) bool {
+	var oe *smithy.OperationError
+	if err == nil || !errors.As(err, &oe) {
+		return false
+	}
+	switch kmsplugin.ParseError(err) {
+	case kmsplugin.KMSErrorTypeThrottled, kmsplugin.KMSErrorTypeOther:
+		return true
+	default:
+		return false
+	}
+}
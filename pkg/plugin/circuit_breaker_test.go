@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	smithy "github.com/aws/smithy-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func kmsOperationError(err error) error {
+	return &smithy.OperationError{ServiceID: "KMS", OperationName: "Encrypt", Err: err}
+}
+
+func TestCircuitBreakers(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", kmsOperationError(errors.New("connection reset")))
+	c.SetDecryptResp("foo", nil)
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	p := NewV2(key, c, nil, sharedHealthCheck, WithCircuitBreakers(
+		CircuitBreakerConfig{FailureThreshold: 2, Window: time.Minute, Cooldown: 50 * time.Millisecond},
+		CircuitBreakerConfig{FailureThreshold: 100, Window: time.Minute, Cooldown: time.Minute},
+	))
+	ctx := context.Background()
+	encrypt := func() error {
+		_, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := encrypt(); err == nil || status.Code(err) == codes.Unavailable {
+			t.Fatalf("#%d: expected KMS error, got %v", i, err)
+		}
+	}
+	if err := encrypt(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+
+	// the decrypt circuit is separate
+	if _, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("1foo")}); err != nil {
+		t.Fatalf("unexpected error from Decrypt %v", err)
+	}
+
+	// a failed trial request reopens the circuit
+	time.Sleep(100 * time.Millisecond)
+	if err := encrypt(); err == nil || status.Code(err) == codes.Unavailable {
+		t.Fatalf("expected trial request to fail with KMS error, got %v", err)
+	}
+	if err := encrypt(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected reopened circuit, got %v", err)
+	}
+
+	// a successful trial request closes the circuit
+	c.SetEncryptResp("foo", nil)
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := encrypt(); err != nil {
+			t.Fatalf("#%d: unexpected error from Encrypt %v", i, err)
+		}
+	}
+}
+
+func TestCircuitBreakerMalformedCiphertext(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetDecryptResp("foo", kmsOperationError(errors.New("connection reset")))
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	p := NewV2(key, c, nil, sharedHealthCheck, WithCircuitBreakers(
+		CircuitBreakerConfig{},
+		CircuitBreakerConfig{FailureThreshold: 1, Window: time.Minute, Cooldown: 50 * time.Millisecond},
+	))
+	ctx := context.Background()
+	decrypt := func(ciphertext []byte) error {
+		_, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: ciphertext})
+		return err
+	}
+
+	if err := decrypt([]byte("1foo")); err == nil || status.Code(err) == codes.Unavailable {
+		t.Fatalf("expected KMS error, got %v", err)
+	}
+	if err := decrypt([]byte("1foo")); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+
+	// a malformed ciphertext arriving while half-open is not the trial request
+	time.Sleep(100 * time.Millisecond)
+	malformed := append([]byte(kmsplugin.KMSStorageVersionV2Features), 0)
+	if err := decrypt(malformed); !errors.Is(err, errMalformedFeaturesHeader) {
+		t.Fatalf("expected malformed features header, got %v", err)
+	}
+	c.SetDecryptResp("foo", nil)
+	for i := 0; i < 3; i++ {
+		if err := decrypt([]byte("1foo")); err != nil {
+			t.Fatalf("#%d: unexpected error from Decrypt %v", i, err)
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoredErrors(t *testing.T) {
+	cb := newCircuitBreaker(key, "test", CircuitBreakerConfig{FailureThreshold: 1, Window: time.Minute, Cooldown: time.Minute})
+	for _, err := range []error{
+		errors.New("not a KMS call"),
+		kmsOperationError(&kmstypes.DisabledException{Message: aws.String("disabled")}),
+		kmsOperationError(&kmstypes.InvalidCiphertextException{Message: aws.String("corrupted")}),
+	} {
+		if err := cb.allow(); err != nil {
+			t.Fatalf("unexpected open circuit %v", err)
+		}
+		cb.record(err)
+	}
+	if err := cb.allow(); err != nil {
+		t.Fatalf("unexpected open circuit %v", err)
+	}
+	cb.record(kmsOperationError(&kmstypes.LimitExceededException{Message: aws.String("throttled")}))
+	if err := cb.allow(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected throttling to open the circuit, got %v", err)
+	}
+
+	if cb := newCircuitBreaker(key, "test", CircuitBreakerConfig{}); cb != nil {
+		t.Fatal("expected zero threshold to disable the circuit breaker")
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	cb := newCircuitBreaker(key, "test", CircuitBreakerConfig{FailureRate: 0.5, MinRequests: 4, Window: time.Minute, Cooldown: time.Minute})
+	failure := kmsOperationError(errors.New("connection reset"))
+	// below the minimum requests, a failure rate of 1 does not open the circuit
+	for _, err := range []error{failure, nil, failure} {
+		if err := cb.allow(); err != nil {
+			t.Fatalf("unexpected open circuit %v", err)
+		}
+		cb.record(err)
+	}
+	if err := cb.allow(); err != nil {
+		t.Fatalf("unexpected open circuit %v", err)
+	}
+	// the next failure is evaluated once there were enough requests, 3 failures of 5 requests
+	cb.record(nil)
+	if err := cb.allow(); err != nil {
+		t.Fatalf("unexpected open circuit %v", err)
+	}
+	cb.record(failure)
+	if err := cb.allow(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the failure rate to open the circuit, got %v", err)
+	}
+}
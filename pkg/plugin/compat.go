@@ -0,0 +1,159 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// CompatBehavior is how the ciphertexts of a CompatPrefix are decrypted
+type CompatBehavior string
+
+const (
+	// CompatKMS ciphertexts are a KMS ciphertext blob after the prefix
+	CompatKMS = CompatBehavior("kms")
+	// CompatKMSBase64 ciphertexts are a base64 encoded KMS ciphertext blob after the prefix
+	CompatKMSBase64 = CompatBehavior("kms-base64")
+)
+
+// CompatPrefix maps the storage prefix of the ciphertexts written by another provider, e.g.
+// a fork, to how they are decrypted, so clusters switching providers can read their existing
+// data without migrating it first. Only decryption is affected, new ciphertexts are written
+// in the format of this provider.
+type CompatPrefix struct {
+	Prefix   string
+	Behavior CompatBehavior
+}
+
+// ParseCompatPrefix parses "<prefix>=<behavior>", the prefix being hex encoded if it
+// starts with "0x", e.g. "fork:v1:=kms" or "0x0a01=kms-base64"
+func ParseCompatPrefix(s string) (CompatPrefix, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return CompatPrefix{}, fmt.Errorf("expected <prefix>=<behavior>, got %q", s)
+	}
+	prefix, behavior := s[:i], CompatBehavior(s[i+1:])
+	if strings.HasPrefix(prefix, "0x") {
+		b, err := hex.DecodeString(prefix[2:])
+		if err != nil || len(b) == 0 {
+			return CompatPrefix{}, fmt.Errorf("invalid hex prefix %q", prefix)
+		}
+		prefix = string(b)
+	}
+	switch kmsplugin.KMSStorageVersion(prefix[:1]) {
+	case kmsplugin.KMSStorageVersionV2, kmsplugin.KMSStorageVersionV2KeyHierarchy, kmsplugin.KMSStorageVersionV2Features, kmsplugin.KMSStorageVersionV3:
+		return CompatPrefix{}, fmt.Errorf("prefix %q would shadow the ciphertexts of this provider starting with %q", prefix, prefix[:1])
+	}
+	if behavior != CompatKMS && behavior != CompatKMSBase64 {
+		return CompatPrefix{}, fmt.Errorf("unknown behavior %q, expected %s or %s", behavior, CompatKMS, CompatKMSBase64)
+	}
+	return CompatPrefix{Prefix: prefix, Behavior: behavior}, nil
+}
+
+func (c CompatPrefix) String() string {
+	return c.label() + "=" + string(c.Behavior)
+}
+
+// label returns the prefix, hex encoded unless printable
+func (c CompatPrefix) label() string {
+	for _, r := range c.Prefix {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return "0x" + hex.EncodeToString([]byte(c.Prefix))
+		}
+	}
+	return c.Prefix
+}
+
+// compatPrefixes is the compatibility table of a plugin, longest prefixes first
+type compatPrefixes []CompatPrefix
+
+func newCompatPrefixes(prefixes []CompatPrefix) compatPrefixes {
+	c := append(compatPrefixes{}, prefixes...)
+	// so a prefix of another prefix doesn't shadow it
+	for i := 1; i < len(c); i++ {
+		for j := i; j > 0 && len(c[j].Prefix) > len(c[j-1].Prefix); j-- {
+			c[j], c[j-1] = c[j-1], c[j]
+		}
+	}
+	return c
+}
+
+// matches returns true if a prefix of the table matches the ciphertext
+func (c compatPrefixes) matches(ciphertext []byte) bool {
+	for _, p := range c {
+		if bytes.HasPrefix(ciphertext, []byte(p.Prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// kmsCiphertext returns the KMS ciphertext blob of a ciphertext of another provider, false if
+// no prefix of the table matches
+func (c compatPrefixes) kmsCiphertext(ciphertext []byte) ([]byte, bool, error) {
+	for _, p := range c {
+		if !bytes.HasPrefix(ciphertext, []byte(p.Prefix)) {
+			continue
+		}
+		blob := ciphertext[len(p.Prefix):]
+		if p.Behavior == CompatKMSBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(string(blob))
+			if err != nil {
+				return nil, true, fmt.Errorf("invalid base64 ciphertext of prefix %q: %w", p.Prefix, err)
+			}
+			blob = decoded
+		}
+		kmsCompatDecryptionCounter.WithLabelValues(p.label()).Inc()
+		return blob, true, nil
+	}
+	return nil, false, nil
+}
+
+// decryptCompat decrypts the ciphertexts of other providers matched by the compatibility table
+func (p *V2Plugin) decryptCompat(ctx context.Context, request *pb.DecryptRequest, storageVersion kmsplugin.KMSStorageVersion) (*pb.DecryptResponse, error) {
+	blob, ok, err := p.compatPrefixes.kmsCiphertext(request.Ciphertext)
+	if !ok {
+		return nil, fmt.Errorf("version %s in Ciphertext doesn't match kmsplugin", storageVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+	request.Ciphertext = append([]byte(kmsplugin.KMSStorageVersionV2), blob...)
+	return p.decryptKMS(ctx, request)
+}
+
+// WithCompatPrefixes decrypts the ciphertexts of other providers with the compatibility table,
+// see CompatPrefix
+func WithCompatPrefixes(prefixes ...CompatPrefix) V2Option {
+	return func(p *V2Plugin) {
+		p.compatPrefixes = newCompatPrefixes(prefixes)
+	}
+}
+
+// WithV1CompatPrefixes is WithCompatPrefixes for the V1Plugin
+func WithV1CompatPrefixes(prefixes ...CompatPrefix) V1Option {
+	return func(p *V1Plugin) {
+		p.compatPrefixes = newCompatPrefixes(prefixes)
+	}
+}
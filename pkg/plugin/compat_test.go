@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	pbv1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestParseCompatPrefix(t *testing.T) {
+	tt := []struct {
+		in       string
+		expected CompatPrefix
+		err      string
+	}{
+		{in: "fork:v1:=kms", expected: CompatPrefix{Prefix: "fork:v1:", Behavior: CompatKMS}},
+		{in: "k=v=kms-base64", expected: CompatPrefix{Prefix: "k=v", Behavior: CompatKMSBase64}},
+		{in: "0x0a01=kms", expected: CompatPrefix{Prefix: "\x0a\x01", Behavior: CompatKMS}},
+		{in: "fork", err: "expected <prefix>=<behavior>"},
+		{in: "=kms", err: "expected <prefix>=<behavior>"},
+		{in: "0xzz=kms", err: "invalid hex prefix"},
+		{in: "1fork=kms", err: "would shadow"},
+		{in: "fork=plain", err: "unknown behavior"},
+	}
+	for _, tc := range tt {
+		c, err := ParseCompatPrefix(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: expected error containing %q, got %v", tc.in, tc.err, err)
+			}
+			continue
+		}
+		if err != nil || c != tc.expected {
+			t.Errorf("%q: expected %+v, got %+v, %v", tc.in, tc.expected, c, err)
+		}
+	}
+	if s := (CompatPrefix{Prefix: "\x0a\x01", Behavior: CompatKMS}).String(); s != "0x0a01=kms" {
+		t.Errorf("expected a binary prefix to be hex encoded, got %q", s)
+	}
+}
+
+func TestCompatPrefixes(t *testing.T) {
+	blob := []byte("kms-blob")
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+		return bytes.Equal(params.CiphertextBlob, blob)
+	}, "secret", nil)
+	c.SetDecryptResp("", &kmstypes.InvalidCiphertextException{})
+
+	prefixes := []CompatPrefix{
+		{Prefix: "fork:", Behavior: CompatKMS},
+		{Prefix: "fork:b64:", Behavior: CompatKMSBase64},
+	}
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	p1 := New(key, c, nil, h, WithV1CompatPrefixes(prefixes...))
+	p2 := NewV2(key, c, nil, h, WithCompatPrefixes(prefixes...))
+	shim := NewV1Shim(p2)
+	ctx := context.Background()
+
+	for _, ciphertext := range [][]byte{
+		append([]byte("fork:"), blob...),
+		// the longest matching prefix wins
+		[]byte("fork:b64:" + base64.StdEncoding.EncodeToString(blob)),
+	} {
+		//nolint:staticcheck
+		if resp, err := p1.Decrypt(ctx, &pbv1.DecryptRequest{Cipher: bytes.Clone(ciphertext)}); err != nil || string(resp.Plain) != "secret" {
+			t.Errorf("v1 %q: expected the plaintext, got %v, %v", ciphertext, resp, err)
+		}
+		if resp, err := p2.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: bytes.Clone(ciphertext)}); err != nil || string(resp.Plaintext) != "secret" {
+			t.Errorf("v2 %q: expected the plaintext, got %v, %v", ciphertext, resp, err)
+		}
+		//nolint:staticcheck
+		if resp, err := shim.Decrypt(ctx, &pbv1.DecryptRequest{Cipher: bytes.Clone(ciphertext)}); err != nil || string(resp.Plain) != "secret" {
+			t.Errorf("shim %q: expected the plaintext, got %v, %v", ciphertext, resp, err)
+		}
+	}
+
+	if _, err := p2.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("fork:b64:!")}); err == nil || !strings.Contains(err.Error(), "invalid base64") {
+		t.Errorf("expected an invalid base64 error, got %v", err)
+	}
+	if _, err := p2.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("other")}); err == nil || !strings.Contains(err.Error(), "doesn't match kmsplugin") {
+		t.Errorf("expected other prefixes to be rejected, got %v", err)
+	}
+	if !strings.Contains(scrapeMetrics(t), `aws_encryption_provider_kms_compat_decryptions_total{prefix="fork:"} 3`) {
+		t.Error("expected the decryptions of the prefix to be counted")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+const (
+	// a ciphertext failing to decrypt is logged at most once per interval,
+	// as the apiserver retries reading the object
+	decryptFailureLogInterval = time.Minute
+	// number of ciphertexts whose failures are tracked, beyond it the oldest are forgotten
+	decryptFailureLogSize = 1024
+)
+
+// decryptFailures samples the logs of the ciphertexts failing to decrypt, shared by all plugins
+var decryptFailures = newDecryptFailureLog(decryptFailureLogInterval, decryptFailureLogSize)
+
+// decryptFailureLog logs ciphertexts failing to decrypt by fingerprint, so operators can
+// identify and count the distinct corrupted objects in etcd without logging their contents
+type decryptFailureLog struct {
+	interval time.Duration
+	size     int
+
+	mu   sync.Mutex
+	seen map[string]*decryptFailure
+}
+
+type decryptFailure struct {
+	loggedAt time.Time
+	// failures not logged since loggedAt
+	suppressed int
+}
+
+func newDecryptFailureLog(interval time.Duration, size int) *decryptFailureLog {
+	return &decryptFailureLog{interval: interval, size: size, seen: make(map[string]*decryptFailure)}
+}
+
+// log logs the failure to decrypt the ciphertext, unless it was logged less than an interval ago
+func (l *decryptFailureLog) log(keyID, version string, ciphertext []byte, err error) {
+	fingerprint := kmsplugin.CiphertextFingerprint(ciphertext)
+	now := time.Now()
+
+	l.mu.Lock()
+	f, ok := l.seen[fingerprint]
+	if ok && now.Sub(f.loggedAt) < l.interval {
+		f.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := 0
+	if ok {
+		suppressed = f.suppressed
+	} else {
+		l.evict(now)
+	}
+	l.seen[fingerprint] = &decryptFailure{loggedAt: now}
+	l.mu.Unlock()
+
+	zap.L().Warn("ciphertext failed to decrypt",
+		zap.String("key", keyID),
+		zap.String("version", version),
+		zap.String("ciphertext-fingerprint", fingerprint),
+		zap.String("error-type", kmsplugin.ParseError(err).String()),
+		zap.Int("suppressed", suppressed),
+		zap.Error(err))
+}
+
+// evict makes room for a new fingerprint, forgetting the ones logged more than an
+// interval ago, or all of them if there are none. It must be called with mu held.
+func (l *decryptFailureLog) evict(now time.Time) {
+	if len(l.seen) < l.size {
+		return
+	}
+	for fingerprint, f := range l.seen {
+		if now.Sub(f.loggedAt) >= l.interval {
+			delete(l.seen, fingerprint)
+		}
+	}
+	if len(l.seen) >= l.size {
+		clear(l.seen)
+	}
+}
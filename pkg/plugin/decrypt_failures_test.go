@@ -0,0 +1,65 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestDecryptFailureLog(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	c := &cloud.KMSMock{}
+	c.SetDecryptResp("", &kmstypes.InvalidCiphertextException{})
+	p := NewV2(key, c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize))
+	decryptFailures = newDecryptFailureLog(time.Hour, 2)
+	defer func() { decryptFailures = newDecryptFailureLog(decryptFailureLogInterval, decryptFailureLogSize) }()
+
+	decrypt := func(ciphertext string) {
+		if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(ciphertext), KeyId: key}); err == nil {
+			t.Fatal("expected an error from Decrypt")
+		}
+	}
+	failures := func() []observer.LoggedEntry {
+		return logs.FilterMessage("ciphertext failed to decrypt").All()
+	}
+
+	decrypt("1secret")
+	decrypt("1secret")
+	entries := failures()
+	if len(entries) != 1 {
+		t.Fatalf("expected the failures of a ciphertext to be logged once per interval, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if got, want := fields["ciphertext-fingerprint"], kmsplugin.CiphertextFingerprint([]byte("1secret")); got != want {
+		t.Fatalf("expected fingerprint %v, got %v", want, got)
+	}
+	if got := fields["error-type"]; got != kmsplugin.KMSErrorTypeCorruption.String() {
+		t.Fatalf("expected error type %s, got %v", kmsplugin.KMSErrorTypeCorruption, got)
+	}
+	for _, e := range logs.All() {
+		for k, v := range e.ContextMap() {
+			if s, ok := v.(string); ok && strings.Contains(s, "secret") {
+				t.Fatalf("ciphertext logged in field %s: %s", k, s)
+			}
+		}
+	}
+
+	// distinct ciphertexts are logged, evicting the oldest beyond the size
+	decrypt("1other")
+	decrypt("1third")
+	decrypt("1secret")
+	if n := len(failures()); n != 4 {
+		t.Fatalf("expected 4 logged failures, got %d", n)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+)
+
+var errUnknownDecryptKey = errors.New("ciphertext written with an unknown key")
+
+// WithDecryptOnlyKeys lets the plugin decrypt the ciphertexts written with the given keys,
+// e.g. the previous key after a key change, while encrypting with its own key. The old
+// provider can then be removed from the EncryptionConfiguration without re-encrypting first.
+//
+// The apiserver sends the key ID returned by Encrypt with each decrypt request, KMS Decrypt
+// is pinned to it, and requests of keys that are neither the plugin key nor one of keyIDs
+// are rejected, so removing a key from the list stops decrypting its ciphertexts. Requests
+// without key ID, e.g. the health checks, are left to KMS to infer the key from the ciphertext.
+// The decryptions with each key are counted, so a key can be removed once unused.
+func WithDecryptOnlyKeys(keyIDs ...string) V2Option {
+	return func(p *V2Plugin) {
+		p.decryptOnlyKeys = make(map[string]struct{}, len(keyIDs))
+		for _, keyID := range keyIDs {
+			p.decryptOnlyKeys[keyID] = struct{}{}
+		}
+	}
+}
+
+// isDecryptOnlyKey returns true if the ciphertexts of keyID are decrypted, see WithDecryptOnlyKeys
+func (p *V2Plugin) isDecryptOnlyKey(keyID string) bool {
+	_, ok := p.decryptOnlyKeys[keyID]
+	return ok
+}
+
+// decryptKeyID returns the key to pin KMS Decrypt to for the key ID of a decrypt request,
+// "" to let KMS infer it from the ciphertext
+func (p *V2Plugin) decryptKeyID(requestKeyID string) (string, error) {
+	if p.decryptOnlyKeys == nil || requestKeyID == "" {
+		return "", nil
+	}
+	if requestKeyID == p.keyID || p.isAliasKey(requestKeyID) {
+		return requestKeyID, nil
+	}
+	if !p.isDecryptOnlyKey(requestKeyID) {
+		zap.L().Error("ciphertext written with a key that is not a decrypt key", zap.String("key", p.keyID), zap.String("ciphertext-key", requestKeyID))
+		return "", fmt.Errorf("%w %q, expected %q or a decrypt-only key", errUnknownDecryptKey, requestKeyID, p.keyID)
+	}
+	return requestKeyID, nil
+}
+
+// observeDecryptOnlyKey counts a successful decryption of a ciphertext of a decrypt-only key
+func (p *V2Plugin) observeDecryptOnlyKey(request *pb.DecryptRequest) {
+	if request.KeyId != p.keyID && p.isDecryptOnlyKey(request.KeyId) {
+		kmsDecryptOnlyKeyCounter.WithLabelValues(p.keyID, request.KeyId).Inc()
+	}
+}
+
+// observeDecryptedKey counts a successful KMS decryption by the key ARN KMS reports it
+// decrypted with, whatever the key sent by the apiserver, so the keys still referenced by
+// the stored data are known
+func observeDecryptedKey(keyID, version string, result *kms.DecryptOutput) {
+	if decryptedKeyID := aws.ToString(result.KeyId); decryptedKeyID != "" {
+		kmsDecryptedKeyCounter.WithLabelValues(keyID, decryptedKeyID, version).Inc()
+	}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestDecryptOnlyKeys(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	c := &cloud.KMSMock{}
+	c.SetDecryptResp("", errors.New("unexpected key"))
+	for _, keyID := range []string{"test-key-decrypt-new", "test-key-decrypt-old"} {
+		c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+			return aws.ToString(params.KeyId) == keyID
+		}, plainMessage, nil)
+	}
+	p := NewV2("test-key-decrypt-new", c, nil, sharedHealthCheck, WithDecryptOnlyKeys("test-key-decrypt-old"))
+
+	tests := []struct {
+		keyID   string
+		wantErr error
+	}{
+		{keyID: "test-key-decrypt-new"},
+		{keyID: "test-key-decrypt-old"},
+		{keyID: "test-key-decrypt-removed", wantErr: errUnknownDecryptKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyID, func(t *testing.T) {
+			resp, err := p.Decrypt(context.Background(), &pb.DecryptRequest{
+				Ciphertext: append([]byte(kmsplugin.KMSStorageVersionV2), "cipher"...),
+				KeyId:      tt.keyID,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr == nil && string(resp.Plaintext) != plainMessage {
+				t.Fatalf("expected %q, got %q", plainMessage, resp.Plaintext)
+			}
+		})
+	}
+
+	expected := `aws_encryption_provider_kms_decrypt_only_key_requests_total{decrypt_key_arn="test-key-decrypt-old",key_arn="test-key-decrypt-new"} 1`
+	if d := scrapeMetrics(t); !strings.Contains(d, expected) {
+		t.Fatalf("expected %q, got\n\n%s\n\n", expected, d)
+	}
+}
+
+// keyReportingKMSMock reports the key of every successful Decrypt, like KMS does
+type keyReportingKMSMock struct {
+	*cloud.KMSMock
+	keyID string
+}
+
+func (m *keyReportingKMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	out, err := m.KMSMock.Decrypt(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.KeyId = aws.String(m.keyID)
+	return out, nil
+}
+
+func TestDecryptedKeyMetric(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	c := &keyReportingKMSMock{KMSMock: (&cloud.KMSMock{}).SetDecryptResp(plainMessage, nil), keyID: "arn:aws:kms:us-west-2:123456789012:key/old"}
+	p := NewV2("test-key-decrypted", c, nil, sharedHealthCheck)
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{
+		Ciphertext: append([]byte(kmsplugin.KMSStorageVersionV2), "cipher"...),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `aws_encryption_provider_kms_decrypted_key_requests_total{ciphertext_key_arn="arn:aws:kms:us-west-2:123456789012:key/old",key_arn="test-key-decrypted",version="v2"} 1`
+	if d := scrapeMetrics(t); !strings.Contains(d, expected) {
+		t.Fatalf("expected %q, got\n\n%s\n\n", expected, d)
+	}
+}
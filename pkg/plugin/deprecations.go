@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+)
+
+// deprecationWarningInterval is the minimum interval between the warnings of a deprecated ciphertext
+const deprecationWarningInterval = time.Minute
+
+// DeprecatedCiphertext matches the ciphertexts of a deprecated format, by prefix or key
+type DeprecatedCiphertext struct {
+	// Prefix of the ciphertexts as stored by the apiserver, e.g. "1" for the ciphertexts
+	// encrypted directly with KMS, see kmsplugin.KMSStorageVersion
+	Prefix string `json:"prefix,omitempty"`
+	// KeyID the ciphertexts were encrypted with, as sent by the apiserver
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// ParseDeprecatedCiphertext parses "prefix=<prefix>" or "key=<key ID>"
+func ParseDeprecatedCiphertext(s string) (DeprecatedCiphertext, error) {
+	kind, value, ok := strings.Cut(s, "=")
+	if !ok || value == "" {
+		return DeprecatedCiphertext{}, fmt.Errorf("expected prefix=<prefix> or key=<key ID>, got %q", s)
+	}
+	switch kind {
+	case "prefix":
+		return DeprecatedCiphertext{Prefix: value}, nil
+	case "key":
+		return DeprecatedCiphertext{KeyID: value}, nil
+	default:
+		return DeprecatedCiphertext{}, fmt.Errorf("unknown deprecated ciphertext kind %q, expected prefix or key", kind)
+	}
+}
+
+func (d DeprecatedCiphertext) String() string {
+	if d.Prefix != "" {
+		return "prefix=" + d.Prefix
+	}
+	return "key=" + d.KeyID
+}
+
+func (d DeprecatedCiphertext) matches(ciphertext []byte, keyID string) bool {
+	if d.Prefix != "" {
+		return bytes.HasPrefix(ciphertext, []byte(d.Prefix))
+	}
+	return keyID == d.KeyID
+}
+
+// DeprecationStatus reports the decryptions of a deprecated ciphertext format
+type DeprecationStatus struct {
+	DeprecatedCiphertext
+	// Decryptions since the provider started
+	Decryptions uint64 `json:"decryptions"`
+	// LastDecryptedAt is the time of the latest decryption, zero if none
+	LastDecryptedAt time.Time `json:"lastDecryptedAt"`
+}
+
+// Deprecations announces deprecated ciphertext formats and counts how many are still being
+// decrypted, so storage migrations can be driven to completion with real data: once no
+// deprecated ciphertext was decrypted after all resources were rewritten, the format can be
+// dropped. Every decryption is counted, and warned about at most once a minute per format.
+// It is shared by the plugins, see WithDeprecations.
+type Deprecations struct {
+	mu       sync.Mutex
+	statuses []DeprecationStatus
+	warnedAt []time.Time
+}
+
+// NewDeprecations returns the Deprecations of the given formats
+func NewDeprecations(deprecated ...DeprecatedCiphertext) *Deprecations {
+	d := &Deprecations{
+		statuses: make([]DeprecationStatus, len(deprecated)),
+		warnedAt: make([]time.Time, len(deprecated)),
+	}
+	for i, dc := range deprecated {
+		d.statuses[i].DeprecatedCiphertext = dc
+		zap.L().Info("ciphertext format deprecated", zap.Stringer("deprecated", dc))
+	}
+	return d
+}
+
+// Status returns the decryptions of every deprecated format
+func (d *Deprecations) Status() []DeprecationStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeprecationStatus{}, d.statuses...)
+}
+
+// observe counts the successful decryption of ciphertext if its format is deprecated
+func (d *Deprecations) observe(pluginKeyID string, ciphertext []byte, keyID string) {
+	if d == nil {
+		return
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range d.statuses {
+		s := &d.statuses[i]
+		if !s.matches(ciphertext, keyID) {
+			continue
+		}
+		s.Decryptions++
+		s.LastDecryptedAt = now
+		kmsDeprecatedCiphertextCounter.WithLabelValues(pluginKeyID, s.String()).Inc()
+		if now.Sub(d.warnedAt[i]) >= deprecationWarningInterval {
+			d.warnedAt[i] = now
+			zap.L().Warn("decrypted a deprecated ciphertext, rewrite the resources with a storage migration",
+				zap.String("key", pluginKeyID), zap.Stringer("deprecated", s.DeprecatedCiphertext), zap.Uint64("decryptions", s.Decryptions))
+		}
+	}
+}
+
+// WithDeprecations counts the decryptions of the deprecated ciphertext formats of d
+func WithDeprecations(d *Deprecations) V2Option {
+	return func(p *V2Plugin) {
+		p.deprecations = d
+	}
+}
+
+// observeDeprecated counts a successful decryption of a deprecated ciphertext, see WithDeprecations
+func (p *V2Plugin) observeDeprecated(ciphertext []byte, request *pb.DecryptRequest) {
+	p.deprecations.observe(p.keyID, ciphertext, request.KeyId)
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestParseDeprecatedCiphertext(t *testing.T) {
+	for s, expected := range map[string]DeprecatedCiphertext{
+		"prefix=1":    {Prefix: "1"},
+		"key=old-key": {KeyID: "old-key"},
+	} {
+		dc, err := ParseDeprecatedCiphertext(s)
+		if err != nil || dc != expected || dc.String() != s {
+			t.Errorf("%s: expected %+v, got %+v, %v", s, expected, dc, err)
+		}
+	}
+	for _, s := range []string{"", "1", "prefix=", "version=1"} {
+		if _, err := ParseDeprecatedCiphertext(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestDeprecations(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	c := &cloud.KMSMock{}
+	c.SetDecryptResp(plainMessage, nil)
+	d := NewDeprecations(DeprecatedCiphertext{Prefix: string(kmsplugin.KMSStorageVersionV2)}, DeprecatedCiphertext{KeyID: "test-key-deprecated-old"})
+	p := NewV2("test-key-deprecated", c, nil, sharedHealthCheck, WithDecryptOnlyKeys("test-key-deprecated-old"), WithDeprecations(d))
+
+	for _, keyID := range []string{"test-key-deprecated", "test-key-deprecated-old", "test-key-deprecated-old"} {
+		if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{
+			Ciphertext: append([]byte(kmsplugin.KMSStorageVersionV2), "cipher"...),
+			KeyId:      keyID,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// failed decryptions are not counted
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte("9cipher"), KeyId: "test-key-deprecated-old"}); err == nil {
+		t.Fatal("expected an unknown storage version to fail")
+	}
+
+	status := d.Status()
+	if len(status) != 2 || status[0].Decryptions != 3 || status[1].Decryptions != 2 || status[1].LastDecryptedAt.IsZero() {
+		t.Fatalf("unexpected deprecation status %+v", status)
+	}
+	metrics := scrapeMetrics(t)
+	for _, expected := range []string{
+		`aws_encryption_provider_kms_deprecated_ciphertext_decryptions_total{deprecated="prefix=1",key_arn="test-key-deprecated"} 3`,
+		`aws_encryption_provider_kms_deprecated_ciphertext_decryptions_total{deprecated="key=test-key-deprecated-old",key_arn="test-key-deprecated"} 2`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s", expected)
+		}
+	}
+}
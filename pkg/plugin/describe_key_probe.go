@@ -0,0 +1,75 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// errDescribeKeyUnsupported is returned by describeKey when the KMS client does not implement DescribeKey
+var errDescribeKeyUnsupported = errors.New("the KMS client does not support DescribeKey")
+
+// keyDescriber is implemented by the KMS clients of cloud.New, DescribeKey is not part of cloud.AWSKMSv2
+type keyDescriber interface {
+	DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
+}
+
+// describeKey calls KMS "DescribeKey" on the key, if svc implements it
+func describeKey(ctx context.Context, svc cloud.AWSKMSv2, keyID string) (*kms.DescribeKeyOutput, error) {
+	d, ok := svc.(keyDescriber)
+	if !ok {
+		return nil, errDescribeKeyUnsupported
+	}
+	return d.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(keyID)})
+}
+
+// describeKeyProbe checks the key with KMS "DescribeKey", see SharedHealthCheck.SetDescribeKeyProbes.
+// The errors are handled as those of the requests of the plugin of the given gRPC version.
+func describeKeyProbe(ctx context.Context, svc cloud.AWSKMSv2, keyID string, partitionErr *kmsplugin.PartitionMismatchError, healthCheck *SharedHealthCheck, version string) error {
+	startTime := time.Now()
+	out, err := describeKey(ctx, svc, keyID)
+	if err == nil && out.KeyMetadata != nil && out.KeyMetadata.KeyState != kmstypes.KeyStateEnabled {
+		err = &kmsplugin.ClassifiedError{
+			Type: kmsplugin.KMSErrorTypeUserInduced,
+			Err:  fmt.Errorf("key %s is %s", keyID, out.KeyMetadata.KeyState),
+		}
+	}
+	if err != nil {
+		if partitionErr != nil {
+			err = partitionErr.WithCause(err)
+		}
+		err = healthCheck.classifyProbe(ctx, err)
+		healthCheck.report(err)
+		errorType := kmsplugin.ParseError(err).String()
+		zap.L().Warn("health check failed at describing the key", zap.String("error-type", errorType), zap.Error(err))
+		failLabel := kmsplugin.GetStatusLabel(err, errorType)
+		kmsLatencyMetric.WithLabelValues(keyID, failLabel, kmsplugin.OperationDescribeKey, version).ObserveSince(startTime)
+		kmsOperationCounter.WithLabelValues(keyID, failLabel, kmsplugin.OperationDescribeKey, version).Inc()
+		kmsFailureCounter.WithLabelValues(keyID, kmsplugin.OperationDescribeKey, version, errorType).Inc()
+		return fmt.Errorf("failed to describe key %w", err)
+	}
+	kmsLatencyMetric.WithLabelValues(keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDescribeKey, version).ObserveSince(startTime)
+	kmsOperationCounter.WithLabelValues(keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDescribeKey, version).Inc()
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// ErrDraining is returned by the health check of a DrainFile while the provider is draining
+var ErrDraining = errors.New("provider is draining, the apiservers should use the replacement socket")
+
+// statusDraining is the Healthz of the Status response while draining,
+// anything but "ok" is NotReady for the apiserver
+const statusDraining = "draining"
+
+// DrainFile coordinates the replacement of the provider with external upgrade tooling.
+//
+// While the marker file exists the provider advertises NotReady, through the Status
+// RPC and the healthz and readyz handlers, so the apiservers can be shifted to the
+// socket of the replacement before this instance exits. Encrypt and Decrypt keep
+// being served, and livez is unaffected, so in-flight and late requests still succeed
+// and the instance is not restarted. Removing the file ends the drain.
+type DrainFile struct {
+	path     string
+	draining atomic.Bool
+}
+
+// NewDrainFile returns a DrainFile for the marker at path, nil if path is empty
+func NewDrainFile(path string) *DrainFile {
+	if path == "" {
+		return nil
+	}
+	return &DrainFile{path: path}
+}
+
+// Draining returns true if the marker file exists, it is checked on every call so
+// the tooling only has to create or remove the file
+func (d *DrainFile) Draining() bool {
+	if d == nil {
+		return false
+	}
+	_, err := os.Stat(d.path)
+	draining := err == nil
+	if d.draining.Swap(draining) != draining {
+		if draining {
+			zap.L().Warn("drain file found, advertising NotReady", zap.String("path", d.path))
+			drainingMetric.Set(1)
+		} else {
+			zap.L().Info("drain file removed, advertising the KMS health again", zap.String("path", d.path))
+			drainingMetric.Set(0)
+		}
+	}
+	return draining
+}
+
+// Name of the check, e.g. for the "exclude" query parameter of the health endpoints
+func (d *DrainFile) Name() string {
+	return "drain-file"
+}
+
+// Health returns ErrDraining while the marker file exists
+func (d *DrainFile) Health() error {
+	if d.Draining() {
+		return ErrDraining
+	}
+	return nil
+}
+
+// Live never fails, a draining provider must not be restarted
+func (d *DrainFile) Live() error {
+	return nil
+}
+
+// WithDrainFile reports the Status of the plugin as draining while the marker file of d exists
+func WithDrainFile(d *DrainFile) V2Option {
+	return func(p *V2Plugin) {
+		p.drainFile = d
+	}
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestDrainFile(t *testing.T) {
+	if NewDrainFile("") != nil {
+		t.Fatal("expected no drain file without a path")
+	}
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	path := filepath.Join(t.TempDir(), "draining")
+	drain := NewDrainFile(path)
+	p := NewV2(key, c, nil, sharedHealthCheck, WithDrainFile(drain), WithStatusCache(time.Minute))
+
+	status := func() string {
+		resp, err := p.Status(context.Background(), &pb.StatusRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Healthz
+	}
+	if healthz := status(); healthz != "ok" {
+		t.Fatalf("expected status ok before draining, got %s", healthz)
+	}
+	if err := drain.Health(); err != nil {
+		t.Fatalf("expected healthy before draining, got %v", err)
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the cached ok status must not delay the drain
+	if healthz := status(); healthz != statusDraining {
+		t.Fatalf("expected status %s, got %s", statusDraining, healthz)
+	}
+	if err := drain.Health(); !errors.Is(err, ErrDraining) {
+		t.Fatalf("expected %v, got %v", ErrDraining, err)
+	}
+	if err := drain.Live(); err != nil {
+		t.Fatalf("expected live while draining, got %v", err)
+	}
+	if !strings.Contains(scrapeMetrics(t), "aws_encryption_provider_draining 1") {
+		t.Error("expected the draining gauge to be set")
+	}
+
+	// requests are still served while draining
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte("hello")}); err != nil {
+		t.Fatalf("expected encrypt to succeed while draining, got %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if healthz := status(); healthz != "ok" {
+		t.Fatalf("expected status ok after draining, got %s", healthz)
+	}
+	if !strings.Contains(scrapeMetrics(t), "aws_encryption_provider_draining 0") {
+		t.Error("expected the draining gauge to be reset")
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// failureThreshold is the number of failures among the latest results making a healthy
+// plugin unhealthy, see SharedHealthCheck.SetFailureThreshold
+type failureThreshold struct {
+	failures int
+	results  int
+	window   time.Duration
+	// latest results, at most results of them and none older than window, guarded by recordMu
+	latest []thresholdResult
+}
+
+// thresholdResult is a result counted by a failureThreshold
+type thresholdResult struct {
+	ts     time.Time
+	failed bool
+}
+
+// SetFailureThreshold makes a Healthy or Recovering SharedHealthCheck only become unhealthy once
+// failures of the latest results failed, instead of on the first error, so a single transient
+// error doesn't churn the kubelet probes. Results older than window are not counted (0 to count
+// them all). failures consecutive errors are e.g. SetFailureThreshold(n, n, 0), and failures out
+// of results SetFailureThreshold(m, k, window). Results are the health checks and the KMS requests,
+// whose successes are counted when the next result is recorded, so scattered errors among
+// successful requests are not consecutive. 1 failure, the default, reports the first error.
+// It must be called before Start.
+func (p *SharedHealthCheck) SetFailureThreshold(failures, results int, window time.Duration) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.threshold = failureThreshold{failures: failures, results: max(results, failures), window: window}
+}
+
+// recordSuccess counts a successful KMS request towards the failure threshold, unless ctx is
+// the context of a health probe whose result is recorded on its own
+func (p *SharedHealthCheck) recordSuccess(ctx context.Context) {
+	if ctx.Value(probeContextKey{}) != nil {
+		return
+	}
+	p.successes.Add(1)
+}
+
+// belowThreshold records the successes of the requests since the latest result, then the
+// result err observed at ts, and returns true if the failures of the latest results are below
+// the threshold. It must be called with recordMu held.
+func (t *failureThreshold) belowThreshold(err error, successes int64, ts time.Time) bool {
+	if t.failures <= 1 {
+		return false
+	}
+	// only the latest results are kept, the successes are observed at most at ts
+	for i := int64(0); i < min(successes, int64(t.results)); i++ {
+		t.latest = append(t.latest, thresholdResult{ts: ts})
+	}
+	t.latest = append(t.latest, thresholdResult{ts: ts, failed: err != nil})
+	first := max(len(t.latest)-t.results, 0)
+	for t.window > 0 && first < len(t.latest) && ts.Sub(t.latest[first].ts) >= t.window {
+		first++
+	}
+	t.latest = append(t.latest[:0], t.latest[first:]...)
+	failures := 0
+	for _, r := range t.latest {
+		if r.failed {
+			failures++
+		}
+	}
+	return failures < t.failures
+}
+
+// suppressFailure returns true if the error err, observed at ts in state, is below the
+// failure threshold and must not change the state. It must be called with recordMu held.
+func (p *SharedHealthCheck) suppressFailure(state HealthState, err error, ts time.Time) bool {
+	below := p.threshold.belowThreshold(err, p.successes.Swap(0), ts)
+	if err == nil || !below || !state.passing() {
+		return false
+	}
+	errorType := kmsplugin.ParseError(err).String()
+	zap.L().Warn("KMS health check failed below the failure threshold", zap.String("error-type", errorType), zap.Int("failures", p.threshold.failures), zap.Error(err))
+	kmsHealthFailureSuppressedCounter.WithLabelValues(errorType).Inc()
+	return true
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+// KMSFairShare limits the concurrent KMS calls of the plugins sharing a KMS client and
+// shares them between the plugin API versions by weight, so a burst of requests of one
+// version can't starve the other. It is work-conserving: a version may use all the calls
+// while the other is idle, but once calls of both versions wait, each released call goes
+// to the waiting version with the fewest calls in flight relative to its weight.
+type KMSFairShare struct {
+	maxInFlight int
+	weights     map[string]int
+
+	mu       sync.Mutex
+	inFlight int
+	versions map[string]*fairShareVersion
+}
+
+type fairShareVersion struct {
+	weight   int
+	inFlight int
+	// waiters are granted a call by closing their channel, in order
+	waiters []chan struct{}
+}
+
+// NewKMSFairShare returns a *KMSFairShare allowing maxInFlight concurrent KMS calls,
+// shared by the weights of the versions, e.g. {"v1": 1, "v2": 3}. Versions without
+// weight have a weight of 1.
+func NewKMSFairShare(maxInFlight int, weights map[string]int) *KMSFairShare {
+	return &KMSFairShare{maxInFlight: maxInFlight, weights: weights, versions: map[string]*fairShareVersion{}}
+}
+
+// Client returns svc, its Encrypt, Decrypt and GenerateDataKey calls taking their share
+// of the calls of version
+func (f *KMSFairShare) Client(svc cloud.AWSKMSv2, version string) cloud.AWSKMSv2 {
+	return &fairShareClient{AWSKMSv2: svc, fairShare: f, version: version}
+}
+
+// version returns the state of version, f.mu must be held
+func (f *KMSFairShare) version(version string) *fairShareVersion {
+	v, ok := f.versions[version]
+	if !ok {
+		v = &fairShareVersion{weight: 1}
+		if w := f.weights[version]; w > 0 {
+			v.weight = w
+		}
+		f.versions[version] = v
+	}
+	return v
+}
+
+// acquire waits for a call of version, returning the function releasing it
+func (f *KMSFairShare) acquire(ctx context.Context, version string) (func(), error) {
+	f.mu.Lock()
+	v := f.version(version)
+	// calls only wait while all of them are in flight
+	if f.inFlight < f.maxInFlight {
+		f.grant(version, v)
+		f.mu.Unlock()
+		return func() { f.release(version, v) }, nil
+	}
+	granted := make(chan struct{})
+	v.waiters = append(v.waiters, granted)
+	kmsFairShareQueueDepthMetric.WithLabelValues(version).Set(float64(len(v.waiters)))
+	f.mu.Unlock()
+
+	kmsFairShareThrottledCounter.WithLabelValues(version).Inc()
+	startTime := time.Now()
+	select {
+	case <-granted:
+		kmsFairShareWaitMetric.WithLabelValues(version).Observe(time.Since(startTime).Seconds())
+		return func() { f.release(version, v) }, nil
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	for i, w := range v.waiters {
+		if w == granted {
+			v.waiters = append(v.waiters[:i], v.waiters[i+1:]...)
+			kmsFairShareQueueDepthMetric.WithLabelValues(version).Set(float64(len(v.waiters)))
+			f.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+	f.mu.Unlock()
+	// granted while giving up
+	f.release(version, v)
+	return nil, ctx.Err()
+}
+
+// grant counts a call of v in flight, f.mu must be held
+func (f *KMSFairShare) grant(version string, v *fairShareVersion) {
+	f.inFlight++
+	v.inFlight++
+	kmsFairShareInFlightMetric.WithLabelValues(version).Set(float64(v.inFlight))
+}
+
+// release ends a call of v and hands it over to the waiting version furthest below its share
+func (f *KMSFairShare) release(version string, v *fairShareVersion) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+	v.inFlight--
+	kmsFairShareInFlightMetric.WithLabelValues(version).Set(float64(v.inFlight))
+
+	for f.inFlight < f.maxInFlight {
+		var nextName string
+		var next *fairShareVersion
+		for name, w := range f.versions {
+			if len(w.waiters) == 0 {
+				continue
+			}
+			// w.inFlight/w.weight < next.inFlight/next.weight, ties broken by name for determinism
+			if next == nil || w.inFlight*next.weight < next.inFlight*w.weight ||
+				(w.inFlight*next.weight == next.inFlight*w.weight && name < nextName) {
+				nextName, next = name, w
+			}
+		}
+		if next == nil {
+			return
+		}
+		granted := next.waiters[0]
+		next.waiters = next.waiters[1:]
+		kmsFairShareQueueDepthMetric.WithLabelValues(nextName).Set(float64(len(next.waiters)))
+		f.grant(nextName, next)
+		close(granted)
+	}
+}
+
+// fairShareClient takes a call of the fair share of its version around the KMS calls of the plugins
+type fairShareClient struct {
+	cloud.AWSKMSv2
+	fairShare *KMSFairShare
+	version   string
+}
+
+// Region returns the region of the wrapped client, see cloud.Region
+func (c *fairShareClient) Region() string {
+	return cloud.Region(c.AWSKMSv2)
+}
+
+// DescribeKey calls the wrapped client outside of the fair share, see describeKey
+func (c *fairShareClient) DescribeKey(ctx context.Context, params *kms.DescribeKeyInput, optFns ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	d, ok := c.AWSKMSv2.(keyDescriber)
+	if !ok {
+		return nil, errDescribeKeyUnsupported
+	}
+	return d.DescribeKey(ctx, params, optFns...)
+}
+
+func (c *fairShareClient) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	release, err := c.fairShare.acquire(ctx, c.version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.AWSKMSv2.Encrypt(ctx, params, optFns...)
+}
+
+func (c *fairShareClient) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	release, err := c.fairShare.acquire(ctx, c.version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.AWSKMSv2.Decrypt(ctx, params, optFns...)
+}
+
+func (c *fairShareClient) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	release, err := c.fairShare.acquire(ctx, c.version)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return c.AWSKMSv2.GenerateDataKey(ctx, params, optFns...)
+}
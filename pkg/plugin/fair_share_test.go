@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// queuedKMSMock reports the key of each Encrypt call, then blocks it until unblocked
+type queuedKMSMock struct {
+	cloud.AWSKMSv2
+	started chan string
+	unblock chan struct{}
+}
+
+func (m *queuedKMSMock) Encrypt(_ context.Context, params *kms.EncryptInput, _ ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	m.started <- aws.ToString(params.KeyId)
+	<-m.unblock
+	return &kms.EncryptOutput{}, nil
+}
+
+func waitForWaiters(t *testing.T, f *KMSFairShare, version string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		f.mu.Lock()
+		waiting := len(f.version(version).waiters)
+		f.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiting %s calls, got %d", n, version, waiting)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestKMSFairShare(t *testing.T) {
+	m := &queuedKMSMock{started: make(chan string, 10), unblock: make(chan struct{})}
+	f := NewKMSFairShare(2, map[string]int{GRPC_V1: 1, GRPC_V2: 1})
+	clients := map[string]cloud.AWSKMSv2{GRPC_V1: f.Client(m, GRPC_V1), GRPC_V2: f.Client(m, GRPC_V2)}
+
+	var wg sync.WaitGroup
+	encrypt := func(version string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := clients[version].Encrypt(context.Background(), &kms.EncryptInput{KeyId: aws.String(version)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// a v1 burst takes all the calls, then queues
+	for i := 0; i < 5; i++ {
+		encrypt(GRPC_V1)
+	}
+	for i := 0; i < 2; i++ {
+		<-m.started
+	}
+	waitForWaiters(t, f, GRPC_V1, 3)
+	encrypt(GRPC_V2)
+	waitForWaiters(t, f, GRPC_V2, 1)
+	metrics := scrapeMetrics(t)
+	for _, expects := range []string{
+		`aws_encryption_provider_kms_fair_share_queue_depth{version="v1"} 3`,
+		`aws_encryption_provider_kms_fair_share_queue_depth{version="v2"} 1`,
+	} {
+		if !strings.Contains(metrics, expects) {
+			t.Errorf("expected %q in metrics", expects)
+		}
+	}
+
+	// the first released call goes to v2, below its share
+	m.unblock <- struct{}{}
+	if version := <-m.started; version != GRPC_V2 {
+		t.Fatalf("expected the released call to go to %s, got %s", GRPC_V2, version)
+	}
+	close(m.unblock)
+	wg.Wait()
+
+	metrics = scrapeMetrics(t)
+	for _, expects := range []string{
+		`aws_encryption_provider_kms_fair_share_queue_depth{version="v1"} 0`,
+		`aws_encryption_provider_kms_fair_share_throttled_total{version="v2"} 1`,
+		`aws_encryption_provider_kms_fair_share_in_flight{version="v1"} 0`,
+	} {
+		if !strings.Contains(metrics, expects) {
+			t.Errorf("expected %q in metrics", expects)
+		}
+	}
+}
+
+func TestKMSFairShareCanceled(t *testing.T) {
+	m := &queuedKMSMock{started: make(chan string, 1), unblock: make(chan struct{})}
+	f := NewKMSFairShare(1, nil)
+	c := f.Client(m, GRPC_V2)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Encrypt(context.Background(), &kms.EncryptInput{}) //nolint:errcheck
+	}()
+	<-m.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Encrypt(ctx, &kms.EncryptInput{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the waiting call to give up with its context, got %v", err)
+	}
+	waitForWaiters(t, f, GRPC_V2, 0)
+
+	close(m.unblock)
+	<-done
+	if _, err := c.Encrypt(context.Background(), &kms.EncryptInput{}); err != nil {
+		t.Fatalf("expected the call released by the canceled waiter to be available, got %v", err)
+	}
+}
+
+func TestKMSFairSharePartitionMismatch(t *testing.T) {
+	c := kms.NewFromConfig(aws.Config{Region: "us-gov-west-1", Credentials: aws.AnonymousCredentials{}})
+	fairShare := NewKMSFairShare(1, nil)
+	keyARN := "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+
+	p1 := New(keyARN, fairShare.Client(c, GRPC_V1), nil, sharedHealthCheck)
+	p2 := NewV2(keyARN, fairShare.Client(c, GRPC_V2), nil, sharedHealthCheck)
+	for _, err := range []error{p1.partitionErr, p2.partitionErr} {
+		if et := kmsplugin.ParseError(err); et != kmsplugin.KMSErrorTypePartitionMismatch {
+			t.Fatalf("expected error type %s through the fair share client, got %s", kmsplugin.KMSErrorTypePartitionMismatch, et)
+		}
+	}
+}
+
+func TestKMSFairShareDescribeKey(t *testing.T) {
+	fairShare := NewKMSFairShare(1, nil)
+	ctx := context.Background()
+
+	c := &describeKeyMock{KMSMock: &cloud.KMSMock{}, state: kmstypes.KeyStateEnabled}
+	out, err := describeKey(ctx, fairShare.Client(c, GRPC_V2), "key")
+	if err != nil {
+		t.Fatalf("expected DescribeKey to be forwarded to the wrapped client, got %v", err)
+	}
+	if out.KeyMetadata.KeyState != kmstypes.KeyStateEnabled {
+		t.Fatalf("unexpected key state %s", out.KeyMetadata.KeyState)
+	}
+	if _, err := describeKey(ctx, fairShare.Client(&cloud.KMSMock{}, GRPC_V2), "key"); !errors.Is(err, errDescribeKeyUnsupported) {
+		t.Fatalf("expected %v for a client without DescribeKey, got %v", errDescribeKeyUnsupported, err)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// Feature is an optional feature a ciphertext can be written with, which the providers
+// decrypting it must support
+type Feature uint8
+
+const (
+	// FeatureEnvelope is the key hierarchy, see WithKeyHierarchy
+	FeatureEnvelope Feature = 1 << iota
+	// FeatureContext is the per-request encryption context, see WithRequestUIDEncryptionContext
+	FeatureContext
+	// FeatureTransformers are the payload transformers, e.g. compression, see WithTransformers
+	FeatureTransformers
+
+	knownFeatures = FeatureEnvelope | FeatureContext | FeatureTransformers
+)
+
+var featureNames = []struct {
+	feature Feature
+	name    string
+}{
+	{FeatureEnvelope, "envelope"},
+	{FeatureContext, "context"},
+	{FeatureTransformers, "transformers"},
+}
+
+var errMalformedFeaturesHeader = errors.New("malformed features header")
+
+func (f Feature) String() string {
+	names := []string{}
+	for _, n := range featureNames {
+		if f&n.feature != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if unknown := f &^ knownFeatures; unknown != 0 {
+		names = append(names, fmt.Sprintf("unknown(%#x)", uint8(unknown)))
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseFeatures parses the names of features, e.g. ["envelope", "context"]
+func ParseFeatures(names []string) (Feature, error) {
+	var features Feature
+	for _, name := range names {
+		found := false
+		for _, n := range featureNames {
+			if n.name == name {
+				features |= n.feature
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown feature %q, expected envelope, context or transformers", name)
+		}
+	}
+	return features, nil
+}
+
+// WithClusterFeatures declares the features every provider of the cluster can decrypt, e.g.
+// once an upgrade is complete. Ciphertexts written with features then get a header recording
+// them, which only the providers supporting this option can read, and the configured
+// features not in features are not used to write, so a partially rolled out feature cannot
+// produce ciphertexts some providers, e.g. after a downgrade, fail to decrypt. Ciphertexts with
+// and without header stay decryptable, whatever the features.
+//
+// Without this option, the configured features are used and no header is written.
+func WithClusterFeatures(features Feature) V2Option {
+	return func(p *V2Plugin) {
+		p.clusterFeatures = &features
+	}
+}
+
+// warnDisabledFeatures logs the configured features not used to write, as not enabled cluster-wide
+func (p *V2Plugin) warnDisabledFeatures() {
+	var configured Feature
+	if p.keyHierarchy != nil {
+		configured |= FeatureEnvelope
+	}
+	if p.requestUIDContextKey != "" {
+		configured |= FeatureContext
+	}
+	if len(p.transformers) > 0 {
+		configured |= FeatureTransformers
+	}
+	if disabled := configured &^ *p.clusterFeatures; disabled != 0 {
+		zap.L().Warn("not writing configured features until enabled cluster-wide",
+			zap.String("key", p.keyID), zap.Stringer("features", disabled))
+	}
+}
+
+// writesFeature returns true if the configured feature f is used to write
+func (p *V2Plugin) writesFeature(f Feature) bool {
+	return p.clusterFeatures == nil || *p.clusterFeatures&f != 0
+}
+
+// addFeaturesHeader prefixes the ciphertext of resp with the features it was written with,
+// if enabled with WithClusterFeatures and written with at least one feature
+func (p *V2Plugin) addFeaturesHeader(resp *pb.EncryptResponse, transformed bool) {
+	if p.clusterFeatures == nil {
+		return
+	}
+	var features Feature
+	if kmsplugin.KMSStorageVersion(resp.Ciphertext[:1]) == kmsplugin.KMSStorageVersionV2KeyHierarchy {
+		features |= FeatureEnvelope
+	}
+	if _, ok := resp.Annotations[RequestEncryptionContextAnnotation]; ok {
+		features |= FeatureContext
+	}
+	if len(p.transformers) > 0 && transformed {
+		features |= FeatureTransformers
+	}
+	// without feature, the ciphertext stays readable by the providers not supporting the header
+	if features == 0 {
+		return
+	}
+	ciphertext := make([]byte, 0, 2+len(resp.Ciphertext))
+	ciphertext = append(ciphertext, kmsplugin.KMSStorageVersionV2Features...)
+	ciphertext = append(ciphertext, byte(features))
+	resp.Ciphertext = append(ciphertext, resp.Ciphertext...)
+}
+
+// parseFeaturesHeader returns the features and the ciphertext without header of a ciphertext
+// with a features header. Ciphertexts with features this provider does not know are rejected.
+func parseFeaturesHeader(ciphertext []byte) (Feature, []byte, error) {
+	if len(ciphertext) < 3 || kmsplugin.KMSStorageVersion(ciphertext[:1]) != kmsplugin.KMSStorageVersionV2Features {
+		return 0, nil, errMalformedFeaturesHeader
+	}
+	features, inner := Feature(ciphertext[1]), ciphertext[2:]
+	if features&^knownFeatures != 0 {
+		return 0, nil, fmt.Errorf("ciphertext written with features %s this provider does not support, upgrade it", features)
+	}
+	if kmsplugin.KMSStorageVersion(inner[:1]) == kmsplugin.KMSStorageVersionV2Features {
+		return 0, nil, errMalformedFeaturesHeader
+	}
+	return features, inner, nil
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// kekEchoKMSMock is an echoKMSMock also decrypting the KEK of its data keys
+type kekEchoKMSMock struct {
+	echoKMSMock
+}
+
+func (m *kekEchoKMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if string(params.CiphertextBlob) == "encrypted-kek" {
+		return &kms.DecryptOutput{Plaintext: []byte(testKEK)}, nil
+	}
+	return m.echoKMSMock.Decrypt(ctx, params, optFns...)
+}
+
+func TestParseFeatures(t *testing.T) {
+	features, err := ParseFeatures([]string{"envelope", "transformers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features != FeatureEnvelope|FeatureTransformers {
+		t.Fatalf("expected envelope and transformers, got %s", features)
+	}
+	if features.String() != "envelope,transformers" {
+		t.Fatalf("unexpected string %q", features.String())
+	}
+	if _, err := ParseFeatures([]string{"compression"}); err == nil {
+		t.Fatal("expected an error for an unknown feature")
+	}
+	if s := Feature(0x80 | FeatureContext).String(); s != "context,unknown(0x80)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestClusterFeatures(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	c := &kekEchoKMSMock{echoKMSMock{KMSMock: &cloud.KMSMock{}}}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	transformer := wrapTransformer{prefix: "a(", suffix: ")"}
+	configured := []V2Option{
+		WithKeyHierarchy(DefaultKEKRotationPeriod),
+		WithRequestUIDEncryptionContext("uid"),
+		WithTransformers(transformer),
+	}
+	// a provider without cluster features reads every ciphertext with header
+	reader := NewV2(key, c, nil, sharedHealthCheck, configured...)
+	// a provider without any option, e.g. an older binary, reads every ciphertext without feature
+	legacy := NewV2(key, c, nil, sharedHealthCheck)
+
+	tests := []struct {
+		name            string
+		clusterFeatures Feature
+		storageVersion  kmsplugin.KMSStorageVersion
+	}{
+		{name: "none enabled", clusterFeatures: 0, storageVersion: kmsplugin.KMSStorageVersionV2},
+		{name: "context", clusterFeatures: FeatureContext, storageVersion: kmsplugin.KMSStorageVersionV2},
+		{name: "transformers", clusterFeatures: FeatureTransformers, storageVersion: kmsplugin.KMSStorageVersionV2},
+		{name: "envelope", clusterFeatures: FeatureEnvelope, storageVersion: kmsplugin.KMSStorageVersionV2KeyHierarchy},
+		{name: "all", clusterFeatures: FeatureEnvelope | FeatureContext | FeatureTransformers, storageVersion: kmsplugin.KMSStorageVersionV2KeyHierarchy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewV2(key, c, nil, sharedHealthCheck, append(configured, WithClusterFeatures(tt.clusterFeatures))...)
+			eRes, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage), Uid: "1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the context is only recorded if used, here without key hierarchy
+			expected := tt.clusterFeatures
+			if tt.clusterFeatures&FeatureEnvelope != 0 {
+				expected &^= FeatureContext
+			}
+			features, inner := Feature(0), eRes.Ciphertext
+			// without feature, no header is written so that older providers can read it
+			if expected != 0 {
+				if features, inner, err = parseFeaturesHeader(eRes.Ciphertext); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if features != expected {
+				t.Fatalf("expected features %q, got %q", expected, features)
+			}
+			if got := kmsplugin.KMSStorageVersion(inner[:1]); got != tt.storageVersion {
+				t.Fatalf("expected storage version %s, got %s", tt.storageVersion, got)
+			}
+			_, hasContext := eRes.Annotations[RequestEncryptionContextAnnotation]
+			if hasContext != (features&FeatureContext != 0) {
+				t.Fatalf("expected the request encryption context only with the context feature, got %v", eRes.Annotations)
+			}
+
+			readers := map[string]*V2Plugin{"writer": p, "reader": reader}
+			if expected == 0 {
+				readers = map[string]*V2Plugin{"writer": p, "legacy": legacy}
+			}
+			for name, d := range readers {
+				ciphertext := append([]byte(nil), eRes.Ciphertext...)
+				request := &pb.DecryptRequest{Ciphertext: ciphertext, KeyId: eRes.KeyId, Annotations: eRes.Annotations}
+				dRes, err := d.Decrypt(context.Background(), request)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if string(request.Ciphertext) != string(eRes.Ciphertext) {
+					t.Fatalf("%s: expected the ciphertext of the request to be left untouched, got %q", name, request.Ciphertext)
+				}
+				if string(dRes.Plaintext) != plainMessage {
+					t.Fatalf("%s: expected %q, got %q", name, plainMessage, dRes.Plaintext)
+				}
+			}
+		})
+	}
+
+	// the headers with features of a newer provider are rejected
+	if _, err := reader.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte("3\x801" + plainMessage)}); err == nil {
+		t.Fatal("expected an error for an unknown feature")
+	}
+	for _, malformed := range []string{"3", "3\x00", "3\x0031"} {
+		if _, err := reader.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte(malformed)}); err == nil {
+			t.Fatalf("expected an error for the malformed ciphertext %q", malformed)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import "sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+
+// HealthState is the KMS health tracked by a SharedHealthCheck, from the results of the
+// health checks and KMS requests it records. Each result moves it to a state:
+//
+//	result                                  from                             to
+//	success                                 FailedUserInduced, FailedInfra   Recovering
+//	success                                 any other                        Healthy
+//	throttled, policy propagation           any                              Degraded
+//	user-induced, partition mismatch        any                              FailedUserInduced
+//	any other error                         any                              FailedInfra
+//
+// Health checks pass in Healthy and Recovering, and in Degraded while throttling is
+// tolerated (see SharedHealthCheck.SetThrottleTolerance). Liveness checks only fail in
+// FailedInfra, restarting the plugin cannot fix the other states. Recovery probes run
+// in FailedUserInduced (see SharedHealthCheck.SetRecoveryProbes). With a failure threshold,
+// the errors leave Healthy and Recovering only once it is reached (see
+// SharedHealthCheck.SetFailureThreshold).
+type HealthState int
+
+const (
+	// HealthStateHealthy is the state before the first result and after successes
+	HealthStateHealthy = HealthState(iota)
+	// HealthStateDegraded means KMS is reachable but throttling or still propagating a key policy
+	HealthStateDegraded
+	// HealthStateFailedUserInduced means the key cannot be used until the user fixes it, e.g. it was disabled
+	HealthStateFailedUserInduced
+	// HealthStateFailedInfra means KMS is unavailable or failing
+	HealthStateFailedInfra
+	// HealthStateRecovering is the state after the first success following a failure
+	HealthStateRecovering
+)
+
+var healthStates = []HealthState{
+	HealthStateHealthy,
+	HealthStateDegraded,
+	HealthStateFailedUserInduced,
+	HealthStateFailedInfra,
+	HealthStateRecovering,
+}
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthStateHealthy:
+		return "healthy"
+	case HealthStateDegraded:
+		return "degraded"
+	case HealthStateFailedUserInduced:
+		return "failed-user-induced"
+	case HealthStateFailedInfra:
+		return "failed-infra"
+	case HealthStateRecovering:
+		return "recovering"
+	default:
+		return ""
+	}
+}
+
+// resultHealthState returns the state a result leads to, regardless of the current state
+func resultHealthState(err error) HealthState {
+	switch kmsplugin.ParseError(err) {
+	case kmsplugin.KMSErrorTypeNil:
+		return HealthStateHealthy
+	case kmsplugin.KMSErrorTypeThrottled, kmsplugin.KMSErrorTypePolicyPropagation:
+		return HealthStateDegraded
+	case kmsplugin.KMSErrorTypeUserInduced, kmsplugin.KMSErrorTypePartitionMismatch:
+		return HealthStateFailedUserInduced
+	default:
+		return HealthStateFailedInfra
+	}
+}
+
+// next returns the state after recording the result err in state s
+func (s HealthState) next(err error) HealthState {
+	next := resultHealthState(err)
+	if next == HealthStateHealthy && (s == HealthStateFailedUserInduced || s == HealthStateFailedInfra) {
+		return HealthStateRecovering
+	}
+	return next
+}
+
+// passing returns true if the health checks pass in state s, regardless of the tolerances
+func (s HealthState) passing() bool {
+	return s == HealthStateHealthy || s == HealthStateRecovering
+}
+
+// isLive returns false if err fails the liveness checks, see HealthState
+func isLive(err error) bool {
+	return resultHealthState(err) != HealthStateFailedInfra
+}
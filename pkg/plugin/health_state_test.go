@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/smithy-go"
+	pbv1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestHealthStateTransitions(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "test"}
+	disabled := &kmstypes.DisabledException{Message: aws.String("test")}
+	internal := &kmstypes.KMSInternalException{Message: aws.String("test")}
+
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	if s := h.HealthState(); s != HealthStateHealthy {
+		t.Fatalf("expected initial state %s, got %s", HealthStateHealthy, s)
+	}
+	for _, tc := range []struct {
+		err      error
+		expected HealthState
+	}{
+		{err: nil, expected: HealthStateHealthy},
+		{err: throttled, expected: HealthStateDegraded},
+		{err: nil, expected: HealthStateHealthy},
+		{err: disabled, expected: HealthStateFailedUserInduced},
+		{err: throttled, expected: HealthStateDegraded},
+		{err: internal, expected: HealthStateFailedInfra},
+		{err: nil, expected: HealthStateRecovering},
+		{err: nil, expected: HealthStateHealthy},
+		{err: disabled, expected: HealthStateFailedUserInduced},
+		{err: nil, expected: HealthStateRecovering},
+		{err: errors.New("fail"), expected: HealthStateFailedInfra},
+	} {
+		from := h.HealthState()
+		h.recordErr(tc.err)
+		if s := h.HealthState(); s != tc.expected {
+			t.Fatalf("expected %s after %v in %s, got %s", tc.expected, tc.err, from, s)
+		}
+	}
+}
+
+func TestHealthStateSince(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "test"}
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	h.recordErr(throttled)
+	since := h.health.Load().stateTs
+	time.Sleep(time.Millisecond)
+	// staying in a state keeps the time it was entered
+	h.recordErr(throttled)
+	if !h.health.Load().stateTs.Equal(since) {
+		t.Fatalf("expected the degraded state to be entered at %v, got %v", since, h.health.Load().stateTs)
+	}
+}
+
+func TestIsLive(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		live bool
+	}{
+		{err: nil, live: true},
+		{err: &smithy.GenericAPIError{Code: "ThrottlingException", Message: "test"}, live: true},
+		{err: &kmstypes.DisabledException{Message: aws.String("test")}, live: true},
+		{err: &kmstypes.KMSInternalException{Message: aws.String("test")}, live: false},
+	} {
+		if live := isLive(tc.err); live != tc.live {
+			t.Fatalf("expected live=%v for %v, got %v", tc.live, tc.err, live)
+		}
+	}
+}
+
+func TestProbeErrorTypes(t *testing.T) {
+	denied := &smithy.OperationError{ServiceID: "KMS", OperationName: "Encrypt", Err: &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "test"}}
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("", denied)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.SetProbeErrorTypes(map[string]kmsplugin.KMSErrorType{"AccessDeniedException": kmsplugin.KMSErrorTypeUserInduced})
+	p := NewV2("test-key-probe-error-types", c, nil, sharedHealthCheck)
+
+	if got := kmsplugin.ParseError(p.Probe()); got != kmsplugin.KMSErrorTypeUserInduced {
+		t.Fatalf("expected the probe error to be %s, got %s", kmsplugin.KMSErrorTypeUserInduced, got)
+	}
+	if err := p.Live(); err != nil {
+		t.Fatalf("expected the reclassified probe error not to fail liveness, got %v", err)
+	}
+	_, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if got := kmsplugin.ParseError(err); got != kmsplugin.KMSErrorTypeOther {
+		t.Fatalf("expected the request error to keep its type %s, got %s", kmsplugin.KMSErrorTypeOther, got)
+	}
+}
+
+func TestDryRunProbes(t *testing.T) {
+	denied := &smithy.OperationError{ServiceID: "KMS", OperationName: "Encrypt", Err: &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "test"}}
+	c := &cloud.KMSMock{}
+	c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+		return aws.ToBool(params.DryRun) && aws.ToString(params.KeyId) == "test-key-dry-run"
+	}, "", &kmstypes.DryRunOperationException{Message: aws.String("test")})
+	c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+		return aws.ToBool(params.DryRun)
+	}, "", denied)
+	c.SetEncryptResp("ciphertext", nil)
+	// the dry run probes never decrypt
+	c.SetDecryptResp("", denied)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.SetDryRunProbes(true)
+
+	if err := NewV2("test-key-dry-run", c, nil, sharedHealthCheck).Probe(); err != nil {
+		t.Fatalf("expected the dry run probe to pass, got %v", err)
+	}
+	if err := New("test-key-dry-run", c, nil, sharedHealthCheck).Probe(); err != nil {
+		t.Fatalf("expected the dry run probe to pass, got %v", err)
+	}
+	if err := NewV2("test-key-dry-run-denied", c, nil, sharedHealthCheck).Probe(); kmsplugin.ParseError(err) != kmsplugin.KMSErrorTypeOther {
+		t.Fatalf("expected the denied dry run probe to fail, got %v", err)
+	}
+
+	// the requests are not dry runs
+	p := New("test-key-dry-run", c, nil, sharedHealthCheck)
+	//nolint:staticcheck
+	eRes, err := p.Encrypt(context.Background(), &pbv1.EncryptRequest{Plain: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	//nolint:staticcheck
+	if string(eRes.Cipher[1:]) != "ciphertext" {
+		t.Fatalf("expected the KMS ciphertext, got %q", eRes.Cipher)
+	}
+}
+
+type describeKeyMock struct {
+	*cloud.KMSMock
+	state kmstypes.KeyState
+	err   error
+}
+
+func (m *describeKeyMock) DescribeKey(_ context.Context, params *kms.DescribeKeyInput, _ ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kmstypes.KeyMetadata{KeyId: params.KeyId, KeyState: m.state}}, nil
+}
+
+func TestDescribeKeyProbes(t *testing.T) {
+	denied := &smithy.OperationError{ServiceID: "KMS", OperationName: "Encrypt", Err: &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "test"}}
+	c := &describeKeyMock{KMSMock: &cloud.KMSMock{}, state: kmstypes.KeyStateEnabled}
+	// the describe key probes never encrypt nor decrypt
+	c.SetEncryptResp("", denied)
+	c.SetDecryptResp("", denied)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.SetDescribeKeyProbes(true)
+	p := NewV2("test-key-describe-key", c, nil, sharedHealthCheck)
+	p1 := New("test-key-describe-key", c, nil, sharedHealthCheck)
+
+	if err := p.Probe(); err != nil {
+		t.Fatalf("expected the probe to pass, got %v", err)
+	}
+	if err := p1.Probe(); err != nil {
+		t.Fatalf("expected the probe to pass, got %v", err)
+	}
+
+	c.state = kmstypes.KeyStateDisabled
+	if got := kmsplugin.ParseError(p.Probe()); got != kmsplugin.KMSErrorTypeUserInduced {
+		t.Fatalf("expected a disabled key to fail the probe as %s, got %s", kmsplugin.KMSErrorTypeUserInduced, got)
+	}
+
+	c.err = &kmstypes.KMSInternalException{Message: aws.String("test")}
+	if got := kmsplugin.ParseError(p1.Probe()); got != kmsplugin.KMSErrorTypeOther {
+		t.Fatalf("expected the DescribeKey error to fail the probe as %s, got %s", kmsplugin.KMSErrorTypeOther, got)
+	}
+}
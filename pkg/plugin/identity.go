@@ -0,0 +1,127 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+)
+
+// Annotation keys of the provider identity assertion, see WithIdentityAssertion.
+// KMSv2 requires annotation keys to be fully qualified domain names.
+const (
+	IdentityKeyARNAnnotation        = "key-arn.aws-encryption-provider.sigs.k8s.io"
+	IdentityFormatVersionAnnotation = "format-version.aws-encryption-provider.sigs.k8s.io"
+	IdentitySignatureAnnotation     = "signature.aws-encryption-provider.sigs.k8s.io"
+)
+
+const identityInfo = "aws-encryption-provider kms v2 identity"
+
+var errIdentityMismatch = errors.New("provider identity assertion mismatch")
+
+// WithIdentityAssertion annotates the EncryptResponse with the key ARN and the ciphertext
+// format version, signed with an HMAC derived from the key ARN, and verifies the annotations
+// on Decrypt before calling KMS. This detects ciphertexts written by another provider, e.g.
+// of another cluster after a misconfiguration, or annotations not matching their ciphertext.
+//
+// The HMAC key is derived from the key ARN only, so the assertion protects against
+// misconfigurations, not against an attacker able to write to etcd.
+//
+// Ciphertexts without annotations, e.g. written before enabling the assertion, are decrypted
+// as before. acceptedKeyIDs lists the keys besides the plugin key that ciphertexts may have
+// been written with, e.g. the previous key after an in-place key change. The keys of
+// WithDecryptOnlyKeys are also accepted.
+func WithIdentityAssertion(acceptedKeyIDs ...string) V2Option {
+	return func(p *V2Plugin) {
+		accepted := map[string]struct{}{p.keyID: {}}
+		for _, keyID := range acceptedKeyIDs {
+			accepted[keyID] = struct{}{}
+		}
+		p.identityAssertion = &identityAssertion{acceptedKeyIDs: accepted}
+	}
+}
+
+type identityAssertion struct {
+	acceptedKeyIDs map[string]struct{}
+}
+
+// identitySignature returns the HMAC of the annotated fields, keyed with a key derived from the key ARN
+func identitySignature(keyARN string, formatVersion, ciphertext []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, []byte(keyARN), nil, identityInfo, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyARN))
+	mac.Write([]byte{0})
+	mac.Write(formatVersion)
+	mac.Write([]byte{0})
+	mac.Write(ciphertext)
+	return mac.Sum(nil), nil
+}
+
+// annotateIdentity adds the signed identity annotations to the response, if enabled
+func (p *V2Plugin) annotateIdentity(resp *pb.EncryptResponse) error {
+	if p.identityAssertion == nil || len(resp.Ciphertext) == 0 {
+		return nil
+	}
+	formatVersion := resp.Ciphertext[:1]
+	signature, err := identitySignature(p.keyID, formatVersion, resp.Ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to sign identity assertion %w", err)
+	}
+	if resp.Annotations == nil {
+		resp.Annotations = make(map[string][]byte)
+	}
+	resp.Annotations[IdentityKeyARNAnnotation] = []byte(p.keyID)
+	resp.Annotations[IdentityFormatVersionAnnotation] = formatVersion
+	resp.Annotations[IdentitySignatureAnnotation] = signature
+	return nil
+}
+
+// verifyIdentity verifies the identity annotations of the request, if enabled and present
+func (p *V2Plugin) verifyIdentity(request *pb.DecryptRequest) error {
+	if p.identityAssertion == nil {
+		return nil
+	}
+	signature, ok := request.Annotations[IdentitySignatureAnnotation]
+	if !ok {
+		zap.L().Debug("no identity assertion to verify")
+		return nil
+	}
+	keyARN := string(request.Annotations[IdentityKeyARNAnnotation])
+	formatVersion := request.Annotations[IdentityFormatVersionAnnotation]
+	if _, ok := p.identityAssertion.acceptedKeyIDs[keyARN]; !ok && !p.isDecryptOnlyKey(keyARN) {
+		zap.L().Error("ciphertext written by a provider of another key", zap.String("key", p.keyID), zap.String("ciphertext-key", keyARN))
+		return fmt.Errorf("%w: ciphertext written with key %q, expected %q", errIdentityMismatch, keyARN, p.keyID)
+	}
+	if len(request.Ciphertext) == 0 || string(formatVersion) != string(request.Ciphertext[:1]) {
+		return fmt.Errorf("%w: format version %q does not match the ciphertext", errIdentityMismatch, formatVersion)
+	}
+	expected, err := identitySignature(keyARN, formatVersion, request.Ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to verify identity assertion %w", err)
+	}
+	if !hmac.Equal(signature, expected) {
+		zap.L().Error("invalid identity assertion signature", zap.String("key", p.keyID))
+		return fmt.Errorf("%w: invalid signature", errIdentityMismatch)
+	}
+	return nil
+}
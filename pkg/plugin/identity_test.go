@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestIdentityAssertion(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp(plainMessage, nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	ctx := context.Background()
+
+	p := NewV2(key, c, nil, sharedHealthCheck, WithIdentityAssertion("previous-key"))
+	eRes, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	if got := string(eRes.Annotations[IdentityKeyARNAnnotation]); got != key {
+		t.Fatalf("expected key annotation %q, got %q", key, got)
+	}
+	if got := string(eRes.Annotations[IdentityFormatVersionAnnotation]); got != "1" {
+		t.Fatalf("expected format version annotation %q, got %q", "1", got)
+	}
+
+	decrypt := func(p *V2Plugin, ciphertext []byte, annotations map[string][]byte) error {
+		_, err := p.Decrypt(ctx, &pb.DecryptRequest{
+			Ciphertext:  append([]byte(nil), ciphertext...),
+			KeyId:       eRes.KeyId,
+			Annotations: annotations,
+		})
+		return err
+	}
+	if err := decrypt(p, eRes.Ciphertext, eRes.Annotations); err != nil {
+		t.Fatalf("unexpected error from Decrypt %v", err)
+	}
+	// ciphertexts written before enabling the assertion
+	if err := decrypt(p, eRes.Ciphertext, nil); err != nil {
+		t.Fatalf("unexpected error from Decrypt without annotations %v", err)
+	}
+
+	// written with the previous key
+	previous := NewV2("previous-key", c, nil, sharedHealthCheck, WithIdentityAssertion())
+	pRes, err := previous.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	if err := decrypt(p, pRes.Ciphertext, pRes.Annotations); err != nil {
+		t.Fatalf("unexpected error from Decrypt of previous key %v", err)
+	}
+
+	tampered := maps.Clone(eRes.Annotations)
+	tampered[IdentitySignatureAnnotation] = []byte("bad")
+	other := NewV2("other-key", c, nil, sharedHealthCheck, WithIdentityAssertion())
+	for name, tc := range map[string]struct {
+		p           *V2Plugin
+		ciphertext  []byte
+		annotations map[string][]byte
+	}{
+		"other provider":     {p: other, ciphertext: eRes.Ciphertext, annotations: eRes.Annotations},
+		"swapped ciphertext": {p: p, ciphertext: []byte("1bar"), annotations: eRes.Annotations},
+		"bad signature":      {p: p, ciphertext: eRes.Ciphertext, annotations: tampered},
+	} {
+		if err := decrypt(tc.p, tc.ciphertext, tc.annotations); !errors.Is(err, errIdentityMismatch) {
+			t.Fatalf("%s: expected identity mismatch, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/membudget"
+)
+
+// DefaultKEKRotationPeriod is how long a KEK is used before a new one is generated
+const DefaultKEKRotationPeriod = 24 * time.Hour
+
+const (
+	dekSize      = 32
+	dekSaltSize  = 32
+	dekNonceSize = 12
+	dekTagSize   = 16
+	dekInfo      = "aws-encryption-provider kms v2 dek"
+)
+
+var errMalformedKeyHierarchyCiphertext = errors.New("malformed key hierarchy ciphertext")
+
+// WithKeyHierarchy enables the key hierarchy mode, following the upstream KMSv2 design.
+//
+// A key encryption key (KEK) is generated with KMS "GenerateDataKey" and cached locally.
+// Every encryption derives a fresh data encryption key (DEK) from the KEK with HKDF and
+// a random salt, so KMS is only called when the KEK rotates (every rotationPeriod) or
+// when an unknown KEK has to be decrypted. The header, salt, nonce and sealed data are
+// written to a single buffer, so an encryption only allocates for the DEK cipher.
+//
+// The ciphertext layout is:
+//
+//	version (1) | KEK ciphertext length (2) | KEK ciphertext | salt (32) | nonce (12) | AES-GCM sealed data
+//
+// Everything before the nonce is authenticated as additional data.
+func WithKeyHierarchy(rotationPeriod time.Duration) V2Option {
+	return func(p *V2Plugin) {
+		p.keyHierarchy = &keyHierarchy{
+			rotationPeriod: rotationPeriod,
+		}
+	}
+}
+
+type keyHierarchy struct {
+	rotationPeriod time.Duration
+
+	mu      sync.Mutex
+	current *kek
+
+	// plaintext KEKs keyed by their KMS ciphertext
+	keks *membudget.Cache
+}
+
+type kek struct {
+	plaintext  []byte
+	ciphertext []byte
+	createdAt  time.Time
+}
+
+func (p *V2Plugin) encryptWithKeyHierarchy(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	zap.L().Debug("starting key hierarchy encrypt operation")
+
+	k, err := p.currentKEK(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %w", err)
+	}
+	defer observePhase(ctx, phaseCrypto)()
+
+	// the header, salt, nonce and sealed data share one buffer, the header being authenticated
+	saltStart := 1 + 2 + len(k.ciphertext)
+	headerLen := saltStart + dekSaltSize
+	ciphertext := make([]byte, 0, headerLen+dekNonceSize+len(request.Plaintext)+dekTagSize)
+	ciphertext = append(ciphertext, kmsplugin.KMSStorageVersionV2KeyHierarchy...)
+	ciphertext = binary.BigEndian.AppendUint16(ciphertext, uint16(len(k.ciphertext)))
+	ciphertext = append(ciphertext, k.ciphertext...)
+	ciphertext = ciphertext[:headerLen+dekNonceSize]
+	salt, nonce := ciphertext[saltStart:headerLen], ciphertext[headerLen:]
+	if _, err := rand.Read(ciphertext[saltStart:]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt and nonce %w", err)
+	}
+	aead, err := newDEKCipher(k.plaintext, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt %w", err)
+	}
+	ciphertext = aead.Seal(ciphertext, nonce, request.Plaintext, ciphertext[:headerLen])
+
+	zap.L().Debug("key hierarchy encrypt operation successful")
+	return &pb.EncryptResponse{
+		Ciphertext: ciphertext,
+		KeyId:      p.reportedKeyID(),
+	}, nil
+}
+
+func (p *V2Plugin) decryptWithKeyHierarchy(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	zap.L().Debug("starting key hierarchy decrypt operation")
+
+	ciphertext := request.Ciphertext
+	if len(ciphertext) < 3 {
+		return nil, errMalformedKeyHierarchyCiphertext
+	}
+	kekLen := int(binary.BigEndian.Uint16(ciphertext[1:3]))
+	headerLen := 3 + kekLen + dekSaltSize
+	if len(ciphertext) < headerLen+dekNonceSize {
+		return nil, errMalformedKeyHierarchyCiphertext
+	}
+	header := ciphertext[:headerLen]
+	encryptedKEK := header[3 : 3+kekLen]
+	salt := header[3+kekLen:]
+	nonce := ciphertext[headerLen : headerLen+dekNonceSize]
+
+	plainKEK, err := p.decryptKEK(ctx, encryptedKEK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %w", err)
+	}
+	defer observePhase(ctx, phaseCrypto)()
+	aead, err := newDEKCipher(plainKEK, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %w", err)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext[headerLen+dekNonceSize:], header)
+	if err != nil {
+		zap.L().Error("request to decrypt failed", zap.String("error-type", kmsplugin.KMSErrorTypeCorruption.String()), zap.Error(err))
+		return nil, fmt.Errorf("failed to decrypt %w", err)
+	}
+
+	zap.L().Debug("key hierarchy decrypt operation successful")
+	return &pb.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+// currentKEK returns the cached KEK, generating a new one via KMS
+// if there is none yet or the current one is due for rotation.
+func (p *V2Plugin) currentKEK(ctx context.Context) (*kek, error) {
+	kh := p.keyHierarchy
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+
+	if kh.current != nil && time.Since(kh.current.createdAt) < kh.rotationPeriod {
+		return kh.current, nil
+	}
+
+	zap.L().Info("rotating key encryption key", zap.String("key", p.keyID))
+	startTime := time.Now()
+	input := &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyID),
+		KeySpec: kmstypes.DataKeySpecAes256,
+	}
+	if len(p.encryptionCtx) > 0 {
+		input.EncryptionContext = p.encryptionCtx
+	}
+
+	kmsDone := observePhase(ctx, phaseKMS)
+	result, err := p.svc.GenerateDataKey(ctx, input)
+	kmsDone()
+	if err != nil {
+		if p.partitionErr != nil {
+			err = p.partitionErr.WithCause(err)
+		}
+		p.healthCheck.report(err)
+		errorType := kmsplugin.ParseError(err).String()
+		zap.L().Error("request to generate data key failed", zap.String("error-type", errorType), zap.Error(err))
+		failLabel := kmsplugin.GetStatusLabel(err, errorType)
+		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationGenerateDataKey, GRPC_V2).ObserveSince(startTime)
+		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationGenerateDataKey, GRPC_V2).Inc()
+		kmsFailureCounter.WithLabelValues(p.keyID, kmsplugin.OperationGenerateDataKey, GRPC_V2, errorType).Inc()
+		return nil, err
+	}
+	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationGenerateDataKey, GRPC_V2).ObserveSince(startTime)
+	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationGenerateDataKey, GRPC_V2).Inc()
+
+	if len(result.Plaintext) != dekSize {
+		return nil, fmt.Errorf("unexpected data key size %d", len(result.Plaintext))
+	}
+	kh.current = &kek{
+		plaintext:  result.Plaintext,
+		ciphertext: result.CiphertextBlob,
+		createdAt:  time.Now(),
+	}
+	kh.keks.Add(string(result.CiphertextBlob), result.Plaintext)
+	return kh.current, nil
+}
+
+// expireCurrent makes the next encryption generate a new KEK, e.g. once the key changed.
+// The key hierarchy is nil when the mode is turned off.
+func (kh *keyHierarchy) expireCurrent() {
+	if kh == nil {
+		return
+	}
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+	kh.current = nil
+}
+
+// decryptKEK returns the plaintext of the given KEK,
+// only calling KMS if it is not cached yet.
+// Ciphertexts of the key hierarchy mode stay decryptable after the mode
+// was turned off, in which case nothing is cached.
+func (p *V2Plugin) decryptKEK(ctx context.Context, encryptedKEK []byte) ([]byte, error) {
+	kh := p.keyHierarchy
+	if kh != nil {
+		if plainKEK, ok := kh.keks.Get(string(encryptedKEK)); ok {
+			return plainKEK, nil
+		}
+	}
+
+	resp, err := p.decryptKMS(ctx, &pb.DecryptRequest{
+		Ciphertext: append([]byte(kmsplugin.KMSStorageVersionV2), encryptedKEK...),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if kh != nil {
+		kh.keks.Add(string(encryptedKEK), resp.Plaintext)
+	}
+	return resp.Plaintext, nil
+}
+
+func newDEKCipher(kek, salt []byte) (cipher.AEAD, error) {
+	dek, err := hkdf.Key(sha256.New, kek, salt, dekInfo, dekSize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pbv1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/membudget"
+)
+
+var testKEK = strings.Repeat("k", dekSize)
+
+type countingKMSMock struct {
+	*cloud.KMSMock
+	generateCalls atomic.Int32
+	decryptCalls  atomic.Int32
+}
+
+func (m *countingKMSMock) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	m.generateCalls.Add(1)
+	return m.KMSMock.GenerateDataKey(ctx, params, optFns...)
+}
+
+func (m *countingKMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	m.decryptCalls.Add(1)
+	return m.KMSMock.Decrypt(ctx, params, optFns...)
+}
+
+func TestKeyHierarchy(t *testing.T) {
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	c.SetDecryptResp(testKEK, nil)
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2(key, c, nil, sharedHealthCheck, WithKeyHierarchy(DefaultKEKRotationPeriod))
+
+	var ciphertexts [][]byte
+	for i := 0; i < 3; i++ {
+		eRes, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+		if err != nil {
+			t.Fatalf("#%d: unexpected error from Encrypt %v", i, err)
+		}
+		if kmsplugin.KMSStorageVersion(eRes.Ciphertext[0]) != kmsplugin.KMSStorageVersionV2KeyHierarchy {
+			t.Fatalf("#%d: unexpected storage version %q", i, eRes.Ciphertext[0])
+		}
+		if eRes.KeyId != key {
+			t.Fatalf("#%d: expected key id %s, got %s", i, key, eRes.KeyId)
+		}
+		ciphertexts = append(ciphertexts, eRes.Ciphertext)
+	}
+	if string(ciphertexts[0]) == string(ciphertexts[1]) {
+		t.Fatal("expected distinct ciphertexts for the same plaintext")
+	}
+	if n := c.generateCalls.Load(); n != 1 {
+		t.Fatalf("expected 1 GenerateDataKey call, got %d", n)
+	}
+
+	for i, ciphertext := range ciphertexts {
+		dRes, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: ciphertext})
+		if err != nil {
+			t.Fatalf("#%d: unexpected error from Decrypt %v", i, err)
+		}
+		if string(dRes.Plaintext) != plainMessage {
+			t.Fatalf("#%d: expected %s, got %s", i, plainMessage, string(dRes.Plaintext))
+		}
+	}
+	if n := c.decryptCalls.Load(); n != 0 {
+		t.Fatalf("expected cached KEK to be used, got %d Decrypt calls", n)
+	}
+
+	// a restarted plugin has to decrypt the KEK once
+	restarted := NewV2(key, c, nil, sharedHealthCheck, WithKeyHierarchy(DefaultKEKRotationPeriod))
+	for i, ciphertext := range ciphertexts {
+		dRes, err := restarted.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: ciphertext})
+		if err != nil {
+			t.Fatalf("#%d: unexpected error from Decrypt %v", i, err)
+		}
+		if string(dRes.Plaintext) != plainMessage {
+			t.Fatalf("#%d: expected %s, got %s", i, plainMessage, string(dRes.Plaintext))
+		}
+	}
+	if n := c.decryptCalls.Load(); n != 1 {
+		t.Fatalf("expected 1 Decrypt call, got %d", n)
+	}
+
+	// the ciphertexts stay decryptable with the mode turned off
+	disabled := NewV2(key, c, nil, sharedHealthCheck)
+	dRes, err := disabled.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: ciphertexts[0]})
+	if err != nil {
+		t.Fatalf("unexpected error from Decrypt %v", err)
+	}
+	if string(dRes.Plaintext) != plainMessage {
+		t.Fatalf("expected %s, got %s", plainMessage, string(dRes.Plaintext))
+	}
+}
+
+func TestKeyHierarchyRotation(t *testing.T) {
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2(key, c, nil, sharedHealthCheck, WithKeyHierarchy(time.Nanosecond))
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond)
+		if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); err != nil {
+			t.Fatalf("#%d: unexpected error from Encrypt %v", i, err)
+		}
+	}
+	if n := c.generateCalls.Load(); n != 2 {
+		t.Fatalf("expected 2 GenerateDataKey calls, got %d", n)
+	}
+
+	c.SetDefaultGenerateDataKeyResp("", "", errors.New("generate fail"))
+	time.Sleep(time.Millisecond)
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); err == nil {
+		t.Fatal("expected error from Encrypt when the KEK cannot be rotated")
+	}
+}
+
+func TestKeyHierarchyTampered(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2(key, c, nil, sharedHealthCheck, WithKeyHierarchy(DefaultKEKRotationPeriod))
+
+	eRes, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+
+	tampered := append([]byte{}, eRes.Ciphertext...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: tampered}); err == nil {
+		t.Fatal("expected error from Decrypt for tampered ciphertext")
+	}
+
+	truncated := eRes.Ciphertext[:10]
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: truncated}); err == nil {
+		t.Fatal("expected error from Decrypt for truncated ciphertext")
+	}
+}
+
+func TestKeyHierarchyFreshDEKs(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	c.SetDecryptResp(testKEK, nil)
+	p := NewV2(key, c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize), WithKeyHierarchy(DefaultKEKRotationPeriod))
+
+	headerLen := 3 + len("encrypted-kek") + dekSaltSize
+	salts := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		eRes, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+		if err != nil {
+			t.Fatalf("#%d: unexpected error from Encrypt %v", i, err)
+		}
+		// the ciphertext is sealed in place, in a buffer of the exact size
+		if len(eRes.Ciphertext) != cap(eRes.Ciphertext) || len(eRes.Ciphertext) != headerLen+dekNonceSize+len(plainMessage)+dekTagSize {
+			t.Fatalf("#%d: unexpected ciphertext length %d and capacity %d", i, len(eRes.Ciphertext), cap(eRes.Ciphertext))
+		}
+		salt := string(eRes.Ciphertext[headerLen-dekSaltSize : headerLen])
+		if salts[salt] {
+			t.Fatalf("#%d: expected a fresh DEK for every encryption", i)
+		}
+		salts[salt] = true
+		dRes, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: eRes.Ciphertext})
+		if err != nil || string(dRes.Plaintext) != plainMessage {
+			t.Fatalf("#%d: expected %s, got %v, %v", i, plainMessage, dRes, err)
+		}
+	}
+}
+
+func BenchmarkKeyHierarchyEncrypt(b *testing.B) {
+	c := &cloud.KMSMock{}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	p := NewV2(key, c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize), WithKeyHierarchy(DefaultKEKRotationPeriod))
+	request := &pb.EncryptRequest{Plaintext: make([]byte, 1024)}
+
+	b.ReportAllocs()
+	b.RunParallel(func(bp *testing.PB) {
+		for bp.Next() {
+			if _, err := p.Encrypt(context.Background(), request); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkKeyHierarchyDecrypt(b *testing.B) {
+	c := &cloud.KMSMock{}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	c.SetDecryptResp(testKEK, nil)
+	p := NewV2(key, c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize), WithKeyHierarchy(DefaultKEKRotationPeriod))
+	eRes, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: make([]byte, 1024)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	request := &pb.DecryptRequest{Ciphertext: eRes.Ciphertext}
+
+	b.ReportAllocs()
+	b.RunParallel(func(bp *testing.PB) {
+		for bp.Next() {
+			if _, err := p.Decrypt(context.Background(), request); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestKeyHierarchyMemoryBudget(t *testing.T) {
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	c.SetDecryptResp(testKEK, nil)
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := NewV2(key, c, nil, sharedHealthCheck, WithKeyHierarchy(DefaultKEKRotationPeriod), WithMemoryBudget(membudget.New(1024)))
+
+	eRes, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	// an in-flight request larger than the budget is rejected
+	_, err = p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: make([]byte, 2048)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted error, got %v", err)
+	}
+	// requests within budget evict the cached KEK, which is decrypted again via KMS
+	if _, err = p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: make([]byte, 960)}); err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	if _, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: eRes.Ciphertext}); err != nil {
+		t.Fatalf("unexpected error from Decrypt %v", err)
+	}
+	if n := c.decryptCalls.Load(); n != 1 {
+		t.Fatalf("expected evicted KEK to be decrypted once, got %d Decrypt calls", n)
+	}
+}
+
+func TestV1KeyHierarchy(t *testing.T) {
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetDefaultGenerateDataKeyResp(testKEK, "encrypted-kek", nil)
+	c.SetDecryptResp(testKEK, nil)
+	c.SetEncryptResp("", errors.New("KMS Encrypt must not be called"))
+
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := New(key, c, nil, sharedHealthCheck, WithV1KeyHierarchy(DefaultKEKRotationPeriod))
+
+	var ciphertexts [][]byte
+	for range 2 {
+		//nolint:staticcheck
+		resp, err := p.Encrypt(context.Background(), &pbv1.EncryptRequest{Plain: []byte(plainMessage)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Cipher[:1]) != string(kmsplugin.KMSStorageVersionV2KeyHierarchy) {
+			t.Fatalf("expected key hierarchy storage version, got %q", resp.Cipher[:1])
+		}
+		ciphertexts = append(ciphertexts, resp.Cipher)
+	}
+	if n := c.generateCalls.Load(); n != 1 {
+		t.Fatalf("expected 1 GenerateDataKey call, got %d", n)
+	}
+
+	//nolint:staticcheck
+	resp, err := p.Decrypt(context.Background(), &pbv1.DecryptRequest{Cipher: ciphertexts[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Plain) != plainMessage {
+		t.Fatalf("expected %q, got %q", plainMessage, resp.Plain)
+	}
+	if n := c.decryptCalls.Load(); n != 0 {
+		t.Fatalf("expected the cached KEK to be used, got %d KMS Decrypt calls", n)
+	}
+
+	// the mode turned off and the KMSv2 plugin still decrypt the ciphertexts
+	off := New(key, c, nil, sharedHealthCheck)
+	decrypter := off.keyHierarchyDecrypter
+	for _, ciphertext := range ciphertexts {
+		//nolint:staticcheck
+		resp, err = off.Decrypt(context.Background(), &pbv1.DecryptRequest{Cipher: ciphertext})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Plain) != plainMessage {
+			t.Fatalf("expected %q, got %q", plainMessage, resp.Plain)
+		}
+	}
+	if decrypter == nil || off.keyHierarchyDecrypter != decrypter || p.keyHierarchyDecrypter != p.keyHierarchy {
+		t.Fatal("expected the key hierarchy decrypter to be built once per plugin")
+	}
+	v2Resp, err := NewV2(key, c, nil, sharedHealthCheck).Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: ciphertexts[1], KeyId: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v2Resp.Plaintext) != plainMessage {
+		t.Fatalf("expected %q, got %q", plainMessage, v2Resp.Plaintext)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"time"
+
+	pb "k8s.io/kms/apis/v1beta1"
+	pbv2 "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// V1Option configures optional behavior of a V1Plugin
+type V1Option func(*V1Plugin)
+
+// WithV1KeyHierarchy enables the envelope encryption of the KMSv2 key hierarchy mode
+// (see WithKeyHierarchy) for v1 requests: a locally cached KMS data key seals the
+// plaintexts with AES-GCM and its KMS ciphertext is stored in the ciphertext, so KMS is only
+// called when it rotates, every rotationPeriod, and Encrypt keeps working during KMS blips.
+//
+// The ciphertexts share the format of the KMSv2 plugin, so they decrypt with both plugins.
+// The V1Plugin always decrypts them, even with the mode turned off, but providers predating
+// the mode do not.
+func WithV1KeyHierarchy(rotationPeriod time.Duration) V1Option {
+	return func(p *V1Plugin) {
+		p.keyHierarchy = newPluginV2(p.keyID, p.svc, p.encryptionCtx, p.healthCheck, WithKeyHierarchy(rotationPeriod))
+	}
+}
+
+//nolint:staticcheck
+func (p *V1Plugin) encryptWithKeyHierarchy(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	kmsPlaintextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V1).Observe(float64(len(request.Plain)))
+	resp, err := p.keyHierarchy.encryptWithKeyHierarchy(ctx, &pbv2.EncryptRequest{Plaintext: request.Plain})
+	if err != nil {
+		return nil, err
+	}
+	kmsCiphertextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V1).Observe(float64(len(resp.Ciphertext)))
+	//nolint:staticcheck
+	return &pb.EncryptResponse{Cipher: resp.Ciphertext}, nil
+}
+
+// decryptWithKeyHierarchy decrypts a ciphertext of the key hierarchy mode, whether the mode
+// is enabled or not. The KMS requests are accounted to the KMSv2 metrics.
+//
+//nolint:staticcheck
+func (p *V1Plugin) decryptWithKeyHierarchy(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	resp, err := p.keyHierarchyDecrypter.decryptWithKeyHierarchy(ctx, &pbv2.DecryptRequest{Ciphertext: request.Cipher, KeyId: p.keyID})
+	if err != nil {
+		decryptFailures.log(p.keyID, GRPC_V1, request.Cipher, err)
+		return nil, err
+	}
+	kmsPlaintextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V1).Observe(float64(len(resp.Plaintext)))
+	//nolint:staticcheck
+	return &pb.DecryptResponse{Plain: resp.Plaintext}, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	loopDetectionWindow   = 10 * time.Second
+	loopDetectionCapacity = 1024
+
+	// the plaintext to encrypt is a ciphertext returned by a previous Encrypt
+	loopReasonCiphertextReencrypted = "ciphertext-reencrypted"
+	// the same plaintext was encrypted again
+	loopReasonRepeatedPlaintext = "repeated-plaintext"
+)
+
+// loopDetector detects patterns of apiserver re-encryption loops, e.g. when
+// providers are mis-ordered in the EncryptionConfiguration, by remembering the
+// hashes of the recently encrypted plaintexts and returned ciphertexts.
+// The plaintexts of the apiserver are random data keys, so seeing one twice
+// within seconds is suspicious.
+type loopDetector struct {
+	keyID   string
+	version string
+
+	mu          sync.Mutex
+	plaintexts  *recentHashes
+	ciphertexts *recentHashes
+	lastLogged  time.Time
+}
+
+func newLoopDetector(keyID, version string) *loopDetector {
+	return &loopDetector{
+		keyID:       keyID,
+		version:     version,
+		plaintexts:  newRecentHashes(loopDetectionCapacity),
+		ciphertexts: newRecentHashes(loopDetectionCapacity),
+	}
+}
+
+// observe records an encryption, reporting a suspected loop if the plaintext was seen recently
+func (d *loopDetector) observe(plaintext, ciphertext []byte) {
+	now := time.Now()
+	ph, ch := sha256.Sum256(plaintext), sha256.Sum256(ciphertext)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reason := ""
+	switch {
+	case d.ciphertexts.seen(ph, now):
+		reason = loopReasonCiphertextReencrypted
+	case d.plaintexts.seen(ph, now):
+		reason = loopReasonRepeatedPlaintext
+	}
+	d.plaintexts.add(ph, now)
+	d.ciphertexts.add(ch, now)
+	if reason == "" {
+		return
+	}
+
+	kmsReencryptionLoopCounter.WithLabelValues(d.keyID, reason, d.version).Inc()
+	if now.Sub(d.lastLogged) >= loopDetectionWindow {
+		d.lastLogged = now
+		zap.L().Warn("suspected re-encryption loop, check the order of the providers in the EncryptionConfiguration",
+			zap.String("key", d.keyID), zap.String("reason", reason), zap.String("version", d.version))
+	}
+}
+
+// recentHashes is a bounded set of hashes seen within loopDetectionWindow,
+// the oldest hashes are forgotten first once full.
+type recentHashes struct {
+	seenAt map[[sha256.Size]byte]time.Time
+	order  [][sha256.Size]byte
+	next   int
+}
+
+func newRecentHashes(capacity int) *recentHashes {
+	return &recentHashes{
+		seenAt: make(map[[sha256.Size]byte]time.Time, capacity),
+		order:  make([][sha256.Size]byte, 0, capacity),
+	}
+}
+
+func (r *recentHashes) seen(h [sha256.Size]byte, now time.Time) bool {
+	ts, ok := r.seenAt[h]
+	return ok && now.Sub(ts) < loopDetectionWindow
+}
+
+func (r *recentHashes) add(h [sha256.Size]byte, now time.Time) {
+	if _, ok := r.seenAt[h]; ok {
+		r.seenAt[h] = now
+		return
+	}
+	if len(r.order) < cap(r.order) {
+		r.order = append(r.order, h)
+	} else {
+		delete(r.seenAt, r.order[r.next])
+		r.order[r.next] = h
+		r.next = (r.next + 1) % len(r.order)
+	}
+	r.seenAt[h] = now
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoopDetector(t *testing.T) {
+	d := newLoopDetector("test-key-loop", GRPC_V2)
+
+	d.observe([]byte("dek-1"), []byte("1cipher-1"))
+	d.observe([]byte("dek-2"), []byte("1cipher-2"))
+	d.observe([]byte("1cipher-1"), []byte("1cipher-3"))
+	d.observe([]byte("dek-2"), []byte("1cipher-4"))
+
+	d2 := scrapeMetrics(t)
+	for _, expects := range []string{
+		`aws_encryption_provider_kms_reencryption_loop_suspected_total{key_arn="test-key-loop",reason="ciphertext-reencrypted",version="v2"} 1`,
+		`aws_encryption_provider_kms_reencryption_loop_suspected_total{key_arn="test-key-loop",reason="repeated-plaintext",version="v2"} 1`,
+	} {
+		if !strings.Contains(d2, expects) {
+			t.Fatalf("expected %q, got\n\n%s\n\n", expects, d2)
+		}
+	}
+}
+
+func TestRecentHashes(t *testing.T) {
+	r := newRecentHashes(2)
+	now := time.Now()
+	h1, h2, h3 := sha256.Sum256([]byte("1")), sha256.Sum256([]byte("2")), sha256.Sum256([]byte("3"))
+
+	r.add(h1, now)
+	if !r.seen(h1, now) {
+		t.Fatal("expected h1 to be seen")
+	}
+	if r.seen(h1, now.Add(loopDetectionWindow)) {
+		t.Fatal("expected h1 to be forgotten after the window")
+	}
+
+	// the oldest hash is forgotten once full
+	r.add(h2, now)
+	r.add(h3, now)
+	if r.seen(h1, now) || !r.seen(h2, now) || !r.seen(h3, now) {
+		t.Fatal("expected only h2 and h3 to be remembered")
+	}
+	if len(r.seenAt) != 2 {
+		t.Fatalf("expected 2 remembered hashes, got %d", len(r.seenAt))
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrMaintenance is returned by the health check of a Maintenance while it is active
+var ErrMaintenance = errors.New("provider is in maintenance mode")
+
+// Maintenance is a time-boxed maintenance mode triggered by an operator, e.g. through the
+// admin API, to orchestrate the maintenance of a node.
+//
+// While active, the provider advertises NotReady through the healthz and readyz checks,
+// but Encrypt and Decrypt keep being served and livez is unaffected, so the kubelet does
+// not restart the provider mid-operation. It ends when it expires or is ended, so a
+// forgotten maintenance cannot keep the provider NotReady.
+type Maintenance struct {
+	maxDuration time.Duration
+
+	mu     sync.Mutex
+	until  time.Time
+	reason string
+}
+
+// MaintenanceStatus is the state of a Maintenance
+type MaintenanceStatus struct {
+	Active bool      `json:"active"`
+	Until  time.Time `json:"until,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// NewMaintenance returns a Maintenance which can be started for at most maxDuration
+func NewMaintenance(maxDuration time.Duration) *Maintenance {
+	return &Maintenance{maxDuration: maxDuration}
+}
+
+// Start starts the maintenance, or extends the active one, for d
+func (m *Maintenance) Start(d time.Duration, reason string) (MaintenanceStatus, error) {
+	if d <= 0 || d > m.maxDuration {
+		return MaintenanceStatus{}, fmt.Errorf("maintenance duration must be positive and at most %s, got %s", m.maxDuration, d)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.until, m.reason = time.Now().Add(d), reason
+	zap.L().Warn("maintenance mode started, advertising NotReady", zap.Time("until", m.until), zap.String("reason", reason))
+	maintenanceMetric.Set(1)
+	return m.statusLocked(), nil
+}
+
+// End ends the maintenance, if active
+func (m *Maintenance) End() MaintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.activeLocked() {
+		zap.L().Info("maintenance mode ended, advertising the KMS health again", zap.String("reason", m.reason))
+	}
+	m.until, m.reason = time.Time{}, ""
+	maintenanceMetric.Set(0)
+	return m.statusLocked()
+}
+
+// Status returns the state of the maintenance
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.statusLocked()
+}
+
+func (m *Maintenance) statusLocked() MaintenanceStatus {
+	if !m.activeLocked() {
+		return MaintenanceStatus{}
+	}
+	return MaintenanceStatus{Active: true, Until: m.until, Reason: m.reason}
+}
+
+// activeLocked returns true until the maintenance expires, clearing it once expired
+func (m *Maintenance) activeLocked() bool {
+	if m.until.IsZero() {
+		return false
+	}
+	if time.Now().Before(m.until) {
+		return true
+	}
+	zap.L().Info("maintenance mode expired, advertising the KMS health again", zap.String("reason", m.reason))
+	m.until, m.reason = time.Time{}, ""
+	maintenanceMetric.Set(0)
+	return false
+}
+
+// Name of the check, e.g. for the "exclude" query parameter of the health endpoints
+func (m *Maintenance) Name() string {
+	return "maintenance"
+}
+
+// Health returns ErrMaintenance while the maintenance is active
+func (m *Maintenance) Health() error {
+	if s := m.Status(); s.Active {
+		return fmt.Errorf("%w until %s: %s", ErrMaintenance, s.Until.Format(time.RFC3339), s.Reason)
+	}
+	return nil
+}
+
+// Live never fails, a provider in maintenance must not be restarted
+func (m *Maintenance) Live() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := NewMaintenance(time.Minute)
+	if _, err := m.Start(2*time.Minute, "too long"); err == nil {
+		t.Fatal("expected a maintenance longer than the maximum to be refused")
+	}
+	if err := m.Health(); err != nil {
+		t.Fatalf("expected no maintenance, got %v", err)
+	}
+
+	status, err := m.Start(20*time.Millisecond, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Active || status.Reason != "test" {
+		t.Fatalf("expected an active maintenance, got %+v", status)
+	}
+	if err := m.Health(); !errors.Is(err, ErrMaintenance) {
+		t.Fatalf("expected %v, got %v", ErrMaintenance, err)
+	}
+	if err := m.Live(); err != nil {
+		t.Fatalf("expected the maintenance not to fail the liveness, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := m.Health(); err != nil {
+		t.Fatalf("expected the maintenance to expire, got %v", err)
+	}
+	if status := m.Status(); status.Active {
+		t.Fatalf("expected an expired maintenance, got %+v", status)
+	}
+}
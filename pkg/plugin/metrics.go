@@ -1,6 +1,9 @@
 package plugin
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/aws-encryption-provider/pkg/metrics"
+)
 
 func init() {
 	registerPrometheusMetrics()
@@ -8,7 +11,38 @@ func init() {
 
 func registerPrometheusMetrics() {
 	prometheus.MustRegister(kmsOperationCounter)
+	prometheus.MustRegister(kmsFailureCounter)
 	prometheus.MustRegister(kmsLatencyMetric)
+	prometheus.MustRegister(kmsPlaintextSizeMetric)
+	prometheus.MustRegister(kmsCiphertextSizeMetric)
+	prometheus.MustRegister(kmsWriteVerificationCounter)
+	prometheus.MustRegister(kmsReencryptionLoopCounter)
+	prometheus.MustRegister(kmsCircuitBreakerOpen)
+	prometheus.MustRegister(kmsCircuitBreakerRejectionCounter)
+	prometheus.MustRegister(kmsRecoveryProbeCounter)
+	prometheus.MustRegister(kmsHealthStateMetric)
+	prometheus.MustRegister(kmsHealthStateTransitionCounter)
+	prometheus.MustRegister(kmsHealthCheckIdleMetric)
+	prometheus.MustRegister(kmsHealthCheckSkippedCounter)
+	prometheus.MustRegister(kmsHealthCheckPayloadSizeMetric)
+	prometheus.MustRegister(kmsHealthFailureSuppressedCounter)
+	prometheus.MustRegister(kmsAPIVersionRejectionCounter)
+	prometheus.MustRegister(kmsCiphertextAgeMetric)
+	prometheus.MustRegister(kmsCiphertextAgeExceededCounter)
+	prometheus.MustRegister(kmsCanaryCounter)
+	prometheus.MustRegister(kmsDecryptOnlyKeyCounter)
+	prometheus.MustRegister(kmsDecryptedKeyCounter)
+	prometheus.MustRegister(kmsDeprecatedCiphertextCounter)
+	prometheus.MustRegister(kmsRequestLatencyMetric)
+	prometheus.MustRegister(kmsRequestPhaseLatencyMetric)
+	prometheus.MustRegister(drainingMetric)
+	prometheus.MustRegister(maintenanceMetric)
+	prometheus.MustRegister(kmsFairShareInFlightMetric)
+	prometheus.MustRegister(kmsFairShareQueueDepthMetric)
+	prometheus.MustRegister(kmsFairShareThrottledCounter)
+	prometheus.MustRegister(kmsFairShareWaitMetric)
+	prometheus.MustRegister(kmsAliasKeyChangeCounter)
+	prometheus.MustRegister(kmsCompatDecryptionCounter)
 }
 
 var (
@@ -25,12 +59,26 @@ var (
 		},
 	)
 
-	kmsLatencyMetric = prometheus.NewHistogramVec(
+	kmsFailureCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_failures_total",
+			Help: "total failed KMS operations by KMS error type, e.g. to tell throttling from disabled keys",
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+			"error_type",
+		},
+	)
+
+	kmsLatencyMetric = metrics.NewDurationHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "aws_encryption_provider_kms_operation_latency_ms",
-			Help:    "Response latency in milliseconds for aws encryption provider kms operation ",
-			Buckets: prometheus.ExponentialBuckets(2, 2, 14),
+			Name:    "aws_encryption_provider_kms_operation_duration_seconds",
+			Help:    "Response latency for aws encryption provider kms operation",
+			Buckets: prometheus.ExponentialBuckets(0.002, 2, 14),
 		},
+		"aws_encryption_provider_kms_operation_latency_ms",
 		[]string{
 			"key_arn",
 			"status",
@@ -38,4 +86,322 @@ var (
 			"version",
 		},
 	)
+
+	// KMS Encrypt accepts at most 4096 bytes of plaintext, the buckets are chosen so that
+	// operators can see workloads approaching the limit
+	kmsPlaintextSizeMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_kms_plaintext_size_bytes",
+			Help:    "Plaintext size in bytes for aws encryption provider kms operation",
+			Buckets: []float64{64, 128, 256, 512, 1024, 2048, 3072, 3584, 4096, 8192, 16384},
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+		},
+	)
+
+	kmsCiphertextSizeMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_kms_ciphertext_size_bytes",
+			Help:    "Ciphertext size in bytes for aws encryption provider kms operation",
+			Buckets: []float64{64, 128, 256, 512, 1024, 2048, 3072, 4096, 6144, 8192, 16384},
+		},
+		[]string{
+			"key_arn",
+			"operation",
+			"version",
+		},
+	)
+
+	kmsWriteVerificationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_write_verifications_total",
+			Help: "total newly encrypted ciphertexts sampled to verify they decrypt back to their plaintext",
+		},
+		[]string{
+			"key_arn",
+			"status",
+		},
+	)
+
+	kmsReencryptionLoopCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_reencryption_loop_suspected_total",
+			Help: "total encryptions of a plaintext recently encrypted, or recently returned as ciphertext, suggesting an apiserver re-encryption loop",
+		},
+		[]string{
+			"key_arn",
+			"reason",
+			"version",
+		},
+	)
+
+	kmsCircuitBreakerOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_circuit_breaker_open",
+			Help: "1 if the circuit breaker of the operation is open or half-open, failing requests fast, 0 otherwise",
+		},
+		[]string{
+			"key_arn",
+			"operation",
+		},
+	)
+
+	kmsCircuitBreakerRejectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_circuit_breaker_rejections_total",
+			Help: "total requests rejected by an open circuit breaker without calling KMS",
+		},
+		[]string{
+			"key_arn",
+			"operation",
+		},
+	)
+
+	kmsRecoveryProbeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_recovery_probes_total",
+			Help: "total probes run to detect the recovery from a user-induced KMS error",
+		},
+		[]string{
+			"status",
+		},
+	)
+
+	kmsHealthStateMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_health_state",
+			Help: "1 for the current KMS health state, 0 for the others",
+		},
+		[]string{
+			"state",
+		},
+	)
+
+	kmsRequestPhaseLatencyMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_request_phase_duration_seconds",
+			Help:    "Time spent in each phase of the Encrypt and Decrypt requests of the apiserver",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 18),
+		},
+		[]string{
+			"operation",
+			"version",
+			"phase",
+		},
+	)
+
+	drainingMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_draining",
+			Help: "1 while the drain file exists and the provider advertises NotReady, 0 otherwise",
+		},
+	)
+
+	maintenanceMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_maintenance",
+			Help: "1 while the maintenance mode is active and the provider advertises NotReady, 0 otherwise",
+		},
+	)
+
+	kmsHealthCheckIdleMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_health_check_idle",
+			Help: "1 while the health check period is stretched because no requests were served, 0 otherwise",
+		},
+	)
+
+	kmsHealthCheckSkippedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_health_checks_skipped_total",
+			Help: "total health checks skipped because the KMS requests of the plugin succeeded recently",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	kmsHealthCheckPayloadSizeMetric = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_health_check_payload_size_bytes",
+			Help: "size of the latest payload mirroring the plaintexts of the requests encrypted by the health probes",
+		},
+	)
+
+	kmsHealthFailureSuppressedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_health_failures_suppressed_total",
+			Help: "total KMS errors not failing the health checks because they are below the failure threshold",
+		},
+		[]string{
+			"error_type",
+		},
+	)
+
+	kmsAPIVersionRejectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_api_version_rejections_total",
+			Help: "total requests refused because their KMS API version is not served, see --kms-api-versions",
+		},
+		[]string{
+			"version",
+			"method",
+		},
+	)
+
+	kmsHealthStateTransitionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_health_state_transitions_total",
+			Help: "total KMS health state transitions",
+		},
+		[]string{
+			"from",
+			"to",
+		},
+	)
+
+	kmsCiphertextAgeMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "aws_encryption_provider_kms_ciphertext_age_seconds",
+			Help: "Age of the decrypted ciphertexts annotated with their encryption time",
+			// 1h, 6h, 1d, 1w, 30d, 90d, 180d, 1y, 2y
+			Buckets: []float64{3600, 21600, 86400, 604800, 2592000, 7776000, 15552000, 31536000, 63072000},
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	kmsCiphertextAgeExceededCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_ciphertext_age_exceeded_total",
+			Help: "total decryptions of ciphertexts older than the configured maximum age",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	kmsCanaryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_canary_round_trips_total",
+			Help: "total encryptions mirrored to the canary key, encrypted and decrypted back in the background",
+		},
+		[]string{
+			"key_arn",
+			"canary_key_arn",
+			"status",
+		},
+	)
+
+	kmsDecryptOnlyKeyCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_decrypt_only_key_requests_total",
+			Help: "total decryptions of ciphertexts written with a decrypt-only key",
+		},
+		[]string{
+			"key_arn",
+			"decrypt_key_arn",
+		},
+	)
+
+	kmsDecryptedKeyCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_decrypted_key_requests_total",
+			Help: "total successful KMS decryptions by the ARN of the key KMS reports it decrypted with",
+		},
+		[]string{
+			"key_arn",
+			"ciphertext_key_arn",
+			"version",
+		},
+	)
+
+	kmsDeprecatedCiphertextCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_deprecated_ciphertext_decryptions_total",
+			Help: "total decryptions of ciphertexts of a deprecated format, by prefix or key",
+		},
+		[]string{
+			"key_arn",
+			"deprecated",
+		},
+	)
+
+	kmsRequestLatencyMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_request_duration_seconds",
+			Help:    "Duration of the Encrypt and Decrypt requests of the apiserver, including the time spent in the provider",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 18),
+		},
+		[]string{
+			"operation",
+			"version",
+			"status",
+		},
+	)
+
+	kmsFairShareInFlightMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_fair_share_in_flight",
+			Help: "KMS calls in flight by plugin API version, see --kms-max-in-flight",
+		},
+		[]string{
+			"version",
+		},
+	)
+
+	kmsFairShareQueueDepthMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_kms_fair_share_queue_depth",
+			Help: "KMS calls waiting for their share of --kms-max-in-flight by plugin API version",
+		},
+		[]string{
+			"version",
+		},
+	)
+
+	kmsFairShareThrottledCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_fair_share_throttled_total",
+			Help: "total KMS calls which waited for their share of --kms-max-in-flight, by plugin API version",
+		},
+		[]string{
+			"version",
+		},
+	)
+
+	kmsFairShareWaitMetric = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_kms_fair_share_wait_seconds",
+			Help:    "Time the throttled KMS calls waited for their share of --kms-max-in-flight, by plugin API version",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 18),
+		},
+		[]string{
+			"version",
+		},
+	)
+
+	kmsAliasKeyChangeCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_alias_key_changes_total",
+			Help: "total changes of the key the alias of the plugin points to, see --key-alias-resolution-period",
+		},
+		[]string{
+			"key_arn",
+		},
+	)
+
+	kmsCompatDecryptionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_kms_compat_decryptions_total",
+			Help: "total ciphertexts of other providers matched by --compat-prefixes, by prefix",
+		},
+		[]string{
+			"prefix",
+		},
+	)
 )
@@ -3,13 +3,9 @@ package plugin
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -21,6 +17,7 @@ import (
 	pb "k8s.io/kms/apis/v1beta1"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
 )
 
 // TestMetrics tests /metrics handler.
@@ -28,30 +25,39 @@ func TestMetrics(t *testing.T) {
 	zap.ReplaceGlobals(zap.NewExample())
 
 	tt := []struct {
-		key        string
-		encryptErr error
-		expects    string
+		key            string
+		encryptErr     error
+		expects        string
+		expectsFailure string
 	}{
 		{
-			key:        "test-key",
-			encryptErr: errors.New("fail"),
-			expects:    `aws_encryption_provider_kms_operations_total{key_arn="test-key",operation="encrypt",status="failure",version="v1"} 1`,
+			key:            "test-key",
+			encryptErr:     errors.New("fail"),
+			expects:        `aws_encryption_provider_kms_operations_total{key_arn="test-key",operation="encrypt",status="failure",version="v1"} 1`,
+			expectsFailure: `aws_encryption_provider_kms_failures_total{error_type="other",key_arn="test-key",operation="encrypt",version="v1"} 1`,
 		},
 		{
-			key:        "test-key-throttle",
-			encryptErr: &kmstypes.LimitExceededException{Message: aws.String("test")},
-			expects:    `aws_encryption_provider_kms_operations_total{key_arn="test-key-throttle",operation="encrypt",status="failure-throttle",version="v1"} 1`,
+			key:            "test-key-throttle",
+			encryptErr:     &kmstypes.LimitExceededException{Message: aws.String("test")},
+			expects:        `aws_encryption_provider_kms_operations_total{key_arn="test-key-throttle",operation="encrypt",status="failure-throttle",version="v1"} 1`,
+			expectsFailure: `aws_encryption_provider_kms_failures_total{error_type="throttled",key_arn="test-key-throttle",operation="encrypt",version="v1"} 1`,
+		},
+		{
+			key:            "test-key-disabled",
+			encryptErr:     &kmstypes.DisabledException{Message: aws.String("test")},
+			expects:        `aws_encryption_provider_kms_operations_total{key_arn="test-key-disabled",operation="encrypt",status="failure",version="v1"} 1`,
+			expectsFailure: `aws_encryption_provider_kms_failures_total{error_type="user-induced",key_arn="test-key-disabled",operation="encrypt",version="v1"} 1`,
 		},
 	}
 	for i, entry := range tt {
 		t.Run(entry.key, func(t *testing.T) {
-			addr := filepath.Join(os.TempDir(), fmt.Sprintf("metrics%x", rand.Int63()))
-			defer os.RemoveAll(addr) //nolint:errcheck
+			ptesting.VerifyNoGoroutineLeaks(t)
+			addr := ptesting.TempSocketPath(t, "metrics")
 
 			c := &cloud.KMSMock{}
 			c.SetEncryptResp("test", entry.encryptErr)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-			go sharedHealthCheck.Start()
+			sharedHealthCheck.Start()
 			defer sharedHealthCheck.Stop()
 			p := New(entry.key, c, nil, sharedHealthCheck)
 
@@ -105,9 +111,57 @@ func TestMetrics(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if !strings.Contains(string(d), entry.expects) {
-				t.Fatalf("#%d: expected %q, got\n\n%s\n\n", i, entry.expects, string(d))
+			for _, expects := range []string{entry.expects, entry.expectsFailure} {
+				if !strings.Contains(string(d), expects) {
+					t.Fatalf("#%d: expected %q, got\n\n%s\n\n", i, expects, string(d))
+				}
 			}
 		})
 	}
 }
+
+// TestSizeMetrics tests the plaintext and ciphertext size histograms.
+func TestSizeMetrics(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp(strings.Repeat("c", 199), nil)
+	c.SetDecryptResp(strings.Repeat("p", 100), nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := New("test-key-size", c, nil, sharedHealthCheck)
+
+	//nolint:staticcheck
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plain: []byte(strings.Repeat("p", 100))}); err != nil {
+		t.Fatal(err)
+	}
+	//nolint:staticcheck
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Cipher: []byte(strings.Repeat("c", 200))}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(promhttp.Handler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	d, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expects := range []string{
+		`aws_encryption_provider_kms_plaintext_size_bytes_sum{key_arn="test-key-size",operation="encrypt",version="v1"} 100`,
+		`aws_encryption_provider_kms_ciphertext_size_bytes_sum{key_arn="test-key-size",operation="encrypt",version="v1"} 200`,
+		`aws_encryption_provider_kms_ciphertext_size_bytes_sum{key_arn="test-key-size",operation="decrypt",version="v1"} 200`,
+		`aws_encryption_provider_kms_plaintext_size_bytes_sum{key_arn="test-key-size",operation="decrypt",version="v1"} 100`,
+		`aws_encryption_provider_kms_plaintext_size_bytes_bucket{key_arn="test-key-size",operation="encrypt",version="v1",le="128"} 1`,
+	} {
+		if !strings.Contains(string(d), expects) {
+			t.Fatalf("expected %q, got\n\n%s\n\n", expects, string(d))
+		}
+	}
+}
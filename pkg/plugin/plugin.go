@@ -40,15 +40,27 @@ type V1Plugin struct {
 	keyID         string
 	encryptionCtx map[string]string
 	healthCheck   *SharedHealthCheck
+	// set if the key can never be used with svc, see kmsplugin.CheckKeyPartition
+	partitionErr *kmsplugin.PartitionMismatchError
+	loopDetector *loopDetector
+	// set to encrypt with a key hierarchy, see WithV1KeyHierarchy
+	keyHierarchy *V2Plugin
+	// decrypts the ciphertexts of the key hierarchy mode, whether it is enabled or not
+	keyHierarchyDecrypter *V2Plugin
+	// ciphertexts of other providers, see WithV1CompatPrefixes
+	compatPrefixes compatPrefixes
+	// see SharedHealthCheck.SetTrafficAwareChecks
+	traffic traffic
 }
 
 // New returns a new *V1Plugin
-func New(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck) *V1Plugin {
+func New(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck, opts ...V1Option) *V1Plugin {
 	return newPlugin(
 		key,
 		svc,
 		encryptionCtx,
 		healthCheck,
+		opts...,
 	)
 }
 
@@ -57,11 +69,14 @@ func newPlugin(
 	svc cloud.AWSKMSv2,
 	encryptionCtx map[string]string,
 	sharedHealthCheck *SharedHealthCheck,
+	opts ...V1Option,
 ) *V1Plugin {
 	p := &V1Plugin{
-		svc:         svc,
-		keyID:       key,
-		healthCheck: sharedHealthCheck,
+		svc:          svc,
+		keyID:        key,
+		healthCheck:  sharedHealthCheck,
+		partitionErr: kmsplugin.CheckKeyPartition(key, cloud.Region(svc)),
+		loopDetector: newLoopDetector(key, GRPC_V1),
 	}
 	if len(encryptionCtx) > 0 {
 		p.encryptionCtx = make(map[string]string)
@@ -69,6 +84,13 @@ func newPlugin(
 	for k, v := range encryptionCtx {
 		p.encryptionCtx[k] = v
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.keyHierarchyDecrypter = p.keyHierarchy
+	if p.keyHierarchyDecrypter == nil {
+		p.keyHierarchyDecrypter = newPluginV2(p.keyID, p.svc, p.encryptionCtx, p.healthCheck)
+	}
 	return p
 }
 
@@ -87,34 +109,48 @@ func newPlugin(
 //  1. there was never a health check done
 //  2. there was no health check done for the last "healthCheckPeriod"
 //     (only use the cached error if the error is from recent API call)
+//  3. with traffic aware checks, no KMS request of the plugin succeeded since,
+//     see SharedHealthCheck.SetTrafficAwareChecks
 func (p *V1Plugin) Health() error {
 	recent, err := p.healthCheck.isRecentlyChecked()
+	if !recent && p.healthCheck.servedRecently(p.keyID, &p.traffic) {
+		return nil
+	}
 	if !recent {
-		//nolint:staticcheck
-		_, err = p.Encrypt(context.Background(), &pb.EncryptRequest{Plain: []byte("foo")})
+		err = p.Probe()
 		p.healthCheck.recordErr(err)
 		if err != nil {
 			zap.L().Warn("health check failed", zap.Error(err))
 		}
-		return err
+		return p.healthCheck.tolerate(err)
 	}
+	// the cached error was logged when recorded
 	if err != nil {
-		zap.L().Warn("health check failed", zap.Error(err))
+		zap.L().Debug("cached health check failed", zap.Error(err))
 	} else {
 		zap.L().Debug("health check success")
 	}
+	return p.healthCheck.tolerate(err)
+}
+
+// Probe calls the KMS "Encrypt" API, bypassing the cached health check result.
+// Describe key probes call "DescribeKey" instead, see SharedHealthCheck.SetDescribeKeyProbes.
+func (p *V1Plugin) Probe() error {
+	if p.healthCheck.describeKey() {
+		return describeKeyProbe(probeContext(), p.svc, p.keyID, p.partitionErr, p.healthCheck, GRPC_V1)
+	}
+	//nolint:staticcheck
+	_, err := p.encrypt(probeContext(), &pb.EncryptRequest{Plain: p.healthCheck.probePlaintext()})
 	return err
 }
 
 // Live checks the liveness of KMS API.
-// If the error is user-induced (e.g., revoke CMK) or throttled, the function returns NO error.
-// If the error is due to KMS availability, the function returns the error.
+// If the error is due to KMS availability (see HealthStateFailedInfra), the function returns the error.
+// If the error is user-induced (e.g., revoke CMK), a key partition mismatch, throttled or
+// a key policy still propagating during the bootstrap grace period, the function returns NO error.
 func (p *V1Plugin) Live() error {
-	if err := p.Health(); err != nil {
-		errType := kmsplugin.ParseError(err)
-		if errType != kmsplugin.KMSErrorTypeUserInduced && errType != kmsplugin.KMSErrorTypeThrottled {
-			return err
-		}
+	if err := p.Health(); !isLive(err) {
+		return err
 	}
 	return nil
 }
@@ -135,76 +171,142 @@ func (p *V1Plugin) Version(ctx context.Context, request *pb.VersionRequest) (*pb
 //
 //nolint:staticcheck
 func (p *V1Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	p.healthCheck.recordRequest()
+	ctx = withCorrelationID(ctx, "")
+	annotateSpan(ctx, p.keyID, GRPC_V1)
+	var resp *pb.EncryptResponse //nolint:staticcheck
+	var err error
+	if p.keyHierarchy != nil {
+		resp, err = p.encryptWithKeyHierarchy(ctx, request)
+	} else {
+		resp, err = p.encrypt(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.loopDetector.observe(request.Plain, resp.Cipher)
+	return resp, nil
+}
+
+//nolint:staticcheck
+func (p *V1Plugin) encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
 	zap.L().Debug("starting encrypt operation")
 
 	startTime := time.Now()
+	kmsPlaintextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V1).Observe(float64(len(request.Plain)))
+	p.healthCheck.recordPlaintext(ctx, request.Plain)
 	input := &kms.EncryptInput{
 		Plaintext: request.Plain,
 		KeyId:     aws.String(p.keyID),
 	}
 	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
+		zap.L().Debug("configuring encryption context", zap.Any("ctx", p.encryptionCtx))
 		input.EncryptionContext = p.encryptionCtx
 	}
+	dryRun := p.healthCheck.dryRun(ctx)
+	if dryRun {
+		input.DryRun = aws.Bool(true)
+	}
 
+	kmsDone := observePhase(ctx, phaseKMS)
 	result, err := p.svc.Encrypt(ctx, input)
+	kmsDone()
+	if dryRun {
+		err = dryRunErr(err)
+	}
 	if err != nil {
-		select {
-		case p.healthCheck.healthCheckErrc <- err:
-		default:
+		if p.partitionErr != nil {
+			err = p.partitionErr.WithCause(err)
 		}
+		err = p.healthCheck.classifyProbe(ctx, err)
+		p.healthCheck.report(err)
 		errorType := kmsplugin.ParseError(err).String()
-		zap.L().Error("request to encrypt failed", zap.String("error-type", errorType), zap.Error(err))
+		zap.L().Error("request to encrypt failed", zap.String("error-type", errorType), correlationIDField(ctx), zap.Error(err))
 		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
+		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V1).ObserveSince(startTime)
 		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V1).Inc()
+		kmsFailureCounter.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V1, errorType).Inc()
 		return nil, fmt.Errorf("failed to encrypt %w", err)
 	}
 
 	zap.L().Debug("encrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
+	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V1).ObserveSince(startTime)
 	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V1).Inc()
+	p.traffic.recordSuccess(ctx)
+	p.healthCheck.recordSuccess(ctx)
+	if dryRun {
+		//nolint:staticcheck
+		return &pb.EncryptResponse{}, nil
+	}
+	cipher := append([]byte(kmsplugin.StorageVersion), result.CiphertextBlob...)
+	kmsCiphertextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V1).Observe(float64(len(cipher)))
 	//nolint:staticcheck
-	return &pb.EncryptResponse{Cipher: append([]byte(kmsplugin.StorageVersion), result.CiphertextBlob...)}, nil
+	return &pb.EncryptResponse{Cipher: cipher}, nil
 }
 
 // Decrypt executes the decrypt operation using AWS KMS
 //
 //nolint:staticcheck
 func (p *V1Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	p.healthCheck.recordRequest()
+	ctx = withCorrelationID(ctx, "")
+	annotateSpan(ctx, p.keyID, GRPC_V1)
 	zap.L().Debug("starting decrypt operation")
 
 	startTime := time.Now()
-	if string(request.Cipher[0]) == kmsplugin.StorageVersion {
+	kmsCiphertextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V1).Observe(float64(len(request.Cipher)))
+	if len(request.Cipher) == 0 {
+		return nil, errEmptyCipher
+	}
+	if kmsplugin.KMSStorageVersion(request.Cipher[:1]) == kmsplugin.KMSStorageVersionV2KeyHierarchy {
+		return p.decryptWithKeyHierarchy(ctx, request)
+	}
+	cipher := request.Cipher
+	if blob, compat, err := p.compatPrefixes.kmsCiphertext(request.Cipher); compat {
+		if err != nil {
+			decryptFailures.log(p.keyID, GRPC_V1, cipher, err)
+			return nil, err
+		}
+		request.Cipher = blob
+	} else if string(request.Cipher[0]) == kmsplugin.StorageVersion {
 		request.Cipher = request.Cipher[1:]
 	}
 	input := &kms.DecryptInput{
 		CiphertextBlob: request.Cipher,
 	}
 	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
+		zap.L().Debug("configuring encryption context", zap.Any("ctx", p.encryptionCtx))
 		input.EncryptionContext = p.encryptionCtx
 	}
 
+	kmsDone := observePhase(ctx, phaseKMS)
 	result, err := p.svc.Decrypt(ctx, input)
+	kmsDone()
 	if err != nil {
+		if p.partitionErr != nil {
+			err = p.partitionErr.WithCause(err)
+		}
+		err = p.healthCheck.classifyProbe(ctx, err)
 		errorType := kmsplugin.ParseError(err).String()
 		if errorType != kmsplugin.KMSErrorTypeCorruption.String() {
-			select {
-			case p.healthCheck.healthCheckErrc <- err:
-			default:
-			}
+			p.healthCheck.report(err)
 		}
-		zap.L().Error("request to decrypt failed", zap.String("error-type", errorType), zap.Error(err))
+		zap.L().Error("request to decrypt failed", zap.String("error-type", errorType), correlationIDField(ctx), zap.Error(err))
 		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
+		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V1).ObserveSince(startTime)
 		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V1).Inc()
+		kmsFailureCounter.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V1, errorType).Inc()
+		decryptFailures.log(p.keyID, GRPC_V1, cipher, err)
 		return nil, fmt.Errorf("failed to decrypt %w", err)
 	}
 
 	zap.L().Debug("decrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V1).Observe(kmsplugin.GetMillisecondsSince(startTime))
+	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V1).ObserveSince(startTime)
 	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V1).Inc()
+	p.traffic.recordSuccess(ctx)
+	p.healthCheck.recordSuccess(ctx)
+	observeDecryptedKey(p.keyID, GRPC_V1, result)
+	kmsPlaintextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V1).Observe(float64(len(result.Plaintext)))
 	//nolint:staticcheck
 	return &pb.DecryptResponse{Plain: result.Plaintext}, nil
 }
@@ -187,7 +187,7 @@ func TestEncrypt(t *testing.T) {
 		func() {
 			c.SetEncryptResp(tc.output, tc.err)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-			go sharedHealthCheck.Start()
+			sharedHealthCheck.Start()
 			p := New(key, c, nil, sharedHealthCheck)
 			defer func() {
 				sharedHealthCheck.Stop()
@@ -266,7 +266,7 @@ func TestDecrypt(t *testing.T) {
 		func() {
 			c.SetDecryptResp(tc.output, tc.err)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-			go sharedHealthCheck.Start()
+			sharedHealthCheck.Start()
 			p := New(key, c, tc.ctx, sharedHealthCheck)
 			defer func() {
 				sharedHealthCheck.Stop()
@@ -310,7 +310,7 @@ func TestHealth(t *testing.T) {
 	for idx, entry := range tt {
 		c := &cloud.KMSMock{}
 		sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-		go sharedHealthCheck.Start()
+		sharedHealthCheck.Start()
 		p := New(key, c, nil, sharedHealthCheck)
 		defer func() {
 			sharedHealthCheck.Stop()
@@ -356,7 +356,7 @@ func TestHealthManyRequests(t *testing.T) {
 
 	c := &cloud.KMSMock{}
 	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-	go sharedHealthCheck.Start()
+	sharedHealthCheck.Start()
 	p := newPlugin(key, c, nil, sharedHealthCheck)
 	defer func() {
 		sharedHealthCheck.Stop()
@@ -383,3 +383,27 @@ func TestHealthManyRequests(t *testing.T) {
 		}
 	}
 }
+
+func TestPartitionMismatch(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("", &smithy.GenericAPIError{Code: "NotFoundException", Message: "test"})
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	keyARN := "arn:aws:kms:us-west-2:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	p := New(keyARN, c, nil, sharedHealthCheck)
+	p.partitionErr = kmsplugin.CheckKeyPartition(keyARN, "us-gov-west-1")
+
+	//nolint:staticcheck
+	_, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plain: []byte(plainMessage)})
+	if et := kmsplugin.ParseError(err); et != kmsplugin.KMSErrorTypePartitionMismatch {
+		t.Fatalf("expected error type %s, got %s", kmsplugin.KMSErrorTypePartitionMismatch, et)
+	}
+	if herr := p.Health(); herr == nil {
+		t.Fatal("expected health error, but got nil")
+	}
+	if lerr := p.Live(); lerr != nil {
+		t.Fatalf("unexpected live error, got %v", lerr)
+	}
+}
@@ -20,17 +20,25 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	pb "k8s.io/kms/apis/v2"
 	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
 	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/membudget"
 )
 
 var _ pb.KeyManagementServiceServer = &V2Plugin{}
 
 const (
 	GRPC_V2 = "v2"
+
+	// memory budget consumer names
+	kekCacheName     = "kek-cache"
+	requestQueueName = "v2-requests"
 )
 
 // Plugin implements the KeyManagementServiceServer
@@ -39,15 +47,55 @@ type V2Plugin struct {
 	keyID         string
 	encryptionCtx map[string]string
 	healthCheck   *SharedHealthCheck
+	// set if the key can never be used with svc, see kmsplugin.CheckKeyPartition
+	partitionErr *kmsplugin.PartitionMismatchError
+	keyHierarchy *keyHierarchy
+	memBudget    *membudget.Budget
+	statusCache  *statusCache
+	// set to verify newly written ciphertexts, see WithWriteVerification
+	writeVerification *writeVerification
+	transformers      []Transformer
+	loopDetector      *loopDetector
+	// nil if disabled, see WithCircuitBreakers
+	encryptBreaker *circuitBreaker
+	decryptBreaker *circuitBreaker
+	// set to sign and verify annotations, see WithIdentityAssertion
+	identityAssertion *identityAssertion
+	// encryption context key of the request UID, see WithRequestUIDEncryptionContext
+	requestUIDContextKey string
+	// set to annotate and track the age of ciphertexts, see WithCiphertextAge
+	ciphertextAge *ciphertextAge
+	// set to mirror encryptions to another key, see WithKeyCanary
+	keyCanary *keyCanary
+	// set to decrypt the ciphertexts of other keys, see WithDecryptOnlyKeys
+	decryptOnlyKeys map[string]struct{}
+	// set to write the features header, see WithClusterFeatures
+	clusterFeatures *Feature
+	// set to advertise NotReady while draining, see WithDrainFile
+	drainFile *DrainFile
+	// set to count the decryptions of deprecated ciphertexts, see WithDeprecations
+	deprecations *Deprecations
+	// set to report the key the alias points to, see WithAliasResolution
+	aliasResolution *aliasResolution
+	// ciphertexts of other providers, see WithCompatPrefixes
+	compatPrefixes compatPrefixes
+	// set to write the KMS ciphertexts with a structured header, see WithStorageVersionV3
+	storageVersionV3 bool
+	// see SharedHealthCheck.SetTrafficAwareChecks
+	traffic traffic
 }
 
+// V2Option configures optional behavior of the V2Plugin
+type V2Option func(*V2Plugin)
+
 // New returns a new *V2Plugin
-func NewV2(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck) *V2Plugin {
+func NewV2(key string, svc cloud.AWSKMSv2, encryptionCtx map[string]string, healthCheck *SharedHealthCheck, opts ...V2Option) *V2Plugin {
 	return newPluginV2(
 		key,
 		svc,
 		encryptionCtx,
 		healthCheck,
+		opts...,
 	)
 }
 
@@ -56,11 +104,14 @@ func newPluginV2(
 	svc cloud.AWSKMSv2,
 	encryptionCtx map[string]string,
 	healthCheck *SharedHealthCheck,
+	opts ...V2Option,
 ) *V2Plugin {
 	p := &V2Plugin{
-		svc:         svc,
-		keyID:       key,
-		healthCheck: healthCheck,
+		svc:          svc,
+		keyID:        key,
+		healthCheck:  healthCheck,
+		partitionErr: kmsplugin.CheckKeyPartition(key, cloud.Region(svc)),
+		loopDetector: newLoopDetector(key, GRPC_V2),
 	}
 	if len(encryptionCtx) > 0 {
 		p.encryptionCtx = make(map[string]string)
@@ -68,9 +119,43 @@ func newPluginV2(
 	for k, v := range encryptionCtx {
 		p.encryptionCtx[k] = v
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.keyHierarchy != nil {
+		budget := p.memBudget
+		if budget == nil {
+			budget = membudget.New(0)
+		}
+		p.keyHierarchy.keks = budget.NewCache(kekCacheName)
+	}
+	if p.clusterFeatures != nil {
+		p.warnDisabledFeatures()
+	}
 	return p
 }
 
+// WithMemoryBudget accounts the caches and in-flight requests of the plugin to the budget,
+// requests that do not fit are rejected with a ResourceExhausted error.
+func WithMemoryBudget(b *membudget.Budget) V2Option {
+	return func(p *V2Plugin) {
+		p.memBudget = b
+	}
+}
+
+// reserve accounts an in-flight request payload to the memory budget, if any
+func (p *V2Plugin) reserve(size int) (release func(), err error) {
+	if p.memBudget == nil {
+		return func() {}, nil
+	}
+	release, err = p.memBudget.Reserve(requestQueueName, int64(size))
+	if err != nil {
+		zap.L().Warn("rejecting request", zap.Int("size", size), zap.Error(err))
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return release, nil
+}
+
 // Health checks KMS API availability.
 //
 // The goal is to:
@@ -86,45 +171,80 @@ func newPluginV2(
 //  1. there was never a health check done
 //  2. there was no health check done for the last "healthCheckPeriod"
 //     (only use the cached error if the error is from recent API call)
+//  3. with traffic aware checks, no KMS request of the plugin succeeded since,
+//     see SharedHealthCheck.SetTrafficAwareChecks
+//
+// The check always goes to KMS, even when the key hierarchy is enabled.
 func (p *V2Plugin) Health() error {
 	recent, err := p.healthCheck.isRecentlyChecked()
+	if !recent && p.healthCheck.servedRecently(p.keyID, &p.traffic) {
+		return nil
+	}
 	if !recent {
-		encResult, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte("foo")})
-		p.healthCheck.recordErr(err)
-		if err != nil {
-			zap.L().Warn("health check failed at encryption", zap.Error(err))
-			return err
-		}
-		_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: encResult.Ciphertext})
+		err := p.Probe()
 		p.healthCheck.recordErr(err)
-		if err != nil {
-			zap.L().Warn("health check failed at decryption", zap.Error(err))
-		}
-		return err
+		return p.healthCheck.tolerate(err)
 	}
+	// the cached error was logged when recorded
 	if err != nil {
-		zap.L().Warn("cached health check failed", zap.Error(err))
+		zap.L().Debug("cached health check failed", zap.Error(err))
 	} else {
 		zap.L().Debug("health check success")
 	}
+	return p.healthCheck.tolerate(err)
+}
+
+// Probe calls the KMS "Encrypt" then "Decrypt" APIs, bypassing the cached health check result.
+// Dry run probes only call "Encrypt", see SharedHealthCheck.SetDryRunProbes, and describe key
+// probes "DescribeKey", see SharedHealthCheck.SetDescribeKeyProbes.
+func (p *V2Plugin) Probe() error {
+	ctx := probeContext()
+	if p.healthCheck.describeKey() {
+		return describeKeyProbe(ctx, p.svc, p.keyID, p.partitionErr, p.healthCheck, GRPC_V2)
+	}
+	encResult, err := p.encryptKMS(ctx, &pb.EncryptRequest{Plaintext: p.healthCheck.probePlaintext()})
+	if err != nil {
+		zap.L().Warn("health check failed at encryption", zap.Error(err))
+		return err
+	}
+	if p.healthCheck.dryRun(ctx) {
+		return nil
+	}
+	if kmsplugin.KMSStorageVersion(encResult.Ciphertext[:1]) == kmsplugin.KMSStorageVersionV3 {
+		_, err = p.decryptV3(ctx, &pb.DecryptRequest{Ciphertext: encResult.Ciphertext})
+	} else {
+		_, err = p.decryptKMS(ctx, &pb.DecryptRequest{Ciphertext: encResult.Ciphertext})
+	}
+	if err != nil {
+		zap.L().Warn("health check failed at decryption", zap.Error(err))
+	}
 	return err
 }
 
 // Live checks the liveness of KMS API.
-// If the error is user-induced (e.g., revoke CMK) or throttled, the function returns NO error.
-// If the error is due to KMS availability, the function returns the error.
+// If the error is due to KMS availability (see HealthStateFailedInfra), the function returns the error.
+// If the error is user-induced (e.g., revoke CMK), a key partition mismatch, throttled or
+// a key policy still propagating during the bootstrap grace period, the function returns NO error.
 func (p *V2Plugin) Live() error {
-	if err := p.Health(); err != nil {
-		errType := kmsplugin.ParseError(err)
-		if errType != kmsplugin.KMSErrorTypeUserInduced && errType != kmsplugin.KMSErrorTypeThrottled {
-			return err
-		}
+	if err := p.Health(); !isLive(err) {
+		return err
 	}
 	return nil
 }
 
 // Status returns the V2Plugin server status
 func (p *V2Plugin) Status(ctx context.Context, request *pb.StatusRequest) (*pb.StatusResponse, error) {
+	// bypasses the cache, so the apiservers are shifted as soon as the drain starts
+	if p.drainFile.Draining() {
+		return &pb.StatusResponse{Version: "v2beta1", Healthz: statusDraining, KeyId: p.reportedKeyID()}, nil
+	}
+	if p.statusCache != nil {
+		return p.statusCache.cachedStatus(p.status), nil
+	}
+	return p.status(), nil
+}
+
+func (p *V2Plugin) status() *pb.StatusResponse {
 	status := "ok"
 	if p.Health() != nil {
 		status = "err"
@@ -132,87 +252,267 @@ func (p *V2Plugin) Status(ctx context.Context, request *pb.StatusRequest) (*pb.S
 	return &pb.StatusResponse{
 		Version: "v2beta1",
 		Healthz: status,
-		KeyId:   p.keyID,
-	}, nil
+		KeyId:   p.resolvedKeyID(),
+	}
 }
 
 // Encrypt executes the encryption operation using AWS KMS
 func (p *V2Plugin) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	return p.encrypt(ctx, request, p.writesFeature(FeatureTransformers))
+}
+
+// encrypt encrypts the plaintext of the request, transformed if transform is set
+func (p *V2Plugin) encrypt(ctx context.Context, request *pb.EncryptRequest, transform bool) (*pb.EncryptResponse, error) {
+	p.healthCheck.recordRequest()
+	ctx = withCorrelationID(ctx, request.Uid)
+	annotateSpan(ctx, p.keyID, GRPC_V2)
+	kmsPlaintextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V2).Observe(float64(len(request.Plaintext)))
+	release, err := p.reserve(len(request.Plaintext))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	plaintext := request.Plaintext
+	if transform {
+		if plaintext, err = p.transformToStorage(ctx, plaintext); err != nil {
+			return nil, err
+		}
+	}
+	transformed := &pb.EncryptRequest{Uid: request.Uid, Plaintext: plaintext}
+
+	if err := p.encryptBreaker.allow(); err != nil {
+		return nil, err
+	}
+	var resp *pb.EncryptResponse
+	if p.keyHierarchy != nil && p.writesFeature(FeatureEnvelope) {
+		resp, err = p.encryptWithKeyHierarchy(ctx, transformed)
+	} else {
+		resp, err = p.encryptKMS(ctx, transformed)
+	}
+	p.encryptBreaker.record(err)
+	if err != nil {
+		return nil, err
+	}
+	p.addFeaturesHeader(resp, transform)
+	if err := p.annotateIdentity(resp); err != nil {
+		return nil, err
+	}
+	p.annotateEncryptedAt(resp)
+	kmsCiphertextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V2).Observe(float64(len(resp.Ciphertext)))
+	p.loopDetector.observe(request.Plaintext, resp.Ciphertext)
+	p.verifyWrite(request.Plaintext, resp, transform)
+	p.mirrorToCanary(request.Plaintext)
+	return resp, nil
+}
+
+func (p *V2Plugin) encryptKMS(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
 	zap.L().Debug("starting encrypt operation")
 
 	startTime := time.Now()
+	p.healthCheck.recordPlaintext(ctx, request.Plaintext)
 	input := &kms.EncryptInput{
 		Plaintext: request.Plaintext,
 		KeyId:     aws.String(p.keyID),
 	}
-	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
-		input.EncryptionContext = p.encryptionCtx
+	encryptionCtx, requestCtxAnnotation, err := p.requestEncryptionContext(request.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptionCtx) > 0 {
+		zap.L().Debug("configuring encryption context", zap.Any("ctx", encryptionCtx))
+		input.EncryptionContext = encryptionCtx
+	}
+	dryRun := p.healthCheck.dryRun(ctx)
+	if dryRun {
+		input.DryRun = aws.Bool(true)
 	}
 
+	kmsDone := observePhase(ctx, phaseKMS)
 	result, err := p.svc.Encrypt(ctx, input)
+	kmsDone()
+	if dryRun {
+		err = dryRunErr(err)
+	}
 	if err != nil {
-		select {
-		case p.healthCheck.healthCheckErrc <- err:
-		default:
+		if p.partitionErr != nil {
+			err = p.partitionErr.WithCause(err)
 		}
+		err = p.healthCheck.classifyProbe(ctx, err)
+		p.healthCheck.report(err)
 		errorType := kmsplugin.ParseError(err).String()
-		zap.L().Error("request to encrypt failed", zap.String("error-type", errorType), zap.Error(err))
+		zap.L().Error("request to encrypt failed", zap.String("error-type", errorType), correlationIDField(ctx), zap.Error(err))
 		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
+		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V2).ObserveSince(startTime)
 		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationEncrypt, GRPC_V2).Inc()
+		kmsFailureCounter.WithLabelValues(p.keyID, kmsplugin.OperationEncrypt, GRPC_V2, errorType).Inc()
 		return nil, fmt.Errorf("failed to encrypt %w", err)
 	}
 
 	zap.L().Debug("encrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
+	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V2).ObserveSince(startTime)
 	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationEncrypt, GRPC_V2).Inc()
-	return &pb.EncryptResponse{
+	p.traffic.recordSuccess(ctx)
+	p.healthCheck.recordSuccess(ctx)
+	if dryRun {
+		return &pb.EncryptResponse{KeyId: p.reportedKeyID()}, nil
+	}
+	resp := &pb.EncryptResponse{
 		Ciphertext: append([]byte(kmsplugin.KMSStorageVersionV2), result.CiphertextBlob...),
-		KeyId:      p.keyID,
-	}, nil
+		KeyId:      p.reportedKeyID(),
+	}
+	if p.storageVersionV3 {
+		resp.Ciphertext = kmsplugin.EncodeHeader(p.storageHeader(result, len(request.Plaintext)), result.CiphertextBlob)
+	}
+	if requestCtxAnnotation != nil {
+		resp.Annotations = map[string][]byte{RequestEncryptionContextAnnotation: requestCtxAnnotation}
+	}
+	return resp, nil
 }
 
 // Decrypt executes the decrypt operation using AWS KMS
 func (p *V2Plugin) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	// ciphertexts without features header were written without feature if the cluster features
+	// are set, and transformed if transformers are configured otherwise
+	return p.decrypt(ctx, request, p.clusterFeatures == nil)
+}
+
+// decrypt decrypts the ciphertext of the request, transforming the plaintext of ciphertexts
+// without features header if transformed is set
+func (p *V2Plugin) decrypt(ctx context.Context, request *pb.DecryptRequest, transformed bool) (*pb.DecryptResponse, error) {
+	p.healthCheck.recordRequest()
+	ctx = withCorrelationID(ctx, request.Uid)
+	annotateSpan(ctx, p.keyID, GRPC_V2)
 	zap.L().Debug("starting decrypt operation")
 
-	startTime := time.Now()
+	kmsCiphertextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V2).Observe(float64(len(request.Ciphertext)))
+	release, err := p.reserve(len(request.Ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if len(request.Ciphertext) == 0 {
+		return nil, errEmptyCipher
+	}
+	if err := p.verifyIdentity(request); err != nil {
+		return nil, err
+	}
+	if _, err := p.decryptKeyID(request.KeyId); err != nil {
+		return nil, err
+	}
+	ciphertext := request.Ciphertext
+	inner := ciphertext
+	if kmsplugin.KMSStorageVersion(ciphertext[:1]) == kmsplugin.KMSStorageVersionV2Features {
+		var features Feature
+		if features, inner, err = parseFeaturesHeader(ciphertext); err != nil {
+			decryptFailures.log(p.keyID, GRPC_V2, ciphertext, err)
+			return nil, err
+		}
+		transformed = features&FeatureTransformers != 0
+	}
+	// decrypt a copy, to leave the request of the caller untouched
+	request = &pb.DecryptRequest{Ciphertext: inner, Uid: request.Uid, KeyId: request.KeyId, Annotations: request.Annotations}
+	// after the checks not calling KMS, every request allowed must be recorded to release the trial
+	if err := p.decryptBreaker.allow(); err != nil {
+		return nil, err
+	}
+	var resp *pb.DecryptResponse
 	storageVersion := kmsplugin.KMSStorageVersion(request.Ciphertext[0])
 	switch storageVersion {
 	case kmsplugin.KMSStorageVersionV2:
-		request.Ciphertext = request.Ciphertext[1:]
+		resp, err = p.decryptKMS(ctx, request)
+	case kmsplugin.KMSStorageVersionV2KeyHierarchy:
+		// decryptable regardless of the current mode, so the mode can be turned off safely
+		resp, err = p.decryptWithKeyHierarchy(ctx, request)
+	case kmsplugin.KMSStorageVersionV3:
+		// decryptable regardless of WithStorageVersionV3, so it can be turned off safely
+		resp, err = p.decryptV3(ctx, request)
 	default:
-		// enforce the kmsplugin.StorageVersion in v2
-		return nil, fmt.Errorf("version %s in Ciphertext doesn't match kmsplugin", storageVersion)
+		// enforces the kmsplugin.StorageVersion in v2, unless written by another provider
+		resp, err = p.decryptCompat(ctx, request, storageVersion)
+	}
+	p.decryptBreaker.record(err)
+	if err != nil {
+		decryptFailures.log(p.keyID, GRPC_V2, ciphertext, err)
+		return nil, err
 	}
+	if transformed {
+		if resp.Plaintext, err = p.transformFromStorage(ctx, resp.Plaintext); err != nil {
+			return nil, err
+		}
+	}
+	kmsPlaintextSizeMetric.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V2).Observe(float64(len(resp.Plaintext)))
+	p.observeCiphertextAge(request)
+	p.observeDecryptOnlyKey(request)
+	p.observeDeprecated(ciphertext, request)
+	return resp, nil
+}
+
+func (p *V2Plugin) decryptKMS(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	// strip the kmsplugin.KMSStorageVersionV2 prefix
+	request.Ciphertext = request.Ciphertext[1:]
+	return p.decryptKMSBlob(ctx, request, kmsplugin.Header{})
+}
+
+// decryptKMSBlob decrypts the KMS ciphertext blob of the request, with the key and
+// algorithm of the header if set
+func (p *V2Plugin) decryptKMSBlob(ctx context.Context, request *pb.DecryptRequest, header kmsplugin.Header) (*pb.DecryptResponse, error) {
+	startTime := time.Now()
 	input := &kms.DecryptInput{
 		CiphertextBlob: request.Ciphertext,
 	}
-	if len(p.encryptionCtx) > 0 {
-		zap.L().Debug("configuring encryption context", zap.String("ctx", fmt.Sprintf("%v", p.encryptionCtx)))
-		input.EncryptionContext = p.encryptionCtx
+	encryptionCtx, err := p.decryptionContext(request.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	if len(encryptionCtx) > 0 {
+		zap.L().Debug("configuring encryption context", zap.Any("ctx", encryptionCtx))
+		input.EncryptionContext = encryptionCtx
+	}
+	keyID, err := p.decryptKeyID(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	if header.KeyARN != "" {
+		keyID = header.KeyARN
+	}
+	if keyID != "" {
+		input.KeyId = aws.String(keyID)
+	}
+	if header.Algorithm != "" {
+		input.EncryptionAlgorithm = kmstypes.EncryptionAlgorithmSpec(header.Algorithm)
 	}
 
+	kmsDone := observePhase(ctx, phaseKMS)
 	result, err := p.svc.Decrypt(ctx, input)
+	kmsDone()
+	if err == nil {
+		err = verifyPlaintextLength(header, result.Plaintext)
+	}
 	if err != nil {
+		if p.partitionErr != nil {
+			err = p.partitionErr.WithCause(err)
+		}
+		err = p.healthCheck.classifyProbe(ctx, err)
 		errorType := kmsplugin.ParseError(err).String()
 		if errorType != kmsplugin.KMSErrorTypeCorruption.String() {
-			select {
-			case p.healthCheck.healthCheckErrc <- err:
-			default:
-			}
+			p.healthCheck.report(err)
 		}
-		zap.L().Error("request to decrypt failed", zap.String("error-type", errorType), zap.Error(err))
+		zap.L().Error("request to decrypt failed", zap.String("error-type", errorType), correlationIDField(ctx), zap.Error(err))
 		failLabel := kmsplugin.GetStatusLabel(err, errorType)
-		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
+		kmsLatencyMetric.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V2).ObserveSince(startTime)
 		kmsOperationCounter.WithLabelValues(p.keyID, failLabel, kmsplugin.OperationDecrypt, GRPC_V2).Inc()
+		kmsFailureCounter.WithLabelValues(p.keyID, kmsplugin.OperationDecrypt, GRPC_V2, errorType).Inc()
 		return nil, fmt.Errorf("failed to decrypt %w", err)
 	}
 
 	zap.L().Debug("decrypt operation successful")
-	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V2).Observe(kmsplugin.GetMillisecondsSince(startTime))
+	kmsLatencyMetric.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V2).ObserveSince(startTime)
 	kmsOperationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess, kmsplugin.OperationDecrypt, GRPC_V2).Inc()
+	p.traffic.recordSuccess(ctx)
+	p.healthCheck.recordSuccess(ctx)
+	observeDecryptedKey(p.keyID, GRPC_V2, result)
 	return &pb.DecryptResponse{Plaintext: result.Plaintext}, nil
 }
 
@@ -242,7 +242,7 @@ func TestEncryptV2(t *testing.T) {
 				c.SetEncryptResp(tc.output, tc.err)
 				c.SetDecryptResp(tc.input, tc.err)
 				sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-				go sharedHealthCheck.Start()
+				sharedHealthCheck.Start()
 				p := NewV2(key, c, nil, sharedHealthCheck)
 				defer func() {
 					sharedHealthCheck.Stop()
@@ -302,7 +302,7 @@ func TestDecryptV2(t *testing.T) {
 		func() {
 			c.SetDecryptResp(tc.output, tc.err)
 			sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-			go sharedHealthCheck.Start()
+			sharedHealthCheck.Start()
 			p := NewV2(key, c, tc.ctx, sharedHealthCheck)
 			defer func() {
 				sharedHealthCheck.Stop()
@@ -355,7 +355,7 @@ func TestHealthV2(t *testing.T) {
 	for idx, entry := range tt {
 		c := &cloud.KMSMock{}
 		sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-		go sharedHealthCheck.Start()
+		sharedHealthCheck.Start()
 		p := NewV2(key, c, nil, sharedHealthCheck)
 		defer func() {
 			sharedHealthCheck.Stop()
@@ -405,7 +405,7 @@ func TestHealthManyRequestsV2(t *testing.T) {
 
 	c := &cloud.KMSMock{}
 	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
-	go sharedHealthCheck.Start()
+	sharedHealthCheck.Start()
 	p := newPluginV2(key, c, nil, sharedHealthCheck)
 	defer func() {
 		sharedHealthCheck.Stop()
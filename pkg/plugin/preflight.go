@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// preflightRetryPeriod is the period the failed preflight checks are retried at, see Preflight
+const preflightRetryPeriod = 2 * time.Second
+
+// errPreflightRoundtrip is returned when the plaintext decrypted by a preflight differs from the encrypted one
+var errPreflightRoundtrip = errors.New("decrypted plaintext differs from the encrypted one")
+
+// Preflight blocks until the plugins are able to serve, so the apiserver never connects to a
+// provider that was never going to work: the credentials resolve (credentials may be nil),
+// KMS "DescribeKey" finds the key of each plugin enabled, and an "Encrypt" then "Decrypt"
+// roundtrip returns the encrypted plaintext, regardless of the health probe modes. The checks
+// are retried until they pass or ctx is done, e.g. while a key policy propagates, and the
+// latest error is returned.
+func Preflight(ctx context.Context, credentials func() error, plugins ...*V2Plugin) error {
+	startTime := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := preflight(ctx, credentials, plugins)
+		if err == nil {
+			zap.L().Info("startup preflight passed", zap.Int("attempts", attempt), zap.Duration("duration", time.Since(startTime)))
+			return nil
+		}
+		zap.L().Warn("startup preflight failed, retrying", zap.Int("attempt", attempt), zap.String("error-type", kmsplugin.ParseError(err).String()), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("startup preflight did not pass within %s after %d attempts: %w", time.Since(startTime).Round(time.Millisecond), attempt, err)
+		case <-time.After(preflightRetryPeriod):
+		}
+	}
+}
+
+func preflight(ctx context.Context, credentials func() error, plugins []*V2Plugin) error {
+	if credentials != nil {
+		if err := credentials(); err != nil {
+			return err
+		}
+	}
+	for _, p := range plugins {
+		if err := p.preflight(ctx); err != nil {
+			return fmt.Errorf("key %s: %w", p.keyID, err)
+		}
+	}
+	return nil
+}
+
+// preflight checks the key of the plugin with KMS, see Preflight
+func (p *V2Plugin) preflight(ctx context.Context) error {
+	if err := describeKeyProbe(ctx, p.svc, p.keyID, p.partitionErr, p.healthCheck, GRPC_V2); err != nil {
+		return err
+	}
+	encResult, err := p.encryptKMS(ctx, &pb.EncryptRequest{Plaintext: healthCheckPlaintext})
+	if err != nil {
+		return err
+	}
+	var decResult *pb.DecryptResponse
+	if kmsplugin.KMSStorageVersion(encResult.Ciphertext[:1]) == kmsplugin.KMSStorageVersionV3 {
+		decResult, err = p.decryptV3(ctx, &pb.DecryptRequest{Ciphertext: encResult.Ciphertext})
+	} else {
+		decResult, err = p.decryptKMS(ctx, &pb.DecryptRequest{Ciphertext: encResult.Ciphertext})
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(decResult.Plaintext, healthCheckPlaintext) {
+		return errPreflightRoundtrip
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestPreflight(t *testing.T) {
+	errCreds := errors.New("no credentials")
+	for _, entry := range []struct {
+		name        string
+		state       kmstypes.KeyState
+		decrypted   string
+		decryptErr  error
+		credentials func() error
+		pass        bool
+		wantErr     error
+	}{
+		{name: "success", state: kmstypes.KeyStateEnabled, decrypted: "foo", pass: true},
+		{name: "credentials", state: kmstypes.KeyStateEnabled, decrypted: "foo", credentials: func() error { return errCreds }, wantErr: errCreds},
+		{name: "disabled key", state: kmstypes.KeyStateDisabled, decrypted: "foo"},
+		{name: "decrypt failure", state: kmstypes.KeyStateEnabled, decryptErr: &kmstypes.KMSInternalException{}},
+		{name: "roundtrip mismatch", state: kmstypes.KeyStateEnabled, decrypted: "bar", wantErr: errPreflightRoundtrip},
+	} {
+		t.Run(entry.name, func(t *testing.T) {
+			c := &describeKeyMock{KMSMock: &cloud.KMSMock{}, state: entry.state}
+			c.SetEncryptResp("foo", nil)
+			c.SetDecryptResp(entry.decrypted, entry.decryptErr)
+			p := NewV2("test-key-preflight", c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err := Preflight(ctx, entry.credentials, p)
+			if entry.pass {
+				if err != nil {
+					t.Fatalf("expected the preflight to pass, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected the preflight to fail")
+			}
+			if entry.wantErr != nil && !errors.Is(err, entry.wantErr) {
+				t.Fatalf("expected %v, got %v", entry.wantErr, err)
+			}
+		})
+	}
+}
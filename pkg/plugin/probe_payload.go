@@ -0,0 +1,92 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+)
+
+const (
+	// MaxProbePayloadSize is the largest plaintext KMS Encrypt accepts, and so the largest
+	// mirrored probe payload, see SharedHealthCheck.SetMirroredProbePayload
+	MaxProbePayloadSize = 4096
+	// probePayloadSamples is the number of latest plaintext sizes the probe payload mirrors
+	probePayloadSamples = 1024
+	// probePayloadQuantile is the quantile of the plaintext sizes the probe payload mirrors
+	probePayloadQuantile = 0.95
+)
+
+// plaintextSizes records the sizes of the latest plaintexts encrypted with KMS in a ring, so
+// the requests record them without locking
+type plaintextSizes struct {
+	next  atomic.Uint64
+	sizes [probePayloadSamples]atomic.Int32
+}
+
+// record records the size of a plaintext, sizes are stored plus one so 0 is no sample
+func (s *plaintextSizes) record(size int) {
+	i := s.next.Add(1) - 1
+	s.sizes[i%probePayloadSamples].Store(int32(min(size, MaxProbePayloadSize)) + 1)
+}
+
+// quantile returns the q quantile of the recorded sizes, false if there is none
+func (s *plaintextSizes) quantile(q float64) (int, bool) {
+	sizes := make([]int, 0, probePayloadSamples)
+	for i := range s.sizes {
+		if size := s.sizes[i].Load(); size > 0 {
+			sizes = append(sizes, int(size-1))
+		}
+	}
+	if len(sizes) == 0 {
+		return 0, false
+	}
+	slices.Sort(sizes)
+	return sizes[int(q*float64(len(sizes)-1))], true
+}
+
+// SetMirroredProbePayload makes the health probes encrypt a payload the size of the 95th
+// percentile of the latest plaintexts encrypted with KMS, at most maxSize bytes, instead of
+// a 3 bytes payload, so the latency and failures of the probes reflect those of the requests,
+// e.g. near the 4096 bytes limit of KMS Encrypt. The probes use the 3 bytes payload until a
+// request is served. 0 disables it, the default. It must be called before Start.
+func (p *SharedHealthCheck) SetMirroredProbePayload(maxSize int) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.probePayloadMax = min(maxSize, MaxProbePayloadSize)
+}
+
+// recordPlaintext records the size of a plaintext encrypted with KMS for the probe payload,
+// unless ctx is the context of a health probe
+func (p *SharedHealthCheck) recordPlaintext(ctx context.Context, plaintext []byte) {
+	if p == nil || p.probePayloadMax <= 0 || ctx.Value(probeContextKey{}) != nil {
+		return
+	}
+	p.plaintextSizes.record(len(plaintext))
+}
+
+// probePlaintext returns the payload encrypted by the health probes, see SetMirroredProbePayload
+func (p *SharedHealthCheck) probePlaintext() []byte {
+	if p == nil || p.probePayloadMax <= 0 {
+		return healthCheckPlaintext
+	}
+	size, ok := p.plaintextSizes.quantile(probePayloadQuantile)
+	if !ok {
+		return healthCheckPlaintext
+	}
+	size = max(min(size, p.probePayloadMax), 1)
+	kmsHealthCheckPayloadSizeMetric.Set(float64(size))
+	return make([]byte, size)
+}
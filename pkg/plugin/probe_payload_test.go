@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestMirroredProbePayload(t *testing.T) {
+	var probed int
+	c := &cloud.KMSMock{}
+	c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+		probed = len(params.Plaintext)
+		return false
+	}, "", nil)
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	h.SetMirroredProbePayload(1024)
+	p := NewV2(key, c, nil, h)
+
+	if err := p.Probe(); err != nil || probed != len(healthCheckPlaintext) {
+		t.Fatalf("expected the default payload before any request, got %d bytes, %v", probed, err)
+	}
+	for _, size := range []int{32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 32, 512} {
+		if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: make([]byte, size), Uid: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Probe(); err != nil || probed != 32 {
+		t.Fatalf("expected the 95th percentile of the plaintext sizes, got %d bytes, %v", probed, err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: make([]byte, 4000), Uid: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Probe(); err != nil || probed != 1024 {
+		t.Fatalf("expected the payload to be bounded, got %d bytes, %v", probed, err)
+	}
+}
+
+func TestPlaintextSizes(t *testing.T) {
+	var s plaintextSizes
+	if _, ok := s.quantile(probePayloadQuantile); ok {
+		t.Fatal("expected no quantile without samples")
+	}
+	for i := 0; i < 2*probePayloadSamples; i++ {
+		s.record(i)
+	}
+	// only the latest samples are kept
+	if got, _ := s.quantile(0); got != probePayloadSamples {
+		t.Fatalf("expected the oldest sample to be %d, got %d", probePayloadSamples, got)
+	}
+	s.record(2 * MaxProbePayloadSize)
+	if got, _ := s.quantile(1); got != MaxProbePayloadSize {
+		t.Fatalf("expected the sizes to be bounded by %d, got %d", MaxProbePayloadSize, got)
+	}
+}
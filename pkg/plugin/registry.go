@@ -0,0 +1,171 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "k8s.io/kms/apis/v2"
+)
+
+var _ pb.KeyManagementServiceServer = &Registry{}
+
+// Registry serves the KMSv2 API of a changing set of plugins on one gRPC server.
+//
+// gRPC services cannot be registered once a server runs, so the registry is
+// registered instead and dispatches every call to its plugins: Encrypt and Status
+// go to the active plugin, the latest one added, and Decrypt goes to the plugin
+// of the request key ID, falling back to the active plugin.
+// All methods are safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	plugins []*registeredPlugin
+}
+
+type registeredPlugin struct {
+	*V2Plugin
+	inflight sync.WaitGroup
+}
+
+// NewRegistry returns a new, empty *Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register registers the registry with the gRPC server
+func (r *Registry) Register(s *grpc.Server) {
+	zap.L().Info("registering the kmsplugin registry with grpc server")
+	pb.RegisterKeyManagementServiceServer(s, r)
+}
+
+// Add adds the plugin, making it the active one.
+// A plugin already added for the same key ID is replaced.
+func (r *Registry) Add(ctx context.Context, p *V2Plugin) error {
+	zap.L().Info("adding plugin to registry", zap.String("key", p.keyID))
+	r.mu.Lock()
+	replaced := r.remove(p.keyID)
+	r.plugins = append(r.plugins, &registeredPlugin{V2Plugin: p})
+	r.mu.Unlock()
+	if replaced != nil {
+		return drain(ctx, replaced)
+	}
+	return nil
+}
+
+// Remove removes the plugin of the key ID and waits until its in-flight calls
+// return, or the context is done. The previously added plugin becomes active.
+func (r *Registry) Remove(ctx context.Context, keyID string) error {
+	zap.L().Info("removing plugin from registry", zap.String("key", keyID))
+	r.mu.Lock()
+	removed := r.remove(keyID)
+	r.mu.Unlock()
+	if removed == nil {
+		return fmt.Errorf("no plugin registered for key %s", keyID)
+	}
+	return drain(ctx, removed)
+}
+
+// KeyIDs returns the key IDs of the registered plugins, the active one last
+func (r *Registry) KeyIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keyIDs := make([]string, 0, len(r.plugins))
+	for _, p := range r.plugins {
+		keyIDs = append(keyIDs, p.keyID)
+	}
+	return keyIDs
+}
+
+// r.mu must be held
+func (r *Registry) remove(keyID string) *registeredPlugin {
+	for i, p := range r.plugins {
+		if p.keyID == keyID {
+			r.plugins = append(r.plugins[:i:i], r.plugins[i+1:]...)
+			return p
+		}
+	}
+	return nil
+}
+
+func drain(ctx context.Context, p *registeredPlugin) error {
+	drained := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		zap.L().Info("drained removed plugin", zap.String("key", p.keyID))
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to drain plugin for key %s: %w", p.keyID, ctx.Err())
+	}
+}
+
+// acquire returns the plugin of the key ID, or the active one if there is none,
+// tracking the call as in-flight until release is called.
+func (r *Registry) acquire(keyID string) (p *registeredPlugin, release func(), err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.plugins) == 0 {
+		return nil, nil, status.Error(codes.Unavailable, "no plugin registered")
+	}
+	p = r.plugins[len(r.plugins)-1]
+	for _, rp := range r.plugins {
+		if keyID != "" && rp.keyID == keyID {
+			p = rp
+			break
+		}
+	}
+	// new calls are only tracked under the read lock, so no call
+	// can start on a plugin once it was removed under the write lock
+	p.inflight.Add(1)
+	return p, p.inflight.Done, nil
+}
+
+// Status returns the status of the active plugin
+func (r *Registry) Status(ctx context.Context, request *pb.StatusRequest) (*pb.StatusResponse, error) {
+	p, release, err := r.acquire("")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.Status(ctx, request)
+}
+
+// Encrypt encrypts with the active plugin
+func (r *Registry) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	p, release, err := r.acquire("")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.Encrypt(ctx, request)
+}
+
+// Decrypt decrypts with the plugin of the request key ID
+func (r *Registry) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	p, release, err := r.acquire(request.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.Decrypt(ctx, request)
+}
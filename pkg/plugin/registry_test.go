@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+// blockingKMSMock blocks Encrypt calls until unblock is closed
+type blockingKMSMock struct {
+	*cloud.KMSMock
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (m *blockingKMSMock) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	m.started <- struct{}{}
+	<-m.unblock
+	return m.KMSMock.Encrypt(ctx, params, optFns...)
+}
+
+func TestRegistry(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	ctx := context.Background()
+
+	r := NewRegistry()
+	if _, err := r.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable error without plugins, got %v", err)
+	}
+
+	c1 := (&cloud.KMSMock{}).SetEncryptResp("foo", nil).SetDecryptResp("from-k1", nil)
+	c2 := (&cloud.KMSMock{}).SetEncryptResp("foo", nil).SetDecryptResp("from-k2", nil)
+	if err := r.Add(ctx, NewV2("k1", c1, nil, sharedHealthCheck)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(ctx, NewV2("k2", c2, nil, sharedHealthCheck)); err != nil {
+		t.Fatal(err)
+	}
+
+	eRes, err := r.Encrypt(ctx, &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eRes.KeyId != "k2" {
+		t.Fatalf("expected encryption with the latest added key k2, got %s", eRes.KeyId)
+	}
+	for keyID, expected := range map[string]string{"k1": "from-k1", "k2": "from-k2", "": "from-k2"} {
+		dRes, err := r.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("1foo"), KeyId: keyID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dRes.Plaintext) != expected {
+			t.Fatalf("key %q: expected %s, got %s", keyID, expected, dRes.Plaintext)
+		}
+	}
+
+	if err := r.Remove(ctx, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove(ctx, "k2"); err == nil {
+		t.Fatal("expected error removing an unknown key")
+	}
+	sRes, err := r.Status(ctx, &pb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sRes.KeyId != "k1" {
+		t.Fatalf("expected k1 to be active again, got %s", sRes.KeyId)
+	}
+}
+
+func TestRegistryRemoveDrains(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	c := &blockingKMSMock{
+		KMSMock: (&cloud.KMSMock{}).SetEncryptResp("foo", nil),
+		started: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+	r := NewRegistry()
+	if err := r.Add(context.Background(), NewV2(key, c, nil, sharedHealthCheck)); err != nil {
+		t.Fatal(err)
+	}
+
+	encErrc := make(chan error, 1)
+	go func() {
+		_, err := r.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+		encErrc <- err
+	}()
+	<-c.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Remove(ctx, key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected removal to wait for the in-flight call, got %v", err)
+	}
+	if _, err := r.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected no new call on a removed plugin, got %v", err)
+	}
+
+	close(c.unblock)
+	if err := <-encErrc; err != nil {
+		t.Fatalf("expected the in-flight call to complete, got %v", err)
+	}
+}
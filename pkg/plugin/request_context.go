@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// RequestEncryptionContextAnnotation records the per-request encryption context an
+// EncryptResponse was encrypted with, as a JSON object, see WithRequestUIDEncryptionContext.
+const RequestEncryptionContextAnnotation = "encryption-context.aws-encryption-provider.sigs.k8s.io"
+
+// WithRequestUIDEncryptionContext adds the UID the apiserver sends with each KMSv2 encrypt
+// request to the KMS encryption context under key, so the KMS Encrypt records of CloudTrail
+// can be correlated to the apiserver logs of the write, and through them to the object.
+//
+// KMS requires the same encryption context on Decrypt, while the apiserver sends a new UID with
+// each request, so the added context is recorded in the RequestEncryptionContextAnnotation of the
+// response and merged back on Decrypt. Ciphertexts without the annotation decrypt as before, so
+// the option can be turned on and off safely. Warming such ciphertexts through the admin API
+// fails, as the annotations are not sent with them.
+//
+// The apiserver does not send object metadata to KMS plugins, the UID identifies the request.
+// In key hierarchy mode KMS is only called when the local key rotates, so there is nothing to correlate.
+func WithRequestUIDEncryptionContext(key string) V2Option {
+	return func(p *V2Plugin) {
+		p.requestUIDContextKey = key
+	}
+}
+
+// requestEncryptionContext returns the encryption context of an encrypt request with the given UID,
+// and the annotation recording the per-request part of it, nil if there is none.
+func (p *V2Plugin) requestEncryptionContext(uid string) (map[string]string, []byte, error) {
+	if p.requestUIDContextKey == "" || uid == "" || !p.writesFeature(FeatureContext) {
+		return p.encryptionCtx, nil, nil
+	}
+	requestCtx := map[string]string{p.requestUIDContextKey: uid}
+	annotation, err := json.Marshal(requestCtx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode request encryption context %w", err)
+	}
+	return mergeEncryptionContext(p.encryptionCtx, requestCtx), annotation, nil
+}
+
+// decryptionContext returns the encryption context to decrypt a ciphertext with the given annotations
+func (p *V2Plugin) decryptionContext(annotations map[string][]byte) (map[string]string, error) {
+	annotation, ok := annotations[RequestEncryptionContextAnnotation]
+	if !ok {
+		return p.encryptionCtx, nil
+	}
+	var requestCtx map[string]string
+	if err := json.Unmarshal(annotation, &requestCtx); err != nil {
+		return nil, fmt.Errorf("failed to decode %s annotation %w", RequestEncryptionContextAnnotation, err)
+	}
+	zap.L().Debug("merging request encryption context", zap.Any("ctx", requestCtx))
+	return mergeEncryptionContext(p.encryptionCtx, requestCtx), nil
+}
+
+// mergeEncryptionContext returns the configured context with the per-request one,
+// the configured context wins so the annotation cannot override it
+func mergeEncryptionContext(configured, request map[string]string) map[string]string {
+	merged := make(map[string]string, len(configured)+len(request))
+	for k, v := range request {
+		merged[k] = v
+	}
+	for k, v := range configured {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestRequestUIDEncryptionContext(t *testing.T) {
+	errContextMismatch := errors.New("encryption context mismatch")
+	hasContext := func(ctx map[string]string) bool {
+		return ctx["cluster"] == "prod" && ctx["k8s-request-uid"] == "uid-1"
+	}
+	c := &cloud.KMSMock{}
+	c.AddEncryptRule(func(params *kms.EncryptInput) bool { return hasContext(params.EncryptionContext) }, "foo", nil)
+	c.AddDecryptRule(func(params *kms.DecryptInput) bool { return hasContext(params.EncryptionContext) }, plainMessage, nil)
+	c.SetEncryptResp("", errContextMismatch)
+	c.SetDecryptResp("", errContextMismatch)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	ctx := context.Background()
+
+	p := NewV2(key, c, map[string]string{"cluster": "prod"}, sharedHealthCheck, WithRequestUIDEncryptionContext("k8s-request-uid"))
+	eRes, err := p.Encrypt(ctx, &pb.EncryptRequest{Uid: "uid-1", Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	if got, want := string(eRes.Annotations[RequestEncryptionContextAnnotation]), `{"k8s-request-uid":"uid-1"}`; got != want {
+		t.Fatalf("expected annotation %s, got %s", want, got)
+	}
+
+	// the apiserver sends another UID on decrypt
+	dRes, err := p.Decrypt(ctx, &pb.DecryptRequest{Uid: "uid-2", Ciphertext: eRes.Ciphertext, KeyId: eRes.KeyId, Annotations: eRes.Annotations})
+	if err != nil {
+		t.Fatalf("unexpected error from Decrypt %v", err)
+	}
+	if string(dRes.Plaintext) != plainMessage {
+		t.Fatalf("expected plaintext %q, got %q", plainMessage, dRes.Plaintext)
+	}
+
+	// the annotation cannot override the configured context
+	overriding := map[string][]byte{RequestEncryptionContextAnnotation: []byte(`{"cluster":"dev","k8s-request-uid":"uid-1"}`)}
+	if _, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("1foo"), KeyId: key, Annotations: overriding}); err != nil {
+		t.Fatalf("unexpected error from Decrypt with overriding annotation %v", err)
+	}
+
+	malformed := map[string][]byte{RequestEncryptionContextAnnotation: []byte("{")}
+	if _, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("1foo"), KeyId: key, Annotations: malformed}); err == nil {
+		t.Fatal("expected an error from Decrypt with a malformed annotation")
+	}
+
+	// without the annotation, only the configured context is sent
+	if _, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: []byte("1foo"), KeyId: key}); !errors.Is(err, errContextMismatch) {
+		t.Fatalf("expected the configured context only, got %v", err)
+	}
+}
+
+// correlationKMSMock records the provider request ID of the last Encrypt call
+type correlationKMSMock struct {
+	*cloud.KMSMock
+	id string
+}
+
+func (m *correlationKMSMock) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	m.id = cloud.CorrelationID(ctx)
+	return m.KMSMock.Encrypt(ctx, params, optFns...)
+}
+
+func TestCorrelationID(t *testing.T) {
+	c := &correlationKMSMock{KMSMock: (&cloud.KMSMock{}).SetEncryptResp("foo", nil)}
+	p := NewV2("test-key-correlation", c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize))
+
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage), Uid: "uid-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.id != "uid-1" {
+		t.Fatalf("expected the request UID as request ID, got %q", c.id)
+	}
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); err != nil {
+		t.Fatal(err)
+	}
+	if c.id == "" || c.id == "uid-1" {
+		t.Fatalf("expected a random request ID without UID, got %q", c.id)
+	}
+}
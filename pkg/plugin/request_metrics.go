@@ -0,0 +1,48 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// request methods of the KMS services, by gRPC full method name
+var requestMethods = map[string]struct{ operation, version string }{
+	"/v1beta1.KeyManagementService/Encrypt": {kmsplugin.OperationEncrypt, GRPC_V1},
+	"/v1beta1.KeyManagementService/Decrypt": {kmsplugin.OperationDecrypt, GRPC_V1},
+	"/v2.KeyManagementService/Encrypt":      {kmsplugin.OperationEncrypt, GRPC_V2},
+	"/v2.KeyManagementService/Decrypt":      {kmsplugin.OperationDecrypt, GRPC_V2},
+}
+
+// RequestMetricsInterceptor returns a gRPC interceptor recording the duration of the Encrypt and
+// Decrypt requests of the apiserver, from receiving them to responding. Unlike the KMS operation
+// durations, it includes the requests served without calling KMS, e.g. in key hierarchy mode,
+// and the time spent in the provider, so it reflects the latency added to each secret write.
+func RequestMetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method, ok := requestMethods[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		startTime := time.Now()
+		resp, err := handler(ctx, req)
+		status := kmsplugin.GetStatusLabel(err, kmsplugin.ParseError(err).String())
+		kmsRequestLatencyMetric.WithLabelValues(method.operation, method.version, status).Observe(time.Since(startTime).Seconds())
+		return resp, err
+	}
+}
@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestRequestMetricsInterceptor(t *testing.T) {
+	interceptor := RequestMetricsInterceptor()
+	handler := func(err error) grpc.UnaryHandler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "resp", err
+		}
+	}
+
+	for _, call := range []struct {
+		method string
+		err    error
+	}{
+		{method: "/v2.KeyManagementService/Encrypt"},
+		{method: "/v2.KeyManagementService/Encrypt"},
+		{method: "/v1beta1.KeyManagementService/Decrypt", err: errors.New("fail")},
+		{method: "/v2.KeyManagementService/Status"},
+	} {
+		resp, err := interceptor(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: call.method}, handler(call.err))
+		if resp != "resp" || !errors.Is(err, call.err) {
+			t.Fatalf("%s: expected the handler response, got %v, %v", call.method, resp, err)
+		}
+	}
+
+	metrics := scrapeMetrics(t)
+	for _, expected := range []string{
+		`aws_encryption_provider_request_duration_seconds_count{operation="encrypt",status="success",version="v2"} 2`,
+		`aws_encryption_provider_request_duration_seconds_count{operation="decrypt",status="failure",version="v1"} 1`,
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s", expected)
+		}
+	}
+	if strings.Contains(metrics, `aws_encryption_provider_request_duration_seconds_count{operation=""`) {
+		t.Error("expected the status requests not to be recorded")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+// request phases, see RequestPhasesStatsHandler
+const (
+	// receiving and decoding the request, encoding and sending the response
+	phaseSerialization = "serialization"
+	// from decoding the request to the start of the handler, e.g. behind other interceptors
+	phaseQueue = "queue"
+	// KMS calls, including the retries and rate limiting of the SDK
+	phaseKMS = "kms"
+	// local encryption and decryption of the key hierarchy
+	phaseCrypto = "crypto"
+	// the rest of the handler, e.g. transformers, annotations and memory budget
+	phaseProvider = "provider"
+)
+
+type requestPhasesKey struct{}
+
+// requestPhases tracks the lifecycle of a request
+type requestPhases struct {
+	operation, version string
+	begin              time.Time
+
+	mu           sync.Mutex
+	decoded      time.Time
+	handlerStart time.Time
+	handlerEnd   time.Time
+	kms          time.Duration
+	crypto       time.Duration
+	done         bool
+}
+
+// requestPhasesFrom returns the phases of the request of ctx, nil if not tracked
+func requestPhasesFrom(ctx context.Context) *requestPhases {
+	phases, _ := ctx.Value(requestPhasesKey{}).(*requestPhases)
+	return phases
+}
+
+// observePhase starts timing phase (phaseKMS or phaseCrypto) of the request of ctx,
+// the returned function stops it
+func observePhase(ctx context.Context, phase string) func() {
+	phases := requestPhasesFrom(ctx)
+	if phases == nil {
+		return func() {}
+	}
+	startTime := time.Now()
+	return func() {
+		d := time.Since(startTime)
+		phases.mu.Lock()
+		defer phases.mu.Unlock()
+		switch phase {
+		case phaseKMS:
+			phases.kms += d
+		case phaseCrypto:
+			phases.crypto += d
+		}
+	}
+}
+
+func (r *requestPhases) markHandler(start bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if start {
+		r.handlerStart = time.Now()
+	} else {
+		r.handlerEnd = time.Now()
+	}
+}
+
+// observe records the phases once the response is sent
+func (r *requestPhases) observe(end time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done || r.handlerStart.IsZero() || r.handlerEnd.IsZero() {
+		// rejected before reaching the handler
+		return
+	}
+	r.done = true
+	decoded := r.decoded
+	if decoded.IsZero() {
+		decoded = r.handlerStart
+	}
+	handler := r.handlerEnd.Sub(r.handlerStart)
+	for phase, d := range map[string]time.Duration{
+		phaseSerialization: decoded.Sub(r.begin) + end.Sub(r.handlerEnd),
+		phaseQueue:         r.handlerStart.Sub(decoded),
+		phaseKMS:           r.kms,
+		phaseCrypto:        r.crypto,
+		phaseProvider:      max(handler-r.kms-r.crypto, 0),
+	} {
+		kmsRequestPhaseLatencyMetric.WithLabelValues(r.operation, r.version, phase).Observe(d.Seconds())
+	}
+}
+
+// RequestPhasesStatsHandler returns a gRPC stats handler breaking the duration of the Encrypt and
+// Decrypt requests down into phases (serialization, queue, kms, crypto and provider), so latency
+// regressions can be attributed to the transport, KMS or the provider itself.
+// It requires RequestPhasesInterceptor to time the handler.
+func RequestPhasesStatsHandler() stats.Handler {
+	return requestPhasesHandler{}
+}
+
+type requestPhasesHandler struct{}
+
+func (requestPhasesHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	method, ok := requestMethods[info.FullMethodName]
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, requestPhasesKey{}, &requestPhases{
+		operation: method.operation,
+		version:   method.version,
+		begin:     time.Now(),
+	})
+}
+
+func (requestPhasesHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	phases := requestPhasesFrom(ctx)
+	if phases == nil {
+		return
+	}
+	switch s := s.(type) {
+	case *stats.InPayload:
+		phases.mu.Lock()
+		phases.decoded = s.RecvTime
+		phases.mu.Unlock()
+	case *stats.End:
+		phases.observe(s.EndTime)
+	}
+}
+
+func (requestPhasesHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (requestPhasesHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// RequestPhasesInterceptor returns a gRPC interceptor timing the handler for RequestPhasesStatsHandler,
+// it should be the last interceptor of the chain so waiting in the others counts as queueing.
+func RequestPhasesInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		phases := requestPhasesFrom(ctx)
+		phases.markHandler(true)
+		defer phases.markHandler(false)
+		return handler(ctx, req)
+	}
+}
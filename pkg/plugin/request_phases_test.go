@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestRequestPhases(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	s := grpc.NewServer(
+		grpc.StatsHandler(RequestPhasesStatsHandler()),
+		grpc.ChainUnaryInterceptor(RequestPhasesInterceptor()),
+	)
+	NewV2(key, c, nil, sharedHealthCheck).Register(s)
+	sock := filepath.Join(t.TempDir(), "kms.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l) //nolint:errcheck
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("unix://"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	client := pb.NewKeyManagementServiceClient(conn)
+	for i := 0; i < 3; i++ {
+		if _, err := client.Encrypt(context.Background(), &pb.EncryptRequest{Uid: "uid", Plaintext: []byte("hello")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Status is not broken down
+	if _, err := client.Status(context.Background(), &pb.StatusRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	s.GracefulStop()
+
+	metrics := scrapeMetrics(t)
+	for _, phase := range []string{phaseSerialization, phaseQueue, phaseKMS, phaseCrypto, phaseProvider} {
+		expected := fmt.Sprintf(`aws_encryption_provider_request_phase_duration_seconds_count{operation="encrypt",phase="%s",version="v2"} 3`, phase)
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("expected %s", expected)
+		}
+	}
+	if strings.Contains(metrics, `aws_encryption_provider_request_phase_duration_seconds_count{operation=""`) {
+		t.Error("expected the status requests not to be broken down")
+	}
+}
+
+func TestObservePhase(t *testing.T) {
+	// untracked requests, e.g. health checks, are ignored
+	observePhase(context.Background(), phaseKMS)()
+
+	phases := &requestPhases{}
+	ctx := context.WithValue(context.Background(), requestPhasesKey{}, phases)
+	observePhase(ctx, phaseKMS)()
+	observePhase(ctx, phaseKMS)()
+	observePhase(ctx, phaseCrypto)()
+	if phases.kms <= 0 || phases.crypto <= 0 {
+		t.Fatalf("expected kms and crypto time, got %v, %v", phases.kms, phases.crypto)
+	}
+}
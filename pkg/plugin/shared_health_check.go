@@ -1,75 +1,431 @@
 package plugin
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	smithy "github.com/aws/smithy-go"
 	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
 )
 
-// TODO: make configurable
 const (
+	// DefaultHealthCheckPeriod is the period the result of a health check is reused for
 	DefaultHealthCheckPeriod = 30 * time.Second
-	DefaultErrcBufSize       = 100
+	// DefaultErrcBufSize is the number of request errors queued for the health check routine,
+	// the errors of requests failing beyond it are dropped
+	DefaultErrcBufSize = 100
+	// DefaultRecoveryProbePeriod is the period of recovery probes, see SetRecoveryProbes
+	DefaultRecoveryProbePeriod = 10 * time.Second
+	// DefaultIdleHealthCheckPeriod is the health check period of an idle provider, see SetIdleHealthChecks
+	DefaultIdleHealthCheckPeriod = 5 * time.Minute
 )
 
+// healthCheckPlaintext is the payload encrypted by health checks, shared so
+// probes don't allocate it. It must not be modified.
+var healthCheckPlaintext = []byte("foo")
+
+// SharedHealthCheckState is the lifecycle state of a SharedHealthCheck routine
+type SharedHealthCheckState int
+
+const (
+	SharedHealthCheckIdle = SharedHealthCheckState(iota)
+	SharedHealthCheckRunning
+	SharedHealthCheckStopped
+)
+
+func (s SharedHealthCheckState) String() string {
+	switch s {
+	case SharedHealthCheckIdle:
+		return "idle"
+	case SharedHealthCheckRunning:
+		return "running"
+	case SharedHealthCheckStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// SharedHealthCheck caches the latest KMS error reported by the plugins sharing it.
+// It must be started with Start and stopped with Stop, both are safe to call
+// multiple times and concurrently. A stopped SharedHealthCheck cannot be restarted.
+//
+// The health of KMS is kept in an immutable healthSnapshot, replaced as a whole:
+// readers load it without locking and always see the error, timestamps and state
+// of the same check, and a snapshot stored by a writer happens before any load
+// returning it. Writers serialize on recordMu and discard results observed before
+// the current snapshot, so the error of a request queued on healthCheckErrc can't
+// override a more recent health check.
 type SharedHealthCheck struct {
-	lastMu  sync.RWMutex
-	lastErr error
-	lastTs  time.Time
+	health   atomic.Pointer[healthSnapshot]
+	recordMu sync.Mutex
+
+	// unix nanoseconds of the latest Encrypt or Decrypt request, see SetIdleHealthChecks
+	lastRequest atomic.Int64
+	idle        atomic.Bool
+	// successful KMS requests since the latest recorded result, see SetFailureThreshold
+	successes atomic.Int64
+
+	stateMu sync.Mutex
+	state   SharedHealthCheckState
+	started bool
 
 	healthCheckPeriod         time.Duration
-	healthCheckErrc           chan error
+	recoveryProbePeriod       time.Duration
+	throttleTolerance         time.Duration
+	idleAfter                 time.Duration
+	idleHealthCheckPeriod     time.Duration
+	recoveryProbes            []func() error
+	probeErrorTypes           map[string]kmsplugin.KMSErrorType
+	dryRunProbes              bool
+	describeKeyProbes         bool
+	trafficAware              bool
+	threshold                 failureThreshold
+	probePayloadMax           int
+	plaintextSizes            plaintextSizes
+	healthCheckErrc           chan healthCheckResult
 	healthCheckStopcCloseOnce *sync.Once
 	healthCheckStopc          chan struct{}
 	healthCheckClosed         chan struct{}
 }
 
+// healthSnapshot is the health of KMS after a check, never modified once stored
+type healthSnapshot struct {
+	err error
+	ts  time.Time
+	// KMS asked not to retry before, see kmsplugin.RetryAfter
+	retryAfterTs time.Time
+	// state is entered at stateTs, see HealthState
+	state   HealthState
+	stateTs time.Time
+	// err is below the failure threshold, see SetFailureThreshold
+	suppressed bool
+}
+
+// healthCheckResult is the error of a request, observed at ts
+type healthCheckResult struct {
+	err error
+	ts  time.Time
+}
+
+// NewSharedHealthCheck returns a new, not yet started *SharedHealthCheck
 func NewSharedHealthCheck(
 	checkPeriod time.Duration,
 	errcBuf int,
 ) *SharedHealthCheck {
 	p := &SharedHealthCheck{
 		healthCheckPeriod:         checkPeriod,
-		healthCheckErrc:           make(chan error, errcBuf),
+		healthCheckErrc:           make(chan healthCheckResult, errcBuf),
 		healthCheckStopcCloseOnce: new(sync.Once),
 		healthCheckStopc:          make(chan struct{}),
 		healthCheckClosed:         make(chan struct{}),
 	}
+	p.health.Store(&healthSnapshot{})
+	p.lastRequest.Store(time.Now().UnixNano())
+	setHealthStateMetric(HealthStateHealthy)
 	return p
 }
 
-func (p *SharedHealthCheck) Start() {
+// Start runs the health check routine in the background and returns
+// a function stopping it, equivalent to Stop.
+// Only the first call starts the routine, later calls are no-ops.
+func (p *SharedHealthCheck) Start() (stop func()) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if p.state != SharedHealthCheckIdle {
+		zap.L().Debug("health check routine already started", zap.Stringer("state", p.state))
+		return p.Stop
+	}
+	p.state, p.started = SharedHealthCheckRunning, true
+	go p.run()
+	return p.Stop
+}
+
+// SetRecoveryProbes makes the health check routine run the probes every period while the
+// latest error is user-induced (e.g. the key was disabled or a grant was missing), and clear
+// the error as soon as they all succeed, instead of waiting for requests to rediscover health.
+// The probes are usually V1Plugin.Probe or V2Plugin.Probe. It must be called before Start.
+func (p *SharedHealthCheck) SetRecoveryProbes(period time.Duration, probes ...func() error) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.recoveryProbePeriod, p.recoveryProbes = period, probes
+}
+
+// SetThrottleTolerance makes health checks only fail on throttling once KMS was throttling for
+// longer than window, e.g. for AWS managed keys whose request quota is shared with other
+// services of the account, where brief spikes are common. 0 disables the tolerance, the
+// default. It must be called before Start.
+func (p *SharedHealthCheck) SetThrottleTolerance(window time.Duration) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.throttleTolerance = window
+}
+
+// SetProbeErrorTypes classifies the KMS errors of the health probes with the given codes, e.g.
+// {"AccessDeniedException": user-induced}, instead of as the errors of the requests, as a probe
+// can fail while the requests work and vice versa, e.g. with an encryption context only allowed
+// for the requests. The errors of the requests are not affected. It must be called before Start.
+func (p *SharedHealthCheck) SetProbeErrorTypes(types map[string]kmsplugin.KMSErrorType) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.probeErrorTypes = types
+}
+
+type probeContextKey struct{}
+
+// probeContext returns the context of the KMS calls of a health probe
+func probeContext() context.Context {
+	return context.WithValue(context.Background(), probeContextKey{}, true)
+}
+
+// classifyProbe returns err classified by SetProbeErrorTypes if ctx is the context of a health probe
+func (p *SharedHealthCheck) classifyProbe(ctx context.Context, err error) error {
+	if p == nil || len(p.probeErrorTypes) == 0 || ctx.Value(probeContextKey{}) == nil {
+		return err
+	}
+	var ae smithy.APIError
+	if !errors.As(err, &ae) {
+		return err
+	}
+	if t, ok := p.probeErrorTypes[ae.ErrorCode()]; ok {
+		return &kmsplugin.ClassifiedError{Type: t, Err: err}
+	}
+	return err
+}
+
+// SetDryRunProbes makes the health probes call KMS "Encrypt" with DryRun, so they check the
+// permissions and state of the key without encrypting anything. KMS answers a dry run that
+// would have succeeded with a DryRunOperationException, which passes the probe. The probes of
+// the KMSv2 plugin then skip "Decrypt", having no ciphertext. It must be called before Start.
+func (p *SharedHealthCheck) SetDryRunProbes(enabled bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.dryRunProbes = enabled
+}
+
+// SetDescribeKeyProbes makes the health probes call KMS "DescribeKey" instead of "Encrypt" and
+// "Decrypt", so they never count against the request quota of the cryptographic operations and
+// only check the credentials and the state of the key: a key which is not enabled fails them as
+// user-induced. It takes precedence over SetDryRunProbes. It must be called before Start.
+func (p *SharedHealthCheck) SetDescribeKeyProbes(enabled bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.describeKeyProbes = enabled
+}
+
+// describeKey returns true if the health probes call "DescribeKey"
+func (p *SharedHealthCheck) describeKey() bool {
+	return p != nil && p.describeKeyProbes
+}
+
+// dryRun returns true if ctx is the context of a health probe to run with DryRun
+func (p *SharedHealthCheck) dryRun(ctx context.Context) bool {
+	return p != nil && p.dryRunProbes && !p.describeKeyProbes && ctx.Value(probeContextKey{}) != nil
+}
+
+// dryRunErr returns nil if err is the DryRunOperationException of a dry run which would
+// have succeeded, err otherwise
+func dryRunErr(err error) error {
+	var dre *kmstypes.DryRunOperationException
+	if errors.As(err, &dre) {
+		return nil
+	}
+	return err
+}
+
+// SetIdleHealthChecks stretches the health check period to idlePeriod once no Encrypt or Decrypt
+// request was served for idleAfter, e.g. to cut the baseline KMS cost of large fleets of mostly idle
+// clusters, whose health is otherwise only evaluated by the probes. The period is only stretched
+// while KMS is healthy, and is restored by the next request. 0 disables it, the default.
+// It must be called before Start.
+func (p *SharedHealthCheck) SetIdleHealthChecks(idleAfter, idlePeriod time.Duration) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.idleAfter, p.idleHealthCheckPeriod = idleAfter, idlePeriod
+}
+
+// recordRequest marks the provider as active
+func (p *SharedHealthCheck) recordRequest() {
+	p.lastRequest.Store(time.Now().UnixNano())
+}
+
+// checkPeriod returns the health check period, stretched while idle and healthy
+func (p *SharedHealthCheck) checkPeriod(lastErr error) time.Duration {
+	if p.idleAfter <= 0 || p.idleHealthCheckPeriod <= p.healthCheckPeriod {
+		return p.healthCheckPeriod
+	}
+	idleFor := time.Since(time.Unix(0, p.lastRequest.Load()))
+	idle := lastErr == nil && idleFor >= p.idleAfter
+	if p.idle.Swap(idle) != idle {
+		if idle {
+			zap.L().Info("no requests, reducing the health check frequency", zap.Duration("idle-for", idleFor), zap.Duration("period", p.idleHealthCheckPeriod))
+			kmsHealthCheckIdleMetric.Set(1)
+		} else {
+			zap.L().Info("restoring the health check frequency", zap.Duration("period", p.healthCheckPeriod))
+			kmsHealthCheckIdleMetric.Set(0)
+		}
+	}
+	if idle {
+		return p.idleHealthCheckPeriod
+	}
+	return p.healthCheckPeriod
+}
+
+func (p *SharedHealthCheck) run() {
 	zap.L().Info("starting health check routine", zap.String("period", p.healthCheckPeriod.String()))
+	defer close(p.healthCheckClosed)
+
+	var recoveryc <-chan time.Time
+	if p.recoveryProbePeriod > 0 && len(p.recoveryProbes) > 0 {
+		ticker := time.NewTicker(p.recoveryProbePeriod)
+		defer ticker.Stop()
+		recoveryc = ticker.C
+	}
 	for {
 		select {
 		case <-p.healthCheckStopc:
 			zap.L().Warn("exiting health check routine")
-			p.healthCheckClosed <- struct{}{}
 			return
-		case err := <-p.healthCheckErrc:
-			p.recordErr(err)
+		case r := <-p.healthCheckErrc:
+			p.record(r.err, r.ts)
+		case <-recoveryc:
+			p.probeRecovery()
 		}
 	}
 }
 
+// probeRecovery runs the recovery probes in the HealthStateFailedUserInduced state
+func (p *SharedHealthCheck) probeRecovery() {
+	h := p.health.Load()
+	lastErr := h.err
+	if h.state != HealthStateFailedUserInduced {
+		return
+	}
+	var err error
+	for _, probe := range p.recoveryProbes {
+		if err = probe(); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		kmsRecoveryProbeCounter.WithLabelValues(kmsplugin.StatusFailure).Inc()
+		zap.L().Debug("recovery probe failed", zap.Error(err))
+	} else {
+		kmsRecoveryProbeCounter.WithLabelValues(kmsplugin.StatusSuccess).Inc()
+		zap.L().Info("recovery probe succeeded, clearing the user-induced health check error", zap.Error(lastErr))
+	}
+	p.recordErr(err)
+}
+
+// Stop stops the health check routine and waits for it to exit.
 func (p *SharedHealthCheck) Stop() {
+	p.stateMu.Lock()
+	started := p.started
+	p.state = SharedHealthCheckStopped
+	p.stateMu.Unlock()
+
 	p.healthCheckStopcCloseOnce.Do(func() {
 		close(p.healthCheckStopc)
-		<-p.healthCheckClosed
 	})
+	if started {
+		<-p.healthCheckClosed
+	}
 }
 
+// HealthState returns the current KMS health state
+func (p *SharedHealthCheck) HealthState() HealthState {
+	return p.health.Load().state
+}
+
+// State returns the lifecycle state of the health check routine
+func (p *SharedHealthCheck) State() SharedHealthCheckState {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return p.state
+}
+
+// isRecentlyChecked also reports a throttled result as recent until the
+// "Retry-After" delay requested by KMS has passed, so health checks don't
+// add load to a throttled KMS.
 func (p *SharedHealthCheck) isRecentlyChecked() (bool, error) {
-	p.lastMu.RLock()
-	err, ts := p.lastErr, p.lastTs
-	never, latest := err == nil && ts.IsZero(), time.Since(ts) < p.healthCheckPeriod
-	p.lastMu.RUnlock()
-	return !never && latest, err
+	h := p.health.Load()
+	never, latest := h.err == nil && h.ts.IsZero(), time.Since(h.ts) < p.checkPeriod(h.err) || time.Now().Before(h.retryAfterTs)
+	return !never && latest, h.err
+}
+
+// report queues the error of a request for the health check routine, dropping it if the
+// queue is full so requests never block on health checks
+func (p *SharedHealthCheck) report(err error) {
+	select {
+	case p.healthCheckErrc <- healthCheckResult{err: err, ts: time.Now()}:
+	default:
+	}
 }
 
+// recordErr records the result of a health check observed now
 func (p *SharedHealthCheck) recordErr(err error) {
-	p.lastMu.Lock()
-	p.lastErr, p.lastTs = err, time.Now()
-	p.lastMu.Unlock()
+	p.record(err, time.Now())
+}
+
+// record stores the snapshot following err, observed at ts, unless a more recent result
+// was already recorded
+func (p *SharedHealthCheck) record(err error, ts time.Time) {
+	var retryAfterTs time.Time
+	if d, ok := kmsplugin.RetryAfter(err); ok {
+		retryAfterTs = ts.Add(d)
+		zap.L().Warn("KMS requested to retry later", zap.Duration("retry-after", d))
+	}
+	p.recordMu.Lock()
+	defer p.recordMu.Unlock()
+	cur := p.health.Load()
+	if ts.Before(cur.ts) {
+		zap.L().Debug("discarding a health check result older than the latest", zap.Time("observed", ts), zap.Time("latest", cur.ts), zap.Error(err))
+		return
+	}
+	next := &healthSnapshot{err: err, ts: ts, retryAfterTs: retryAfterTs, state: cur.state, stateTs: cur.stateTs}
+	if p.suppressFailure(cur.state, err, ts) {
+		next.suppressed = true
+	} else if state := cur.state.next(err); state != cur.state || cur.stateTs.IsZero() {
+		if state != cur.state {
+			zap.L().Info("KMS health state changed", zap.Stringer("from", cur.state), zap.Stringer("to", state), zap.Error(err))
+			kmsHealthStateTransitionCounter.WithLabelValues(cur.state.String(), state.String()).Inc()
+			setHealthStateMetric(state)
+		}
+		next.state, next.stateTs = state, ts
+	}
+	p.health.Store(next)
+}
+
+// tolerate returns nil for a throttled err while KMS has been HealthStateDegraded for less
+// than the throttle tolerance window, see SetThrottleTolerance, and for an err below the
+// failure threshold, see SetFailureThreshold
+func (p *SharedHealthCheck) tolerate(err error) error {
+	if err != nil && p.health.Load().suppressed {
+		return nil
+	}
+	if p.throttleTolerance <= 0 || kmsplugin.ParseError(err) != kmsplugin.KMSErrorTypeThrottled {
+		return err
+	}
+	h := p.health.Load()
+	state, since := h.state, h.stateTs
+	if state != HealthStateDegraded || time.Since(since) >= p.throttleTolerance {
+		return err
+	}
+	zap.L().Debug("tolerating KMS throttling in health check", zap.Time("degraded-since", since), zap.Error(err))
+	return nil
+}
+
+func setHealthStateMetric(current HealthState) {
+	for _, s := range healthStates {
+		v := 0.0
+		if s == current {
+			v = 1
+		}
+		kmsHealthStateMetric.WithLabelValues(s.String()).Set(v)
+	}
 }
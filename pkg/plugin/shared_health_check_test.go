@@ -0,0 +1,479 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
+)
+
+func TestSharedHealthCheckLifecycle(t *testing.T) {
+	ptesting.VerifyNoGoroutineLeaks(t)
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	if s := h.State(); s != SharedHealthCheckIdle {
+		t.Fatalf("expected state %s, got %s", SharedHealthCheckIdle, s)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Start()
+		}()
+	}
+	wg.Wait()
+	if s := h.State(); s != SharedHealthCheckRunning {
+		t.Fatalf("expected state %s, got %s", SharedHealthCheckRunning, s)
+	}
+
+	h.report(errors.New("fail"))
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := h.isRecentlyChecked(); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("took too long to record the error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stop := h.Start()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop()
+		}()
+	}
+	wg.Wait()
+	h.Stop()
+	if s := h.State(); s != SharedHealthCheckStopped {
+		t.Fatalf("expected state %s, got %s", SharedHealthCheckStopped, s)
+	}
+
+	// a stopped health check cannot be restarted
+	h.Start()
+	if s := h.State(); s != SharedHealthCheckStopped {
+		t.Fatalf("expected state %s, got %s", SharedHealthCheckStopped, s)
+	}
+}
+
+func TestSharedHealthCheckStopBeforeStart(t *testing.T) {
+	ptesting.VerifyNoGoroutineLeaks(t)
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+
+	done := make(chan struct{})
+	go func() {
+		h.Stop()
+		h.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked on a health check that was never started")
+	}
+	if s := h.State(); s != SharedHealthCheckStopped {
+		t.Fatalf("expected state %s, got %s", SharedHealthCheckStopped, s)
+	}
+}
+
+func TestSharedHealthCheckRetryAfter(t *testing.T) {
+	h := NewSharedHealthCheck(time.Nanosecond, DefaultErrcBufSize)
+
+	h.recordErr(errors.New("fail"))
+	time.Sleep(time.Millisecond)
+	if recent, _ := h.isRecentlyChecked(); recent {
+		t.Fatal("expected error without retry-after to expire with the check period")
+	}
+
+	h.recordErr(&smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"60"}},
+		}},
+		Err: errors.New("throttled"),
+	})
+	time.Sleep(time.Millisecond)
+	recent, err := h.isRecentlyChecked()
+	if !recent || err == nil {
+		t.Fatalf("expected throttled error to be reused until retry-after, got recent=%v err=%v", recent, err)
+	}
+}
+
+func TestSharedHealthCheckRecoveryProbes(t *testing.T) {
+	ptesting.VerifyNoGoroutineLeaks(t)
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	var probes atomic.Int32
+	var recovered atomic.Bool
+	h.SetRecoveryProbes(10*time.Millisecond, func() error {
+		probes.Add(1)
+		if !recovered.Load() {
+			return &kmstypes.DisabledException{Message: aws.String("still disabled")}
+		}
+		return nil
+	})
+	defer h.Start()()
+
+	// not user-induced, no recovery probes
+	h.report(&kmstypes.KMSInternalException{Message: aws.String("test")})
+	time.Sleep(100 * time.Millisecond)
+	if n := probes.Load(); n != 0 {
+		t.Fatalf("expected no recovery probe, got %d", n)
+	}
+
+	h.report(&kmstypes.DisabledException{Message: aws.String("test")})
+	deadline := time.Now().Add(2 * time.Second)
+	for probes.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("took too long to run recovery probes")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := h.isRecentlyChecked(); err == nil {
+		t.Fatal("expected the error to be kept while recovery probes fail")
+	}
+
+	recovered.Store(true)
+	for {
+		if _, err := h.isRecentlyChecked(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("took too long to clear the user-induced error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if recent, _ := h.isRecentlyChecked(); !recent {
+		t.Fatal("expected the recovery to be a recent health check")
+	}
+}
+
+func TestSharedHealthCheckThrottleTolerance(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "test"}
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("", throttled)
+	h := NewSharedHealthCheck(time.Nanosecond, DefaultErrcBufSize)
+	h.SetThrottleTolerance(50 * time.Millisecond)
+	p := NewV2(key, c, nil, h)
+
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected brief throttling to be tolerated, got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := p.Health(); err == nil {
+		t.Fatal("expected sustained throttling to fail the health check")
+	}
+
+	// other errors are not tolerated and end the throttling window
+	c.SetEncryptResp("", &kmstypes.KMSInternalException{Message: aws.String("test")})
+	if err := p.Health(); err == nil {
+		t.Fatal("expected internal errors to fail the health check")
+	}
+	c.SetEncryptResp("", throttled)
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected throttling to be tolerated again, got %v", err)
+	}
+}
+
+func TestSharedHealthCheckFailureThreshold(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("", &kmstypes.KMSInternalException{Message: aws.String("test")})
+	h := NewSharedHealthCheck(time.Nanosecond, DefaultErrcBufSize)
+	h.SetFailureThreshold(3, 3, 0)
+	p := NewV2(key, c, nil, h)
+
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected a failure below the threshold to pass, got %v", err)
+	}
+	if err := p.Live(); err != nil {
+		t.Fatalf("expected a failure below the threshold to be live, got %v", err)
+	}
+	if s := h.HealthState(); s != HealthStateHealthy {
+		t.Fatalf("expected %s below the threshold, got %s", HealthStateHealthy, s)
+	}
+	if err := p.Health(); err == nil {
+		t.Fatal("expected the third consecutive failure to fail the health check")
+	}
+	if s := h.HealthState(); s != HealthStateFailedInfra {
+		t.Fatalf("expected %s, got %s", HealthStateFailedInfra, s)
+	}
+
+	// failures after reaching the threshold are not suppressed until the plugin recovers
+	if err := p.Health(); err == nil {
+		t.Fatal("expected failures to keep failing the health check")
+	}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected a success, got %v", err)
+	}
+	c.SetEncryptResp("", &kmstypes.KMSInternalException{Message: aws.String("test")})
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected a failure after the success to be below the threshold again, got %v", err)
+	}
+	if s := h.HealthState(); s != HealthStateRecovering {
+		t.Fatalf("expected %s below the threshold, got %s", HealthStateRecovering, s)
+	}
+}
+
+func TestSharedHealthCheckFailureThresholdRequests(t *testing.T) {
+	c := &cloud.KMSMock{}
+	h := NewSharedHealthCheck(time.Nanosecond, DefaultErrcBufSize)
+	h.SetFailureThreshold(2, 2, 0)
+	p := NewV2(key, c, nil, h)
+	fail := func() {
+		c.SetEncryptResp("", &kmstypes.KMSInternalException{Message: aws.String("test")})
+		if err := p.Health(); err != nil {
+			t.Fatalf("expected the failure to be below the threshold, got %v", err)
+		}
+	}
+
+	// errors scattered among successful requests are not consecutive
+	for i := 0; i < 3; i++ {
+		fail()
+		c.SetEncryptResp("foo", nil)
+		if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); err != nil {
+			t.Fatalf("#%d: unexpected error from Encrypt %v", i, err)
+		}
+	}
+	if s := h.HealthState(); s != HealthStateHealthy {
+		t.Fatalf("expected %s, got %s", HealthStateHealthy, s)
+	}
+	fail()
+	if err := p.Health(); err == nil {
+		t.Fatal("expected the second consecutive failure to fail the health check")
+	}
+}
+
+func TestFailureThreshold(t *testing.T) {
+	failed, now := errors.New("test"), time.Now()
+	for _, entry := range []struct {
+		name    string
+		th      failureThreshold
+		results []error
+		// successful requests before each result
+		successes int64
+		gaps      time.Duration
+		below     bool
+	}{
+		{name: "disabled", th: failureThreshold{failures: 1, results: 1}, results: []error{failed}},
+		{name: "consecutive", th: failureThreshold{failures: 2, results: 2}, results: []error{failed, failed}},
+		{name: "success in between", th: failureThreshold{failures: 2, results: 2}, results: []error{failed, nil, failed}, below: true},
+		{name: "request successes in between", th: failureThreshold{failures: 2, results: 2}, results: []error{failed, failed}, successes: 1, below: true},
+		{name: "m of k", th: failureThreshold{failures: 2, results: 3}, results: []error{failed, nil, failed}},
+		{name: "m of k expired", th: failureThreshold{failures: 2, results: 3}, results: []error{failed, nil, nil, failed}, below: true},
+		{name: "within window", th: failureThreshold{failures: 2, results: 2, window: time.Minute}, results: []error{failed, failed}, gaps: time.Second},
+		{name: "outside window", th: failureThreshold{failures: 2, results: 2, window: time.Minute}, results: []error{failed, failed}, gaps: time.Hour, below: true},
+	} {
+		t.Run(entry.name, func(t *testing.T) {
+			var below bool
+			for i, err := range entry.results {
+				below = entry.th.belowThreshold(err, entry.successes, now.Add(time.Duration(i)*entry.gaps))
+			}
+			if below != entry.below {
+				t.Fatalf("expected below %v, got %v", entry.below, below)
+			}
+		})
+	}
+}
+
+func TestSharedHealthCheckIdle(t *testing.T) {
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	h := NewSharedHealthCheck(20*time.Millisecond, DefaultErrcBufSize)
+	h.SetIdleHealthChecks(100*time.Millisecond, time.Hour)
+	p := NewV2(key, c, nil, h)
+
+	probes := func() int32 { return c.decryptCalls.Load() }
+	if err := p.Health(); err != nil || probes() != 1 {
+		t.Fatalf("expected a first probe, got %d probes, %v", probes(), err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := p.Health(); err != nil || probes() != 2 {
+		t.Fatalf("expected a probe every period while active, got %d probes, %v", probes(), err)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	if err := p.Health(); err != nil || probes() != 2 {
+		t.Fatalf("expected no probe while idle, got %d probes, %v", probes(), err)
+	}
+	if !strings.Contains(scrapeMetrics(t), "aws_encryption_provider_kms_health_check_idle 1") {
+		t.Error("expected the idle gauge to be set")
+	}
+
+	// the next request restores the period
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Health(); err != nil || probes() != 3 {
+		t.Fatalf("expected a probe once active again, got %d probes, %v", probes(), err)
+	}
+	if !strings.Contains(scrapeMetrics(t), "aws_encryption_provider_kms_health_check_idle 0") {
+		t.Error("expected the idle gauge to be reset")
+	}
+}
+
+func TestSharedHealthCheckTrafficAware(t *testing.T) {
+	c := &countingKMSMock{KMSMock: &cloud.KMSMock{}}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	h := NewSharedHealthCheck(20*time.Millisecond, DefaultErrcBufSize)
+	h.SetTrafficAwareChecks(true)
+	p := NewV2("test-key-traffic-aware", c, nil, h)
+	other := NewV2("test-key-traffic-aware-other", c, nil, h)
+
+	probes := func() int32 { return c.decryptCalls.Load() }
+	if err := p.Health(); err != nil || probes() != 1 {
+		t.Fatalf("expected a first probe, got %d probes, %v", probes(), err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Health(); err != nil || probes() != 1 {
+		t.Fatalf("expected no probe after a successful request, got %d probes, %v", probes(), err)
+	}
+	if !strings.Contains(scrapeMetrics(t), `aws_encryption_provider_kms_health_checks_skipped_total{key_arn="test-key-traffic-aware"} 1`) {
+		t.Error("expected the skipped health check to be counted")
+	}
+	// the requests of a key do not vouch for another key
+	if err := other.Health(); err != nil || probes() != 2 {
+		t.Fatalf("expected a probe of the other key, got %d probes, %v", probes(), err)
+	}
+
+	// the successes older than the period do not skip the probes
+	time.Sleep(30 * time.Millisecond)
+	if err := p.Health(); err != nil || probes() != 3 {
+		t.Fatalf("expected a probe once the requests are older than the period, got %d probes, %v", probes(), err)
+	}
+}
+
+func BenchmarkV1PluginHealthCached(b *testing.B) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	p := New(key, c, nil, NewSharedHealthCheck(time.Hour, DefaultErrcBufSize))
+	if err := p.Health(); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Health(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkV2PluginHealthCached(b *testing.B) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	p := NewV2(key, c, nil, NewSharedHealthCheck(time.Hour, DefaultErrcBufSize))
+	if err := p.Health(); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Health(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkV2PluginHealthProbe(b *testing.B) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	// a zero period never reuses the last result, so every call probes KMS
+	p := NewV2(key, c, map[string]string{"a": "b"}, NewSharedHealthCheck(0, DefaultErrcBufSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Health(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkV1PluginHealthProbe(b *testing.B) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	p := New(key, c, map[string]string{"a": "b"}, NewSharedHealthCheck(0, DefaultErrcBufSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Health(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSharedHealthCheckStaleResult(t *testing.T) {
+	h := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	observed := time.Now()
+	h.recordErr(nil)
+	// e.g. the error of a request queued before the health check
+	h.record(&kmstypes.KMSInternalException{Message: aws.String("test")}, observed)
+	if _, err := h.isRecentlyChecked(); err != nil || h.HealthState() != HealthStateHealthy {
+		t.Fatalf("expected the older error to be discarded, got %v in state %s", err, h.HealthState())
+	}
+}
+
+func TestStressV2(t *testing.T) {
+	ptesting.VerifyNoGoroutineLeaks(t)
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	// no check period, so every Health call probes KMS
+	h := NewSharedHealthCheck(0, DefaultErrcBufSize)
+	defer h.Start()()
+	p := NewV2(key, c, nil, h)
+
+	// flip KMS between healthy and failing while the plugin is hammered
+	ctx, cancel := context.WithCancel(context.Background())
+	flipped := make(chan struct{})
+	go func() {
+		defer close(flipped)
+		for i := 0; ctx.Err() == nil; i++ {
+			var err error
+			if i%2 == 0 {
+				err = &kmstypes.KMSInternalException{Message: aws.String("test")}
+			}
+			c.SetEncryptResp("foo", err)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	res := StressV2(context.Background(), p, StressConfig{Workers: 4, Requests: 50})
+	cancel()
+	<-flipped
+	if res.Encrypts != 200 || res.Decrypts != 200 || res.Healths != 200 {
+		t.Fatalf("expected 200 calls of each, got %+v", res)
+	}
+
+	// the errors queued during the stress test don't override the latest health check
+	c.SetEncryptResp("foo", nil)
+	if err := p.Health(); err != nil {
+		t.Fatalf("expected a healthy KMS, got %v", err)
+	}
+	h.Stop()
+	if _, err := h.isRecentlyChecked(); err != nil {
+		t.Fatalf("expected the latest health check to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+)
+
+// WithStatusCache caches the Status response, refreshed every interval by RunStatusCache.
+//
+// The apiserver polls Status frequently, with the cache the response is returned right away
+// while it is refreshed in the background, so at most one health evaluation runs per interval.
+// Only the first calls wait for it, or the calls once the response is older than two intervals,
+// e.g. if RunStatusCache is not running.
+func WithStatusCache(interval time.Duration) V2Option {
+	return func(p *V2Plugin) {
+		p.statusCache = &statusCache{interval: interval}
+	}
+}
+
+// RunStatusCache refreshes the cached Status response every interval until stopc is closed,
+// see WithStatusCache. It returns right away if the cache is not enabled.
+func (p *V2Plugin) RunStatusCache(stopc <-chan struct{}) {
+	if p.statusCache == nil {
+		return
+	}
+	p.statusCache.run(p.status, stopc)
+}
+
+type statusCache struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	resp      *pb.StatusResponse
+	updatedAt time.Time
+}
+
+// run refreshes the cached response with fetch every interval until stopc is closed
+func (c *statusCache) run(fetch func() *pb.StatusResponse, stopc <-chan struct{}) {
+	zap.L().Info("starting status cache routine", zap.Duration("interval", c.interval))
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		zap.L().Debug("refreshing cached status")
+		resp := fetch()
+		c.mu.Lock()
+		c.resp, c.updatedAt = resp, time.Now()
+		c.mu.Unlock()
+		select {
+		case <-stopc:
+			zap.L().Warn("exiting status cache routine")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cachedStatus returns the cached response, evaluating it with fetch if there is none yet or
+// it is older than two intervals. The concurrent calls wait for the same evaluation.
+func (c *statusCache) cachedStatus(fetch func() *pb.StatusResponse) *pb.StatusResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resp == nil || time.Since(c.updatedAt) >= 2*c.interval {
+		zap.L().Debug("evaluating uncached status")
+		c.resp, c.updatedAt = fetch(), time.Now()
+	}
+	return c.resp
+}
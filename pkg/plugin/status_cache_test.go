@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestStatusCache(t *testing.T) {
+	c := &statusCache{interval: 50 * time.Millisecond}
+	var calls atomic.Int32
+	fetch := func() *pb.StatusResponse {
+		n := calls.Add(1)
+		healthz := "ok"
+		if n > 1 {
+			healthz = "err"
+		}
+		return &pb.StatusResponse{Healthz: healthz}
+	}
+
+	for i := 0; i < 10; i++ {
+		if resp := c.cachedStatus(fetch); resp.Healthz != "ok" {
+			t.Fatalf("#%d: expected cached status ok, got %s", i, resp.Healthz)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected 1 status evaluation, got %d", n)
+	}
+
+	// without refresh routine, a status older than two intervals is evaluated again
+	time.Sleep(110 * time.Millisecond)
+	if resp := c.cachedStatus(fetch); resp.Healthz != "err" {
+		t.Fatalf("expected the expired status to be evaluated again, got %s", resp.Healthz)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected 2 status evaluations, got %d", n)
+	}
+}
+
+func TestStatusCacheRefresh(t *testing.T) {
+	c := &statusCache{interval: 20 * time.Millisecond}
+	var healthz atomic.Value
+	healthz.Store("ok")
+	fetch := func() *pb.StatusResponse {
+		return &pb.StatusResponse{Healthz: healthz.Load().(string)}
+	}
+	stopc := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.run(fetch, stopc)
+	}()
+	defer func() {
+		close(stopc)
+		<-done
+	}()
+
+	// the status is refreshed in the background, without calls
+	healthz.Store("err")
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		resp := c.resp
+		c.mu.Unlock()
+		if resp != nil && resp.Healthz == "err" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("took too long to refresh the status")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStatusCacheConcurrentFirstCalls(t *testing.T) {
+	c := &statusCache{interval: time.Minute}
+	var calls atomic.Int32
+	unblock := make(chan struct{})
+	fetch := func() *pb.StatusResponse {
+		calls.Add(1)
+		<-unblock
+		return &pb.StatusResponse{Healthz: "ok"}
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := c.cachedStatus(fetch); resp.Healthz != "ok" {
+				t.Errorf("expected status ok, got %s", resp.Healthz)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected the first calls to wait for 1 status evaluation, got %d", n)
+	}
+}
+
+func TestStatusWithCache(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	p := NewV2(key, c, nil, sharedHealthCheck, WithStatusCache(time.Minute))
+	resp, err := p.Status(context.Background(), &pb.StatusRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if resp.Healthz != "ok" || resp.KeyId != key || resp.Version != "v2beta1" {
+		t.Fatalf("unexpected status %+v", resp)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
+)
+
+// WithStorageVersionV3 writes the ciphertexts encrypted directly with KMS with the structured
+// kmsplugin.KMSStorageVersionV3 header, recording the key ARN, algorithm and provider version,
+// so they are decrypted with the key they were encrypted with. Only enable it once every
+// provider of the cluster can decrypt them; they stay decryptable once disabled.
+// Key hierarchy ciphertexts are not affected.
+func WithStorageVersionV3() V2Option {
+	return func(p *V2Plugin) {
+		p.storageVersionV3 = true
+	}
+}
+
+// storageHeader returns the header of a ciphertext encrypted by result from a plaintext of
+// plaintextLength bytes
+func (p *V2Plugin) storageHeader(result *kms.EncryptOutput, plaintextLength int) kmsplugin.Header {
+	h := kmsplugin.Header{
+		KeyARN:          aws.ToString(result.KeyId),
+		Algorithm:       string(result.EncryptionAlgorithm),
+		ProviderVersion: version.Version,
+		PlaintextLength: plaintextLength,
+	}
+	if h.KeyARN == "" {
+		h.KeyARN = p.reportedKeyID()
+	}
+	if h.Algorithm == "" {
+		h.Algorithm = string(kmstypes.EncryptionAlgorithmSpecSymmetricDefault)
+	}
+	return h
+}
+
+// decryptV3 decrypts a kmsplugin.KMSStorageVersionV3 ciphertext with the key and algorithm of its header
+func (p *V2Plugin) decryptV3(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	header, payload, err := kmsplugin.DecodeHeader(request.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	request.Ciphertext = payload
+	return p.decryptKMSBlob(ctx, request, header)
+}
+
+// verifyPlaintextLength returns a corruption error if the plaintext decrypted by KMS does not
+// have the length recorded in the header, e.g. truncated by a bug or a KMS-side anomaly, so it
+// is never handed back to the apiserver
+func verifyPlaintextLength(header kmsplugin.Header, plaintext []byte) error {
+	if header.PlaintextLength == 0 || len(plaintext) == header.PlaintextLength {
+		return nil
+	}
+	return &kmsplugin.ClassifiedError{
+		Type: kmsplugin.KMSErrorTypeCorruption,
+		Err:  fmt.Errorf("decrypted %d bytes of plaintext, the storage version header records %d", len(plaintext), header.PlaintextLength),
+	}
+}
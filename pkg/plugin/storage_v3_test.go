@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func TestStorageVersionV3(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp(encryptedMessage, nil)
+	c.SetDecryptResp("", errUnknownDecryptKey)
+	c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+		return aws.ToString(params.KeyId) == "test-key-v3" &&
+			params.EncryptionAlgorithm == "SYMMETRIC_DEFAULT" &&
+			string(params.CiphertextBlob) == encryptedMessage
+	}, plainMessage, nil)
+	// the probes encrypt their own plaintext, the header records its length
+	c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+		return string(params.Plaintext) == string(healthCheckPlaintext)
+	}, "probe", nil)
+	c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+		return string(params.CiphertextBlob) == "probe"
+	}, string(healthCheckPlaintext), nil)
+	p := NewV2("test-key-v3", c, nil, sharedHealthCheck, WithStorageVersionV3())
+	if err := p.Probe(); err != nil {
+		t.Fatalf("unexpected health probe error %v", err)
+	}
+
+	resp, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, payload, err := kmsplugin.DecodeHeader(resp.Ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyARN != "test-key-v3" || h.Algorithm != "SYMMETRIC_DEFAULT" || h.PlaintextLength != len(plainMessage) || string(payload) != encryptedMessage {
+		t.Fatalf("unexpected header %+v and payload %q", h, payload)
+	}
+
+	// decryptable without the option, with the key of the header
+	for _, d := range []*V2Plugin{p, NewV2("test-key-other", c, nil, sharedHealthCheck)} {
+		dec, err := d.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: resp.Ciphertext, KeyId: resp.KeyId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(dec.Plaintext) != plainMessage {
+			t.Fatalf("expected %q, got %q", plainMessage, dec.Plaintext)
+		}
+	}
+}
+
+func TestStorageVersionV3PlaintextLength(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp(encryptedMessage, nil)
+	c.SetDecryptResp(plainMessage[:len(plainMessage)-1], nil)
+	p := NewV2("test-key-v3-truncated", c, nil, NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize), WithStorageVersionV3())
+
+	resp, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: resp.Ciphertext, KeyId: resp.KeyId})
+	if kmsplugin.ParseError(err) != kmsplugin.KMSErrorTypeCorruption {
+		t.Fatalf("expected a truncated plaintext to be a corruption error, got %v", err)
+	}
+
+	// the headers without a plaintext length are not verified
+	ciphertext := kmsplugin.EncodeHeader(kmsplugin.Header{KeyARN: "test-key-v3-truncated"}, []byte(encryptedMessage))
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: ciphertext, KeyId: resp.KeyId}); err != nil {
+		t.Fatalf("expected no verification without a plaintext length, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	pbv1 "k8s.io/kms/apis/v1beta1"
+	pb "k8s.io/kms/apis/v2"
+)
+
+// StressConfig configures StressV1 and StressV2
+type StressConfig struct {
+	// Workers is the number of goroutines calling each of Encrypt, Decrypt and Health, 4 if unset
+	Workers int
+	// Requests is the number of calls of each goroutine, 100 if unset
+	Requests int
+}
+
+// StressResult counts the calls of a stress test and their failures
+type StressResult struct {
+	Encrypts      int64
+	EncryptErrors int64
+	Decrypts      int64
+	DecryptErrors int64
+	Healths       int64
+	HealthErrors  int64
+}
+
+// StressV1 hammers Encrypt, Decrypt and Health of p concurrently, e.g. under the race
+// detector while the KMS responses of a mock change, to check that the interleaving of
+// the recording of errors and the health reads is safe. It returns once all the calls
+// are done or ctx is done.
+func StressV1(ctx context.Context, p *V1Plugin, cfg StressConfig) StressResult {
+	return stress(ctx, cfg,
+		func(ctx context.Context) ([]byte, error) {
+			//nolint:staticcheck
+			resp, err := p.Encrypt(ctx, &pbv1.EncryptRequest{Plain: healthCheckPlaintext})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Cipher, nil
+		},
+		func(ctx context.Context, ciphertext []byte) error {
+			//nolint:staticcheck
+			_, err := p.Decrypt(ctx, &pbv1.DecryptRequest{Cipher: ciphertext})
+			return err
+		},
+		p.Health,
+	)
+}
+
+// StressV2 is StressV1 for a V2Plugin
+func StressV2(ctx context.Context, p *V2Plugin, cfg StressConfig) StressResult {
+	return stress(ctx, cfg,
+		func(ctx context.Context) ([]byte, error) {
+			resp, err := p.Encrypt(ctx, &pb.EncryptRequest{Plaintext: healthCheckPlaintext, Uid: "stress"})
+			if err != nil {
+				return nil, err
+			}
+			return resp.Ciphertext, nil
+		},
+		func(ctx context.Context, ciphertext []byte) error {
+			_, err := p.Decrypt(ctx, &pb.DecryptRequest{Ciphertext: ciphertext, Uid: "stress", KeyId: p.keyID})
+			return err
+		},
+		p.Health,
+	)
+}
+
+// stress runs the workers, decrypting the latest successfully encrypted ciphertext
+func stress(ctx context.Context, cfg StressConfig, encrypt func(context.Context) ([]byte, error), decrypt func(context.Context, []byte) error, health func() error) StressResult {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Requests <= 0 {
+		cfg.Requests = 100
+	}
+
+	var (
+		wg         sync.WaitGroup
+		ciphertext atomic.Pointer[[]byte]
+		counts     [6]atomic.Int64
+	)
+	placeholder := []byte("stress")
+	ciphertext.Store(&placeholder)
+	run := func(calls, errors *atomic.Int64, call func() error) {
+		defer wg.Done()
+		for i := 0; i < cfg.Requests && ctx.Err() == nil; i++ {
+			calls.Add(1)
+			if call() != nil {
+				errors.Add(1)
+			}
+		}
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(3)
+		go run(&counts[0], &counts[1], func() error {
+			c, err := encrypt(ctx)
+			if err == nil {
+				ciphertext.Store(&c)
+			}
+			return err
+		})
+		go run(&counts[2], &counts[3], func() error {
+			return decrypt(ctx, *ciphertext.Load())
+		})
+		go run(&counts[4], &counts[5], health)
+	}
+	wg.Wait()
+
+	return StressResult{
+		Encrypts:      counts[0].Load(),
+		EncryptErrors: counts[1].Load(),
+		Decrypts:      counts[2].Load(),
+		DecryptErrors: counts[3].Load(),
+		Healths:       counts[4].Load(),
+		HealthErrors:  counts[5].Load(),
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+// span attributes of the requests, see tracing.UnaryServerInterceptor
+const (
+	attrKeyARN  = attribute.Key("aws.kms.key_arn")
+	attrVersion = attribute.Key("aws.kms.plugin_version")
+)
+
+// annotateSpan adds the key and version of the plugin serving the request to its span,
+// a no-op if the request is not traced
+func annotateSpan(ctx context.Context, keyID, version string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(attrKeyARN.String(keyID), attrVersion.String(version))
+}
+
+// withCorrelationID returns ctx carrying the provider request ID of a request, the UID the
+// apiserver sent with it if any, sent with its KMS calls, see cloud.WithCorrelationIDs
+func withCorrelationID(ctx context.Context, uid string) context.Context {
+	if cloud.CorrelationID(ctx) != "" {
+		return ctx
+	}
+	if uid == "" {
+		uid = cloud.NewCorrelationID()
+	}
+	return cloud.ContextWithCorrelationID(ctx, uid)
+}
+
+// correlationIDField is the log field of the provider request ID of ctx
+func correlationIDField(ctx context.Context) zap.Field {
+	return zap.String("request-id", cloud.CorrelationID(ctx))
+}
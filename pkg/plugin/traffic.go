@@ -0,0 +1,66 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// traffic records the latest successful KMS request of a plugin, so its health checks can
+// be skipped while the requests show KMS works, see SharedHealthCheck.SetTrafficAwareChecks
+type traffic struct {
+	// unix nanoseconds of the latest successful KMS request, 0 if none
+	lastSuccess atomic.Int64
+}
+
+// recordSuccess records a successful KMS request, unless ctx is the context of a health probe
+func (t *traffic) recordSuccess(ctx context.Context) {
+	if ctx.Value(probeContextKey{}) != nil {
+		return
+	}
+	t.lastSuccess.Store(time.Now().UnixNano())
+}
+
+// SetTrafficAwareChecks skips the health checks of a plugin whose KMS requests succeeded within
+// the health check period and after the latest recorded error, so the health checks only call
+// KMS while the plugin is idle. The successes are tracked per plugin, as those of a key do not
+// prove another key works. It must be called before Start.
+func (p *SharedHealthCheck) SetTrafficAwareChecks(enabled bool) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.trafficAware = enabled
+}
+
+// servedRecently returns true if the health check of the plugin with traffic t can be skipped,
+// see SetTrafficAwareChecks
+func (p *SharedHealthCheck) servedRecently(keyID string, t *traffic) bool {
+	if !p.trafficAware {
+		return false
+	}
+	ts := t.lastSuccess.Load()
+	if ts == 0 {
+		return false
+	}
+	lastSuccess := time.Unix(0, ts)
+	if time.Since(lastSuccess) >= p.healthCheckPeriod || !lastSuccess.After(p.health.Load().ts) {
+		return false
+	}
+	zap.L().Debug("skipping health check, KMS requests succeeded recently", zap.String("key", keyID), zap.Time("last-success", lastSuccess))
+	kmsHealthCheckSkippedCounter.WithLabelValues(keyID).Inc()
+	return true
+}
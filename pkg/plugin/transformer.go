@@ -0,0 +1,60 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// Transformer transforms the payloads of the V2Plugin, e.g. to compress them,
+// add authenticated data or convert between formats.
+//
+// A Transformer must stay able to read everything it ever wrote: a payload
+// written with a transformer cannot be decrypted once it is removed.
+type Transformer interface {
+	// TransformToStorage is applied to the plaintext before it is encrypted
+	TransformToStorage(ctx context.Context, plaintext []byte) ([]byte, error)
+	// TransformFromStorage is applied to the plaintext after it is decrypted,
+	// and must undo TransformToStorage
+	TransformFromStorage(ctx context.Context, plaintext []byte) ([]byte, error)
+}
+
+// WithTransformers applies the transformers in order before encryption,
+// and in reverse order after decryption. Health checks are not transformed.
+func WithTransformers(transformers ...Transformer) V2Option {
+	return func(p *V2Plugin) {
+		p.transformers = append(p.transformers, transformers...)
+	}
+}
+
+func (p *V2Plugin) transformToStorage(ctx context.Context, plaintext []byte) ([]byte, error) {
+	for i, t := range p.transformers {
+		var err error
+		if plaintext, err = t.TransformToStorage(ctx, plaintext); err != nil {
+			return nil, fmt.Errorf("transformer #%d failed to transform plaintext to storage %w", i, err)
+		}
+	}
+	return plaintext, nil
+}
+
+func (p *V2Plugin) transformFromStorage(ctx context.Context, plaintext []byte) ([]byte, error) {
+	for i := len(p.transformers) - 1; i >= 0; i-- {
+		var err error
+		if plaintext, err = p.transformers[i].TransformFromStorage(ctx, plaintext); err != nil {
+			return nil, fmt.Errorf("transformer #%d failed to transform plaintext from storage %w", i, err)
+		}
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+// echoKMSMock returns the plaintext as ciphertext, to observe what is encrypted
+type echoKMSMock struct {
+	*cloud.KMSMock
+}
+
+func (m *echoKMSMock) Encrypt(ctx context.Context, params *kms.EncryptInput, optFns ...func(*kms.Options)) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: params.Plaintext}, nil
+}
+
+func (m *echoKMSMock) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: params.CiphertextBlob}, nil
+}
+
+type wrapTransformer struct {
+	prefix, suffix string
+}
+
+func (t wrapTransformer) TransformToStorage(ctx context.Context, plaintext []byte) ([]byte, error) {
+	return []byte(t.prefix + string(plaintext) + t.suffix), nil
+}
+
+func (t wrapTransformer) TransformFromStorage(ctx context.Context, plaintext []byte) ([]byte, error) {
+	s, ok := bytes.CutPrefix(plaintext, []byte(t.prefix))
+	if !ok {
+		return nil, errors.New("missing prefix")
+	}
+	s, ok = bytes.CutSuffix(s, []byte(t.suffix))
+	if !ok {
+		return nil, errors.New("missing suffix")
+	}
+	return s, nil
+}
+
+func TestTransformers(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	p := NewV2(key, &echoKMSMock{KMSMock: &cloud.KMSMock{}}, nil, sharedHealthCheck,
+		WithTransformers(wrapTransformer{prefix: "a(", suffix: ")"}, wrapTransformer{prefix: "b(", suffix: ")"}))
+
+	request := &pb.EncryptRequest{Plaintext: []byte(plainMessage)}
+	eRes, err := p.Encrypt(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(request.Plaintext) != plainMessage {
+		t.Fatalf("expected the request to be left unchanged, got %q", request.Plaintext)
+	}
+	// transformers apply in order before encryption
+	if expected := "1b(a(" + plainMessage + "))"; string(eRes.Ciphertext) != expected {
+		t.Fatalf("expected ciphertext %q, got %q", expected, eRes.Ciphertext)
+	}
+
+	dRes, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: eRes.Ciphertext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dRes.Plaintext) != plainMessage {
+		t.Fatalf("expected %q, got %q", plainMessage, dRes.Plaintext)
+	}
+
+	if _, err := p.Decrypt(context.Background(), &pb.DecryptRequest{Ciphertext: []byte("1" + plainMessage)}); err == nil {
+		t.Fatal("expected error decrypting a payload the transformers cannot read")
+	}
+}
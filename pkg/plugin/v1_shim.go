@@ -0,0 +1,104 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	pb "k8s.io/kms/apis/v1beta1"
+	pbv2 "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/version"
+)
+
+var _ pb.KeyManagementServiceServer = &V1Shim{}
+
+var errEmptyCipher = errors.New("empty cipher")
+
+// V1Shim answers KMS v1beta1 requests with a V2Plugin, so apiservers of both API versions,
+// e.g. during an upgrade, are served by the same implementation, caches and options.
+//
+// Both versions share the ciphertext format, so ciphertexts written by the V1Plugin decrypt
+// through the shim and conversely. v1beta1 has no annotations, so ciphertexts encrypted through
+// the shim carry none, which the V2Plugin options relying on them tolerate. The V1Plugin does not
+// transform its payloads, so neither does the shim, whatever the transformers. With the key hierarchy
+// or WithClusterFeatures enabled, the ciphertexts encrypted through the shim can only be decrypted
+// by a V2Plugin.
+type V1Shim struct {
+	p *V2Plugin
+}
+
+// NewV1Shim returns a new *V1Shim serving v1beta1 requests with p
+func NewV1Shim(p *V2Plugin) *V1Shim {
+	return &V1Shim{p: p}
+}
+
+// Version returns the same version as the V1Plugin
+//
+//nolint:staticcheck
+func (s *V1Shim) Version(ctx context.Context, request *pb.VersionRequest) (*pb.VersionResponse, error) {
+	//nolint:staticcheck
+	return &pb.VersionResponse{
+		Version:        version.APIVersion,
+		RuntimeName:    version.Runtime,
+		RuntimeVersion: version.Version,
+	}, nil
+}
+
+// Encrypt encrypts with the V2Plugin without transformers, dropping the annotations of the response
+//
+//nolint:staticcheck
+func (s *V1Shim) Encrypt(ctx context.Context, request *pb.EncryptRequest) (*pb.EncryptResponse, error) {
+	resp, err := s.p.encrypt(ctx, &pbv2.EncryptRequest{Plaintext: request.Plain}, false)
+	if err != nil {
+		return nil, err
+	}
+	//nolint:staticcheck
+	return &pb.EncryptResponse{Cipher: resp.Ciphertext}, nil
+}
+
+// Decrypt decrypts with the V2Plugin. Like the V1Plugin, it also accepts
+// ciphertexts without the kmsplugin.StorageVersion prefix.
+//
+//nolint:staticcheck
+func (s *V1Shim) Decrypt(ctx context.Context, request *pb.DecryptRequest) (*pb.DecryptResponse, error) {
+	if len(request.Cipher) == 0 {
+		return nil, errEmptyCipher
+	}
+	ciphertext := request.Cipher
+	switch kmsplugin.KMSStorageVersion(ciphertext[0]) {
+	case kmsplugin.KMSStorageVersionV2, kmsplugin.KMSStorageVersionV2KeyHierarchy, kmsplugin.KMSStorageVersionV2Features, kmsplugin.KMSStorageVersionV3:
+	default:
+		// ciphertexts of other providers are decrypted by the V2Plugin
+		if !s.p.compatPrefixes.matches(ciphertext) {
+			ciphertext = append([]byte(kmsplugin.StorageVersion), ciphertext...)
+		}
+	}
+	// like the ciphertexts of the V1Plugin, the ciphertexts of the shim are not transformed
+	resp, err := s.p.decrypt(ctx, &pbv2.DecryptRequest{Ciphertext: ciphertext, KeyId: s.p.keyID}, false)
+	if err != nil {
+		return nil, err
+	}
+	//nolint:staticcheck
+	return &pb.DecryptResponse{Plain: resp.Plaintext}, nil
+}
+
+// Register registers the V1Shim with the grpc server, instead of a V1Plugin
+func (s *V1Shim) Register(server *grpc.Server) {
+	zap.L().Info("registering the kmsplugin v1 shim with grpc server")
+	pb.RegisterKeyManagementServiceServer(server, s)
+}
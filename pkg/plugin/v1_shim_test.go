@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	pb "k8s.io/kms/apis/v1beta1"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestV1Shim(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+		return string(params.CiphertextBlob) == "foo"
+	}, plainMessage, nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	ctx := context.Background()
+
+	shim := NewV1Shim(NewV2(key, c, nil, sharedHealthCheck, WithIdentityAssertion()))
+	v1 := New(key, c, nil, sharedHealthCheck)
+
+	//nolint:staticcheck
+	eRes, err := shim.Encrypt(ctx, &pb.EncryptRequest{Plain: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	if string(eRes.Cipher) != "1foo" {
+		t.Fatalf("expected the shared ciphertext format %q, got %q", "1foo", eRes.Cipher)
+	}
+	//nolint:staticcheck
+	v1Res, err := v1.Encrypt(ctx, &pb.EncryptRequest{Plain: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from V1Plugin Encrypt %v", err)
+	}
+
+	for name, cipher := range map[string][]byte{
+		"shim":       eRes.Cipher,
+		"v1 plugin":  v1Res.Cipher,
+		"unprefixed": []byte("foo"),
+	} {
+		//nolint:staticcheck
+		dRes, err := shim.Decrypt(ctx, &pb.DecryptRequest{Cipher: append([]byte(nil), cipher...)})
+		if err != nil {
+			t.Fatalf("%s: unexpected error from Decrypt %v", name, err)
+		}
+		if string(dRes.Plain) != plainMessage {
+			t.Fatalf("%s: expected plaintext %q, got %q", name, plainMessage, dRes.Plain)
+		}
+	}
+	//nolint:staticcheck
+	if dRes, err := v1.Decrypt(ctx, &pb.DecryptRequest{Cipher: eRes.Cipher}); err != nil || string(dRes.Plain) != plainMessage {
+		t.Fatalf("expected the V1Plugin to decrypt the shim ciphertext, got %v", err)
+	}
+	//nolint:staticcheck
+	if _, err := shim.Decrypt(ctx, &pb.DecryptRequest{}); err == nil {
+		t.Fatal("expected an error decrypting an empty cipher")
+	}
+}
+
+func TestV1ShimTransformers(t *testing.T) {
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("", errors.New("unexpected plaintext"))
+	c.AddEncryptRule(func(params *kms.EncryptInput) bool {
+		return string(params.Plaintext) == plainMessage
+	}, "foo", nil)
+	c.AddDecryptRule(func(params *kms.DecryptInput) bool {
+		return string(params.CiphertextBlob) == "foo"
+	}, plainMessage, nil)
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	ctx := context.Background()
+
+	// the V1Plugin ciphertexts are never transformed, so neither are the shim ones
+	shim := NewV1Shim(NewV2(key, c, nil, sharedHealthCheck, WithTransformers(wrapTransformer{prefix: "a(", suffix: ")"})))
+	//nolint:staticcheck
+	eRes, err := shim.Encrypt(ctx, &pb.EncryptRequest{Plain: []byte(plainMessage)})
+	if err != nil {
+		t.Fatalf("unexpected error from Encrypt %v", err)
+	}
+	if string(eRes.Cipher) != "1foo" {
+		t.Fatalf("expected the untransformed plaintext to be encrypted, got %q", eRes.Cipher)
+	}
+
+	for name, cipher := range map[string][]byte{
+		"shim":       eRes.Cipher,
+		"v1 plugin":  []byte("1foo"),
+		"unprefixed": []byte("foo"),
+	} {
+		//nolint:staticcheck
+		dRes, err := shim.Decrypt(ctx, &pb.DecryptRequest{Cipher: cipher})
+		if err != nil {
+			t.Fatalf("%s: unexpected error from Decrypt %v", name, err)
+		}
+		if string(dRes.Plain) != plainMessage {
+			t.Fatalf("%s: expected plaintext %q, got %q", name, plainMessage, dRes.Plain)
+		}
+	}
+}
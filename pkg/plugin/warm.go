@@ -0,0 +1,63 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+var errEmptyCiphertext = errors.New("empty ciphertext")
+
+// Warm prepares the plugin to decrypt the ciphertext without returning its plaintext,
+// e.g. before traffic is sent to a restored apiserver.
+//
+// The KEK of a key hierarchy ciphertext is decrypted and cached. Other ciphertexts
+// are decrypted through KMS to check they can be, as nothing is cached for them.
+func (p *V2Plugin) Warm(ctx context.Context, ciphertext []byte) error {
+	if len(ciphertext) == 0 {
+		return errEmptyCiphertext
+	}
+	switch storageVersion := kmsplugin.KMSStorageVersion(ciphertext[0]); storageVersion {
+	case kmsplugin.KMSStorageVersionV2:
+		_, err := p.decryptKMS(ctx, &pb.DecryptRequest{Ciphertext: ciphertext})
+		return err
+	case kmsplugin.KMSStorageVersionV3:
+		_, err := p.decryptV3(ctx, &pb.DecryptRequest{Ciphertext: ciphertext})
+		return err
+	case kmsplugin.KMSStorageVersionV2Features:
+		_, inner, err := parseFeaturesHeader(ciphertext)
+		if err != nil {
+			return err
+		}
+		return p.Warm(ctx, inner)
+	case kmsplugin.KMSStorageVersionV2KeyHierarchy:
+		if len(ciphertext) < 3 {
+			return errMalformedKeyHierarchyCiphertext
+		}
+		kekLen := int(binary.BigEndian.Uint16(ciphertext[1:3]))
+		if len(ciphertext) < 3+kekLen {
+			return errMalformedKeyHierarchyCiphertext
+		}
+		_, err := p.decryptKEK(ctx, ciphertext[3:3+kekLen])
+		return err
+	default:
+		return fmt.Errorf("version %s in Ciphertext doesn't match kmsplugin", storageVersion)
+	}
+}
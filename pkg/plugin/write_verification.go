@@ -0,0 +1,91 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+const (
+	// maximum number of verifications running concurrently, samples are skipped beyond it
+	maxConcurrentVerifications = 4
+	verificationTimeout        = 30 * time.Second
+
+	verificationStatusMismatch = "mismatch"
+	verificationStatusSkipped  = "skipped"
+)
+
+// WithWriteVerification verifies, until the given time, that a sample of the
+// newly encrypted ciphertexts decrypt back to their plaintext, e.g. while rotating
+// to a new key and before the old one is scheduled for deletion.
+// The verification runs in the background, it does not delay nor fail Encrypt.
+// sampleRate is the fraction of Encrypt calls verified, between 0 and 1.
+func WithWriteVerification(until time.Time, sampleRate float64) V2Option {
+	return func(p *V2Plugin) {
+		p.writeVerification = &writeVerification{
+			until:      until,
+			sampleRate: sampleRate,
+			sem:        make(chan struct{}, maxConcurrentVerifications),
+		}
+	}
+}
+
+type writeVerification struct {
+	until      time.Time
+	sampleRate float64
+	sem        chan struct{}
+}
+
+// verifyWrite decrypts a sample of the ciphertexts in the background, transformed if they
+// were written transformed
+func (p *V2Plugin) verifyWrite(plaintext []byte, resp *pb.EncryptResponse, transformed bool) {
+	wv := p.writeVerification
+	if wv == nil || time.Now().After(wv.until) || rand.Float64() >= wv.sampleRate {
+		return
+	}
+	select {
+	case wv.sem <- struct{}{}:
+	default:
+		kmsWriteVerificationCounter.WithLabelValues(p.keyID, verificationStatusSkipped).Inc()
+		return
+	}
+
+	plaintext = bytes.Clone(plaintext)
+	request := &pb.DecryptRequest{Ciphertext: bytes.Clone(resp.Ciphertext), KeyId: resp.KeyId, Annotations: maps.Clone(resp.Annotations)}
+	go func() {
+		defer func() { <-wv.sem }()
+		ctx, cancel := context.WithTimeout(context.Background(), verificationTimeout)
+		defer cancel()
+
+		dRes, err := p.decrypt(ctx, request, transformed)
+		switch {
+		case err != nil:
+			zap.L().Error("failed to decrypt newly written ciphertext", zap.String("key", p.keyID), zap.Error(err))
+			kmsWriteVerificationCounter.WithLabelValues(p.keyID, kmsplugin.StatusFailure).Inc()
+		case !bytes.Equal(dRes.Plaintext, plaintext):
+			zap.L().Error("newly written ciphertext decrypted to a different plaintext", zap.String("key", p.keyID))
+			kmsWriteVerificationCounter.WithLabelValues(p.keyID, verificationStatusMismatch).Inc()
+		default:
+			kmsWriteVerificationCounter.WithLabelValues(p.keyID, kmsplugin.StatusSuccess).Inc()
+		}
+	}()
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	pb "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+func TestWriteVerification(t *testing.T) {
+	sharedHealthCheck := NewSharedHealthCheck(DefaultHealthCheckPeriod, DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+
+	tests := []struct {
+		key       string
+		decrypted string
+		until     time.Time
+		expects   string
+	}{
+		{
+			key:       "test-key-verify-ok",
+			decrypted: plainMessage,
+			until:     time.Now().Add(time.Hour),
+			expects:   `aws_encryption_provider_kms_write_verifications_total{key_arn="test-key-verify-ok",status="success"} 1`,
+		},
+		{
+			key:       "test-key-verify-mismatch",
+			decrypted: "other",
+			until:     time.Now().Add(time.Hour),
+			expects:   `aws_encryption_provider_kms_write_verifications_total{key_arn="test-key-verify-mismatch",status="mismatch"} 1`,
+		},
+		{
+			key:       "test-key-verify-expired",
+			decrypted: plainMessage,
+			until:     time.Now().Add(-time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			c := (&cloud.KMSMock{}).SetEncryptResp("foo", nil).SetDecryptResp(tt.decrypted, nil)
+			p := NewV2(tt.key, c, nil, sharedHealthCheck, WithWriteVerification(tt.until, 1))
+			if _, err := p.Encrypt(context.Background(), &pb.EncryptRequest{Plaintext: []byte(plainMessage)}); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for {
+				d := scrapeMetrics(t)
+				if tt.expects == "" {
+					if strings.Contains(d, "write_verifications_total{key_arn=\""+tt.key) {
+						t.Fatalf("expected no verification after the window, got\n\n%s\n\n", d)
+					}
+					return
+				}
+				if strings.Contains(d, tt.expects) {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %q, got\n\n%s\n\n", tt.expects, d)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(promhttp.Handler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	d, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(d)
+}
@@ -0,0 +1,74 @@
+// Package readyz implements readyz handlers.
+package readyz
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/healthz"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+)
+
+var _ healthz.Evaluator = &Startup{}
+
+// ErrStarting is returned by the ready check of a Startup until it completes
+var ErrStarting = errors.New("provider is starting")
+
+// Startup is the startup of the provider, e.g. the startup preflight (see plugin.Preflight),
+// failing the ready checks until it completes but never the live checks.
+type Startup struct {
+	completed atomic.Bool
+}
+
+// Complete marks the startup as completed
+func (s *Startup) Complete() {
+	if !s.completed.Swap(true) {
+		zap.L().Info("startup completed, advertising readiness")
+	}
+}
+
+// Health returns ErrStarting until the startup completes
+func (s *Startup) Health() error {
+	if !s.completed.Load() {
+		return ErrStarting
+	}
+	return nil
+}
+
+// Name of the check, see healthz.Named
+func (s *Startup) Name() string {
+	return "startup"
+}
+
+// Live never fails, a starting provider must not be restarted
+func (s *Startup) Live() error {
+	return nil
+}
+
+// Check returns an error while the provider is not able to serve: the startup did not
+// complete (nil if there is nothing to wait for), a gRPC server is not serving, then the
+// first failing health check of the plugins, then the evaluators.
+func Check(startup *Startup, servers []*server.Server, p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators ...healthz.Evaluator) error {
+	return healthz.Run(Checks(startup, servers, p1s, p2s, evaluators...))
+}
+
+// Checks returns the checks of Check, named "startup", "grpc-server-<index>" then as in
+// healthz.HealthChecks
+func Checks(startup *Startup, servers []*server.Server, p1s []*plugin.V1Plugin, p2s []*plugin.V2Plugin, evaluators ...healthz.Evaluator) []healthz.NamedCheck {
+	var checks []healthz.NamedCheck
+	if startup != nil {
+		checks = append(checks, healthz.NamedCheck{Name: startup.Name(), Check: startup.Health})
+	}
+	for i, s := range servers {
+		checks = append(checks, healthz.NamedCheck{Name: fmt.Sprintf("grpc-server-%d", i), Check: func() error {
+			if !s.Serving() {
+				return fmt.Errorf("gRPC server #%d is not serving", i)
+			}
+			return nil
+		}})
+	}
+	return append(checks, healthz.HealthChecks(p1s, p2s, evaluators...)...)
+}
@@ -0,0 +1,81 @@
+package readyz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"go.uber.org/zap"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/healthz"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
+)
+
+// TestReadyz tests the readyz handler waits for the startup and the gRPC servers, and fails on KMS errors.
+func TestReadyz(t *testing.T) {
+	zap.ReplaceGlobals(zap.NewExample())
+	ptesting.VerifyNoGoroutineLeaks(t)
+	addr := ptesting.TempSocketPath(t, "readyz")
+
+	c := &cloud.KMSMock{}
+	c.SetEncryptResp("foo", nil)
+	c.SetDecryptResp("foo", nil)
+	sharedHealthCheck := plugin.NewSharedHealthCheck(time.Nanosecond, plugin.DefaultErrcBufSize)
+	sharedHealthCheck.Start()
+	defer sharedHealthCheck.Stop()
+	p := plugin.NewV2("test-key", c, nil, sharedHealthCheck)
+	s := server.New()
+	p.Register(s.Server)
+
+	startup := &Startup{}
+	ts := httptest.NewServer(healthz.NewChecksHandler("ready", Checks(startup, []*server.Server{s}, nil, []*plugin.V2Plugin{p}), plugin.DefaultHealthCheckPeriod))
+	defer ts.Close()
+	get := func() int {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close() //nolint:errcheck
+		return resp.StatusCode
+	}
+
+	if code := get(); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 while starting, got %d", code)
+	}
+	if err := startup.Live(); err != nil {
+		t.Fatalf("expected a starting provider to be live, got %v", err)
+	}
+	startup.Complete()
+	if code := get(); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 before the gRPC server serves, got %d", code)
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- s.ListenAndServe(addr)
+	}()
+	defer func() {
+		s.Stop()
+		if err := <-errc; err != nil {
+			t.Fatalf("unexpected gRPC server stop error %v", err)
+		}
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for get() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("expected 200 OK once started and serving")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// user-induced errors pass livez but not readyz
+	c.SetEncryptResp("", &kmstypes.DisabledException{Message: aws.String("test")})
+	if code := get(); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 on KMS errors, got %d", code)
+	}
+}
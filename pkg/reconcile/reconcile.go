@@ -0,0 +1,172 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reconcile correlates the KMS events CloudTrail recorded for the principal of the
+// provider with the KMS audit log of the provider (see cloud.WithAuditLog) by request ID,
+// e.g. for compliance reviews.
+package reconcile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	cttypes "github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+)
+
+const kmsEventSource = "kms.amazonaws.com"
+
+// Event is a KMS event recorded by CloudTrail
+type Event struct {
+	Time      time.Time `json:"time"`
+	Name      string    `json:"name"`
+	RequestID string    `json:"requestID"`
+	Principal string    `json:"principal"`
+}
+
+// cloudTrailRecord holds the fields of a CloudTrail event record the reconciliation needs
+type cloudTrailRecord struct {
+	RequestID    string `json:"requestID"`
+	UserIdentity struct {
+		ARN            string `json:"arn"`
+		SessionContext struct {
+			SessionIssuer struct {
+				ARN string `json:"arn"`
+			} `json:"sessionIssuer"`
+		} `json:"sessionContext"`
+	} `json:"userIdentity"`
+}
+
+// LookupKMSEvents returns the KMS events CloudTrail recorded between start and end for
+// principalARN, the ARN of the IAM role (or of its assumed role session) or user of the provider
+func LookupKMSEvents(ctx context.Context, api cloudtrail.LookupEventsAPIClient, principalARN string, start, end time.Time) ([]Event, error) {
+	paginator := cloudtrail.NewLookupEventsPaginator(api, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []cttypes.LookupAttribute{{
+			AttributeKey:   cttypes.LookupAttributeKeyEventSource,
+			AttributeValue: aws.String(kmsEventSource),
+		}},
+		StartTime: aws.Time(start),
+		EndTime:   aws.Time(end),
+	})
+	var events []Event
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up CloudTrail events %w", err)
+		}
+		for _, e := range page.Events {
+			var r cloudTrailRecord
+			if err := json.Unmarshal([]byte(aws.ToString(e.CloudTrailEvent)), &r); err != nil {
+				return nil, fmt.Errorf("failed to decode CloudTrail event %s %w", aws.ToString(e.EventId), err)
+			}
+			principal := r.UserIdentity.ARN
+			if principal != principalARN && r.UserIdentity.SessionContext.SessionIssuer.ARN != principalARN {
+				continue
+			}
+			events = append(events, Event{
+				Time:      aws.ToTime(e.EventTime),
+				Name:      aws.ToString(e.EventName),
+				RequestID: r.RequestID,
+				Principal: principal,
+			})
+		}
+	}
+	return events, nil
+}
+
+// ReadAuditLog reads the JSON lines audit records written by cloud.WithAuditLog
+func ReadAuditLog(r io.Reader) ([]cloud.AuditRecord, error) {
+	var records []cloud.AuditRecord
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record cloud.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to decode audit record at line %d %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Report is the reconciliation of the CloudTrail events and the audit records of a window
+type Report struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Matched counts the requests both recorded, by operation
+	Matched map[string]int `json:"matched"`
+	// CloudTrailOnly are the events of the principal without audit record, e.g. requests
+	// of another process with the same principal or of a provider without audit log
+	CloudTrailOnly []Event `json:"cloudTrailOnly"`
+	// LocalOnly are the audit records without event, e.g. not delivered by CloudTrail yet
+	LocalOnly []cloud.AuditRecord `json:"localOnly"`
+}
+
+// Reconcile matches the events with the audit records of the window by request ID
+func Reconcile(start, end time.Time, events []Event, records []cloud.AuditRecord) *Report {
+	r := &Report{Start: start, End: end, Matched: map[string]int{}}
+	local := map[string]cloud.AuditRecord{}
+	for _, record := range records {
+		if record.RequestID == "" || record.Time.Before(start) || record.Time.After(end) {
+			continue
+		}
+		local[record.RequestID] = record
+	}
+	for _, e := range events {
+		if _, ok := local[e.RequestID]; ok {
+			r.Matched[e.Name]++
+			delete(local, e.RequestID)
+			continue
+		}
+		r.CloudTrailOnly = append(r.CloudTrailOnly, e)
+	}
+	for _, record := range local {
+		r.LocalOnly = append(r.LocalOnly, record)
+	}
+	sort.Slice(r.CloudTrailOnly, func(i, j int) bool { return r.CloudTrailOnly[i].Time.Before(r.CloudTrailOnly[j].Time) })
+	sort.Slice(r.LocalOnly, func(i, j int) bool { return r.LocalOnly[i].Time.Before(r.LocalOnly[j].Time) })
+	return r
+}
+
+// String formats the report for humans
+func (r *Report) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "window: %s - %s\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+	operations := make([]string, 0, len(r.Matched))
+	for op := range r.Matched {
+		operations = append(operations, op)
+	}
+	sort.Strings(operations)
+	for _, op := range operations {
+		fmt.Fprintf(&b, "matched %s: %d\n", op, r.Matched[op])
+	}
+	fmt.Fprintf(&b, "cloudtrail only: %d\n", len(r.CloudTrailOnly))
+	for _, e := range r.CloudTrailOnly {
+		fmt.Fprintf(&b, "  %s %s %s %s\n", e.Time.Format(time.RFC3339), e.Name, e.RequestID, e.Principal)
+	}
+	fmt.Fprintf(&b, "local only: %d\n", len(r.LocalOnly))
+	for _, record := range r.LocalOnly {
+		fmt.Fprintf(&b, "  %s %s %s %d\n", record.Time.Format(time.RFC3339), record.Operation, record.RequestID, record.StatusCode)
+	}
+	return b.String()
+}
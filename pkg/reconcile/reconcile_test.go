@@ -0,0 +1,97 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	cttypes "github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+)
+
+const (
+	roleARN    = "arn:aws:iam::123456789012:role/provider"
+	sessionARN = "arn:aws:sts::123456789012:assumed-role/provider/i-0123"
+)
+
+type lookupEventsMock struct {
+	pages [][]cttypes.Event
+}
+
+func (m *lookupEventsMock) LookupEvents(_ context.Context, in *cloudtrail.LookupEventsInput, _ ...func(*cloudtrail.Options)) (*cloudtrail.LookupEventsOutput, error) {
+	i := 0
+	if in.NextToken != nil {
+		fmt.Sscan(*in.NextToken, &i) //nolint:errcheck
+	}
+	out := &cloudtrail.LookupEventsOutput{Events: m.pages[i]}
+	if i+1 < len(m.pages) {
+		out.NextToken = aws.String(fmt.Sprint(i + 1))
+	}
+	return out, nil
+}
+
+func event(t time.Time, name, requestID, userARN, issuerARN string) cttypes.Event {
+	return cttypes.Event{
+		EventId:   aws.String(requestID),
+		EventName: aws.String(name),
+		EventTime: aws.Time(t),
+		CloudTrailEvent: aws.String(fmt.Sprintf(`{"requestID":%q,"userIdentity":{"arn":%q,"sessionContext":{"sessionIssuer":{"arn":%q}}}}`,
+			requestID, userARN, issuerARN)),
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	end := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	start := end.Add(-time.Hour)
+	at := start.Add(time.Minute)
+	api := &lookupEventsMock{pages: [][]cttypes.Event{
+		{
+			event(at, "Encrypt", "matched-1", sessionARN, roleARN),
+			event(at, "Decrypt", "other-principal", "arn:aws:iam::123456789012:user/admin", ""),
+		},
+		{
+			event(at, "Decrypt", "matched-2", sessionARN, roleARN),
+			event(at, "Decrypt", "cloudtrail-only", roleARN, ""),
+		},
+	}}
+	events, err := LookupKMSEvents(context.Background(), api, roleARN, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected the 3 events of the principal, got %+v", events)
+	}
+
+	log := strings.Join([]string{
+		`{"time":"2024-01-01T00:01:00Z","operation":"Encrypt","requestID":"matched-1","statusCode":200}`,
+		``,
+		`{"time":"2024-01-01T00:01:00Z","operation":"Decrypt","requestID":"matched-2","statusCode":200}`,
+		`{"time":"2024-01-01T00:02:00Z","operation":"Decrypt","requestID":"local-only","statusCode":400}`,
+		`{"time":"2023-12-31T23:00:00Z","operation":"Decrypt","requestID":"before-window","statusCode":200}`,
+	}, "\n")
+	records, err := ReadAuditLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Reconcile(start, end, events, records)
+	if r.Matched["Encrypt"] != 1 || r.Matched["Decrypt"] != 1 {
+		t.Fatalf("expected 1 matched encrypt and decrypt, got %v", r.Matched)
+	}
+	if len(r.CloudTrailOnly) != 1 || r.CloudTrailOnly[0].RequestID != "cloudtrail-only" {
+		t.Fatalf("unexpected cloudtrail only events %+v", r.CloudTrailOnly)
+	}
+	if len(r.LocalOnly) != 1 || r.LocalOnly[0].RequestID != "local-only" {
+		t.Fatalf("unexpected local only records %+v", r.LocalOnly)
+	}
+	if s := r.String(); !strings.Contains(s, "cloudtrail-only") || !strings.Contains(s, "local-only") {
+		t.Fatalf("expected the report to list the discrepancies, got\n%s", s)
+	}
+
+	if _, err := ReadAuditLog(strings.NewReader("{")); err == nil {
+		t.Fatal("expected an error reading a malformed audit log")
+	}
+}
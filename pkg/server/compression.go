@@ -0,0 +1,108 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+const (
+	CompressionGzip = gzip.Name
+	CompressionZstd = "zstd"
+)
+
+// RegisterCompressors registers the named gRPC compressors, which the servers
+// then advertise and accept. A server replies with the compression of the request.
+// It must be called before any server is created. gzip is always registered, by
+// the grpc gzip package, zstd is not registered by default.
+func RegisterCompressors(names []string) error {
+	for _, name := range names {
+		switch name {
+		case CompressionGzip:
+			// registered by the import of the grpc gzip package
+		case CompressionZstd:
+			encoding.RegisterCompressor(&zstdCompressor{})
+		default:
+			return fmt.Errorf("unknown gRPC compression %q, expected %s or %s", name, CompressionGzip, CompressionZstd)
+		}
+		zap.L().Info("registered gRPC compressor", zap.String("compression", name))
+	}
+	return nil
+}
+
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func (c *zstdCompressor) Name() string {
+	return CompressionZstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if zw, ok := c.encoders.Get().(*zstdWriter); ok {
+		zw.Reset(w)
+		return zw, nil
+	}
+	e, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{Encoder: e, pool: &c.encoders}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	if zr, ok := c.decoders.Get().(*zstdReader); ok {
+		if err := zr.Reset(r); err != nil {
+			c.decoders.Put(zr)
+			return nil, err
+		}
+		return zr, nil
+	}
+	d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{Decoder: d, pool: &c.decoders}, nil
+}
+
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	defer w.pool.Put(w)
+	return w.Encoder.Close()
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r)
+	}
+	return n, err
+}
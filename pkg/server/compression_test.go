@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/encoding"
+)
+
+func TestRegisterCompressors(t *testing.T) {
+	if err := RegisterCompressors([]string{"brotli"}); err == nil {
+		t.Fatal("expected error for unknown compression")
+	}
+	if err := RegisterCompressors([]string{CompressionGzip, CompressionZstd}); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(strings.Repeat("secret payload ", 1000))
+	for _, name := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(name, func(t *testing.T) {
+			c := encoding.GetCompressor(name)
+			if c == nil {
+				t.Fatalf("compressor %s not registered", name)
+			}
+			// run twice to exercise pooled writers and readers
+			for i := 0; i < 2; i++ {
+				var buf bytes.Buffer
+				w, err := c.Compress(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(payload); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if buf.Len() >= len(payload) {
+					t.Fatalf("expected compressed size < %d, got %d", len(payload), buf.Len())
+				}
+
+				r, err := c.Decompress(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Fatal("decompressed payload does not match")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// ConnectionLimits bound how long a client connection can hold on to the server, so a stuck or
+// very slow apiserver connection cannot pin the buffers of its requests and responses, which
+// contain plaintext, in memory indefinitely. Zero values disable the limits.
+type ConnectionLimits struct {
+	// WriteTimeout closes a connection when a write to it blocks longer, e.g. because the
+	// client stopped reading its responses
+	WriteTimeout time.Duration
+	// MaxConnectionAge closes connections once they are this old, the client reconnects
+	MaxConnectionAge time.Duration
+	// MaxConnectionAgeGrace is how long the requests in flight on a connection closed for its
+	// age may still take before the connection is forcibly closed
+	MaxConnectionAgeGrace time.Duration
+}
+
+// NewWithLimits returns a new *Server enforcing the connection limits
+func NewWithLimits(limits ConnectionLimits, opts ...grpc.ServerOption) *Server {
+	if limits.MaxConnectionAge > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionAge:      limits.MaxConnectionAge,
+			MaxConnectionAgeGrace: limits.MaxConnectionAgeGrace,
+		}))
+	}
+	s := New(opts...)
+	s.writeTimeout = limits.WriteTimeout
+	return s
+}
+
+// trackingListener tracks the connections it accepts in the connection metrics
+type trackingListener struct {
+	net.Listener
+	writeTimeout time.Duration
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	openConnections.Inc()
+	return &trackedConn{Conn: conn, opened: time.Now(), writeTimeout: l.writeTimeout}, nil
+}
+
+// trackedConn applies the write timeout to each write, and records its age when closed
+type trackedConn struct {
+	net.Conn
+	opened       time.Time
+	writeTimeout time.Duration
+	closeOnce    sync.Once
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.Conn.Write(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		connectionWriteTimeouts.Inc()
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		openConnections.Dec()
+		connectionAge.Observe(time.Since(c.opened).Seconds())
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestConnectionWriteTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close() //nolint:errcheck
+	defer server.Close() //nolint:errcheck
+	conn := &trackedConn{Conn: server, opened: time.Now(), writeTimeout: 50 * time.Millisecond}
+
+	// the client never reads, so the write blocks until the timeout
+	start := time.Now()
+	if _, err := conn.Write([]byte("plaintext")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the write to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the write to time out after 50ms, took %v", elapsed)
+	}
+	if !strings.Contains(scrapeMetrics(t), "aws_encryption_provider_grpc_connection_write_timeouts_total 1") {
+		t.Fatal("expected a write timeout to be counted")
+	}
+}
+
+func TestTrackingListener(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "kms.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl := &trackingListener{Listener: l}
+	defer tl.Close() //nolint:errcheck
+
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close() //nolint:errcheck
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(scrapeMetrics(t), "aws_encryption_provider_grpc_open_connections 1") {
+		t.Fatal("expected an open connection")
+	}
+	// closing twice records the connection once
+	conn.Close() //nolint:errcheck
+	conn.Close() //nolint:errcheck
+	metrics := scrapeMetrics(t)
+	if !strings.Contains(metrics, "aws_encryption_provider_grpc_open_connections 0") {
+		t.Fatal("expected no open connection")
+	}
+	if !strings.Contains(metrics, "aws_encryption_provider_grpc_connection_age_seconds_count 1") {
+		t.Fatal("expected the age of the closed connection to be observed")
+	}
+}
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(promhttp.Handler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	d, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(d)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Services of the standard gRPC health service, see RegisterHealthService
+const (
+	// HealthServiceReadiness is also served as "", the overall health of the server
+	HealthServiceReadiness = "readiness"
+	HealthServiceLiveness  = "liveness"
+)
+
+// healthWatchInterval is the interval the checks are evaluated at for Watch streams
+var healthWatchInterval = 5 * time.Second
+
+// HealthChecks are the checks of the standard gRPC health service
+type HealthChecks struct {
+	// Ready fails the readiness service, e.g. the checks of /healthz
+	Ready func() error
+	// Live fails the liveness service, e.g. the checks of /livez
+	Live func() error
+}
+
+// RegisterHealthService registers the standard grpc.health.v1.Health service, so kubelet gRPC
+// probes and grpc_health_probe can check the server instead of the HTTP endpoints. It must be
+// called before the server starts serving.
+func (s *Server) RegisterHealthService(checks HealthChecks) {
+	healthpb.RegisterHealthServer(s.Server, &healthService{checks: map[string]func() error{
+		"":                     checks.Ready,
+		HealthServiceReadiness: checks.Ready,
+		HealthServiceLiveness:  checks.Live,
+	}})
+}
+
+type healthService struct {
+	healthpb.UnimplementedHealthServer
+	checks map[string]func() error
+}
+
+func (h *healthService) evaluate(service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	check, ok := h.checks[service]
+	if !ok {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	if err := check(); err != nil {
+		zap.L().Debug("gRPC health check failed", zap.String("service", service), zap.Error(err))
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+// Check implements healthpb.HealthServer
+func (h *healthService) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	st, err := h.evaluate(req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch implements healthpb.HealthServer, sending the status when it changes
+func (h *healthService) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		// unknown services are reported as such until they are registered, per the protocol
+		st, _ := h.evaluate(req.Service)
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
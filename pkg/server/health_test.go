@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHealthService(t *testing.T) {
+	prevInterval := healthWatchInterval
+	healthWatchInterval = 10 * time.Millisecond
+	defer func() { healthWatchInterval = prevInterval }()
+
+	var ready atomic.Bool
+	s := New()
+	s.RegisterHealthService(HealthChecks{
+		Ready: func() error {
+			if !ready.Load() {
+				return errors.New("KMS unavailable")
+			}
+			return nil
+		},
+		Live: func() error { return nil },
+	})
+	sock := filepath.Join(t.TempDir(), "kms.sock")
+	go s.ListenAndServe(sock) //nolint:errcheck
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("unix://"+sock, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() //nolint:errcheck
+	client := healthpb.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for service, expected := range map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":                     healthpb.HealthCheckResponse_NOT_SERVING,
+		HealthServiceReadiness: healthpb.HealthCheckResponse_NOT_SERVING,
+		HealthServiceLiveness:  healthpb.HealthCheckResponse_SERVING,
+	} {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatalf("%q: %v", service, err)
+		}
+		if resp.Status != expected {
+			t.Errorf("%q: expected %s, got %s", service, expected, resp.Status)
+		}
+	}
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected unknown services to be not found, got %v", err)
+	}
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING, got %v, %v", resp, err)
+	}
+	ready.Store(true)
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING once ready, got %v, %v", resp, err)
+	}
+}
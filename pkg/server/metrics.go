@@ -0,0 +1,37 @@
+package server
+
+import "github.com/prometheus/client_golang/prometheus"
+
+func init() {
+	registerPrometheusMetrics()
+}
+
+func registerPrometheusMetrics() {
+	prometheus.MustRegister(openConnections)
+	prometheus.MustRegister(connectionAge)
+	prometheus.MustRegister(connectionWriteTimeouts)
+}
+
+var (
+	openConnections = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aws_encryption_provider_grpc_open_connections",
+			Help: "Number of currently open connections to the gRPC servers",
+		},
+	)
+
+	connectionAge = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "aws_encryption_provider_grpc_connection_age_seconds",
+			Help:    "Age of the connections to the gRPC servers when they are closed",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		},
+	)
+
+	connectionWriteTimeouts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "aws_encryption_provider_grpc_connection_write_timeouts_total",
+			Help: "Number of writes to gRPC connections that timed out, closing the connection",
+		},
+	)
+)
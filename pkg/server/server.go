@@ -17,6 +17,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
@@ -24,15 +28,46 @@ import (
 
 type Server struct {
 	*grpc.Server
+	// listeners being served
+	serving      atomic.Int32
+	writeTimeout time.Duration
 }
 
-func New() *Server {
+func New(opts ...grpc.ServerOption) *Server {
 	return &Server{
-		grpc.NewServer(),
+		Server: grpc.NewServer(opts...),
 	}
 }
 
+// Serving returns true while the server accepts connections
+func (s *Server) Serving() bool {
+	return s.serving.Load() > 0
+}
+
+func (s *Server) serve(l net.Listener) error {
+	s.serving.Add(1)
+	defer s.serving.Add(-1)
+	return s.Serve(&trackingListener{Listener: l, writeTimeout: s.writeTimeout})
+}
+
+// IsAbstractSocket returns true for Linux abstract unix socket addresses, e.g. "@kms-plugin".
+// These are not backed by a file, so no writable volume is needed to create them.
+func IsAbstractSocket(addr string) bool {
+	return strings.HasPrefix(addr, "@")
+}
+
 func (s *Server) ListenAndServe(addr string) error {
+	if IsAbstractSocket(addr) {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("abstract socket %q is only supported on linux", addr)
+		}
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			return fmt.Errorf("failed to create listener: %v", err)
+		}
+		return s.serve(l)
+	}
+
 	// Server should remove the socket file prior to binding it in case the socket isn't cleaned up gracefully.
 	// This can happen if the application is killed by SIGKILL or SIGSTOP, i.e. kill -9 or docker kill by default.
 	if _, err := os.Stat(addr); err != nil {
@@ -53,5 +88,5 @@ func (s *Server) ListenAndServe(addr string) error {
 		return fmt.Errorf("failed to create listener: %v", err)
 	}
 
-	return s.Serve(l)
+	return s.serve(l)
 }
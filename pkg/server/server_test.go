@@ -2,8 +2,11 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"runtime"
 	"testing"
 	"time"
 
@@ -64,3 +67,41 @@ func TestListenAndServe(t *testing.T) {
 		})
 	}
 }
+
+func TestListenAndServeAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only supported on linux")
+	}
+	zap.ReplaceGlobals(zap.NewExample())
+	addr := fmt.Sprintf("@aws-encryption-provider-test-%d", os.Getpid())
+
+	s := New()
+	ch := make(chan error, 1)
+	go func() {
+		ch <- s.ListenAndServe(addr)
+	}()
+	defer s.Stop()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to dial abstract socket %s: %v", addr, err)
+	}
+	conn.Close() //nolint:errcheck
+
+	// no socket file is created
+	if _, err := os.Stat(addr); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no file for abstract socket, got %v", err)
+	}
+	select {
+	case err := <-ch:
+		t.Fatalf("unexpected ListenAndServe() error = %v", err)
+	default:
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// TLSConfig configures the mutual TLS of a TCP listener, see ListenAndServeTLS
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded certificate and key of the server
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the PEM encoded bundle of the CAs client certificates must be signed by
+	ClientCAFile string
+	// AllowedSANs are the DNS names, IP addresses, URIs or email addresses a client certificate must
+	// have one of as subject alternative name, any client certificate of the CAs if empty
+	AllowedSANs []string
+}
+
+// errClientNotAllowed is returned by the handshake of clients without an allowed SAN
+var errClientNotAllowed = errors.New("client certificate has no allowed subject alternative name")
+
+// tlsConfig returns the tls.Config of cfg. The files are read for every handshake, so rotated
+// certificates are picked up without a restart, and a file that can't be read fails the handshake.
+func (cfg TLSConfig) tlsConfig() (*tls.Config, error) {
+	// fail fast on misconfigurations
+	if _, err := cfg.load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := cfg.load()
+			if err != nil {
+				zap.L().Error("failed to load the TLS configuration", zap.Error(err))
+			}
+			return c, err
+		},
+	}, nil
+}
+
+func (cfg TLSConfig) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the server certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client CA: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificate found in the client CA %s", cfg.ClientCAFile)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		// gRPC clients require HTTP/2 to be negotiated
+		NextProtos:       []string{"h2"},
+		VerifyConnection: cfg.verifySAN,
+	}, nil
+}
+
+// verifySAN checks the client certificate, already verified against the CAs, has an allowed SAN
+func (cfg TLSConfig) verifySAN(cs tls.ConnectionState) error {
+	if len(cfg.AllowedSANs) == 0 {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errClientNotAllowed
+	}
+	leaf := cs.PeerCertificates[0]
+	sans := append(slices.Clone(leaf.DNSNames), leaf.EmailAddresses...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if slices.Contains(cfg.AllowedSANs, san) {
+			return nil
+		}
+	}
+	zap.L().Warn("rejecting client certificate", zap.Strings("sans", sans), zap.String("subject", leaf.Subject.String()))
+	return errClientNotAllowed
+}
+
+// ListenAndServeTLS serves on the TCP address addr with mutual TLS, e.g. in addition to the unix
+// socket of ListenAndServe, for control planes running the provider on another host.
+func (s *Server) ListenAndServeTLS(addr string, cfg TLSConfig) error {
+	tlsCfg, err := cfg.tlsConfig()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to create listener: %v", err)
+	}
+	return s.serve(tls.NewListener(l, tlsCfg))
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	serverCert := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "kms-plugin"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	certFile, keyFile := serverCert.writePEM(t, dir, "server")
+	newClientCert := func(dnsName string) *testCert {
+		return newTestCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: dnsName},
+			DNSNames:    []string{dnsName},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca)
+	}
+
+	cfg := TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, AllowedSANs: []string{"apiserver"}}
+	if err := (&Server{}).ListenAndServeTLS("127.0.0.1:0", TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile + ".missing"}); err == nil {
+		t.Fatal("expected a missing client CA to fail")
+	}
+
+	// reserve a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close() //nolint:errcheck
+
+	s := New()
+	s.RegisterHealthService(HealthChecks{Ready: func() error { return nil }, Live: func() error { return nil }})
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServeTLS(addr, cfg) }()
+	defer s.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	check := func(clientCerts ...tls.Certificate) error {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: clientCerts,
+		})))
+		if err != nil {
+			return err
+		}
+		defer conn.Close() //nolint:errcheck
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return err
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for err := check(newClientCert("apiserver").tlsCertificate()); err != nil; err = check(newClientCert("apiserver").tlsCertificate()) {
+		select {
+		case err := <-errc:
+			t.Fatalf("server failed: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected an allowed client to succeed, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := check(newClientCert("intruder").tlsCertificate()); err == nil {
+		t.Error("expected a client without an allowed SAN to fail")
+	}
+	if err := check(); err == nil {
+		t.Error("expected a client without certificate to fail")
+	}
+	if !s.Serving() {
+		t.Error("expected the server to be serving")
+	}
+}
@@ -0,0 +1,38 @@
+package slo
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var burnRateDesc = prometheus.NewDesc(
+	"aws_encryption_provider_slo_burn_rate",
+	"Rate at which the error or latency budget of the operation is consumed over the window, 1 exhausting it exactly over the SLO period",
+	[]string{"operation", "sli", "window"},
+	nil,
+)
+
+var _ prometheus.Collector = &Tracker{}
+
+// Describe implements prometheus.Collector
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- burnRateDesc
+}
+
+// Collect implements prometheus.Collector, computing the burn rates at scrape time
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	for _, op := range t.operations() {
+		for _, window := range []struct {
+			label    string
+			duration time.Duration
+		}{
+			{"5m", ShortWindow},
+			{"1h", LongWindow},
+		} {
+			errorRate, latencyRate := t.BurnRates(op, window.duration)
+			ch <- prometheus.MustNewConstMetric(burnRateDesc, prometheus.GaugeValue, errorRate, op, "errors", window.label)
+			ch <- prometheus.MustNewConstMetric(burnRateDesc, prometheus.GaugeValue, latencyRate, op, "latency", window.label)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package slo computes service level objective burn rates of the encrypt
+// and decrypt operations, for alerting without Prometheus recording rules.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+// Windows over which burn rates are computed, following the multiwindow
+// alerting of the Google SRE workbook: alert when both windows burn fast.
+const (
+	ShortWindow = 5 * time.Minute
+	LongWindow  = time.Hour
+
+	resolution = 10 * time.Second
+)
+
+// Objective is the targeted fraction of good requests, e.g. 0.999.
+// A request is good if it succeeds within LatencyThreshold.
+type Objective struct {
+	Target           float64
+	LatencyThreshold time.Duration
+}
+
+// Tracker records the requests of each operation over LongWindow
+// and computes the burn rates of the error and latency budgets,
+// i.e. the rate of bad requests divided by the rate allowed by the objective.
+// A burn rate of 1 exhausts the budget exactly at the end of the SLO period.
+type Tracker struct {
+	objective Objective
+	now       func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string][]bucket
+}
+
+type bucket struct {
+	slot   int64
+	total  uint64
+	errors uint64
+	slow   uint64
+}
+
+// NewTracker returns a new *Tracker of the objective
+func NewTracker(objective Objective) (*Tracker, error) {
+	if objective.Target <= 0 || objective.Target >= 1 {
+		return nil, fmt.Errorf("SLO target expected in (0, 1), got %v", objective.Target)
+	}
+	if objective.LatencyThreshold <= 0 {
+		return nil, fmt.Errorf("SLO latency threshold expected >0, got %v", objective.LatencyThreshold)
+	}
+	return &Tracker{
+		objective: objective,
+		now:       time.Now,
+		buckets:   make(map[string][]bucket),
+	}, nil
+}
+
+// Record records a request of the operation
+func (t *Tracker) Record(operation string, latency time.Duration, err error) {
+	slot := t.now().UnixNano() / int64(resolution)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buckets, ok := t.buckets[operation]
+	if !ok {
+		buckets = make([]bucket, LongWindow/resolution)
+		t.buckets[operation] = buckets
+	}
+	b := &buckets[slot%int64(len(buckets))]
+	if b.slot != slot {
+		*b = bucket{slot: slot}
+	}
+	b.total++
+	if err != nil {
+		b.errors++
+	}
+	if latency > t.objective.LatencyThreshold {
+		b.slow++
+	}
+}
+
+// BurnRates returns the error and latency burn rates of the operation over the window
+func (t *Tracker) BurnRates(operation string, window time.Duration) (errorRate, latencyRate float64) {
+	now := t.now().UnixNano() / int64(resolution)
+	oldest := now - int64(window/resolution)
+	var total, errors, slow uint64
+	t.mu.Lock()
+	for _, b := range t.buckets[operation] {
+		if b.slot > oldest && b.slot <= now {
+			total, errors, slow = total+b.total, errors+b.errors, slow+b.slow
+		}
+	}
+	t.mu.Unlock()
+	if total == 0 {
+		return 0, 0
+	}
+	budget := 1 - t.objective.Target
+	return float64(errors) / float64(total) / budget, float64(slow) / float64(total) / budget
+}
+
+// operations returns the recorded operations
+func (t *Tracker) operations() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ops := make([]string, 0, len(t.buckets))
+	for op := range t.buckets {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// UnaryServerInterceptor records the Encrypt and Decrypt calls of the KMS plugin gRPC services
+func (t *Tracker) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var operation string
+		switch path.Base(info.FullMethod) {
+		case "Encrypt":
+			operation = kmsplugin.OperationEncrypt
+		case "Decrypt":
+			operation = kmsplugin.OperationDecrypt
+		default:
+			return handler(ctx, req)
+		}
+		startTime := t.now()
+		resp, err := handler(ctx, req)
+		t.Record(operation, t.now().Sub(startTime), err)
+		return resp, err
+	}
+}
@@ -0,0 +1,106 @@
+package slo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"sigs.k8s.io/aws-encryption-provider/pkg/kmsplugin"
+)
+
+func newTestTracker(t *testing.T, now *time.Time) *Tracker {
+	tr, err := NewTracker(Objective{Target: 0.99, LatencyThreshold: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.now = func() time.Time { return *now }
+	return tr
+}
+
+func TestNewTrackerValidation(t *testing.T) {
+	for _, o := range []Objective{
+		{Target: 0, LatencyThreshold: time.Second},
+		{Target: 1, LatencyThreshold: time.Second},
+		{Target: 0.99},
+	} {
+		if _, err := NewTracker(o); err == nil {
+			t.Fatalf("expected error for objective %+v", o)
+		}
+	}
+}
+
+func TestBurnRates(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := newTestTracker(t, &now)
+
+	// 2% errors and 1% slow requests burn the 1% budget at 2x and 1x
+	for i := 0; i < 100; i++ {
+		var err error
+		if i < 2 {
+			err = errors.New("fail")
+		}
+		latency := time.Millisecond
+		if i == 99 {
+			latency = time.Second
+		}
+		tr.Record(kmsplugin.OperationEncrypt, latency, err)
+	}
+	assertRates(t, tr, ShortWindow, 2, 1)
+	assertRates(t, tr, LongWindow, 2, 1)
+
+	// only good requests in the last 5 minutes
+	now = now.Add(30 * time.Minute)
+	for i := 0; i < 100; i++ {
+		tr.Record(kmsplugin.OperationEncrypt, time.Millisecond, nil)
+	}
+	assertRates(t, tr, ShortWindow, 0, 0)
+	assertRates(t, tr, LongWindow, 1, 0.5)
+
+	// everything recorded is out of the long window
+	now = now.Add(2 * LongWindow)
+	assertRates(t, tr, LongWindow, 0, 0)
+}
+
+func assertRates(t *testing.T, tr *Tracker, window time.Duration, expectedErrors, expectedLatency float64) {
+	t.Helper()
+	errorRate, latencyRate := tr.BurnRates(kmsplugin.OperationEncrypt, window)
+	if math.Abs(errorRate-expectedErrors) > 1e-9 || math.Abs(latencyRate-expectedLatency) > 1e-9 {
+		t.Fatalf("window %v: expected burn rates %v/%v, got %v/%v", window, expectedErrors, expectedLatency, errorRate, latencyRate)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := newTestTracker(t, &now)
+	interceptor := tr.UnaryServerInterceptor()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.New("fail")
+	}
+	for _, method := range []string{"/v2.KeyManagementService/Decrypt", "/v2.KeyManagementService/Status"} {
+		if _, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler); err == nil {
+			t.Fatal("expected the handler error")
+		}
+	}
+
+	if ops := tr.operations(); len(ops) != 1 || ops[0] != kmsplugin.OperationDecrypt {
+		t.Fatalf("expected only decrypt to be recorded, got %v", ops)
+	}
+	errorRate, latencyRate := tr.BurnRates(kmsplugin.OperationDecrypt, ShortWindow)
+	if math.Abs(errorRate-100) > 1e-9 || latencyRate != 0 {
+		t.Fatalf("expected burn rates 100/0, got %v/%v", errorRate, latencyRate)
+	}
+	// 2 SLIs over 2 windows
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(tr)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mfs) != 1 || len(mfs[0].GetMetric()) != 4 {
+		t.Fatalf("expected 4 burn rate metrics, got %v", mfs)
+	}
+}
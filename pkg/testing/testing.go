@@ -0,0 +1,148 @@
+// Package testing provides helpers to check that tests (and embedders testing the
+// provider lifecycle) do not leak goroutines, sockets or temporary files.
+package testing
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TB is the subset of testing.TB used by the helpers
+type TB interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Cleanup(func())
+}
+
+// LeakCheckTimeout is how long the helpers wait for goroutines to exit
+var LeakCheckTimeout = 5 * time.Second
+
+// goroutines started by the standard library that outlive the test on purpose
+var defaultIgnoredGoroutines = []string{
+	// idle keep-alive connections of http.DefaultClient
+	"net/http.(*persistConn)",
+}
+
+// VerifyNoGoroutineLeaks fails the test if goroutines started after the call are
+// still running when the test and its cleanups are done. Goroutines whose stack
+// contains any of the ignore substrings are not reported.
+//
+// Call it first, so its cleanup runs last.
+func VerifyNoGoroutineLeaks(t TB, ignore ...string) {
+	t.Helper()
+	before := goroutines()
+	ignore = append(ignore, defaultIgnoredGoroutines...)
+	t.Cleanup(func() {
+		t.Helper()
+		var leaked []string
+		deadline := time.Now().Add(LeakCheckTimeout)
+		for {
+			leaked = leaked[:0]
+			for id, stack := range goroutines() {
+				if _, ok := before[id]; ok || containsAny(stack, ignore) {
+					continue
+				}
+				leaked = append(leaked, stack)
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if len(leaked) > 0 {
+			sort.Strings(leaked)
+			t.Errorf("found %d leaked goroutine(s):\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
+
+// VerifyPathsRemoved fails the test if any of the paths (e.g. unix sockets)
+// still exists when the test and its later registered cleanups are done.
+func VerifyPathsRemoved(t TB, paths ...string) {
+	t.Helper()
+	t.Cleanup(func() {
+		t.Helper()
+		for _, p := range paths {
+			if _, err := os.Lstat(p); err == nil {
+				t.Errorf("expected %s to be removed", p)
+			} else if !os.IsNotExist(err) {
+				t.Errorf("failed to os.Lstat %s: %v", p, err)
+			}
+		}
+	})
+}
+
+// VerifyNoNewTempFiles fails the test if files matching the glob pattern
+// in os.TempDir() were created during the test and not removed.
+func VerifyNoNewTempFiles(t TB, pattern string) {
+	t.Helper()
+	before := tempFiles(pattern)
+	t.Cleanup(func() {
+		t.Helper()
+		for p := range tempFiles(pattern) {
+			if _, ok := before[p]; !ok {
+				t.Errorf("expected temporary file %s to be removed", p)
+			}
+		}
+	})
+}
+
+// TempSocketPath returns a unique unix socket path in os.TempDir(), short enough
+// for the unix socket path limit, and verifies it is removed by the end of the test.
+func TempSocketPath(t TB, prefix string) string {
+	t.Helper()
+	f, err := os.CreateTemp("", prefix+"*.sock")
+	if err != nil {
+		t.Errorf("failed to create temporary socket path: %v", err)
+		return filepath.Join(os.TempDir(), prefix+".sock")
+	}
+	p := f.Name()
+	// only the unique name is needed, the server creates the socket
+	_ = f.Close()
+	_ = os.Remove(p)
+	VerifyPathsRemoved(t, p)
+	return p
+}
+
+// goroutines returns the stack of every goroutine keyed by its header, e.g. "goroutine 12"
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	out := make(map[string]string)
+	for _, g := range bytes.Split(buf, []byte("\n\n")) {
+		stack := string(g)
+		header, _, _ := strings.Cut(stack, " [")
+		out[header] = stack
+	}
+	return out
+}
+
+func tempFiles(pattern string) map[string]struct{} {
+	matches, _ := filepath.Glob(filepath.Join(os.TempDir(), pattern))
+	out := make(map[string]struct{}, len(matches))
+	for _, m := range matches {
+		out[m] = struct{}{}
+	}
+	return out
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
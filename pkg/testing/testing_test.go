@@ -0,0 +1,94 @@
+package testing
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTB records errors and runs cleanups on demand
+type fakeTB struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeTB) runCleanups() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func TestVerifyNoGoroutineLeaks(t *testing.T) {
+	defer func(timeout time.Duration) { LeakCheckTimeout = timeout }(LeakCheckTimeout)
+	LeakCheckTimeout = 100 * time.Millisecond
+
+	ft := &fakeTB{}
+	VerifyNoGoroutineLeaks(ft)
+	leaked := make(chan struct{})
+	go func() { <-leaked }()
+	ft.runCleanups()
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "leaked goroutine") {
+		t.Fatalf("expected a leaked goroutine, got %v", ft.errors)
+	}
+	close(leaked)
+
+	ft = &fakeTB{}
+	VerifyNoGoroutineLeaks(ft)
+	done := make(chan struct{})
+	go func() { <-done }()
+	close(done)
+	ft.runCleanups()
+	if len(ft.errors) != 0 {
+		t.Fatalf("unexpected errors %v", ft.errors)
+	}
+}
+
+func TestVerifyPathsRemoved(t *testing.T) {
+	ft := &fakeTB{}
+	p := TempSocketPath(ft, "testing")
+	if err := os.WriteFile(p, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	ft.runCleanups()
+	if len(ft.errors) != 1 {
+		t.Fatalf("expected an error for %s, got %v", p, ft.errors)
+	}
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+
+	ft = &fakeTB{}
+	VerifyPathsRemoved(ft, p)
+	ft.runCleanups()
+	if len(ft.errors) != 0 {
+		t.Fatalf("unexpected errors %v", ft.errors)
+	}
+}
+
+func TestVerifyNoNewTempFiles(t *testing.T) {
+	ft := &fakeTB{}
+	VerifyNoNewTempFiles(ft, "verify-temp-files-*")
+	f, err := os.CreateTemp("", "verify-temp-files-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()                 //nolint:errcheck
+	defer os.Remove(f.Name()) //nolint:errcheck
+	ft.runCleanups()
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], filepath.Base(f.Name())) {
+		t.Fatalf("expected an error for %s, got %v", f.Name(), ft.errors)
+	}
+}
@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+
+	// the span of the apiserver, propagated in the request metadata
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	))
+
+	interceptor := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/v2.KeyManagementService/Encrypt"}
+	var handlerSpan trace.SpanContext
+	if _, err := interceptor(ctx, "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return "resp", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("fail")
+	if _, err := interceptor(context.Background(), "req", info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != info.FullMethod || spans[0].SpanKind() != trace.SpanKindServer {
+		t.Fatalf("expected a server span %s, got %s %s", info.FullMethod, spans[0].SpanKind(), spans[0].Name())
+	}
+	if spans[0].SpanContext().TraceID() != traceID || spans[0].Parent().SpanID() != spanID {
+		t.Fatalf("expected a child of the propagated span, got parent %v", spans[0].Parent())
+	}
+	if handlerSpan.SpanID() != spans[0].SpanContext().SpanID() {
+		t.Fatal("expected the handler to run in the server span")
+	}
+	if spans[1].Parent().IsValid() || spans[1].Status().Code != codes.Error {
+		t.Fatalf("expected a failed root span, got parent %v and status %v", spans[1].Parent(), spans[1].Status())
+	}
+}
@@ -39,7 +39,7 @@ func setup(t *testing.T) (string, *server.Server, *cloud.KMSMock, pb.KeyManageme
 	s := server.New()
 	c := &cloud.KMSMock{}
 	sharedHealthCheck := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
-	go sharedHealthCheck.Start()
+	sharedHealthCheck.Start()
 	defer sharedHealthCheck.Stop()
 	p := plugin.New(key, c, nil, sharedHealthCheck)
 	p.Register(s.Server)
@@ -0,0 +1,211 @@
+//go:build localkms
+
+/*
+Copyright 2020 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package localkms runs the provider end to end against local-kms in a container, through the
+// real AWS SDK client, e.g. to validate AWS SDK upgrades. It requires Docker and the localkms
+// build tag, from its own module: cd test/localkms && go test -tags localkms ./...
+package localkms
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	pbv2 "k8s.io/kms/apis/v2"
+	"sigs.k8s.io/aws-encryption-provider/pkg/cloud"
+	"sigs.k8s.io/aws-encryption-provider/pkg/conformance"
+	"sigs.k8s.io/aws-encryption-provider/pkg/plugin"
+	"sigs.k8s.io/aws-encryption-provider/pkg/server"
+	ptesting "sigs.k8s.io/aws-encryption-provider/pkg/testing"
+)
+
+const (
+	// defaultImage is the local-kms image, overridden by $LOCAL_KMS_IMAGE, e.g. to pin a digest
+	defaultImage = "nsmithuk/local-kms:3"
+	region       = "us-west-2"
+)
+
+// startLocalKMS starts local-kms and returns its endpoint, the container is removed with the test
+func startLocalKMS(t *testing.T) string {
+	t.Helper()
+	image := os.Getenv("LOCAL_KMS_IMAGE")
+	if image == "" {
+		image = defaultImage
+	}
+	ctx := context.Background()
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        image,
+			ExposedPorts: []string{"8080/tcp"},
+			Env:          map[string]string{"PORT": "8080", "KMS_REGION": region},
+			WaitingFor:   wait.ForListeningPort("8080/tcp").WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	})
+	testcontainers.CleanupContainer(t, c)
+	if err != nil {
+		t.Fatalf("failed to start %s %v", image, err)
+	}
+	endpoint, err := c.PortEndpoint(ctx, "8080/tcp", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// local-kms accepts any credentials, isolate the SDK from the credentials of the host
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "localkms")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "localkms")
+	return endpoint
+}
+
+// createKey creates a symmetric key and returns its ARN
+func createKey(t *testing.T, endpoint string) string {
+	t.Helper()
+	c := kms.New(kms.Options{
+		Region:       region,
+		BaseEndpoint: aws.String(endpoint),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	out, err := c.CreateKey(context.Background(), &kms.CreateKeyInput{Description: aws.String(t.Name())})
+	if err != nil {
+		t.Fatalf("failed to create key %v", err)
+	}
+	return aws.ToString(out.KeyMetadata.Arn)
+}
+
+// newClient returns the KMS client of the provider, as created by the server
+func newClient(t *testing.T, endpoint string) cloud.AWSKMSv2 {
+	t.Helper()
+	c, err := cloud.New(region, endpoint, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create KMS client %v", err)
+	}
+	return c
+}
+
+func newHealthCheck(t *testing.T) *plugin.SharedHealthCheck {
+	t.Helper()
+	hc := plugin.NewSharedHealthCheck(plugin.DefaultHealthCheckPeriod, plugin.DefaultErrcBufSize)
+	hc.Start()
+	t.Cleanup(hc.Stop)
+	return hc
+}
+
+// serve serves the plugins of key on a temporary socket and returns its path
+func serve(t *testing.T, c cloud.AWSKMSv2, key string, v1Opts []plugin.V1Option, v2Opts []plugin.V2Option) string {
+	t.Helper()
+	addr := ptesting.TempSocketPath(t, "localkms")
+	hc := newHealthCheck(t)
+	s := server.New()
+	plugin.New(key, c, nil, hc, v1Opts...).Register(s.Server)
+	plugin.NewV2(key, c, nil, hc, v2Opts...).Register(s.Server)
+	errc := make(chan error)
+	go func() {
+		errc <- s.ListenAndServe(addr)
+	}()
+	t.Cleanup(func() {
+		s.Stop()
+		if err := <-errc; err != nil {
+			t.Errorf("unexpected gRPC server stop error %v", err)
+		}
+	})
+	return addr
+}
+
+// TestConformance runs the conformance tests against the provider using local-kms,
+// with and without the key hierarchy
+func TestConformance(t *testing.T) {
+	endpoint := startLocalKMS(t)
+	c := newClient(t, endpoint)
+	key := createKey(t, endpoint)
+
+	t.Run("direct", func(t *testing.T) {
+		addr := serve(t, c, key, nil, nil)
+		t.Run("v1", func(t *testing.T) { conformance.RunV1(t, conformance.Config{Addr: addr}) })
+		t.Run("v2", func(t *testing.T) { conformance.RunV2(t, conformance.Config{Addr: addr}) })
+	})
+	t.Run("key hierarchy", func(t *testing.T) {
+		addr := serve(t, c, key,
+			[]plugin.V1Option{plugin.WithV1KeyHierarchy(time.Hour)},
+			[]plugin.V2Option{plugin.WithKeyHierarchy(time.Hour)})
+		t.Run("v1", func(t *testing.T) { conformance.RunV1(t, conformance.Config{Addr: addr}) })
+		t.Run("v2", func(t *testing.T) { conformance.RunV2(t, conformance.Config{Addr: addr}) })
+	})
+}
+
+// TestEncryptionContext checks KMS enforces the encryption context of the ciphertexts
+func TestEncryptionContext(t *testing.T) {
+	endpoint := startLocalKMS(t)
+	c := newClient(t, endpoint)
+	key := createKey(t, endpoint)
+	hc := newHealthCheck(t)
+	ctx := context.Background()
+
+	p := plugin.NewV2(key, c, map[string]string{"cluster": "a"}, hc)
+	enc, err := p.Encrypt(ctx, &pbv2.EncryptRequest{Plaintext: []byte("secret"), Uid: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := p.Decrypt(ctx, &pbv2.DecryptRequest{Ciphertext: enc.Ciphertext, KeyId: enc.KeyId, Uid: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.Plaintext, []byte("secret")) {
+		t.Fatalf("expected the plaintext, got %q", dec.Plaintext)
+	}
+
+	other := plugin.NewV2(key, c, map[string]string{"cluster": "b"}, hc)
+	if _, err := other.Decrypt(ctx, &pbv2.DecryptRequest{Ciphertext: enc.Ciphertext, KeyId: enc.KeyId, Uid: "3"}); err == nil {
+		t.Fatal("expected decrypting with another encryption context to fail")
+	}
+}
+
+// TestDecryptOnlyKeys checks the ciphertexts of the previous key decrypt during a key rotation
+func TestDecryptOnlyKeys(t *testing.T) {
+	endpoint := startLocalKMS(t)
+	c := newClient(t, endpoint)
+	oldKey, newKey := createKey(t, endpoint), createKey(t, endpoint)
+	hc := newHealthCheck(t)
+	ctx := context.Background()
+
+	enc, err := plugin.NewV2(oldKey, c, nil, hc).Encrypt(ctx, &pbv2.EncryptRequest{Plaintext: []byte("secret"), Uid: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := plugin.NewV2(newKey, c, nil, hc, plugin.WithDecryptOnlyKeys(oldKey))
+	dec, err := p.Decrypt(ctx, &pbv2.DecryptRequest{Ciphertext: enc.Ciphertext, KeyId: enc.KeyId, Uid: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.Plaintext, []byte("secret")) {
+		t.Fatalf("expected the plaintext, got %q", dec.Plaintext)
+	}
+	status, err := p.Status(ctx, &pbv2.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.KeyId != newKey {
+		t.Fatalf("expected the status of %s, got %s", newKey, status.KeyId)
+	}
+}